@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"cema_backend/types"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// actorKey is the gin context key the authenticated doctor is kept under
+const actorKey = "auth.actor"
+
+// actorFromClaims reads the doctor a token was issued to from its claims
+func actorFromClaims(claims jwt.Claims) (types.Actor, bool) {
+	mapClaims, ok := claims.(jwt.MapClaims)
+	if !ok {
+		return types.Actor{}, false
+	}
+	// JSON numbers are decoded as float64
+	id, _ := mapClaims["id"].(float64)
+	email, _ := mapClaims["email"].(string)
+	role, _ := mapClaims["role"].(string)
+	sessionID, _ := mapClaims["sid"].(string)
+	if id <= 0 || email == "" {
+		return types.Actor{}, false
+	}
+	actor := types.Actor{ID: int(id), Email: email, Role: role, Permissions: []string{}, SessionID: sessionID}
+	// JSON arrays are decoded as []interface{}
+	permissions, _ := mapClaims["permissions"].([]interface{})
+	for _, permission := range permissions {
+		if name, ok := permission.(string); ok {
+			actor.Permissions = append(actor.Permissions, name)
+		}
+	}
+	return actor, true
+}
+
+// SetActor records the authenticated doctor making the request
+func SetActor(c *gin.Context, actor types.Actor) {
+	c.Set(actorKey, actor)
+}
+
+// CurrentActor returns the authenticated doctor making the request.
+// Handlers behind AuthMiddleware always have one, elsewhere ok is false.
+func CurrentActor(c *gin.Context) (types.Actor, bool) {
+	value, ok := c.Get(actorKey)
+	if !ok {
+		return types.Actor{}, false
+	}
+	actor, ok := value.(types.Actor)
+	return actor, ok
+}
+
+// RequireActor returns the authenticated doctor making the request.
+// When there is none it responds with 401 Unauthorized and ok is false.
+func RequireActor(c *gin.Context) (types.Actor, bool) {
+	actor, ok := CurrentActor(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		c.Abort()
+	}
+	return actor, ok
+}
+
+// HasPermission reports whether the actor's role grants the permission
+func HasPermission(actor types.Actor, permission string) bool {
+	for _, granted := range actor.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission only lets through requests of doctors whose role grants the permission,
+// it is used after AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor, ok := RequireActor(c)
+		if !ok {
+			return
+		}
+		if !HasPermission(actor, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied: " + permission + " is required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"cema_backend/logging"
+	"cema_backend/types"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader is the header integrations send their API key in, instead of an access token
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyScheme starts every API key so leaked keys are easy to recognise and search for
+const apiKeyScheme = "cema_"
+
+// apiKeys checks the API keys of integrations, nil until UseAPIKeys is called
+var apiKeys types.APIKeyStore
+
+// UseAPIKeys makes AuthMiddleware accept API keys alongside access tokens
+func UseAPIKeys(store types.APIKeyStore) {
+	apiKeys = store
+}
+
+// NewAPIKey generates an API key to hand out once, along with its prefix to tell it apart in listings
+// and the hash of it to store in its place
+func NewAPIKey() (key string, prefix string, hash string, err error) {
+	buf := make([]byte, 4+32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	prefix = apiKeyScheme + hex.EncodeToString(buf[:4])
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(buf[4:])
+	return key, prefix, HashToken(key), nil
+}
+
+// ValidAllowedIP reports whether an entry of an IP allowlist is an IP address or a CIDR range
+func ValidAllowedIP(entry string) bool {
+	if strings.Contains(entry, "/") {
+		_, _, err := net.ParseCIDR(entry)
+		return err == nil
+	}
+	return net.ParseIP(entry) != nil
+}
+
+// TrustProxies makes the router take the client's address from X-Forwarded-For only on requests from the proxies,
+// comma separated IP addresses and CIDR ranges. With none every request uses the address of the connection,
+// so IP allowlists and login throttling cannot be dodged with a forged header.
+func TrustProxies(router *gin.Engine, proxies string) error {
+	trusted := []string{}
+	for _, entry := range strings.Split(proxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !ValidAllowedIP(entry) {
+			return fmt.Errorf("trusted proxy %q is not an IP address or CIDR range", entry)
+		}
+		trusted = append(trusted, entry)
+	}
+	if len(trusted) == 0 {
+		return router.SetTrustedProxies(nil)
+	}
+	return router.SetTrustedProxies(trusted)
+}
+
+// IPAllowed reports whether an IP address is in an allowlist of IP addresses and CIDR ranges,
+// an empty allowlist allows every address
+func IPAllowed(allowed []string, ipAddress string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey checks the API key of a request and returns the service account of the key,
+// carrying the key's permissions. When the key is refused it responds and ok is false.
+func authenticateAPIKey(c *gin.Context, key string) (types.Actor, bool) {
+	if apiKeys == nil || !strings.HasPrefix(key, apiKeyScheme) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return types.Actor{}, false
+	}
+
+	apiKey, actor, err := apiKeys.AuthenticateAPIKey(HashToken(key))
+	if err != nil {
+		if err.Error() == "api key is invalid" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		} else {
+			logging.Error("Failed to check API key: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking API key"})
+		}
+		c.Abort()
+		return types.Actor{}, false
+	}
+
+	ipAddress := c.ClientIP()
+	if !IPAllowed(apiKey.AllowedIPs, ipAddress) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key cannot be used from this IP address"})
+		c.Abort()
+		return types.Actor{}, false
+	}
+
+	// A failure to record the use does not turn the request away
+	if err := apiKeys.MarkAPIKeyUsed(apiKey.ID, ipAddress); err != nil {
+		logging.Error("Failed to record API key use: " + err.Error())
+	}
+	return actor, true
+}
+
+// RejectAPIKeys only lets through requests of doctors signed in with an access token,
+// for routes about their own account. It is used after AuthMiddleware.
+func RejectAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor, ok := RequireActor(c)
+		if !ok {
+			return
+		}
+		if actor.APIKeyID != 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used here"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
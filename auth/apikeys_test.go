@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"cema_backend/types"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// testAPIKeys is an APIKeyStore with the keys by their hash, recording the uses
+type testAPIKeys struct {
+	keys map[string]types.APIKey
+	used []string
+}
+
+func (s *testAPIKeys) AuthenticateAPIKey(keyHash string) (types.APIKey, types.Actor, error) {
+	apiKey, ok := s.keys[keyHash]
+	if !ok {
+		return types.APIKey{}, types.Actor{}, errors.New("api key is invalid")
+	}
+	actor := types.Actor{ID: apiKey.AccountID, Email: apiKey.Prefix + "@api-keys.invalid", Role: "integration",
+		Permissions: apiKey.Permissions, APIKeyID: apiKey.ID}
+	return apiKey, actor, nil
+}
+
+func (s *testAPIKeys) MarkAPIKeyUsed(apiKeyID int, ipAddress string) error {
+	s.used = append(s.used, ipAddress)
+	return nil
+}
+
+func TestAuthMiddlewareAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useTestKeys(t)
+
+	key, prefix, hash, err := NewAPIKey()
+	require.NoError(t, err)
+	require.NotEqual(t, key, hash)
+	restricted, _, restrictedHash, err := NewAPIKey()
+	require.NoError(t, err)
+
+	store := &testAPIKeys{keys: map[string]types.APIKey{
+		hash:           {ID: 1, Prefix: prefix, AccountID: 40, Permissions: []string{"observations:write"}},
+		restrictedHash: {ID: 2, AccountID: 41, Permissions: []string{"observations:write"}, AllowedIPs: []string{"10.0.4.0/24", "192.168.1.20"}},
+	}}
+	UseAPIKeys(store)
+	defer UseAPIKeys(nil)
+
+	var actor types.Actor
+	router := gin.New()
+	require.NoError(t, TrustProxies(router, ""))
+	router.Use(AuthMiddleware())
+	router.POST("/observations", RequirePermission("observations:write"), func(c *gin.Context) {
+		actor, _ = CurrentActor(c)
+		c.Status(http.StatusOK)
+	})
+	router.GET("/clients", RequirePermission("clients:read"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.PUT("/password", RejectAPIKeys(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// forwardedFor is the X-Forwarded-For header of the requests, none when empty
+	forwardedFor := ""
+	request := func(method, path, key, ipAddress string) int {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set(APIKeyHeader, key)
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		req.RemoteAddr = ipAddress + ":40000"
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	// Test case: The key acts as its service account with the permissions of the key only, and its use is recorded
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/observations", key, "10.0.9.1"))
+	require.Equal(t, 40, actor.ID)
+	require.Equal(t, 1, actor.APIKeyID)
+	require.Equal(t, []string{"10.0.9.1"}, store.used)
+	require.Equal(t, http.StatusForbidden, request(http.MethodGet, "/clients", key, "10.0.9.1"))
+
+	// Test case: Keys cannot be used for the routes of a doctor's own account
+	require.Equal(t, http.StatusForbidden, request(http.MethodPut, "/password", key, "10.0.9.1"))
+
+	// Test case: Unknown, revoked or expired keys are refused
+	require.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/observations", key+"x", "10.0.9.1"))
+	require.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/observations", "not-a-key", "10.0.9.1"))
+
+	// Test case: Keys with an allowlist are only accepted from the allowed addresses
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/observations", restricted, "10.0.4.17"))
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/observations", restricted, "192.168.1.20"))
+	require.Equal(t, http.StatusForbidden, request(http.MethodPost, "/observations", restricted, "192.168.1.21"))
+
+	// Test case: Without trusted proxies an allowed address in X-Forwarded-For does not get a key in
+	forwardedFor = "10.0.4.17"
+	require.Equal(t, http.StatusForbidden, request(http.MethodPost, "/observations", restricted, "192.168.1.21"))
+
+	// Test case: Behind a trusted proxy the forwarded address is the one checked
+	require.NoError(t, TrustProxies(router, "172.16.0.0/12, 192.168.1.21"))
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/observations", restricted, "192.168.1.21"))
+	forwardedFor = "203.0.113.9"
+	require.Equal(t, http.StatusForbidden, request(http.MethodPost, "/observations", restricted, "172.16.0.5"))
+
+	// Test case: Entries that are not addresses are refused
+	require.Error(t, TrustProxies(router, "proxy.rfh.local"))
+}
+
+func TestIPAllowed(t *testing.T) {
+	require.True(t, IPAllowed(nil, "203.0.113.9"))
+	require.True(t, IPAllowed([]string{"2001:db8::/32"}, "2001:db8::1"))
+	require.False(t, IPAllowed([]string{"2001:db8::/32"}, "10.0.0.1"))
+	require.False(t, IPAllowed([]string{"10.0.0.1"}, "not-an-ip"))
+
+	require.True(t, ValidAllowedIP("10.0.4.0/24"))
+	require.False(t, ValidAllowedIP("10.0.4.0/33"))
+	require.False(t, ValidAllowedIP("lab.rfh.local"))
+}
@@ -1,36 +1,43 @@
 package auth
 
 import (
+	"cema_backend/logging"
+	"cema_backend/types"
+	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 )
 
-// CreateJWT generates a JWT token for a given email.
-// It takes a secret key and an email as input and returns the signed token or an error.
-func CreateJWT(secret []byte, email string) (string, error) {
-	// Define the token claims
-	claims := jwt.MapClaims{
-		"email": email,
-		"exp":   time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
-	}
+// keys signs and verifies the access tokens, nil until UseKeys is called
+var keys *Keys
 
-	// Create a new token with the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// UseKeys sets the keys access tokens are signed and verified with
+func UseKeys(k *Keys) {
+	keys = k
+}
 
-	// Sign the token with the secret key
-	signedToken, err := token.SignedString(secret)
-	if err != nil {
-		return "", err
+// CreateJWT generates a JWT access token for the given doctor's session.
+// It takes the doctor's identity and how long the token is valid for as input
+// and returns the token signed with the current key or an error.
+func CreateJWT(actor types.Actor, ttl time.Duration) (string, error) {
+	if keys == nil {
+		return "", fmt.Errorf("no JWT signing key is configured")
 	}
+	return keys.Sign(actor, ttl)
+}
 
-	return signedToken, nil
+// JWKS handles publishing the public keys tokens are signed with, for other services to verify them
+func JWKS(c *gin.Context) {
+	jwks := []JWK{}
+	if keys != nil {
+		jwks = keys.JWKS()
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": jwks})
 }
 
 // HashPassword hashes a plain text password using bcrypt.
@@ -52,8 +59,27 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+// sessions checks the session of each token, nil until UseSessions is called
+var sessions types.SessionStore
+
+// UseSessions makes AuthMiddleware check that the session of a token has not been revoked
+func UseSessions(store types.SessionStore) {
+	sessions = store
+}
+
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Integrations authenticate with an API key, acting as the service account of the key
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			actor, ok := authenticateAPIKey(c, key)
+			if !ok {
+				return
+			}
+			SetActor(c, actor)
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
@@ -61,17 +87,38 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		tokenString, ok := strings.CutPrefix(authHeader, "Bearer ")
+		if !ok || keys == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
 
-		if err != nil || !token.Valid {
+		// Make the authenticated doctor available to the handlers
+		actor, err := keys.Parse(tokenString)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
+		// Tokens of sessions that were logged out, revoked or have expired are turned away before the tokens expire
+		if sessions != nil {
+			active, err := sessions.SessionActive(actor.SessionID)
+			if err != nil {
+				logging.Error("Failed to check session: " + err.Error())
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking session"})
+				c.Abort()
+				return
+			}
+			if !active {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has ended"})
+				c.Abort()
+				return
+			}
+		}
+		SetActor(c, actor)
+
 		c.Next()
 	}
 }
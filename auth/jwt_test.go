@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"cema_backend/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+// testSecret is the HS256 secret tokens of the tests are signed with
+const testSecret = "a-test-secret-of-at-least-32-bytes"
+
+// useTestKeys signs and verifies the tokens of a test with the test secret
+func useTestKeys(t *testing.T) {
+	keys, err := LoadKeys(KeyConfig{Issuer: "cema_backend", Audience: "cema_backend", KeyID: "test", Secret: testSecret})
+	require.NoError(t, err)
+	UseKeys(keys)
+	t.Cleanup(func() { UseKeys(nil) })
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useTestKeys(t)
+
+	router := gin.New()
+	router.Use(AuthMiddleware())
+	router.GET("/me", func(c *gin.Context) {
+		actor, ok := RequireActor(c)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, actor)
+	})
+
+	// Test case: The doctor the token was issued to is available to the handlers
+	doctor := types.Actor{ID: 7, Email: "stan@rfh.com", Role: "doctor", Permissions: []string{"clients:read"}}
+	token, err := CreateJWT(doctor, time.Minute)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"id": 7, "email": "stan@rfh.com", "role": "doctor", "permissions": ["clients:read"]}`, resp.Body.String())
+
+	// Test case: Tokens without the doctor's id are rejected
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"email": "stan@rfh.com",
+		"iss":   "cema_backend",
+		"aud":   "cema_backend",
+		"nbf":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	legacy.Header["kid"] = "test"
+	signed, err := legacy.SignedString([]byte(testSecret))
+	require.NoError(t, err)
+
+	req, _ = http.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		SetActor(c, types.Actor{ID: 7, Email: "nurse@rfh.com", Role: "nurse", Permissions: []string{"clients:read", "clients:update"}})
+	})
+	router.GET("/clients", RequirePermission("clients:read"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.DELETE("/clients/:id", RequirePermission("clients:delete"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// Test case: A permission of the role lets the request through
+	req, _ := http.NewRequest(http.MethodGet, "/clients", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: A permission the role does not grant is forbidden
+	req, _ = http.NewRequest(http.MethodDelete, "/clients/3", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusForbidden, resp.Code)
+}
+
+// revokedSessions is a SessionStore with some sessions revoked
+type revokedSessions map[string]bool
+
+func (r revokedSessions) SessionActive(sessionID string) (bool, error) {
+	return !r[sessionID], nil
+}
+
+func TestAuthMiddlewareSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useTestKeys(t)
+	UseSessions(revokedSessions{"revoked-session": true})
+	defer UseSessions(nil)
+
+	router := gin.New()
+	router.Use(AuthMiddleware())
+	router.GET("/me", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// Test case: Tokens of active sessions are accepted, those of revoked sessions are not
+	for session, code := range map[string]int{"active-session": http.StatusOK, "revoked-session": http.StatusUnauthorized} {
+		token, err := CreateJWT(types.Actor{ID: 7, Email: "stan@rfh.com", SessionID: session}, time.Minute)
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, code, resp.Code, session)
+	}
+}
@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"cema_backend/types"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// clockSkew is how far either side of nbf and exp a token is still accepted, for services whose clocks drift
+	clockSkew = 30 * time.Second
+	// minSecretLength is the shortest HS256 secret accepted, 256 bits
+	minSecretLength = 32
+	// minRSABits is the smallest RSA key accepted
+	minRSABits = 2048
+)
+
+// KeyConfig is how access tokens are signed and verified, from the configuration
+type KeyConfig struct {
+	Issuer   string
+	Audience string
+	// KeyID is the kid of the signing key, sent in the header of each token
+	KeyID string
+	// Secret signs with HS256, PrivateKeyFile signs with EdDSA or RS256 according to the PEM key in it.
+	// Exactly one of them has to be set.
+	Secret         string
+	PrivateKeyFile string
+	// PreviousKeys are retired keys still accepted until the tokens they signed expire, separated by commas.
+	// Each is kid=secret for an HS256 secret or kid=@file for a PEM public key.
+	PreviousKeys string
+}
+
+// key is a key tokens are signed or verified with
+type key struct {
+	id     string
+	method jwt.SigningMethod
+	// signing is only set for the current key
+	signing   interface{}
+	verifying interface{}
+}
+
+// Keys signs access tokens with the current key and verifies those signed with any of its keys
+type Keys struct {
+	issuer   string
+	audience string
+	current  *key
+	keys     map[string]*key
+	methods  []string
+}
+
+// LoadKeys loads the signing key and the previous keys of the configuration.
+// It fails when no signing key is configured, so the server does not start without one.
+func LoadKeys(config KeyConfig) (*Keys, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, fmt.Errorf("JWT issuer and audience are required")
+	}
+	if config.KeyID == "" {
+		return nil, fmt.Errorf("JWT key id is required")
+	}
+
+	var current *key
+	var err error
+	switch {
+	case config.Secret != "" && config.PrivateKeyFile != "":
+		return nil, fmt.Errorf("set either JWT_SECRET or JWT_PRIVATE_KEY_FILE, not both")
+	case config.Secret != "":
+		current, err = secretKey(config.KeyID, config.Secret)
+	case config.PrivateKeyFile != "":
+		current, err = privateKey(config.KeyID, config.PrivateKeyFile)
+	default:
+		return nil, fmt.Errorf("no JWT signing key is configured, set JWT_SECRET or JWT_PRIVATE_KEY_FILE")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &Keys{issuer: config.Issuer, audience: config.Audience, current: current, keys: map[string]*key{current.id: current}}
+	for _, entry := range strings.Split(config.PreviousKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, value, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || value == "" {
+			return nil, fmt.Errorf("previous JWT key %q is not kid=secret or kid=@file", entry)
+		}
+		if _, exists := keys.keys[id]; exists {
+			return nil, fmt.Errorf("JWT key id %q is used more than once", id)
+		}
+
+		var previous *key
+		if file, isFile := strings.CutPrefix(value, "@"); isFile {
+			previous, err = publicKey(id, file)
+		} else {
+			previous, err = secretKey(id, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Only the current key signs
+		previous.signing = nil
+		keys.keys[id] = previous
+	}
+
+	// Only the algorithms of the configured keys are accepted
+	seen := map[string]bool{}
+	for _, k := range keys.keys {
+		if alg := k.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			keys.methods = append(keys.methods, alg)
+		}
+	}
+	sort.Strings(keys.methods)
+	return keys, nil
+}
+
+// secretKey makes an HS256 key of a secret
+func secretKey(id, secret string) (*key, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("JWT secret of key %q must be at least %d bytes", id, minSecretLength)
+	}
+	return &key{id: id, method: jwt.SigningMethodHS256, signing: []byte(secret), verifying: []byte(secret)}, nil
+}
+
+// privateKey loads an Ed25519 or RSA private key from a PEM file, signing with EdDSA or RS256
+func privateKey(id, file string) (*key, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT private key %s: %w", file, err)
+	}
+
+	switch private := parsed.(type) {
+	case ed25519.PrivateKey:
+		return &key{id: id, method: jwt.SigningMethodEdDSA, signing: private, verifying: private.Public()}, nil
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("JWT RSA key %s must be at least %d bits", file, minRSABits)
+		}
+		return &key{id: id, method: jwt.SigningMethodRS256, signing: private, verifying: &private.PublicKey}, nil
+	}
+	return nil, fmt.Errorf("JWT private key %s is not an Ed25519 or RSA key", file)
+}
+
+// publicKey loads an Ed25519 or RSA public key from a PEM file, verifying EdDSA or RS256
+func publicKey(id, file string) (*key, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PUBLIC KEY" {
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT public key %s: %w", file, err)
+	}
+
+	switch public := parsed.(type) {
+	case ed25519.PublicKey:
+		return &key{id: id, method: jwt.SigningMethodEdDSA, verifying: public}, nil
+	case *rsa.PublicKey:
+		return &key{id: id, method: jwt.SigningMethodRS256, verifying: public}, nil
+	}
+	return nil, fmt.Errorf("JWT public key %s is not an Ed25519 or RSA key", file)
+}
+
+// readPEM reads the first PEM block of a file
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT key %s is not PEM encoded", file)
+	}
+	return block, nil
+}
+
+// Sign issues an access token for the actor valid for ttl, signed with the current key
+func (k *Keys) Sign(actor types.Actor, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"id":          actor.ID,
+		"email":       actor.Email,
+		"role":        actor.Role,
+		"permissions": actor.Permissions,
+		"sid":         actor.SessionID,
+		"iss":         k.issuer,
+		"aud":         k.audience,
+		"iat":         now.Unix(),
+		"nbf":         now.Unix(),
+		"exp":         now.Add(ttl).Unix(),
+	}
+	token := jwt.NewWithClaims(k.current.method, claims)
+	token.Header["kid"] = k.current.id
+	return token.SignedString(k.current.signing)
+}
+
+// Parse verifies an access token and returns the doctor it was issued to.
+// The token has to name one of the keys in kid, be signed with that key's algorithm,
+// come from the issuer for the audience and be within its nbf and exp.
+func (k *Keys) Parse(tokenString string) (types.Actor, error) {
+	parser := jwt.Parser{ValidMethods: k.methods, SkipClaimsValidation: true}
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+		key, ok := k.keys[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", id)
+		}
+		// The algorithm is pinned to the key, a token cannot choose how it is checked
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), id)
+		}
+		return key.verifying, nil
+	})
+	if err != nil {
+		return types.Actor{}, fmt.Errorf("token is invalid: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return types.Actor{}, fmt.Errorf("token is invalid")
+	}
+	now := time.Now()
+	switch {
+	case !claims.VerifyExpiresAt(now.Add(-clockSkew).Unix(), true):
+		return types.Actor{}, fmt.Errorf("token has expired")
+	case !claims.VerifyNotBefore(now.Add(clockSkew).Unix(), true):
+		return types.Actor{}, fmt.Errorf("token is not valid yet")
+	case !claims.VerifyIssuer(k.issuer, true):
+		return types.Actor{}, fmt.Errorf("token issuer is invalid")
+	case !claims.VerifyAudience(k.audience, true):
+		return types.Actor{}, fmt.Errorf("token audience is invalid")
+	}
+
+	// Tokens issued before the id was added to the claims have to be renewed by logging in again
+	actor, ok := actorFromClaims(claims)
+	if !ok {
+		return types.Actor{}, fmt.Errorf("token does not identify a doctor")
+	}
+	return actor, nil
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// Curve and X are set for Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JWKS returns the public keys for other services to verify tokens with, the current key first.
+// HS256 secrets are never published.
+func (k *Keys) JWKS() []JWK {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		if id != k.current.id {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	ids = append([]string{k.current.id}, ids...)
+
+	jwks := []JWK{}
+	for _, id := range ids {
+		key := k.keys[id]
+		switch public := key.verifying.(type) {
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{KeyType: "OKP", KeyID: id, Algorithm: "EdDSA", Use: "sig", Curve: "Ed25519",
+				X: base64.RawURLEncoding.EncodeToString(public)})
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{KeyType: "RSA", KeyID: id, Algorithm: "RS256", Use: "sig",
+				N: base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())})
+		}
+	}
+	return jwks
+}
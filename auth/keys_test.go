@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"cema_backend/types"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+// writeEd25519Key writes a new Ed25519 private key and its public key as PEM files
+func writeEd25519Key(t *testing.T) (privateFile, publicFile string) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privateFile = filepath.Join(dir, "jwt.pem")
+	publicFile = filepath.Join(dir, "jwt.pub.pem")
+	require.NoError(t, os.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600))
+	require.NoError(t, os.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0600))
+	return privateFile, publicFile
+}
+
+func TestLoadKeys(t *testing.T) {
+	config := KeyConfig{Issuer: "cema_backend", Audience: "cema_backend", KeyID: "test"}
+
+	// Test case: The server does not start without a signing key
+	_, err := LoadKeys(config)
+	require.ErrorContains(t, err, "no JWT signing key is configured")
+
+	// Test case: Short secrets are refused
+	config.Secret = "your_jwt_secret"
+	_, err = LoadKeys(config)
+	require.ErrorContains(t, err, "at least 32 bytes")
+
+	// Test case: A secret and a private key cannot both sign
+	privateFile, _ := writeEd25519Key(t)
+	config.Secret = testSecret
+	config.PrivateKeyFile = privateFile
+	_, err = LoadKeys(config)
+	require.Error(t, err)
+
+	// Test case: An Ed25519 private key signs with EdDSA
+	config.Secret = ""
+	keys, err := LoadKeys(config)
+	require.NoError(t, err)
+	require.Equal(t, []string{"EdDSA"}, keys.methods)
+
+	// Test case: Previous keys have to name their kid
+	config.PreviousKeys = testSecret
+	_, err = LoadKeys(config)
+	require.Error(t, err)
+}
+
+func TestKeysParse(t *testing.T) {
+	keys, err := LoadKeys(KeyConfig{Issuer: "cema_backend", Audience: "cema_backend", KeyID: "test", Secret: testSecret})
+	require.NoError(t, err)
+	doctor := types.Actor{ID: 7, Email: "stan@rfh.com", Role: "doctor", Permissions: []string{}}
+
+	// Test case: A token of the keys is accepted
+	token, err := keys.Sign(doctor, time.Minute)
+	require.NoError(t, err)
+	actor, err := keys.Parse(token)
+	require.NoError(t, err)
+	require.Equal(t, doctor, actor)
+
+	// sign signs claims as a token would be, with changes
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, changes jwt.MapClaims) string {
+		claims := jwt.MapClaims{
+			"id": 7, "email": "stan@rfh.com", "iss": "cema_backend", "aud": "cema_backend",
+			"nbf": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+
+	// Test case: Tokens for another audience, from another issuer, expired, not valid yet
+	// or missing a time claim are refused
+	for name, changes := range map[string]jwt.MapClaims{
+		"audience":    {"aud": "billing"},
+		"issuer":      {"iss": "someone-else"},
+		"expired":     {"exp": time.Now().Add(-time.Hour).Unix()},
+		"not yet":     {"nbf": time.Now().Add(time.Hour).Unix()},
+		"without exp": {"exp": nil},
+		"without nbf": {"nbf": nil},
+	} {
+		_, err := keys.Parse(sign(jwt.SigningMethodHS256, "test", []byte(testSecret), changes))
+		require.Error(t, err, name)
+	}
+
+	// Test case: Tokens of an unknown key, or without a kid, are refused
+	_, err = keys.Parse(sign(jwt.SigningMethodHS256, "other", []byte(testSecret), nil))
+	require.Error(t, err)
+	_, err = keys.Parse(sign(jwt.SigningMethodHS256, "", []byte(testSecret), nil))
+	require.Error(t, err)
+
+	// Test case: Unsigned tokens and other algorithms are refused
+	_, err = keys.Parse(sign(jwt.SigningMethodNone, "test", jwt.UnsafeAllowNoneSignatureType, nil))
+	require.Error(t, err)
+	_, err = keys.Parse(sign(jwt.SigningMethodHS512, "test", []byte(testSecret), nil))
+	require.Error(t, err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	privateFile, publicFile := writeEd25519Key(t)
+	old, err := LoadKeys(KeyConfig{Issuer: "cema_backend", Audience: "cema_backend", KeyID: "2025-01", PrivateKeyFile: privateFile})
+	require.NoError(t, err)
+	hmacOld, err := LoadKeys(KeyConfig{Issuer: "cema_backend", Audience: "cema_backend", KeyID: "2024-07", Secret: testSecret})
+	require.NoError(t, err)
+
+	// The new key signs, the old ones are still accepted
+	keys, err := LoadKeys(KeyConfig{
+		Issuer: "cema_backend", Audience: "cema_backend", KeyID: "2025-06", Secret: "a-new-secret-that-replaces-the-old-ones",
+		PreviousKeys: "2025-01=@" + publicFile + ", 2024-07=" + testSecret,
+	})
+	require.NoError(t, err)
+	doctor := types.Actor{ID: 7, Email: "stan@rfh.com"}
+
+	// Test case: Tokens signed with a previous key keep working
+	for _, previous := range []*Keys{old, hmacOld} {
+		token, err := previous.Sign(doctor, time.Minute)
+		require.NoError(t, err)
+		_, err = keys.Parse(token)
+		require.NoError(t, err)
+	}
+
+	// Test case: A token cannot be signed with HS256 using the public key of an EdDSA key
+	publicPEM, err := os.ReadFile(publicFile)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id": 7, "email": "stan@rfh.com", "iss": "cema_backend", "aud": "cema_backend",
+		"nbf": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	forged.Header["kid"] = "2025-01"
+	signed, err := forged.SignedString(publicPEM)
+	require.NoError(t, err)
+	_, err = keys.Parse(signed)
+	require.Error(t, err)
+
+	// Test case: Only public keys are published, never the secrets
+	jwks := keys.JWKS()
+	require.Len(t, jwks, 1)
+	require.Equal(t, "2025-01", jwks[0].KeyID)
+	require.Equal(t, "OKP", jwks[0].KeyType)
+	require.Equal(t, "Ed25519", jwks[0].Curve)
+}
@@ -0,0 +1,21 @@
+package auth
+
+import "time"
+
+// LoginBackoff is how long the next login of an account or IP address is blocked for after a number of
+// failed logins in a row. The wait doubles with each failure from one second, reaching the threshold
+// locks it out for the lockout duration.
+func LoginBackoff(failures, threshold int, lockout time.Duration) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	if failures >= threshold {
+		return lockout
+	}
+	wait := time.Second << (failures - 1)
+	// The wait never goes beyond a lockout, including when the shift overflows
+	if wait <= 0 || wait > lockout {
+		return lockout
+	}
+	return wait
+}
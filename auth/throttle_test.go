@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	lockout := 15 * time.Minute
+
+	// Test case: The wait doubles with each failure
+	require.Equal(t, time.Duration(0), LoginBackoff(0, 5, lockout))
+	require.Equal(t, time.Second, LoginBackoff(1, 5, lockout))
+	require.Equal(t, 2*time.Second, LoginBackoff(2, 5, lockout))
+	require.Equal(t, 8*time.Second, LoginBackoff(4, 5, lockout))
+
+	// Test case: Reaching the threshold locks out, and failures after it stay locked out
+	require.Equal(t, lockout, LoginBackoff(5, 5, lockout))
+	require.Equal(t, lockout, LoginBackoff(6, 5, lockout))
+
+	// Test case: A high threshold still never waits longer than a lockout
+	require.Equal(t, lockout, LoginBackoff(15, 20, lockout))
+	require.Equal(t, lockout, LoginBackoff(70, 100, lockout))
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	// The SHA-1 secret of the RFC 6238 test vectors
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	// Test case: The codes of the test vectors, the last 6 digits of the 8 digit codes
+	step, ok := ValidateTOTP(secret, "287082", time.Unix(59, 0), 0)
+	require.True(t, ok)
+	require.Equal(t, int64(1), step)
+
+	_, ok = ValidateTOTP(secret, "081804", time.Unix(1111111109, 0), 0)
+	require.True(t, ok)
+
+	// Test case: The code of the previous period is still accepted for clock drift
+	_, ok = ValidateTOTP(secret, "081804", time.Unix(1111111109+30, 0), 0)
+	require.True(t, ok)
+
+	// Test case: A code that was already used is refused
+	_, ok = ValidateTOTP(secret, "287082", time.Unix(59, 0), 1)
+	require.False(t, ok)
+
+	// Test case: A wrong code is refused
+	_, ok = ValidateTOTP(secret, "123456", time.Unix(59, 0), 0)
+	require.False(t, ok)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+
+	// Test case: A code is found by its hash however it is typed
+	require.Equal(t, hashes[0], HashRecoveryCode(codes[0]))
+	require.Equal(t, hashes[0], HashRecoveryCode(strings.ToUpper(strings.Replace(codes[0], "-", " ", 1))))
+	require.NotEqual(t, hashes[0], hashes[1])
+}
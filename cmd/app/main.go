@@ -1,11 +1,21 @@
 package app
 
 import (
+	"cema_backend/auth"
+	"cema_backend/config"
 	"cema_backend/logging"
+	"cema_backend/notify"
 	"cema_backend/service/clients"
+	"cema_backend/service/diagnoses"
 	"cema_backend/service/doctors"
+	"cema_backend/service/documents"
+	"cema_backend/service/encounters"
+	"cema_backend/service/formulary"
+	"cema_backend/service/observations"
+	"cema_backend/service/pharmacy"
 	"cema_backend/service/programs"
 	"database/sql"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -27,7 +37,25 @@ func NewAPIServer(addr string, db *sql.DB) *APIServer {
 
 // Run starts the API server and sets up the routes
 func (s *APIServer) Run() error {
+	// Access tokens are signed and verified with the configured keys, the server does not start without one
+	keys, err := auth.LoadKeys(auth.KeyConfig{
+		Issuer:         config.Envs.JWTIssuer,
+		Audience:       config.Envs.JWTAudience,
+		KeyID:          config.Envs.JWTKeyID,
+		Secret:         config.Envs.JWTSecret,
+		PrivateKeyFile: config.Envs.JWTPrivateKeyFile,
+		PreviousKeys:   config.Envs.JWTPreviousKeys,
+	})
+	if err != nil {
+		return err
+	}
+	auth.UseKeys(keys)
+
 	router := gin.Default()
+	// The client's address is only taken from X-Forwarded-For behind the configured proxies
+	if err := auth.TrustProxies(router, config.Envs.TrustedProxies); err != nil {
+		return err
+	}
 
 	// base case for the server to check if the server is reachable
 	router.GET("/ping", func(c *gin.Context) {
@@ -35,15 +63,27 @@ func (s *APIServer) Run() error {
 			"message": "pong",
 		})
 	})
+	// Public keys for other services to verify access tokens with
+	router.GET("/.well-known/jwks.json", auth.JWKS)
+
 	// CORS configuration, allows all for now
-	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true
-	router.Use(cors.New(config))
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	router.Use(cors.New(corsConfig))
 
 	// Register Doctor routes
 	// Each service has its own store and handler but they all use the same database connection
 	doctorStore := doctors.NewStore(s.db)
-	doctorHandler := doctors.NewHandler(doctorStore)
+	// Tokens are only accepted while their session has not been revoked
+	auth.UseSessions(doctorStore)
+	// Integrations can authenticate with API keys instead of access tokens
+	auth.UseAPIKeys(doctorStore)
+	// New passwords have to meet the password policy, reset links are sent with the notifier
+	passwords, err := doctors.LoadPasswordPolicy(config.Envs.PasswordMinLength, config.Envs.PasswordHistory, config.Envs.PasswordBlocklistFile)
+	if err != nil {
+		return err
+	}
+	doctorHandler := doctors.NewHandler(doctorStore, passwords, notify.New(config.Envs.NotifyFile))
 	doctorRoutes := router.Group("/doctors")
 	doctorHandler.RegisterRoutes(doctorRoutes)
 
@@ -55,10 +95,63 @@ func (s *APIServer) Run() error {
 
 	//Register Client routes
 	clientStore := clients.NewStore(s.db)
+	// Prescriptions are checked against the drug interaction rules
+	if err := clients.LoadInteractionRules(clientStore, config.Envs.InteractionRulesFile); err != nil {
+		return err
+	}
 	clientHandler := clients.NewHandler(clientStore)
 	clientRoutes := router.Group("/clients")
 	clientHandler.RegisterRoutes(clientRoutes)
 
+	// Register Observation routes
+	observationStore := observations.NewStore(s.db)
+	observationHandler := observations.NewHandler(observationStore)
+	observationRoutes := router.Group("/observations")
+	observationHandler.RegisterRoutes(observationRoutes)
+
+	// Register Encounter routes
+	encounterStore := encounters.NewStore(s.db)
+	encounterHandler := encounters.NewHandler(encounterStore)
+	encounterRoutes := router.Group("/encounters")
+	encounterHandler.RegisterRoutes(encounterRoutes)
+
+	// Register Diagnosis routes, diagnoses are coded with the ICD catalog
+	catalog, err := diagnoses.LoadCatalog(config.Envs.ICD10CodesFile, config.Envs.ICD11CodesFile)
+	if err != nil {
+		return err
+	}
+	diagnosisStore := diagnoses.NewStore(s.db)
+	diagnosisHandler := diagnoses.NewHandler(diagnosisStore, catalog)
+	diagnosisRoutes := router.Group("/diagnoses")
+	diagnosisHandler.RegisterRoutes(diagnosisRoutes)
+
+	// Register Formulary routes
+	formularyStore := formulary.NewStore(s.db)
+	formularyHandler := formulary.NewHandler(formularyStore)
+	formularyRoutes := router.Group("/formulary")
+	formularyHandler.RegisterRoutes(formularyRoutes)
+
+	// Register Pharmacy routes, dispensing issues stock from the formulary drugs
+	pharmacyStore := pharmacy.NewStore(s.db)
+	pharmacyHandler := pharmacy.NewHandler(pharmacyStore)
+	pharmacyRoutes := router.Group("/pharmacy")
+	pharmacyHandler.RegisterRoutes(pharmacyRoutes)
+
+	// Register Document routes, printed documents carry the facility letterhead
+	documentStore := documents.NewStore(s.db)
+	documentHandler := documents.NewHandler(documentStore, clientStore, documents.Letterhead{
+		Name:      config.Envs.FacilityName,
+		Address:   config.Envs.FacilityAddress,
+		Phone:     config.Envs.FacilityPhone,
+		Email:     config.Envs.FacilityEmail,
+		VerifyURL: config.Envs.DocumentVerifyURL,
+	})
+	documentRoutes := router.Group("/documents")
+	documentHandler.RegisterRoutes(documentRoutes)
+
+	// Archived clients are purged once a day after the retention period
+	clients.StartRetentionJob(clientStore, config.Envs.ClientRetentionDays, 24*time.Hour)
+
 	logging.Info("Listening on port: " + s.addr)
 	return router.Run(s.addr)
 }
@@ -3,6 +3,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -16,6 +17,47 @@ type Config struct {
 	DBAddress  string `env:"DB_ADDRESS" envDefault:"localhost"`
 	DBPort     string `env:"DB_PORT" envDefault:"3306"`
 	DBName     string `env:"DB_NAME" envDefault:"your_db_name"`
+	// Number of days archived clients are kept before being purged, 0 disables the purge
+	ClientRetentionDays int `env:"CLIENT_RETENTION_DAYS" envDefault:"0"`
+	// Code files replacing the bundled ICD-10 catalog and adding ICD-11 codes, empty uses the bundled codes only
+	ICD10CodesFile string `env:"ICD10_CODES_FILE" envDefault:""`
+	ICD11CodesFile string `env:"ICD11_CODES_FILE" envDefault:""`
+	// Drug interaction rules replacing the bundled rules, empty uses the bundled rules
+	InteractionRulesFile string `env:"INTERACTION_RULES_FILE" envDefault:""`
+	// Facility letterhead printed on documents
+	FacilityName    string `env:"FACILITY_NAME" envDefault:"CEMA Health Facility"`
+	FacilityAddress string `env:"FACILITY_ADDRESS" envDefault:""`
+	FacilityPhone   string `env:"FACILITY_PHONE" envDefault:""`
+	FacilityEmail   string `env:"FACILITY_EMAIL" envDefault:""`
+	// Base URL the QR code of a document links to with the document id appended, empty encodes the id only
+	DocumentVerifyURL string `env:"DOCUMENT_VERIFY_URL" envDefault:""`
+	// Access tokens are signed with JWT_SECRET (HS256) or JWT_PRIVATE_KEY_FILE (Ed25519 or RSA), identified by JWT_KEY_ID.
+	// JWT_PREVIOUS_KEYS are retired keys still accepted, as kid=secret or kid=@public-key-file separated by commas.
+	JWTSecret         string `env:"JWT_SECRET" envDefault:""`
+	JWTPrivateKeyFile string `env:"JWT_PRIVATE_KEY_FILE" envDefault:""`
+	JWTKeyID          string `env:"JWT_KEY_ID" envDefault:"default"`
+	JWTPreviousKeys   string `env:"JWT_PREVIOUS_KEYS" envDefault:""`
+	JWTIssuer         string `env:"JWT_ISSUER" envDefault:"cema_backend"`
+	JWTAudience       string `env:"JWT_AUDIENCE" envDefault:"cema_backend"`
+	// Minutes an access token is valid for, and days a session can go without being refreshed
+	AccessTokenMinutes int `env:"ACCESS_TOKEN_MINUTES" envDefault:"15"`
+	RefreshTokenDays   int `env:"REFRESH_TOKEN_DAYS" envDefault:"7"`
+	// Failed logins of an account, or from an IP address, before it is locked out and for how many minutes
+	LoginLockoutThreshold   int `env:"LOGIN_LOCKOUT_THRESHOLD" envDefault:"5"`
+	LoginIPLockoutThreshold int `env:"LOGIN_IP_LOCKOUT_THRESHOLD" envDefault:"20"`
+	LoginLockoutMinutes     int `env:"LOGIN_LOCKOUT_MINUTES" envDefault:"15"`
+	// Password policy: fewest characters, number of previous passwords that cannot be used again
+	// and a list of breached passwords replacing the bundled list, empty uses the bundled list
+	PasswordMinLength     int    `env:"PASSWORD_MIN_LENGTH" envDefault:"10"`
+	PasswordHistory       int    `env:"PASSWORD_HISTORY" envDefault:"5"`
+	PasswordBlocklistFile string `env:"PASSWORD_BLOCKLIST_FILE" envDefault:""`
+	// Base URL of the password reset page with the reset token appended, empty sends the token only
+	PasswordResetURL string `env:"PASSWORD_RESET_URL" envDefault:""`
+	// File notifications such as password reset links are appended to, empty writes them to the log
+	NotifyFile string `env:"NOTIFY_FILE" envDefault:""`
+	// Reverse proxies whose X-Forwarded-For header gives the client's address, comma separated IP addresses
+	// and CIDR ranges. Empty trusts none and uses the address of the connection.
+	TrustedProxies string `env:"TRUSTED_PROXIES" envDefault:""`
 }
 
 var Envs = initConfig()
@@ -32,6 +74,36 @@ func initConfig() Config {
 		DBAddress:  getEnv("DB_ADDRESS", "localhost"),
 		DBPort:     getEnv("DB_PORT", "3306"),
 		DBName:     getEnv("DB_NAME", "your_db_name"),
+
+		ClientRetentionDays:  getEnvAsInt("CLIENT_RETENTION_DAYS", 0),
+		ICD10CodesFile:       getEnv("ICD10_CODES_FILE", ""),
+		ICD11CodesFile:       getEnv("ICD11_CODES_FILE", ""),
+		InteractionRulesFile: getEnv("INTERACTION_RULES_FILE", ""),
+		FacilityName:         getEnv("FACILITY_NAME", "CEMA Health Facility"),
+		FacilityAddress:      getEnv("FACILITY_ADDRESS", ""),
+		FacilityPhone:        getEnv("FACILITY_PHONE", ""),
+		FacilityEmail:        getEnv("FACILITY_EMAIL", ""),
+		DocumentVerifyURL:    getEnv("DOCUMENT_VERIFY_URL", ""),
+		JWTSecret:            getEnv("JWT_SECRET", ""),
+		JWTPrivateKeyFile:    getEnv("JWT_PRIVATE_KEY_FILE", ""),
+		JWTKeyID:             getEnv("JWT_KEY_ID", "default"),
+		JWTPreviousKeys:      getEnv("JWT_PREVIOUS_KEYS", ""),
+		JWTIssuer:            getEnv("JWT_ISSUER", "cema_backend"),
+		JWTAudience:          getEnv("JWT_AUDIENCE", "cema_backend"),
+		AccessTokenMinutes:   getEnvAsInt("ACCESS_TOKEN_MINUTES", 15),
+		RefreshTokenDays:     getEnvAsInt("REFRESH_TOKEN_DAYS", 7),
+
+		LoginLockoutThreshold:   getEnvAsInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+		LoginIPLockoutThreshold: getEnvAsInt("LOGIN_IP_LOCKOUT_THRESHOLD", 20),
+		LoginLockoutMinutes:     getEnvAsInt("LOGIN_LOCKOUT_MINUTES", 15),
+
+		PasswordMinLength:     getEnvAsInt("PASSWORD_MIN_LENGTH", 10),
+		PasswordHistory:       getEnvAsInt("PASSWORD_HISTORY", 5),
+		PasswordBlocklistFile: getEnv("PASSWORD_BLOCKLIST_FILE", ""),
+		PasswordResetURL:      getEnv("PASSWORD_RESET_URL", ""),
+		NotifyFile:            getEnv("NOTIFY_FILE", ""),
+
+		TrustedProxies: getEnv("TRUSTED_PROXIES", ""),
 	}
 }
 
@@ -42,3 +114,14 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getEnvAsInt retrieves an environment variable as an integer or returns a fallback value
+// if it is not set or is not a number
+func getEnvAsInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,34 @@
+// Package dbtest connects store tests to a migrated test database.
+package dbtest
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+// Open connects to the migrated database of TEST_DB_DSN, the test is skipped without one
+func Open(t *testing.T) *sql.DB {
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	require.NoError(t, err)
+	require.NoError(t, db.Ping())
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Doctor adds a staff account for the test and returns its id, it is removed when the test ends
+func Doctor(t *testing.T, db *sql.DB, email string) int {
+	result, err := db.Exec(`INSERT INTO doctors (firstname, lastname, email, password) VALUES ('Test', 'Doctor', ?, '')`, email)
+	require.NoError(t, err)
+	id, err := result.LastInsertId()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Exec(`DELETE FROM doctors WHERE id = ?`, id) })
+	return int(id)
+}
@@ -0,0 +1,9 @@
+ALTER TABLE clients DROP INDEX ft_clients_names;
+
+ALTER TABLE clients
+  DROP INDEX idx_clients_firstname_soundex,
+  DROP INDEX idx_clients_lastname_soundex,
+  DROP INDEX idx_clients_phonenumber_reversed,
+  DROP COLUMN firstname_soundex,
+  DROP COLUMN lastname_soundex,
+  DROP COLUMN phonenumber_reversed;
@@ -0,0 +1,10 @@
+ALTER TABLE clients
+  ADD COLUMN firstname_soundex VARCHAR(32) AS (SOUNDEX(firstname)) STORED,
+  ADD COLUMN lastname_soundex VARCHAR(32) AS (SOUNDEX(lastname)) STORED,
+  ADD COLUMN phonenumber_reversed VARCHAR(20) AS (REVERSE(phonenumber)) STORED,
+  ADD INDEX idx_clients_firstname_soundex (firstname_soundex),
+  ADD INDEX idx_clients_lastname_soundex (lastname_soundex),
+  ADD INDEX idx_clients_phonenumber_reversed (phonenumber_reversed);
+
+ALTER TABLE clients
+  ADD FULLTEXT INDEX ft_clients_names (firstname, lastname, emergency_contact);
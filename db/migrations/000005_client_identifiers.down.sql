@@ -0,0 +1,24 @@
+UPDATE prescriptions p
+JOIN clients c ON c.id = p.client_id
+SET p.client_phone = c.phonenumber;
+
+-- The parked prescriptions are put back as they were
+ALTER TABLE prescriptions
+  DROP FOREIGN KEY fk_prescriptions_client,
+  MODIFY client_id INT NULL;
+INSERT INTO prescriptions SELECT * FROM unmatched_prescriptions;
+DROP TABLE IF EXISTS unmatched_prescriptions;
+
+ALTER TABLE prescriptions
+  DROP COLUMN client_id,
+  MODIFY client_phone VARCHAR(15) NOT NULL;
+
+DROP TABLE IF EXISTS client_phones;
+
+ALTER TABLE clients
+  ADD COLUMN phonenumber_reversed VARCHAR(20) AS (REVERSE(phonenumber)) STORED,
+  ADD INDEX idx_clients_phonenumber_reversed (phonenumber_reversed);
+
+ALTER TABLE clients
+  DROP INDEX unique_client_uuid,
+  DROP COLUMN uuid;
@@ -0,0 +1,49 @@
+-- Every client gets a stable identifier that does not change with their phone number
+ALTER TABLE clients ADD COLUMN uuid CHAR(36) NULL AFTER id;
+UPDATE clients SET uuid = UUID() WHERE uuid IS NULL;
+ALTER TABLE clients
+  MODIFY uuid CHAR(36) NOT NULL,
+  ADD UNIQUE KEY unique_client_uuid (uuid);
+
+-- A client can have several phone numbers, one of them is the primary number
+-- which is also kept on clients.phonenumber
+CREATE TABLE IF NOT EXISTS client_phones (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  client_id INT NOT NULL,
+  phonenumber VARCHAR(20) NOT NULL,
+  is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+  phonenumber_reversed VARCHAR(20) AS (REVERSE(phonenumber)) STORED,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+  UNIQUE KEY unique_client_phone (phonenumber),
+  INDEX idx_client_phones_reversed (phonenumber_reversed)
+);
+
+-- Phone number search now goes through client_phones so that every number of a client is searchable
+ALTER TABLE clients
+  DROP INDEX idx_clients_phonenumber_reversed,
+  DROP COLUMN phonenumber_reversed;
+
+INSERT IGNORE INTO client_phones (client_id, phonenumber, is_primary)
+SELECT id, phonenumber, TRUE FROM clients WHERE phonenumber IS NOT NULL AND phonenumber <> '';
+
+-- Prescriptions reference the client instead of their phone number
+ALTER TABLE prescriptions
+  ADD COLUMN client_id INT NULL AFTER id,
+  MODIFY client_phone VARCHAR(15) NULL;
+
+UPDATE prescriptions p
+JOIN client_phones cp ON cp.phonenumber = p.client_phone
+SET p.client_id = cp.client_id
+WHERE p.client_id IS NULL;
+
+-- Prescriptions that could not be matched to a client are parked, as they were, for the records staff
+-- to match by hand. Their number is reported when the migration runs.
+CREATE TABLE IF NOT EXISTS unmatched_prescriptions LIKE prescriptions;
+INSERT INTO unmatched_prescriptions SELECT * FROM prescriptions WHERE client_id IS NULL;
+DELETE FROM prescriptions WHERE client_id IS NULL;
+SELECT COUNT(*) AS unmatched_prescriptions FROM unmatched_prescriptions;
+
+ALTER TABLE prescriptions
+  MODIFY client_id INT NOT NULL,
+  ADD CONSTRAINT fk_prescriptions_client FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE;
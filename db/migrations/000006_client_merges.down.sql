@@ -0,0 +1,4 @@
+ALTER TABLE clients
+  DROP FOREIGN KEY fk_clients_merged_into,
+  DROP COLUMN merged_into,
+  DROP COLUMN merged_at;
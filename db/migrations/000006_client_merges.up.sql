@@ -0,0 +1,5 @@
+-- A merged client is kept as a tombstone pointing at the client it was merged into
+ALTER TABLE clients
+  ADD COLUMN merged_into INT NULL,
+  ADD COLUMN merged_at TIMESTAMP NULL,
+  ADD CONSTRAINT fk_clients_merged_into FOREIGN KEY (merged_into) REFERENCES clients(id);
@@ -0,0 +1,4 @@
+ALTER TABLE clients
+  DROP INDEX idx_clients_archived_at,
+  DROP COLUMN archived_at,
+  DROP COLUMN archived_by;
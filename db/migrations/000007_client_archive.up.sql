@@ -0,0 +1,5 @@
+-- Deleting a client archives it, archived clients are purged after the retention period
+ALTER TABLE clients
+  ADD COLUMN archived_at TIMESTAMP NULL,
+  ADD COLUMN archived_by VARCHAR(255) NULL,
+  ADD INDEX idx_clients_archived_at (archived_at);
@@ -0,0 +1,18 @@
+ALTER TABLE clients ADD COLUMN age INT NULL AFTER weight;
+
+UPDATE clients
+SET age = TIMESTAMPDIFF(YEAR, date_of_birth, CURDATE())
+WHERE date_of_birth IS NOT NULL;
+
+ALTER TABLE clients
+  DROP INDEX idx_clients_date_of_birth,
+  DROP INDEX idx_clients_national_id,
+  DROP INDEX idx_clients_county,
+  DROP COLUMN date_of_birth,
+  DROP COLUMN dob_estimated,
+  DROP COLUMN sex,
+  DROP COLUMN national_id,
+  DROP COLUMN birth_certificate_number,
+  DROP COLUMN county,
+  DROP COLUMN sub_county,
+  DROP COLUMN ward;
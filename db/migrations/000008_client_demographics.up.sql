@@ -0,0 +1,21 @@
+-- Clients store their date of birth instead of an age that goes out of date,
+-- along with their sex, identification numbers and location
+ALTER TABLE clients
+  ADD COLUMN date_of_birth DATE NULL AFTER weight,
+  ADD COLUMN dob_estimated BOOLEAN NOT NULL DEFAULT FALSE AFTER date_of_birth,
+  ADD COLUMN sex VARCHAR(16) NULL AFTER dob_estimated,
+  ADD COLUMN national_id VARCHAR(20) NULL AFTER sex,
+  ADD COLUMN birth_certificate_number VARCHAR(20) NULL AFTER national_id,
+  ADD COLUMN county VARCHAR(100) NULL AFTER birth_certificate_number,
+  ADD COLUMN sub_county VARCHAR(100) NULL AFTER county,
+  ADD COLUMN ward VARCHAR(100) NULL AFTER sub_county,
+  ADD INDEX idx_clients_date_of_birth (date_of_birth),
+  ADD INDEX idx_clients_national_id (national_id),
+  ADD INDEX idx_clients_county (county);
+
+-- The age captured at registration only gives an estimated date of birth
+UPDATE clients
+SET date_of_birth = DATE_SUB(DATE(created_at), INTERVAL age YEAR), dob_estimated = TRUE
+WHERE age IS NOT NULL AND age > 0;
+
+ALTER TABLE clients DROP COLUMN age;
@@ -0,0 +1,9 @@
+ALTER TABLE clients
+  ADD COLUMN height FLOAT NULL AFTER phonenumber,
+  ADD COLUMN weight FLOAT NULL AFTER height;
+
+UPDATE clients c SET
+  height = (SELECT o.height FROM observations o WHERE o.client_id = c.id AND o.height IS NOT NULL ORDER BY o.observed_at DESC LIMIT 1),
+  weight = (SELECT o.weight FROM observations o WHERE o.client_id = c.id AND o.weight IS NOT NULL ORDER BY o.observed_at DESC LIMIT 1);
+
+DROP TABLE IF EXISTS observations;
@@ -0,0 +1,29 @@
+-- Vitals and measurements are recorded over time instead of being overwritten on the client
+CREATE TABLE IF NOT EXISTS observations (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  client_id INT NOT NULL,
+  doctor_id INT NULL,
+  observed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  height FLOAT NULL,        -- cm
+  weight FLOAT NULL,        -- kg
+  systolic_bp INT NULL,     -- mmHg
+  diastolic_bp INT NULL,    -- mmHg
+  temperature FLOAT NULL,   -- degrees Celsius
+  pulse INT NULL,           -- beats per minute
+  spo2 INT NULL,            -- %
+  muac FLOAT NULL,          -- mid-upper arm circumference, cm
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+  FOREIGN KEY (doctor_id) REFERENCES doctors(id) ON DELETE SET NULL,
+  INDEX idx_observations_client_observed (client_id, observed_at)
+);
+
+-- The height and weight captured so far become each client's first observation
+INSERT INTO observations (client_id, observed_at, height, weight)
+SELECT id, COALESCE(updated_at, created_at), NULLIF(height, 0), NULLIF(weight, 0)
+FROM clients
+WHERE NULLIF(height, 0) IS NOT NULL OR NULLIF(weight, 0) IS NOT NULL;
+
+ALTER TABLE clients
+  DROP COLUMN height,
+  DROP COLUMN weight;
@@ -0,0 +1,5 @@
+ALTER TABLE prescriptions
+  DROP FOREIGN KEY fk_prescriptions_encounter,
+  DROP COLUMN encounter_id;
+
+DROP TABLE IF EXISTS encounters;
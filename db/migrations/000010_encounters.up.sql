@@ -0,0 +1,24 @@
+-- An encounter is a visit, it ties together what happened when a client was seen
+CREATE TABLE IF NOT EXISTS encounters (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  client_id INT NOT NULL,
+  doctor_id INT NULL,
+  program_id INT NULL,
+  encountered_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  -- SOAP notes
+  subjective TEXT,
+  objective TEXT,
+  assessment TEXT,
+  plan TEXT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+  FOREIGN KEY (doctor_id) REFERENCES doctors(id) ON DELETE SET NULL,
+  FOREIGN KEY (program_id) REFERENCES programs(id) ON DELETE SET NULL,
+  INDEX idx_encounters_client_time (client_id, encountered_at)
+);
+
+-- Prescriptions can be written during an encounter
+ALTER TABLE prescriptions
+  ADD COLUMN encounter_id INT NULL AFTER client_id,
+  ADD CONSTRAINT fk_prescriptions_encounter FOREIGN KEY (encounter_id) REFERENCES encounters(id) ON DELETE SET NULL;
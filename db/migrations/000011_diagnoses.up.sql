@@ -0,0 +1,17 @@
+-- Coded diagnoses made during encounters, the description is copied from the catalog
+CREATE TABLE IF NOT EXISTS diagnoses (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  encounter_id INT NOT NULL,
+  doctor_id INT NULL,
+  code_system VARCHAR(10) NOT NULL,
+  code VARCHAR(20) NOT NULL,
+  description VARCHAR(255) NOT NULL,
+  is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+  certainty ENUM('provisional', 'confirmed') NOT NULL DEFAULT 'provisional',
+  diagnosed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  FOREIGN KEY (encounter_id) REFERENCES encounters(id) ON DELETE CASCADE,
+  FOREIGN KEY (doctor_id) REFERENCES doctors(id) ON DELETE SET NULL,
+  UNIQUE KEY unique_encounter_code (encounter_id, code_system, code),
+  INDEX idx_diagnoses_code (code_system, code)
+);
@@ -0,0 +1,10 @@
+ALTER TABLE prescriptions ADD COLUMN medicines TEXT NULL AFTER doctor_id;
+
+UPDATE prescriptions p SET medicines = COALESCE(
+  (SELECT GROUP_CONCAT(i.drug ORDER BY i.position SEPARATOR ',') FROM prescription_items i WHERE i.prescription_id = p.id),
+  ''
+);
+
+ALTER TABLE prescriptions MODIFY medicines TEXT NOT NULL;
+
+DROP TABLE IF EXISTS prescription_items;
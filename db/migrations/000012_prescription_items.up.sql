@@ -0,0 +1,36 @@
+-- Each medicine of a prescription is a line item with its dose, route, frequency and duration
+CREATE TABLE IF NOT EXISTS prescription_items (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  prescription_id BIGINT UNSIGNED NOT NULL,
+  position INT NOT NULL,
+  drug VARCHAR(255) NOT NULL,
+  strength VARCHAR(50) NULL,
+  dose DECIMAL(10, 3) NULL,
+  unit VARCHAR(20) NULL,
+  route VARCHAR(20) NULL,
+  frequency VARCHAR(20) NULL,
+  duration_days INT NULL,
+  quantity INT NULL,
+  instructions TEXT NULL,
+  FOREIGN KEY (prescription_id) REFERENCES prescriptions(id) ON DELETE CASCADE,
+  UNIQUE KEY unique_prescription_position (prescription_id, position)
+);
+
+-- Existing prescriptions were stored as comma separated medicine names,
+-- each name becomes an item with only the drug set
+INSERT INTO prescription_items (prescription_id, position, drug)
+WITH RECURSIVE split (prescription_id, position, drug, rest) AS (
+  SELECT id, 1,
+    TRIM(SUBSTRING_INDEX(medicines, ',', 1)),
+    IF(LOCATE(',', medicines) > 0, SUBSTRING(medicines, LOCATE(',', medicines) + 1), NULL)
+  FROM prescriptions
+  UNION ALL
+  SELECT prescription_id, position + 1,
+    TRIM(SUBSTRING_INDEX(rest, ',', 1)),
+    IF(LOCATE(',', rest) > 0, SUBSTRING(rest, LOCATE(',', rest) + 1), NULL)
+  FROM split
+  WHERE rest IS NOT NULL
+)
+SELECT prescription_id, position, drug FROM split WHERE drug <> '';
+
+ALTER TABLE prescriptions DROP COLUMN medicines;
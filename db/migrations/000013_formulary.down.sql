@@ -0,0 +1,7 @@
+ALTER TABLE prescription_items
+  DROP FOREIGN KEY fk_prescription_items_drug,
+  DROP COLUMN drug_id,
+  DROP COLUMN free_text;
+
+DROP TABLE IF EXISTS formulary_brands;
+DROP TABLE IF EXISTS formulary;
@@ -0,0 +1,30 @@
+-- Drugs that can be prescribed, inactive drugs are kept for existing prescriptions but cannot be prescribed
+CREATE TABLE IF NOT EXISTS formulary (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  generic_name VARCHAR(255) NOT NULL,
+  atc_code VARCHAR(10) NULL,
+  form VARCHAR(50) NOT NULL,
+  strength VARCHAR(50) NOT NULL DEFAULT '',
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY unique_formulary_drug (generic_name, form, strength),
+  INDEX idx_formulary_atc_code (atc_code)
+);
+
+CREATE TABLE IF NOT EXISTS formulary_brands (
+  drug_id INT NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  PRIMARY KEY (drug_id, name),
+  INDEX idx_formulary_brands_name (name),
+  FOREIGN KEY (drug_id) REFERENCES formulary(id) ON DELETE CASCADE
+);
+
+-- Prescription items reference the formulary, free text drugs are an explicit override.
+-- Items written before the formulary existed are free text.
+ALTER TABLE prescription_items
+  ADD COLUMN drug_id INT NULL AFTER position,
+  ADD COLUMN free_text BOOLEAN NOT NULL DEFAULT FALSE AFTER drug,
+  ADD CONSTRAINT fk_prescription_items_drug FOREIGN KEY (drug_id) REFERENCES formulary(id);
+
+UPDATE prescription_items SET free_text = TRUE;
@@ -0,0 +1,6 @@
+ALTER TABLE prescriptions
+  DROP COLUMN override_reason,
+  DROP COLUMN overridden_warnings;
+
+DROP TABLE IF EXISTS drug_interactions;
+DROP TABLE IF EXISTS client_allergies;
@@ -0,0 +1,34 @@
+-- Allergies and intolerances of clients, resolved ones are kept but no longer checked
+CREATE TABLE IF NOT EXISTS client_allergies (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  client_id INT NOT NULL,
+  substance VARCHAR(255) NOT NULL,
+  drug_id INT NULL,
+  atc_code VARCHAR(10) NULL,
+  kind ENUM('allergy', 'intolerance') NOT NULL DEFAULT 'allergy',
+  reaction VARCHAR(255) NULL,
+  severity ENUM('mild', 'moderate', 'severe') NOT NULL DEFAULT 'moderate',
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+  recorded_by INT NULL,
+  recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+  FOREIGN KEY (drug_id) REFERENCES formulary(id),
+  FOREIGN KEY (recorded_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  INDEX idx_client_allergies_client (client_id, active)
+);
+
+-- Drug interaction rules, replaced from the interaction data file when the server starts.
+-- Each side is an ATC code, matching every drug under it, or a generic name.
+CREATE TABLE IF NOT EXISTS drug_interactions (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  drug_a VARCHAR(255) NOT NULL,
+  drug_b VARCHAR(255) NOT NULL,
+  severity ENUM('minor', 'moderate', 'major', 'contraindicated') NOT NULL,
+  description TEXT NOT NULL,
+  UNIQUE KEY unique_interaction (drug_a, drug_b)
+);
+
+-- Prescriptions written despite blocking warnings keep the warnings and the reason they were overridden
+ALTER TABLE prescriptions
+  ADD COLUMN override_reason TEXT NULL,
+  ADD COLUMN overridden_warnings JSON NULL;
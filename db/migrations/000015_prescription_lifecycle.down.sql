@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS prescription_events;
+
+DROP INDEX idx_prescriptions_client_status ON prescriptions;
+
+ALTER TABLE prescriptions
+  DROP COLUMN status,
+  DROP COLUMN cancel_reason,
+  DROP COLUMN refills,
+  DROP COLUMN refills_used,
+  DROP COLUMN valid_until,
+  DROP COLUMN dispensed_at;
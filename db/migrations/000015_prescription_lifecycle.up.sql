@@ -0,0 +1,38 @@
+-- A prescription goes from active to dispensed (possibly partially dispensed first) to completed,
+-- or is cancelled with a reason. A dispensed prescription with refills left goes back to active when refilled.
+-- Active and partially dispensed prescriptions past valid_until are expired, they are not dispensed any more.
+ALTER TABLE prescriptions
+  ADD COLUMN status ENUM('active', 'partially_dispensed', 'dispensed', 'completed', 'cancelled') NOT NULL DEFAULT 'active',
+  ADD COLUMN cancel_reason TEXT NULL,
+  ADD COLUMN refills INT NOT NULL DEFAULT 0,
+  ADD COLUMN refills_used INT NOT NULL DEFAULT 0,
+  ADD COLUMN valid_until DATE NULL,
+  ADD COLUMN dispensed_at DATETIME NULL;
+
+-- Prescriptions written before the lifecycle are taken as handed out on the day they were issued,
+-- and completed once their longest item has run its course
+UPDATE prescriptions SET valid_until = DATE_ADD(date_issued, INTERVAL 30 DAY), status = 'dispensed', dispensed_at = date_issued;
+UPDATE prescriptions p SET p.status = 'completed'
+WHERE NOT EXISTS (
+  SELECT 1 FROM prescription_items i
+  WHERE i.prescription_id = p.id AND DATE_ADD(p.date_issued, INTERVAL GREATEST(COALESCE(i.duration_days, 0), 1) DAY) > CURRENT_DATE
+);
+ALTER TABLE prescriptions MODIFY valid_until DATE NOT NULL;
+
+CREATE INDEX idx_prescriptions_client_status ON prescriptions (client_id, status);
+
+-- History of a prescription, amendments and status changes are recorded instead of overwriting it
+CREATE TABLE IF NOT EXISTS prescription_events (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  prescription_id BIGINT UNSIGNED NOT NULL,
+  event ENUM('created', 'amended', 'partially_dispensed', 'dispensed', 'completed', 'cancelled', 'refilled') NOT NULL,
+  reason TEXT NULL,
+  recorded_by INT NULL,
+  recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (prescription_id) REFERENCES prescriptions(id) ON DELETE CASCADE,
+  FOREIGN KEY (recorded_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  INDEX idx_prescription_events_prescription (prescription_id, recorded_at)
+);
+
+INSERT INTO prescription_events (prescription_id, event, recorded_by, recorded_at)
+SELECT id, 'created', doctor_id, date_issued FROM prescriptions;
@@ -0,0 +1,6 @@
+ALTER TABLE formulary DROP COLUMN reorder_level;
+
+DROP TABLE IF EXISTS stock_movements;
+DROP TABLE IF EXISTS dispensing_items;
+DROP TABLE IF EXISTS dispensings;
+DROP TABLE IF EXISTS stock_batches;
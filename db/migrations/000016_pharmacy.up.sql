@@ -0,0 +1,58 @@
+-- Batches (lots) of formulary drugs held in stock, a batch number is unique for its drug
+CREATE TABLE IF NOT EXISTS stock_batches (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  drug_id INT NOT NULL,
+  batch_number VARCHAR(50) NOT NULL,
+  expiry_date DATE NOT NULL,
+  FOREIGN KEY (drug_id) REFERENCES formulary(id),
+  UNIQUE KEY unique_drug_batch (drug_id, batch_number),
+  INDEX idx_stock_batches_expiry (expiry_date)
+);
+
+-- Dispensing of a prescription, each fill (the first one and every refill) can be dispensed over several dispensings
+CREATE TABLE IF NOT EXISTS dispensings (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  prescription_id BIGINT UNSIGNED NOT NULL,
+  fill INT NOT NULL,
+  notes TEXT NULL,
+  dispensed_by INT NULL,
+  dispensed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (prescription_id) REFERENCES prescriptions(id) ON DELETE CASCADE,
+  FOREIGN KEY (dispensed_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  INDEX idx_dispensings_prescription (prescription_id, fill)
+);
+
+-- Quantities handed out for the items of a prescription, free text items have no batch
+CREATE TABLE IF NOT EXISTS dispensing_items (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  dispensing_id INT NOT NULL,
+  prescription_item_id INT NOT NULL,
+  batch_id INT NULL,
+  quantity INT NOT NULL,
+  FOREIGN KEY (dispensing_id) REFERENCES dispensings(id) ON DELETE CASCADE,
+  FOREIGN KEY (prescription_item_id) REFERENCES prescription_items(id) ON DELETE CASCADE,
+  FOREIGN KEY (batch_id) REFERENCES stock_batches(id)
+);
+
+-- Stock ledger, the balance of a batch is the sum of its movements.
+-- Receipts are positive, issues negative and adjustments either.
+CREATE TABLE IF NOT EXISTS stock_movements (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  drug_id INT NOT NULL,
+  batch_id INT NOT NULL,
+  kind ENUM('receipt', 'issue', 'adjustment') NOT NULL,
+  quantity INT NOT NULL,
+  reason VARCHAR(255) NULL,
+  dispensing_id INT NULL,
+  recorded_by INT NULL,
+  recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (drug_id) REFERENCES formulary(id),
+  FOREIGN KEY (batch_id) REFERENCES stock_batches(id),
+  FOREIGN KEY (dispensing_id) REFERENCES dispensings(id) ON DELETE SET NULL,
+  FOREIGN KEY (recorded_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  INDEX idx_stock_movements_drug (drug_id, recorded_at),
+  INDEX idx_stock_movements_batch (batch_id)
+);
+
+-- Drugs at or below their reorder level are reported as low on stock
+ALTER TABLE formulary ADD COLUMN reorder_level INT NOT NULL DEFAULT 0;
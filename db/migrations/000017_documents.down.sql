@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS issued_documents;
+
+ALTER TABLE doctors DROP COLUMN registration_number;
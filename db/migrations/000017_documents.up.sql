@@ -0,0 +1,15 @@
+-- Registration number of a doctor with the medical board, printed on the documents they issue
+ALTER TABLE doctors ADD COLUMN registration_number VARCHAR(50) NULL UNIQUE;
+
+-- Printed documents, the id is encoded in the QR code of the document to verify it was issued here
+CREATE TABLE IF NOT EXISTS issued_documents (
+  id CHAR(36) PRIMARY KEY,
+  kind ENUM('prescription', 'client_summary') NOT NULL,
+  client_id INT NOT NULL,
+  prescription_id BIGINT UNSIGNED NULL,
+  doctor_id INT NULL,
+  issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+  FOREIGN KEY (prescription_id) REFERENCES prescriptions(id) ON DELETE CASCADE,
+  FOREIGN KEY (doctor_id) REFERENCES doctors(id) ON DELETE SET NULL
+);
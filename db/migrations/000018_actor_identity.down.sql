@@ -0,0 +1,17 @@
+ALTER TABLE client_allergies
+  DROP FOREIGN KEY fk_client_allergies_resolved_by,
+  DROP COLUMN resolved_by,
+  DROP COLUMN resolved_at;
+
+ALTER TABLE enrollments
+  DROP FOREIGN KEY fk_enrollments_enrolled_by,
+  DROP COLUMN enrolled_by;
+
+ALTER TABLE clients
+  DROP FOREIGN KEY fk_clients_registered_by,
+  DROP FOREIGN KEY fk_clients_merged_by,
+  DROP COLUMN registered_by,
+  DROP COLUMN merged_by;
+
+ALTER TABLE doctors
+  DROP COLUMN role;
@@ -0,0 +1,19 @@
+-- The role of a doctor is carried in their token along with their id and email
+ALTER TABLE doctors
+  ADD COLUMN role VARCHAR(50) NOT NULL DEFAULT 'doctor';
+
+-- Who made the changes to a client's record, taken from the authenticated doctor
+ALTER TABLE clients
+  ADD COLUMN registered_by INT NULL,
+  ADD COLUMN merged_by INT NULL,
+  ADD CONSTRAINT fk_clients_registered_by FOREIGN KEY (registered_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  ADD CONSTRAINT fk_clients_merged_by FOREIGN KEY (merged_by) REFERENCES doctors(id) ON DELETE SET NULL;
+
+ALTER TABLE enrollments
+  ADD COLUMN enrolled_by INT NULL,
+  ADD CONSTRAINT fk_enrollments_enrolled_by FOREIGN KEY (enrolled_by) REFERENCES doctors(id) ON DELETE SET NULL;
+
+ALTER TABLE client_allergies
+  ADD COLUMN resolved_by INT NULL,
+  ADD COLUMN resolved_at TIMESTAMP NULL,
+  ADD CONSTRAINT fk_client_allergies_resolved_by FOREIGN KEY (resolved_by) REFERENCES doctors(id) ON DELETE SET NULL;
@@ -0,0 +1,6 @@
+ALTER TABLE doctors
+  DROP FOREIGN KEY fk_doctors_role;
+
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
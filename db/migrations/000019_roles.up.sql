@@ -0,0 +1,61 @@
+-- Roles of the staff and the permissions each role grants,
+-- the permissions of a doctor's role are carried in their token
+CREATE TABLE IF NOT EXISTS roles (
+  name VARCHAR(50) PRIMARY KEY,
+  description VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+  name VARCHAR(50) PRIMARY KEY,
+  description VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role VARCHAR(50) NOT NULL,
+  permission VARCHAR(50) NOT NULL,
+  PRIMARY KEY (role, permission),
+  FOREIGN KEY (role) REFERENCES roles(name) ON DELETE CASCADE,
+  FOREIGN KEY (permission) REFERENCES permissions(name) ON DELETE CASCADE
+);
+
+INSERT INTO roles (name, description) VALUES
+  ('admin', 'Manages staff accounts, roles and facility wide operations'),
+  ('doctor', 'Examines clients and prescribes'),
+  ('nurse', 'Registers clients and records their care'),
+  ('pharmacist', 'Dispenses prescriptions'),
+  ('records_clerk', 'Keeps client records');
+
+INSERT INTO permissions (name, description) VALUES
+  ('clients:read', 'View and search clients'),
+  ('clients:create', 'Register clients'),
+  ('clients:update', 'Update client details and phone numbers'),
+  ('clients:delete', 'Archive and restore clients'),
+  ('clients:merge', 'Merge duplicate clients'),
+  ('clients:purge', 'Purge archived clients'),
+  ('enrollments:write', 'Enroll clients in programs'),
+  ('allergies:write', 'Record and resolve client allergies'),
+  ('prescriptions:read', 'View prescriptions and active medications'),
+  ('prescriptions:write', 'Create and amend prescriptions'),
+  ('prescriptions:status', 'Change the status of prescriptions'),
+  ('prescriptions:refill', 'Refill prescriptions'),
+  ('programs:read', 'View programs'),
+  ('programs:write', 'Create programs'),
+  ('roles:manage', 'Assign roles to staff');
+
+-- Admins have every permission
+INSERT INTO role_permissions (role, permission) SELECT 'admin', name FROM permissions;
+
+INSERT INTO role_permissions (role, permission) VALUES
+  ('doctor', 'clients:read'), ('doctor', 'clients:create'), ('doctor', 'clients:update'), ('doctor', 'clients:delete'),
+  ('doctor', 'enrollments:write'), ('doctor', 'allergies:write'),
+  ('doctor', 'prescriptions:read'), ('doctor', 'prescriptions:write'), ('doctor', 'prescriptions:status'), ('doctor', 'prescriptions:refill'),
+  ('doctor', 'programs:read'),
+  ('nurse', 'clients:read'), ('nurse', 'clients:create'), ('nurse', 'clients:update'),
+  ('nurse', 'enrollments:write'), ('nurse', 'allergies:write'), ('nurse', 'prescriptions:read'), ('nurse', 'programs:read'),
+  ('pharmacist', 'clients:read'), ('pharmacist', 'prescriptions:read'), ('pharmacist', 'prescriptions:status'), ('pharmacist', 'prescriptions:refill'),
+  ('pharmacist', 'programs:read'),
+  ('records_clerk', 'clients:read'), ('records_clerk', 'clients:create'), ('records_clerk', 'clients:update'), ('records_clerk', 'clients:delete'),
+  ('records_clerk', 'clients:merge'), ('records_clerk', 'enrollments:write'), ('records_clerk', 'programs:read');
+
+ALTER TABLE doctors
+  ADD CONSTRAINT fk_doctors_role FOREIGN KEY (role) REFERENCES roles(name);
@@ -0,0 +1,10 @@
+DELETE FROM permissions WHERE name = 'staff:manage';
+
+DROP TABLE IF EXISTS staff_invitations;
+
+ALTER TABLE doctors
+  DROP FOREIGN KEY fk_doctors_reviewed_by,
+  DROP COLUMN status,
+  DROP COLUMN reviewed_by,
+  DROP COLUMN reviewed_at,
+  DROP COLUMN rejection_reason;
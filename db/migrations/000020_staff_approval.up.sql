@@ -0,0 +1,31 @@
+-- Self registered staff wait for an admin to approve them, staff invited by an admin are active straight away.
+-- Staff registered before approval was required stay active.
+ALTER TABLE doctors
+  ADD COLUMN status ENUM('pending', 'active', 'rejected') NOT NULL DEFAULT 'pending',
+  ADD COLUMN reviewed_by INT NULL,
+  ADD COLUMN reviewed_at TIMESTAMP NULL,
+  ADD COLUMN rejection_reason VARCHAR(255) NULL,
+  ADD CONSTRAINT fk_doctors_reviewed_by FOREIGN KEY (reviewed_by) REFERENCES doctors(id) ON DELETE SET NULL;
+
+UPDATE doctors SET status = 'active';
+
+-- Invitations are single use and expire, only the SHA-256 hash of the signup token is kept
+CREATE TABLE IF NOT EXISTS staff_invitations (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  email VARCHAR(255) NOT NULL,
+  role VARCHAR(50) NOT NULL,
+  invited_by INT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP NULL,
+  used_by INT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (role) REFERENCES roles(name),
+  FOREIGN KEY (invited_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  FOREIGN KEY (used_by) REFERENCES doctors(id) ON DELETE SET NULL
+);
+
+INSERT INTO permissions (name, description) VALUES
+  ('staff:manage', 'Approve, reject and invite staff');
+INSERT INTO role_permissions (role, permission) VALUES
+  ('admin', 'staff:manage');
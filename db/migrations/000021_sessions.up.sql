@@ -0,0 +1,25 @@
+-- A session is a login of a doctor. Its short lived access tokens are renewed with a refresh token
+-- that can only be used once, only the SHA-256 hashes of the refresh tokens are kept.
+CREATE TABLE IF NOT EXISTS sessions (
+  id CHAR(36) PRIMARY KEY,
+  doctor_id INT NOT NULL,
+  user_agent VARCHAR(255) NULL,
+  ip_address VARCHAR(45) NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  refreshed_at TIMESTAMP NULL,
+  expires_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP NULL,
+  revoked_by INT NULL,
+  revoke_reason ENUM('logout', 'admin', 'reuse', 'inactive') NULL,
+  FOREIGN KEY (doctor_id) REFERENCES doctors(id) ON DELETE CASCADE,
+  FOREIGN KEY (revoked_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  INDEX idx_sessions_doctor (doctor_id, revoked_at)
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  token_hash CHAR(64) PRIMARY KEY,
+  session_id CHAR(36) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  used_at TIMESTAMP NULL,
+  FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
@@ -0,0 +1,14 @@
+UPDATE sessions SET revoke_reason = 'inactive' WHERE revoke_reason = 'mfa';
+ALTER TABLE sessions
+  MODIFY revoke_reason ENUM('logout', 'admin', 'reuse', 'inactive') NULL;
+
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+
+ALTER TABLE roles
+  DROP COLUMN mfa_required;
+
+ALTER TABLE doctors
+  DROP COLUMN mfa_secret,
+  DROP COLUMN mfa_enabled_at,
+  DROP COLUMN mfa_last_step;
@@ -0,0 +1,36 @@
+-- Staff can protect their login with an authenticator app (TOTP). The secret is kept until MFA is confirmed with a
+-- first code, mfa_last_step is the time step of the last code used so a code cannot be used twice.
+ALTER TABLE doctors
+  ADD COLUMN mfa_secret VARCHAR(64) NULL,
+  ADD COLUMN mfa_enabled_at TIMESTAMP NULL,
+  ADD COLUMN mfa_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- Admins can require MFA for everyone with a role
+ALTER TABLE roles
+  ADD COLUMN mfa_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- One time recovery codes for when the authenticator app is lost, only their SHA-256 hashes are kept
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  doctor_id INT NOT NULL,
+  code_hash CHAR(64) NOT NULL,
+  used_at TIMESTAMP NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (doctor_id) REFERENCES doctors(id) ON DELETE CASCADE,
+  UNIQUE KEY uq_mfa_recovery_codes (doctor_id, code_hash)
+);
+
+-- A login with the right password gets a short lived challenge, answered with a code to start the session
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+  token_hash CHAR(64) PRIMARY KEY,
+  doctor_id INT NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (doctor_id) REFERENCES doctors(id) ON DELETE CASCADE
+);
+
+-- Sessions of staff whose role came to require MFA end at their next refresh
+ALTER TABLE sessions
+  MODIFY revoke_reason ENUM('logout', 'admin', 'reuse', 'inactive', 'mfa') NULL;
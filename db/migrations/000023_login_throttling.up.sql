@@ -0,0 +1,25 @@
+-- Failed logins are counted per account (by the email tried, whether or not it exists) and per IP address.
+-- Each failure blocks the next attempt for longer, reaching the threshold locks it out until blocked_until.
+CREATE TABLE IF NOT EXISTS login_throttles (
+  kind ENUM('account', 'ip') NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  failures INT NOT NULL DEFAULT 0,
+  last_failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  blocked_until TIMESTAMP NULL,
+  PRIMARY KEY (kind, subject)
+);
+
+-- Lockouts, suspicious logins and unlocks, for admins to review
+CREATE TABLE IF NOT EXISTS security_events (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  kind ENUM('lockout', 'ip_lockout', 'suspicious_login', 'unlock') NOT NULL,
+  doctor_id INT NULL,
+  email VARCHAR(255) NULL,
+  ip_address VARCHAR(45) NULL,
+  detail VARCHAR(255) NULL,
+  recorded_by INT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (doctor_id) REFERENCES doctors(id) ON DELETE SET NULL,
+  FOREIGN KEY (recorded_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  INDEX idx_security_events_kind (kind, created_at)
+);
@@ -0,0 +1,6 @@
+UPDATE sessions SET revoke_reason = 'admin' WHERE revoke_reason = 'password';
+ALTER TABLE sessions
+  MODIFY revoke_reason ENUM('logout', 'admin', 'reuse', 'inactive', 'mfa') NULL;
+
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS password_history;
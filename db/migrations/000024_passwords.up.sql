@@ -0,0 +1,24 @@
+-- Previous password hashes of each doctor, so recent passwords cannot be used again
+CREATE TABLE IF NOT EXISTS password_history (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  doctor_id INT NOT NULL,
+  password_hash VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (doctor_id) REFERENCES doctors(id) ON DELETE CASCADE,
+  INDEX idx_password_history_doctor (doctor_id, created_at)
+);
+
+-- Password reset tokens are single use and expire, only their SHA-256 hashes are kept
+CREATE TABLE IF NOT EXISTS password_resets (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  doctor_id INT NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (doctor_id) REFERENCES doctors(id) ON DELETE CASCADE
+);
+
+-- Changing or resetting a password ends the other sessions
+ALTER TABLE sessions
+  MODIFY revoke_reason ENUM('logout', 'admin', 'reuse', 'inactive', 'mfa', 'password') NULL;
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS api_key_permissions;
+DROP TABLE IF EXISTS api_keys;
+
+-- Service accounts are kept as rejected accounts, the changes of their keys stay recorded against them
+UPDATE doctors SET status = 'rejected', role = 'doctor' WHERE status = 'service';
+ALTER TABLE doctors
+  MODIFY status ENUM('pending', 'active', 'rejected') NOT NULL DEFAULT 'pending';
+
+DELETE FROM permissions WHERE name = 'apikeys:manage';
+DELETE FROM roles WHERE name = 'integration';
@@ -0,0 +1,42 @@
+-- Integrations such as the lab analyser bridge authenticate with API keys instead of a doctor's login.
+-- Each key has a service account in doctors so the changes it makes are recorded against the key,
+-- service accounts cannot log in.
+ALTER TABLE doctors
+  MODIFY status ENUM('pending', 'active', 'rejected', 'service') NOT NULL DEFAULT 'pending';
+
+INSERT INTO roles (name, description) VALUES
+  ('integration', 'Service account of an API key, granted only the permissions of its key');
+
+INSERT INTO permissions (name, description) VALUES
+  ('apikeys:manage', 'Create and revoke API keys for integrations');
+INSERT INTO role_permissions (role, permission) VALUES
+  ('admin', 'apikeys:manage');
+
+-- Only the SHA-256 hash of a key is kept, the prefix tells keys apart in listings
+CREATE TABLE IF NOT EXISTS api_keys (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  name VARCHAR(100) NOT NULL,
+  prefix VARCHAR(20) NOT NULL UNIQUE,
+  key_hash CHAR(64) NOT NULL UNIQUE,
+  account_id INT NOT NULL,
+  -- Comma separated IP addresses and CIDR ranges the key can be used from, NULL allows any
+  allowed_ips VARCHAR(1000) NULL,
+  expires_at TIMESTAMP NULL,
+  last_used_at TIMESTAMP NULL,
+  last_used_ip VARCHAR(45) NULL,
+  created_by INT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  revoked_at TIMESTAMP NULL,
+  revoked_by INT NULL,
+  FOREIGN KEY (account_id) REFERENCES doctors(id) ON DELETE CASCADE,
+  FOREIGN KEY (created_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  FOREIGN KEY (revoked_by) REFERENCES doctors(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_key_permissions (
+  api_key_id INT NOT NULL,
+  permission VARCHAR(50) NOT NULL,
+  PRIMARY KEY (api_key_id, permission),
+  FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE,
+  FOREIGN KEY (permission) REFERENCES permissions(name) ON DELETE CASCADE
+);
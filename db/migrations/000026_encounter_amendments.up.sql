@@ -0,0 +1,14 @@
+-- Edits to the SOAP notes of an encounter keep the notes they replace
+CREATE TABLE IF NOT EXISTS encounter_amendments (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  encounter_id INT NOT NULL,
+  subjective TEXT,
+  objective TEXT,
+  assessment TEXT,
+  plan TEXT,
+  amended_by INT NULL,
+  amended_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (encounter_id) REFERENCES encounters(id) ON DELETE CASCADE,
+  FOREIGN KEY (amended_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  INDEX idx_encounter_amendments_encounter (encounter_id, amended_at)
+);
@@ -0,0 +1,7 @@
+-- Adding, importing, changing and deactivating formulary drugs is kept to pharmacists and admins,
+-- every signed in doctor can still look drugs up
+INSERT INTO permissions (name, description) VALUES
+  ('formulary:manage', 'Add, import, update and deactivate formulary drugs');
+INSERT INTO role_permissions (role, permission) VALUES
+  ('admin', 'formulary:manage'),
+  ('pharmacist', 'formulary:manage');
@@ -0,0 +1 @@
+ALTER TABLE stock_batches DROP COLUMN balance;
@@ -0,0 +1,9 @@
+-- The balance of each batch is kept on the batch, so issuing stock can check and decrement it in one statement
+-- instead of summing the ledger without a lock. The ledger stays the record of every movement.
+ALTER TABLE stock_batches ADD COLUMN balance INT NOT NULL DEFAULT 0;
+
+UPDATE stock_batches b
+SET balance = (SELECT COALESCE(SUM(m.quantity), 0) FROM stock_movements m WHERE m.batch_id = b.id);
+
+-- Batches that were already issued below zero are reported for a stock count
+SELECT b.id, b.drug_id, b.batch_number, b.balance FROM stock_batches b WHERE b.balance < 0;
@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS client_events;
+
+ALTER TABLE client_phones
+  DROP FOREIGN KEY fk_client_phones_added_by,
+  DROP COLUMN added_by;
+
+ALTER TABLE clients
+  DROP FOREIGN KEY fk_clients_restored_by,
+  DROP COLUMN restored_by,
+  DROP COLUMN restored_at;
+
+ALTER TABLE clients ADD COLUMN archived_email VARCHAR(255) NULL;
+UPDATE clients c JOIN doctors d ON d.id = c.archived_by SET c.archived_email = d.email;
+ALTER TABLE clients
+  DROP FOREIGN KEY fk_clients_archived_by,
+  DROP COLUMN archived_by,
+  RENAME COLUMN archived_email TO archived_by;
@@ -0,0 +1,30 @@
+-- Archiving records the doctor by id like every other change to a client, the emails recorded so far are matched to doctors
+ALTER TABLE clients ADD COLUMN archived_by_id INT NULL;
+UPDATE clients c JOIN doctors d ON d.email = c.archived_by SET c.archived_by_id = d.id;
+ALTER TABLE clients
+  DROP COLUMN archived_by,
+  RENAME COLUMN archived_by_id TO archived_by,
+  ADD CONSTRAINT fk_clients_archived_by FOREIGN KEY (archived_by) REFERENCES doctors(id) ON DELETE SET NULL;
+
+-- Who restored a client last, and who added each phone number
+ALTER TABLE clients
+  ADD COLUMN restored_by INT NULL,
+  ADD COLUMN restored_at TIMESTAMP NULL,
+  ADD CONSTRAINT fk_clients_restored_by FOREIGN KEY (restored_by) REFERENCES doctors(id) ON DELETE SET NULL;
+
+ALTER TABLE client_phones
+  ADD COLUMN added_by INT NULL,
+  ADD CONSTRAINT fk_client_phones_added_by FOREIGN KEY (added_by) REFERENCES doctors(id) ON DELETE SET NULL;
+
+-- Changes that delete the rows they are about, removed phone numbers and purged clients, are kept here.
+-- The client is referred to by its uuid so the record outlives a purge.
+CREATE TABLE IF NOT EXISTS client_events (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  client_uuid CHAR(36) NOT NULL,
+  event ENUM('phone_removed', 'purged') NOT NULL,
+  detail VARCHAR(255) NULL,
+  recorded_by INT NULL,
+  recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (recorded_by) REFERENCES doctors(id) ON DELETE SET NULL,
+  INDEX idx_client_events_client (client_uuid, recorded_at)
+);
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE name IN ('encounters:read', 'encounters:write', 'observations:read', 'observations:write',
+  'diagnoses:read', 'diagnoses:write', 'documents:read', 'pharmacy:dispense', 'stock:manage');
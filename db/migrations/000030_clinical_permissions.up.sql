@@ -0,0 +1,23 @@
+-- Encounters, observations, diagnoses, documents and the pharmacy each need a permission of the doctor's role
+-- like the clients routes, so that a role or an API key only reaches the records it works with
+INSERT INTO permissions (name, description) VALUES
+  ('encounters:read', 'View encounters'),
+  ('encounters:write', 'Record encounters and amend their notes'),
+  ('observations:read', 'View observations'),
+  ('observations:write', 'Record observations'),
+  ('diagnoses:read', 'View diagnoses, search codes and report on them'),
+  ('diagnoses:write', 'Record and update diagnoses'),
+  ('documents:read', 'Print prescriptions and client summaries'),
+  ('pharmacy:dispense', 'Dispense prescriptions'),
+  ('stock:manage', 'Receive, adjust and report on stock');
+
+INSERT INTO role_permissions (role, permission) VALUES
+  ('admin', 'encounters:read'), ('admin', 'encounters:write'), ('admin', 'observations:read'), ('admin', 'observations:write'),
+  ('admin', 'diagnoses:read'), ('admin', 'diagnoses:write'), ('admin', 'documents:read'),
+  ('admin', 'pharmacy:dispense'), ('admin', 'stock:manage'),
+  ('doctor', 'encounters:read'), ('doctor', 'encounters:write'), ('doctor', 'observations:read'), ('doctor', 'observations:write'),
+  ('doctor', 'diagnoses:read'), ('doctor', 'diagnoses:write'), ('doctor', 'documents:read'),
+  ('nurse', 'encounters:read'), ('nurse', 'observations:read'), ('nurse', 'observations:write'), ('nurse', 'diagnoses:read'),
+  ('nurse', 'documents:read'),
+  ('pharmacist', 'pharmacy:dispense'), ('pharmacist', 'stock:manage'), ('pharmacist', 'documents:read'),
+  ('records_clerk', 'documents:read');
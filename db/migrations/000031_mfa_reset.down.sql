@@ -0,0 +1,3 @@
+DELETE FROM security_events WHERE kind = 'mfa_reset';
+ALTER TABLE security_events
+  MODIFY kind ENUM('lockout', 'ip_lockout', 'suspicious_login', 'unlock') NOT NULL;
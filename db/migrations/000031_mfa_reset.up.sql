@@ -0,0 +1,3 @@
+-- Admins reset the MFA of staff who lost their authenticator and their recovery codes, which is recorded
+ALTER TABLE security_events
+  MODIFY kind ENUM('lockout', 'ip_lockout', 'suspicious_login', 'unlock', 'mfa_reset') NOT NULL;
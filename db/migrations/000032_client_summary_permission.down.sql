@@ -0,0 +1,2 @@
+UPDATE permissions SET description = 'Print prescriptions and client summaries' WHERE name = 'documents:read';
+DELETE FROM permissions WHERE name = 'documents:summary';
@@ -0,0 +1,10 @@
+-- A client summary is signed by the doctor who issues it, so issuing one needs its own permission
+-- that only clinicians hold instead of documents:read, which every role has
+INSERT INTO permissions (name, description) VALUES
+  ('documents:summary', 'Issue client summaries signed in their own name');
+
+INSERT INTO role_permissions (role, permission) VALUES
+  ('admin', 'documents:summary'),
+  ('doctor', 'documents:summary');
+
+UPDATE permissions SET description = 'Print prescriptions' WHERE name = 'documents:read';
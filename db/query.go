@@ -0,0 +1,28 @@
+package db
+
+import "strings"
+
+// EscapeLike escapes the wildcard characters of a LIKE pattern, for searches to match them literally
+func EscapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// NullIfEmpty stores empty optional values as NULL
+func NullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// NullIfZero stores a missing id as NULL
+func NullIfZero(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// PrescriptionStatus is the status of a prescription p, with expiry applied: active and partially dispensed
+// prescriptions past their validity are reported as expired, which is never stored
+const PrescriptionStatus = `CASE WHEN p.status IN ('active', 'partially_dispensed') AND p.valid_until < CURRENT_DATE THEN 'expired' ELSE p.status END`
@@ -0,0 +1,58 @@
+// This module delivers notifications to staff, such as password reset links.
+// The file and log sinks stand in for email or SMS, which plug in by implementing types.Notifier.
+package notify
+
+import (
+	"cema_backend/logging"
+	"cema_backend/types"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// New returns a notifier appending to the file at path, or writing to the log when path is empty
+func New(path string) types.Notifier {
+	if path == "" {
+		return LogNotifier{}
+	}
+	return &FileNotifier{path: path}
+}
+
+// FileNotifier appends each notification to a file as a line of JSON
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Notify appends the notification to the file with the time it was sent
+func (n *FileNotifier) Notify(notification types.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// The file holds reset links, only the server can read it
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open notification file: %w", err)
+	}
+	defer file.Close()
+
+	line := struct {
+		types.Notification
+		SentAt time.Time `json:"sent_at"`
+	}{notification, time.Now()}
+	if err := json.NewEncoder(file).Encode(line); err != nil {
+		return fmt.Errorf("failed to write notification: %w", err)
+	}
+	return nil
+}
+
+// LogNotifier writes each notification to the log
+type LogNotifier struct{}
+
+// Notify writes the notification to the log
+func (LogNotifier) Notify(notification types.Notification) error {
+	logging.Info("Notification to " + notification.To + ": " + notification.Subject + "\n" + notification.Body)
+	return nil
+}
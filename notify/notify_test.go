@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"cema_backend/types"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	notifier := New(path)
+
+	// Test case: Each notification is appended as a line of JSON
+	require.NoError(t, notifier.Notify(types.Notification{To: "stan@rfh.com", Subject: "Reset your password", Body: "first"}))
+	require.NoError(t, notifier.Notify(types.Notification{To: "stan@rfh.com", Subject: "Reset your password", Body: "second"}))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+
+	var notification types.Notification
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &notification))
+	require.Equal(t, "second", notification.Body)
+
+	// Test case: Only the server can read the file
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
@@ -0,0 +1,89 @@
+// This file handles the sort options and opaque cursors used to page through clients.
+package clients
+
+import (
+	"cema_backend/types"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// dateOfBirthColumn sorts clients without a date of birth as the oldest ones
+const dateOfBirthColumn = "COALESCE(date_of_birth, '1000-01-01')"
+
+// sortColumns maps the sort keys accepted by the API to their column in the clients table.
+// A key can be prefixed with "-" to sort in descending order.
+var sortColumns = map[string]string{
+	"id":        "id",
+	"firstname": "firstname",
+	"lastname":  "lastname",
+	"age":       dateOfBirthColumn,
+}
+
+// sortKey is the sort option a listing is sorted by, the default being by id
+func sortKey(sort string) string {
+	if sort == "" {
+		return "id"
+	}
+	return sort
+}
+
+// parseSort splits a sort option into its column and direction
+// and reports whether the sort key is known
+func parseSort(sort string) (column string, desc bool, ok bool) {
+	if sort == "" {
+		return "id", false, true
+	}
+	desc = strings.HasPrefix(sort, "-")
+	column, ok = sortColumns[strings.TrimPrefix(sort, "-")]
+	// The youngest clients have the latest dates of birth
+	if column == dateOfBirthColumn {
+		desc = !desc
+	}
+	return column, desc, ok
+}
+
+// encodeCursor turns a cursor into the opaque string handed out to API users
+func encodeCursor(cursor types.ClientCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor reads a cursor previously created by encodeCursor
+func decodeCursor(cursor string) (*types.ClientCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	var decoded types.ClientCursor
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return &decoded, nil
+}
+
+// cursorFor builds the cursor pointing after the given client for a sort option and its column
+func cursorFor(client types.Client, sort string, column string) types.ClientCursor {
+	cursor := types.ClientCursor{Sort: sortKey(sort), ID: client.ID}
+	switch column {
+	case "firstname":
+		cursor.Value = client.FirstName
+	case "lastname":
+		cursor.Value = client.LastName
+	case dateOfBirthColumn:
+		cursor.Value = client.DateOfBirth
+		if cursor.Value == "" {
+			cursor.Value = "1000-01-01"
+		}
+	default:
+		cursor.Value = strconv.Itoa(client.ID)
+	}
+	return cursor
+}
@@ -0,0 +1,121 @@
+// This file handles the date of birth, age and demographic details of clients.
+package clients
+
+import (
+	"cema_backend/types"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dateLayout is the format dates of birth are sent and returned in
+const dateLayout = "2006-01-02"
+
+// maxAge is the oldest age accepted for a client
+const maxAge = 130
+
+// sexes are the accepted values for a client's sex
+var sexes = map[string]bool{
+	"female":   true,
+	"male":     true,
+	"intersex": true,
+	"unknown":  true,
+}
+
+var (
+	// Kenyan national ID numbers have 7 digits on older cards and 8 on newer ones
+	nationalIDPattern = regexp.MustCompile(`^\d{7,8}$`)
+	// Birth certificate entry numbers are all digits
+	birthCertificatePattern = regexp.MustCompile(`^\d{5,10}$`)
+)
+
+// counties are the 47 counties of Kenya, keyed by their normalized name
+var counties = map[string]string{}
+
+func init() {
+	for _, county := range []string{
+		"Mombasa", "Kwale", "Kilifi", "Tana River", "Lamu", "Taita-Taveta", "Garissa", "Wajir",
+		"Mandera", "Marsabit", "Isiolo", "Meru", "Tharaka-Nithi", "Embu", "Kitui", "Machakos",
+		"Makueni", "Nyandarua", "Nyeri", "Kirinyaga", "Murang'a", "Kiambu", "Turkana", "West Pokot",
+		"Samburu", "Trans Nzoia", "Uasin Gishu", "Elgeyo-Marakwet", "Nandi", "Baringo", "Laikipia", "Nakuru",
+		"Narok", "Kajiado", "Kericho", "Bomet", "Kakamega", "Vihiga", "Bungoma", "Busia",
+		"Siaya", "Kisumu", "Homa Bay", "Migori", "Kisii", "Nyamira", "Nairobi",
+	} {
+		counties[normalizeCounty(county)] = county
+	}
+}
+
+// normalizeCounty lowercases a county name and drops spaces, hyphens and apostrophes
+// so that "Taita Taveta" and "taita-taveta" are the same county
+func normalizeCounty(county string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '\'':
+			return -1
+		}
+		return r
+	}, strings.ToLower(county))
+}
+
+// ageOn returns the age in completed years of someone born on dob at the given date
+func ageOn(dob time.Time, on time.Time) int {
+	age := on.Year() - dob.Year()
+	if on.Month() < dob.Month() || (on.Month() == dob.Month() && on.Day() < dob.Day()) {
+		age--
+	}
+	return age
+}
+
+// resolveDateOfBirth checks the client's date of birth, or estimates one from their age
+// when only the age is known, and sets the client's age from it.
+// It returns the error message to send back if neither is valid.
+func resolveDateOfBirth(client *types.Client, now time.Time) string {
+	if client.DateOfBirth == "" {
+		if client.Age <= 0 {
+			return "Date of birth or age is required"
+		}
+		if client.Age > maxAge {
+			return "Invalid age"
+		}
+		client.DateOfBirth = now.AddDate(-client.Age, 0, 0).Format(dateLayout)
+		client.DOBEstimated = true
+		return ""
+	}
+
+	dob, err := time.Parse(dateLayout, client.DateOfBirth)
+	if err != nil {
+		return "Invalid date of birth format. Use YYYY-MM-DD"
+	}
+	if dob.After(now) || ageOn(dob, now) > maxAge {
+		return "Invalid date of birth"
+	}
+	client.Age = ageOn(dob, now)
+	return ""
+}
+
+// validateDemographics checks the client's sex, identification numbers and county
+// and returns the error message to send back if one is invalid.
+// The county is changed to its canonical spelling.
+func validateDemographics(client *types.Client) string {
+	client.Sex = strings.ToLower(client.Sex)
+	if client.Sex != "" && !sexes[client.Sex] {
+		return "Invalid sex, use female, male, intersex or unknown"
+	}
+	if client.NationalID != "" && !nationalIDPattern.MatchString(client.NationalID) {
+		return "Invalid national ID number"
+	}
+	if client.BirthCertificateNumber != "" && !birthCertificatePattern.MatchString(client.BirthCertificateNumber) {
+		return "Invalid birth certificate number"
+	}
+	if client.County != "" {
+		county, ok := counties[normalizeCounty(client.County)]
+		if !ok {
+			return "Unknown county"
+		}
+		client.County = county
+	}
+	if (client.SubCounty != "" || client.Ward != "") && client.County == "" {
+		return "County is required with a sub-county or ward"
+	}
+	return ""
+}
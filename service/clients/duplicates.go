@@ -0,0 +1,87 @@
+// This file scores how likely existing clients are to be the same person as a newly registered one.
+// The same person is often registered twice under different phone numbers,
+// so the names, age and emergency contact are compared instead.
+package clients
+
+import (
+	"cema_backend/types"
+	"sort"
+	"strings"
+)
+
+// minDuplicateScore is the score from which an existing client is reported as a likely duplicate
+const minDuplicateScore = 0.75
+
+// findDuplicates scores the candidates against the new client and returns the likely duplicates, best first
+func findDuplicates(client types.Client, candidates []types.ClientResponse) []types.DuplicateCandidate {
+	duplicates := []types.DuplicateCandidate{}
+	for _, candidate := range candidates {
+		score, reasons := scoreDuplicate(client, candidate)
+		if score >= minDuplicateScore {
+			duplicates = append(duplicates, types.DuplicateCandidate{Client: candidate, Score: score, Reasons: reasons})
+		}
+	}
+
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return duplicates[i].Score > duplicates[j].Score
+	})
+	return duplicates
+}
+
+// scoreDuplicate weighs the name, age and emergency contact similarity of two clients
+// and returns the score with the reasons it is considered a match
+func scoreDuplicate(client types.Client, candidate types.ClientResponse) (float64, []string) {
+	// The same national ID is the same person whatever else differs
+	if client.NationalID != "" && client.NationalID == candidate.NationalID {
+		return 1, []string{"same national ID"}
+	}
+
+	var reasons []string
+	first, last := strings.ToLower(client.FirstName), strings.ToLower(client.LastName)
+
+	// Names are sometimes entered the other way round
+	name := max(
+		(similarity(first, candidate.FirstName)+similarity(last, candidate.LastName))/2,
+		(similarity(first, candidate.LastName)+similarity(last, candidate.FirstName))/2,
+	)
+	if name >= 0.8 {
+		reasons = append(reasons, "similar name")
+	}
+
+	age := 0.0
+	switch diff := client.Age - candidate.Age; {
+	case diff == 0:
+		age = 1
+		reasons = append(reasons, "same age")
+	case diff >= -2 && diff <= 2:
+		age = 0.6
+		reasons = append(reasons, "similar age")
+	}
+
+	emergency := 0.0
+	switch {
+	case normalizePhone(client.EmergencyNumber) != "" && normalizePhone(client.EmergencyNumber) == normalizePhone(candidate.EmergencyNumber):
+		emergency = 1
+		reasons = append(reasons, "same emergency contact number")
+	case similarity(strings.ToLower(client.EmergencyContact), candidate.EmergencyContact) >= 0.8:
+		emergency = 0.5
+		reasons = append(reasons, "similar emergency contact")
+	}
+
+	return 0.6*name + 0.2*age + 0.2*emergency, reasons
+}
+
+// normalizePhone reduces a Kenyan phone number to its last 9 digits
+// so that +254, 254 and 0 prefixed numbers compare equal
+func normalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) > 9 {
+		digits = digits[len(digits)-9:]
+	}
+	return digits
+}
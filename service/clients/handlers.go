@@ -1,10 +1,16 @@
 package clients
 
 import (
+	"cema_backend/auth"
+	"cema_backend/config"
 	"cema_backend/logging"
+	"cema_backend/service/formulary"
+	"cema_backend/service/observations"
 	"cema_backend/types"
+	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,21 +34,101 @@ func validatePhoneNumber(phone string) bool {
 	return matched
 }
 
+// validateClient checks that all the required client fields are set and the demographic details are valid.
+// It returns the error message to send back if one is missing or invalid.
+// The date of birth is expected to have been resolved with resolveDateOfBirth.
+func validateClient(client *types.Client) string {
+	if client.FirstName == "" || client.LastName == "" || client.PhoneNumber == "" || client.DateOfBirth == "" {
+		return "All fields are required"
+	}
+	if client.EmergencyContact == "" || client.EmergencyNumber == "" {
+		return "Emergency contact and number are required"
+	}
+	return validateDemographics(client)
+}
+
+// validateClientUpdate checks the fields of a partial update that were sent, the others are kept as stored.
+// Clients registered before a field was required, such as those without a date of birth, can still be updated.
+// The normalised values of the sent fields are applied to the client.
+func validateClientUpdate(request types.ClientUpdate, client *types.Client) string {
+	for _, field := range []*string{request.FirstName, request.LastName, request.PhoneNumber, request.EmergencyContact, request.EmergencyNumber} {
+		if field != nil && strings.TrimSpace(*field) == "" {
+			return "Fields that are sent cannot be empty"
+		}
+	}
+
+	sent := types.Client{}
+	if request.Sex != nil {
+		sent.Sex = client.Sex
+	}
+	if request.NationalID != nil {
+		sent.NationalID = client.NationalID
+	}
+	if request.BirthCertificateNumber != nil {
+		sent.BirthCertificateNumber = client.BirthCertificateNumber
+	}
+	location := request.County != nil || request.SubCounty != nil || request.Ward != nil
+	if location {
+		sent.County, sent.SubCounty, sent.Ward = client.County, client.SubCounty, client.Ward
+	}
+	if msg := validateDemographics(&sent); msg != "" {
+		return msg
+	}
+	if request.Sex != nil {
+		client.Sex = sent.Sex
+	}
+	if location {
+		client.County = sent.County
+	}
+	return ""
+}
+
+// measurements makes the vitals of the height and weight of a client, those that are nil are not taken
+func measurements(height, weight *float32) types.Vitals {
+	var vitals types.Vitals
+	if height != nil {
+		h := float64(*height)
+		vitals.Height = &h
+	}
+	if weight != nil {
+		w := float64(*weight)
+		vitals.Weight = &w
+	}
+	return vitals
+}
+
 // RegisterClients handles the registration of a new client
 func (h *Handler) RegisterClients(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
 	var request types.Client
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
 		return
 	}
 
-	// Validate the request
-	if request.FirstName == "" || request.LastName == "" || request.PhoneNumber == "" || request.Height == 0 || request.Weight == 0 || request.Age == 0 {
+	// Validate the request, a client who does not know their date of birth gets one estimated from their age
+	if msg := resolveDateOfBirth(&request, time.Now()); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if msg := validateClient(&request); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if request.Sex == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sex is required"})
+		return
+	}
+	// The height and weight taken at registration are recorded as the client's first observation
+	if request.Height == 0 || request.Weight == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "All fields are required"})
 		return
 	}
-	if request.EmergencyContact == "" || request.EmergencyNumber == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Emergency contact and number are required"})
+	if msg := observations.ValidateVitals(measurements(&request.Height, &request.Weight)); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
 		return
 	}
 
@@ -56,8 +142,8 @@ func (h *Handler) RegisterClients(c *gin.Context) {
 		return
 	}
 
-	// Check if the client already exists
-	_, err := h.store.SearchClient(request.PhoneNumber)
+	// Check if the client already exists, archived clients still own their phone numbers
+	_, err := h.store.SearchClient(request.PhoneNumber, true)
 	if err == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Client already exists"})
 		return
@@ -67,30 +153,55 @@ func (h *Handler) RegisterClients(c *gin.Context) {
 		return
 	}
 
+	// Look for clients that may be the same person registered under another number.
+	// They are only reported, a failed lookup does not stop the registration.
+	duplicates := []types.DuplicateCandidate{}
+	candidates, err := h.store.FindDuplicateCandidates(request)
+	if err != nil {
+		logging.Error("Failed to Find Duplicate Clients: " + err.Error())
+	} else {
+		duplicates = findDuplicates(request, candidates)
+	}
+
 	// Register the client
-	err = h.store.RegisterClients(types.Client{
-		FirstName:        request.FirstName,
-		LastName:         request.LastName,
-		PhoneNumber:      request.PhoneNumber,
-		Height:           request.Height,
-		Weight:           request.Weight,
-		Age:              request.Age,
-		EmergencyContact: request.EmergencyContact,
-		EmergencyNumber:  request.EmergencyNumber,
-	})
+	clientID, err := h.store.RegisterClients(types.Client{
+		FirstName:              request.FirstName,
+		LastName:               request.LastName,
+		PhoneNumber:            request.PhoneNumber,
+		Height:                 request.Height,
+		Weight:                 request.Weight,
+		DateOfBirth:            request.DateOfBirth,
+		DOBEstimated:           request.DOBEstimated,
+		Sex:                    request.Sex,
+		NationalID:             request.NationalID,
+		BirthCertificateNumber: request.BirthCertificateNumber,
+		County:                 request.County,
+		SubCounty:              request.SubCounty,
+		Ward:                   request.Ward,
+		EmergencyContact:       request.EmergencyContact,
+		EmergencyNumber:        request.EmergencyNumber,
+	}, actor.ID)
 
 	if err != nil {
 		logging.Error("Failed to Register Client: " + err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error registering client"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Client registered successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Client registered successfully",
+		"id":                  clientID,
+		"possible_duplicates": duplicates,
+	})
 }
 
 // enrollClient handles the enrollment of a client in a program
 func (h *Handler) EnrollClient(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
 	var request struct {
-		PhoneNumber string `json:"phoneNumber" binding:"required"`
+		ClientID    string `json:"client_id" binding:"required"`
 		ProgramName string `json:"programName" binding:"required"`
 	}
 
@@ -101,13 +212,13 @@ func (h *Handler) EnrollClient(c *gin.Context) {
 	}
 
 	// Validate the request
-	if request.PhoneNumber == "" || request.ProgramName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "PhoneNumber and ProgramName are required"})
+	if request.ClientID == "" || request.ProgramName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ClientID and ProgramName are required"})
 		return
 	}
 
 	// Enroll the client
-	err := h.store.EnrollClient(request.PhoneNumber, request.ProgramName)
+	err := h.store.EnrollClient(request.ClientID, request.ProgramName, actor.ID)
 	if err != nil {
 		logging.Error("Failed to Enroll Client: " + err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error enrolling client"})
@@ -116,10 +227,27 @@ func (h *Handler) EnrollClient(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Client enrolled successfully"})
 }
 
-// SearchClient handles the search for a client by email
+// GetClient handles the retrieval of a client by their identifier
+func (h *Handler) GetClient(c *gin.Context) {
+	client, err := h.store.GetClient(c.Param("id"))
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		logging.Error("Failed to Get Client: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving client"})
+		return
+	}
+	c.JSON(http.StatusOK, client)
+}
+
+// SearchClient handles the search for a client by any of their phone numbers.
+// Archived clients are only found when include_archived is set.
 func (h *Handler) SearchClient(c *gin.Context) {
 	var request struct {
-		Phonenumber string `json:"phonenumber" binding:"required"`
+		Phonenumber     string `json:"phonenumber" binding:"required"`
+		IncludeArchived bool   `json:"include_archived"`
 	}
 
 	// Bind the request payload
@@ -135,7 +263,7 @@ func (h *Handler) SearchClient(c *gin.Context) {
 	}
 
 	// Search for the client
-	client, err := h.store.SearchClient(request.Phonenumber)
+	client, err := h.store.SearchClient(request.Phonenumber, request.IncludeArchived)
 	if err != nil {
 		logging.Error("Failed to Search Client: " + err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Client not Found"})
@@ -144,36 +272,305 @@ func (h *Handler) SearchClient(c *gin.Context) {
 	c.JSON(http.StatusOK, client)
 }
 
-// GetAllClients handles the retrieval of all clients
+// FindClients handles the free text search of clients by name, phone number fragment or emergency contact.
+// Results are ranked by relevance and paged with the limit and offset query parameters.
+func (h *Handler) FindClients(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if len([]rune(q)) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query must be at least 2 characters"})
+		return
+	}
+
+	limit := defaultPageSize
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(n, maxPageSize)
+	}
+	offset := 0
+	if value := c.Query("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+		offset = n
+	}
+
+	candidates, truncated, err := h.store.FindClients(q)
+	if err != nil {
+		logging.Error("Failed to Find Clients: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error searching for clients"})
+		return
+	}
+
+	// Rank the candidates and cut out the requested page
+	matches := rankClients(q, candidates)
+	page := types.ClientSearchPage{Items: []types.ClientMatch{}, Total: len(matches), Truncated: truncated}
+	if offset < len(matches) {
+		end := min(offset+limit, len(matches))
+		page.Items = matches[offset:end]
+		if end < len(matches) {
+			page.NextOffset = end
+		}
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// GetAllClients handles the retrieval of a page of clients.
+// It accepts the limit, after, min_age, max_age, program, enrolled_since, name and sort query parameters.
 func (h *Handler) GetAllClients(c *gin.Context) {
-	clients, err := h.store.GetAllClients()
+	query, msg := parseClientQuery(c)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	page, err := h.store.GetAllClients(query)
 	if err != nil {
 		logging.Error("Failed to Get All Clients: " + err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error retrieving clients"})
 		return
 	}
-	c.JSON(http.StatusOK, clients)
+	c.JSON(http.StatusOK, page)
 }
 
-// DeleteClient handles the deletion of a client by phone number
-func (h *Handler) DeleteClient(c *gin.Context) {
-	var request struct {
-		Phonenumber string `json:"phonenumber" binding:"required"`
+// parseClientQuery reads the listing options from the query string
+// and returns the error message to send back if one of them is invalid
+func parseClientQuery(c *gin.Context) (types.ClientQuery, string) {
+	query := types.ClientQuery{
+		Limit:      defaultPageSize,
+		Program:    c.Query("program"),
+		NamePrefix: c.Query("name"),
+		Sort:       c.Query("sort"),
+
+		IncludeArchived: c.Query("archived") == "true",
 	}
 
-	// Validate the request
-	if request.Phonenumber == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number is required"})
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return query, "Invalid limit"
+		}
+		query.Limit = min(n, maxPageSize)
+	}
+	if after := c.Query("after"); after != "" {
+		cursor, err := decodeCursor(after)
+		if err != nil {
+			return query, "Invalid cursor"
+		}
+		query.After = cursor
+	}
+	if minAge := c.Query("min_age"); minAge != "" {
+		n, err := strconv.Atoi(minAge)
+		if err != nil || n < 0 {
+			return query, "Invalid min_age"
+		}
+		query.MinAge = n
+	}
+	if maxAge := c.Query("max_age"); maxAge != "" {
+		n, err := strconv.Atoi(maxAge)
+		if err != nil || n < 0 {
+			return query, "Invalid max_age"
+		}
+		query.MaxAge = n
+	}
+	if since := c.Query("enrolled_since"); since != "" {
+		parsed, err := time.Parse("2006-01-02", since)
+		if err != nil {
+			return query, "Invalid enrolled_since date. Use YYYY-MM-DD"
+		}
+		query.EnrolledSince = parsed
+	}
+	if _, _, ok := parseSort(query.Sort); !ok {
+		return query, "Invalid sort option"
+	}
+	// A cursor holds a value of the column it was sorted by, it cannot continue another sort
+	if query.After != nil && query.After.Sort != sortKey(query.Sort) {
+		return query, "Cursor does not match the sort option"
+	}
+	return query, ""
+}
+
+// UpdateClient handles a partial update of the client identified in the path.
+// Only the fields present in the request body are changed.
+func (h *Handler) UpdateClient(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
 		return
 	}
+	clientID := c.Param("id")
+
+	var request types.ClientUpdate
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
 		return
 	}
-	// Delete the client
-	// Assuming the phone number is unique for each client
-	err := h.store.DeleteClient(request.Phonenumber)
+
+	// Get the current state of the client
+	existing, err := h.store.GetClient(clientID)
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		logging.Error("Error searching for client: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error searching for client"})
+		return
+	}
+	if existing.MergedInto != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Client has been merged", "merged_into": existing.MergedInto})
+		return
+	}
+	if existing.ArchivedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Client is archived"})
+		return
+	}
+
+	client := types.Client{
+		ID:                     existing.ID,
+		UUID:                   existing.UUID,
+		FirstName:              existing.FirstName,
+		LastName:               existing.LastName,
+		PhoneNumber:            existing.PhoneNumber,
+		DateOfBirth:            existing.DateOfBirth,
+		DOBEstimated:           existing.DOBEstimated,
+		Age:                    existing.Age,
+		Sex:                    existing.Sex,
+		NationalID:             existing.NationalID,
+		BirthCertificateNumber: existing.BirthCertificateNumber,
+		County:                 existing.County,
+		SubCounty:              existing.SubCounty,
+		Ward:                   existing.Ward,
+		EmergencyContact:       existing.EmergencyContact,
+		EmergencyNumber:        existing.EmergencyNumber,
+	}
+
+	// Apply the fields that were sent
+	if request.FirstName != nil {
+		client.FirstName = *request.FirstName
+	}
+	if request.LastName != nil {
+		client.LastName = *request.LastName
+	}
+	if request.PhoneNumber != nil {
+		client.PhoneNumber = *request.PhoneNumber
+	}
+	// A new date of birth is exact unless said otherwise, a new age replaces it with an estimate
+	if request.DateOfBirth != nil {
+		client.DateOfBirth = *request.DateOfBirth
+		client.DOBEstimated = false
+	} else if request.Age != nil {
+		client.DateOfBirth = ""
+		client.Age = *request.Age
+	}
+	if request.DOBEstimated != nil {
+		client.DOBEstimated = *request.DOBEstimated
+	}
+	if request.Sex != nil {
+		client.Sex = *request.Sex
+	}
+	if request.NationalID != nil {
+		client.NationalID = *request.NationalID
+	}
+	if request.BirthCertificateNumber != nil {
+		client.BirthCertificateNumber = *request.BirthCertificateNumber
+	}
+	if request.County != nil {
+		client.County = *request.County
+	}
+	if request.SubCounty != nil {
+		client.SubCounty = *request.SubCounty
+	}
+	if request.Ward != nil {
+		client.Ward = *request.Ward
+	}
+	// A height or weight that is sent is recorded as a new observation, the previous ones are kept
+	if request.Height != nil || request.Weight != nil {
+		if msg := observations.ValidateVitals(measurements(request.Height, request.Weight)); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if request.Height != nil {
+			client.Height = *request.Height
+		}
+		if request.Weight != nil {
+			client.Weight = *request.Weight
+		}
+	}
+	if request.EmergencyContact != nil {
+		client.EmergencyContact = *request.EmergencyContact
+	}
+	if request.EmergencyNumber != nil {
+		client.EmergencyNumber = *request.EmergencyNumber
+	}
+
+	// Validate the fields that were sent
+	if request.DateOfBirth != nil || request.Age != nil {
+		if msg := resolveDateOfBirth(&client, time.Now()); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+	}
+	if msg := validateClientUpdate(request, &client); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	// Only the numbers that changed are validated, stored ones are left as they are
+	if client.PhoneNumber != existing.PhoneNumber {
+		if !validatePhoneNumber(client.PhoneNumber) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number format"})
+			return
+		}
+		// The new number must not belong to another client
+		owner, err := h.store.SearchClient(client.PhoneNumber, true)
+		if err == nil && owner.UUID != existing.UUID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Client already exists"})
+			return
+		} else if err != nil && err.Error() != "client does not exist" {
+			logging.Error("Error searching for client: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error searching for client"})
+			return
+		}
+	}
+	if client.EmergencyNumber != existing.EmergencyNumber && !validatePhoneNumber(client.EmergencyNumber) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid emergency contact number format"})
+		return
+	}
+
+	if err := h.store.UpdateClient(client, actor.ID); err != nil {
+		logging.Error("Failed to Update Client: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating client"})
+		return
+	}
+
+	// Return the client as it is now stored
+	updated, err := h.store.GetClient(clientID)
+	if err != nil {
+		logging.Error("Failed to Get Client: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving updated client"})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteClient handles the deletion of the client identified in the path.
+// The client is archived with the authenticated user recorded as the one who archived them.
+func (h *Handler) DeleteClient(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	err := h.store.DeleteClient(c.Param("id"), actor.ID)
 	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
 		logging.Error("Failed to Delete Client: " + err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error deleting client"})
 		return
@@ -181,58 +578,483 @@ func (h *Handler) DeleteClient(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Client deleted successfully"})
 }
 
-// CreatePrescription handles the creation of a new prescription
-func (h *Handler) CreatePrescription(c *gin.Context) {
+// RestoreClient handles bringing back an archived client
+func (h *Handler) RestoreClient(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	err := h.store.RestoreClient(c.Param("id"), actor.ID)
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Archived client not Found"})
+			return
+		}
+		logging.Error("Failed to Restore Client: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error restoring client"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Client restored successfully"})
+}
+
+// PurgeArchivedClients handles running the retention purge straight away
+// and returns the report of what was removed
+func (h *Handler) PurgeArchivedClients(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	days := config.Envs.ClientRetentionDays
+	if days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Client retention purge is disabled"})
+		return
+	}
+
+	report, err := purgeArchivedClients(h.store, days, actor.ID)
+	if err != nil {
+		logging.Error("Failed to Purge Archived Clients: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error purging archived clients"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// MergeClients handles merging a duplicate client into the client identified in the path.
+// The duplicate's enrollments, prescriptions and phone numbers are moved over and it is kept as a tombstone.
+func (h *Handler) MergeClients(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
 	var request struct {
-		ClientPhone string   `json:"client_phone"`
-		DoctorID    int      `json:"doctor_id"`
-		Medicines   []string `json:"medicines"`
-		DateIssued  string   `json:"date_issued"`
+		DuplicateID string `json:"duplicate_id" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
 		return
 	}
 
-	// Convert medicines array to comma-separated string
-	medicinesStr := strings.Join(request.Medicines, ",")
+	survivorID := c.Param("id")
+	if request.DuplicateID == survivorID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A client cannot be merged into itself"})
+		return
+	}
+
+	err := h.store.MergeClients(survivorID, request.DuplicateID, actor.ID)
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		logging.Error("Failed to Merge Clients: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error merging clients"})
+		return
+	}
+
+	// Return the surviving client with everything merged into it
+	survivor, err := h.store.GetClient(survivorID)
+	if err != nil {
+		logging.Error("Failed to Get Client: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving merged client"})
+		return
+	}
+	c.JSON(http.StatusOK, survivor)
+}
+
+// AddClientPhone handles adding a phone number to a client, or making one of their numbers the primary number
+func (h *Handler) AddClientPhone(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	var request types.ClientPhone
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	if !validatePhoneNumber(request.PhoneNumber) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number format"})
+		return
+	}
+
+	err := h.store.AddClientPhone(c.Param("id"), request, actor.ID)
+	if err != nil {
+		logging.Error("Failed to Add Client Phone: " + err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error adding phone number"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Phone number added successfully"})
+}
+
+// RemoveClientPhone handles removing one of the client's secondary phone numbers
+func (h *Handler) RemoveClientPhone(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	err := h.store.RemoveClientPhone(c.Param("id"), c.Param("phonenumber"), actor.ID)
+	if err != nil {
+		logging.Error("Failed to Remove Client Phone: " + err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error removing phone number"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Phone number removed successfully"})
+}
+
+// prescriptionRequest is the body of both creating and amending a prescription
+type prescriptionRequest struct {
+	// ID is the prescription being amended, it is not sent when creating one
+	ID          int                      `json:"id"`
+	ClientID    string                   `json:"client_id"`
+	DoctorID    int                      `json:"doctor_id"`
+	EncounterID int                      `json:"encounter_id"`
+	Items       []types.PrescriptionItem `json:"items"`
+	DateIssued  string                   `json:"date_issued"`
+	// OverrideReason is required to prescribe despite blocking warnings
+	OverrideReason string `json:"override_reason"`
+	Refills        int    `json:"refills"`
+	// ValidityDays is how long the prescription can be dispensed for, 30 days by default
+	// and unchanged when amending without it
+	ValidityDays int `json:"validity_days"`
+}
+
+// bindPrescription binds and validates a prescription request, returning the date it was issued.
+// When the request is invalid it responds and ok is false.
+func bindPrescription(c *gin.Context, actor types.Actor) (request prescriptionRequest, dateIssued time.Time, ok bool) {
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return request, dateIssued, false
+	}
+	// Prescriptions are always issued, and amended, by the signed in doctor
+	if !actingDoctor(c, actor, request.DoctorID) {
+		return request, dateIssued, false
+	}
+
+	if msg := validatePrescriptionItems(request.Items); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return request, dateIssued, false
+	}
 
 	// Parse date
-	parsedDate, err := time.Parse("02/01/2006", request.DateIssued)
+	dateIssued, err := time.Parse("02/01/2006", request.DateIssued)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use DD/MM/YYYY"})
+		return request, dateIssued, false
+	}
+
+	if request.Refills < 0 || request.Refills > maxRefills {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Refills must be between 0 and %d", maxRefills)})
+		return request, dateIssued, false
+	}
+	if request.ValidityDays < 0 || request.ValidityDays > maxValidityDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Validity must be between 1 and %d days", maxValidityDays)})
+		return request, dateIssued, false
+	}
+	return request, dateIssued, true
+}
+
+// CreatePrescription handles the creation of a new prescription issued by the signed in doctor
+func (h *Handler) CreatePrescription(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	request, parsedDate, ok := bindPrescription(c, actor)
+	if !ok {
 		return
 	}
+	if request.ValidityDays == 0 {
+		request.ValidityDays = defaultValidityDays
+	}
 
 	prescription := types.Prescription{
-		ClientPhone: request.ClientPhone,
-		DoctorID:    request.DoctorID,
-		Medicines:   medicinesStr,
-		DateIssued:  parsedDate,
+		ClientID:       request.ClientID,
+		DoctorID:       actor.ID,
+		EncounterID:    request.EncounterID,
+		Items:          request.Items,
+		DateIssued:     parsedDate,
+		OverrideReason: request.OverrideReason,
+		Refills:        request.Refills,
+		ValidUntil:     parsedDate.AddDate(0, 0, request.ValidityDays),
 	}
 
-	err = h.store.CreatePrescription(prescription)
+	id, warnings, err := h.store.CreatePrescription(prescription)
 	if err != nil {
+		if err.Error() == "prescription has blocking warnings" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Prescription has blocking warnings, an override_reason is required", "warnings": warnings})
+			return
+		}
+		if err.Error() == "encounter does not exist" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Encounter not Found for this client"})
+			return
+		}
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		if err.Error() == "drug is not in the formulary" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Drug not Found in the formulary or inactive"})
+			return
+		}
 		logging.Error("Failed to create prescription: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating prescription"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Prescription created successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Prescription created successfully", "id": id, "warnings": warnings})
 }
 
-// UpdatePrescription handles the updating of an existing prescription
+// UpdatePrescription handles amending a prescription that has not been dispensed, the items sent replace the previous ones
 func (h *Handler) UpdatePrescription(c *gin.Context) {
-	var request types.Prescription
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	request, parsedDate, ok := bindPrescription(c, actor)
+	if !ok {
 		return
 	}
 
-	err := h.store.UpdatePrescription(request)
+	// The client and encounter of a prescription do not change
+	prescription := types.Prescription{
+		ID:             request.ID,
+		Items:          request.Items,
+		DateIssued:     parsedDate,
+		OverrideReason: request.OverrideReason,
+		Refills:        request.Refills,
+	}
+	if request.ValidityDays > 0 {
+		prescription.ValidUntil = parsedDate.AddDate(0, 0, request.ValidityDays)
+	}
+
+	warnings, err := h.store.UpdatePrescription(prescription, actor.ID)
 	if err != nil {
+		if err.Error() == "prescription has blocking warnings" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Prescription has blocking warnings, an override_reason is required", "warnings": warnings})
+			return
+		}
+		if err.Error() == "prescription does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not Found"})
+			return
+		}
+		if err.Error() == "prescription cannot be amended" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Prescription has been dispensed, cancelled or has expired and cannot be amended"})
+			return
+		}
+		if err.Error() == "drug is not in the formulary" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Drug not Found in the formulary or inactive"})
+			return
+		}
 		logging.Error("Failed to update prescription: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating prescription"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Prescription updated successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Prescription updated successfully", "warnings": warnings})
+}
+
+// GetPrescription handles retrieving a prescription with its history
+func (h *Handler) GetPrescription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prescription id"})
+		return
+	}
+
+	prescription, err := h.store.GetPrescription(id)
+	if err != nil {
+		if err.Error() == "prescription does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not Found"})
+			return
+		}
+		logging.Error("Failed to get prescription: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving prescription"})
+		return
+	}
+	c.JSON(http.StatusOK, prescription)
+}
+
+// ChangePrescriptionStatus handles moving a prescription to another status by the signed in doctor,
+// a cancellation needs a reason
+func (h *Handler) ChangePrescriptionStatus(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prescription id"})
+		return
+	}
+
+	var request struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	request.Status = strings.ToLower(strings.TrimSpace(request.Status))
+	request.Reason = strings.TrimSpace(request.Reason)
+
+	// Dispensing is recorded by the pharmacy along with the stock it is issued from
+	switch request.Status {
+	case statusCompleted, statusCancelled:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be completed or cancelled, prescriptions are dispensed from the pharmacy"})
+		return
+	}
+	if request.Status == statusCancelled && request.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to cancel a prescription"})
+		return
+	}
+
+	err = h.store.ChangePrescriptionStatus(id, request.Status, request.Reason, actor.ID)
+	if err != nil {
+		prescriptionLifecycleError(c, err, "Failed to change prescription status: ", "Error changing prescription status")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Prescription status changed successfully"})
+}
+
+// RefillPrescription handles using a refill of a dispensed prescription so it can be dispensed again
+func (h *Handler) RefillPrescription(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prescription id"})
+		return
+	}
+
+	err = h.store.RefillPrescription(id, actor.ID)
+	if err != nil {
+		prescriptionLifecycleError(c, err, "Failed to refill prescription: ", "Error refilling prescription")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Prescription refilled successfully"})
+}
+
+// actingDoctor checks a doctor_id sent in a request against the signed in doctor.
+// Leaving it out is allowed, a different doctor is refused with 403 Forbidden.
+func actingDoctor(c *gin.Context, actor types.Actor, doctorID int) bool {
+	if doctorID != 0 && doctorID != actor.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "doctor_id does not match the signed in doctor"})
+		return false
+	}
+	return true
+}
+
+// prescriptionLifecycleError sends back the error of a status change or refill
+func prescriptionLifecycleError(c *gin.Context, err error, logMessage string, message string) {
+	switch err.Error() {
+	case "prescription does not exist":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not Found"})
+	case "prescription status cannot be changed":
+		c.JSON(http.StatusConflict, gin.H{"error": "Prescription cannot be changed to this status from its current status"})
+	case "prescription has expired":
+		c.JSON(http.StatusConflict, gin.H{"error": "Prescription has expired"})
+	case "no refills remaining":
+		c.JSON(http.StatusConflict, gin.H{"error": "Prescription has no refills remaining"})
+	default:
+		logging.Error(logMessage + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// GetActiveMedications handles listing the medications a client is currently on
+func (h *Handler) GetActiveMedications(c *gin.Context) {
+	medications, err := h.store.GetActiveMedications(c.Param("id"))
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		logging.Error("Failed to get active medications: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving active medications"})
+		return
+	}
+	c.JSON(http.StatusOK, medications)
+}
+
+// AddClientAllergy handles recording an allergy or intolerance of a client, by the signed in doctor
+func (h *Handler) AddClientAllergy(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	var allergy types.Allergy
+	if err := c.ShouldBindJSON(&allergy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+
+	allergy.Substance = strings.TrimSpace(allergy.Substance)
+	allergy.ATCCode = strings.ToUpper(strings.TrimSpace(allergy.ATCCode))
+	allergy.Kind = strings.ToLower(strings.TrimSpace(allergy.Kind))
+	allergy.Severity = strings.ToLower(strings.TrimSpace(allergy.Severity))
+	if allergy.Kind == "" {
+		allergy.Kind = "allergy"
+	}
+	if allergy.Severity == "" {
+		allergy.Severity = "moderate"
+	}
+	if allergy.Substance == "" && allergy.DrugID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Substance or drug_id is required"})
+		return
+	}
+	if allergy.Kind != "allergy" && allergy.Kind != "intolerance" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Kind must be allergy or intolerance"})
+		return
+	}
+	if allergy.Severity != "mild" && allergy.Severity != "moderate" && allergy.Severity != "severe" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Severity must be mild, moderate or severe"})
+		return
+	}
+	if allergy.ATCCode != "" && !formulary.ATCCodePattern.MatchString(allergy.ATCCode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ATC code"})
+		return
+	}
+
+	id, err := h.store.AddClientAllergy(c.Param("id"), allergy, actor.ID)
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		if err.Error() == "drug is not in the formulary" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Drug not Found in the formulary"})
+			return
+		}
+		logging.Error("Failed to add allergy: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error adding allergy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Allergy recorded successfully", "id": id})
+}
+
+// ResolveClientAllergy handles marking an allergy of a client as no longer active
+func (h *Handler) ResolveClientAllergy(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	allergyID, err := strconv.Atoi(c.Param("allergy_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid allergy id"})
+		return
+	}
+
+	err = h.store.ResolveClientAllergy(c.Param("id"), allergyID, actor.ID)
+	if err != nil {
+		if err.Error() == "allergy does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Allergy not Found"})
+			return
+		}
+		logging.Error("Failed to resolve allergy: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resolving allergy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Allergy resolved successfully"})
 }
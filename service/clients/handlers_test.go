@@ -2,11 +2,15 @@ package clients
 
 import (
 	"bytes"
+	"cema_backend/auth"
 	"cema_backend/types"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/mock"
@@ -19,58 +23,158 @@ type MockClientStore struct {
 }
 
 // CreatePrescription implements types.ClientStore.
-func (m *MockClientStore) CreatePrescription(prescription types.Prescription) error {
+func (m *MockClientStore) CreatePrescription(prescription types.Prescription) (int, []types.PrescriptionWarning, error) {
 	args := m.Called(prescription)
-	return args.Error(0)
+	return args.Int(0), args.Get(1).([]types.PrescriptionWarning), args.Error(2)
 }
 
 // GetPrescriptionsByClient implements types.ClientStore.
-func (m *MockClientStore) GetPrescriptionsByClient(client_phone string) ([]types.Prescription, error) {
-	args := m.Called(client_phone)
+func (m *MockClientStore) GetPrescriptionsByClient(clientID string) ([]types.Prescription, error) {
+	args := m.Called(clientID)
 	return args.Get(0).([]types.Prescription), args.Error(1)
 }
 
 // UpdatePrescription implements types.ClientStore.
-func (m *MockClientStore) UpdatePrescription(prescription types.Prescription) error {
-	args := m.Called(prescription)
+func (m *MockClientStore) UpdatePrescription(prescription types.Prescription, recordedBy int) ([]types.PrescriptionWarning, error) {
+	args := m.Called(prescription, recordedBy)
+	return args.Get(0).([]types.PrescriptionWarning), args.Error(1)
+}
+
+// GetPrescription implements types.ClientStore.
+func (m *MockClientStore) GetPrescription(id int) (types.Prescription, error) {
+	args := m.Called(id)
+	return args.Get(0).(types.Prescription), args.Error(1)
+}
+
+// ChangePrescriptionStatus implements types.ClientStore.
+func (m *MockClientStore) ChangePrescriptionStatus(id int, status string, reason string, changedBy int) error {
+	args := m.Called(id, status, reason, changedBy)
 	return args.Error(0)
 }
 
+// RefillPrescription implements types.ClientStore.
+func (m *MockClientStore) RefillPrescription(id int, refilledBy int) error {
+	args := m.Called(id, refilledBy)
+	return args.Error(0)
+}
+
+// GetActiveMedications implements types.ClientStore.
+func (m *MockClientStore) GetActiveMedications(clientID string) ([]types.Medication, error) {
+	args := m.Called(clientID)
+	return args.Get(0).([]types.Medication), args.Error(1)
+}
+
 // DeleteClient implements types.ClientStore.
-func (m *MockClientStore) DeleteClient(phonenumber string) error {
-	args := m.Called(phonenumber)
+func (m *MockClientStore) DeleteClient(clientID string, archivedBy int) error {
+	args := m.Called(clientID, archivedBy)
 	return args.Error(0)
 }
 
-// GetAllClients implements types.ClientStore.
-func (m *MockClientStore) GetAllClients() ([]types.Client, error) {
-	args := m.Called()
-	return args.Get(0).([]types.Client), args.Error(1)
+// RestoreClient implements types.ClientStore.
+func (m *MockClientStore) RestoreClient(clientID string, restoredBy int) error {
+	args := m.Called(clientID, restoredBy)
+	return args.Error(0)
 }
 
-// RegisterClients implements types.ClientStore.
-func (m *MockClientStore) RegisterClients(client types.Client) error {
+// PurgeArchivedClients implements types.ClientStore.
+func (m *MockClientStore) PurgeArchivedClients(before time.Time, purgedBy int) (types.PurgeReport, error) {
+	args := m.Called(before, purgedBy)
+	return args.Get(0).(types.PurgeReport), args.Error(1)
+}
+
+// FindDuplicateCandidates implements types.ClientStore.
+func (m *MockClientStore) FindDuplicateCandidates(client types.Client) ([]types.ClientResponse, error) {
 	args := m.Called(client)
+	return args.Get(0).([]types.ClientResponse), args.Error(1)
+}
+
+// MergeClients implements types.ClientStore.
+func (m *MockClientStore) MergeClients(survivorID string, duplicateID string, mergedBy int) error {
+	args := m.Called(survivorID, duplicateID, mergedBy)
+	return args.Error(0)
+}
+
+// AddClientPhone implements types.ClientStore.
+func (m *MockClientStore) AddClientPhone(clientID string, phone types.ClientPhone, addedBy int) error {
+	args := m.Called(clientID, phone, addedBy)
 	return args.Error(0)
 }
 
+// RemoveClientPhone implements types.ClientStore.
+func (m *MockClientStore) RemoveClientPhone(clientID string, phonenumber string, removedBy int) error {
+	args := m.Called(clientID, phonenumber, removedBy)
+	return args.Error(0)
+}
+
+// GetAllClients implements types.ClientStore.
+func (m *MockClientStore) GetAllClients(query types.ClientQuery) (types.ClientPage, error) {
+	args := m.Called(query)
+	return args.Get(0).(types.ClientPage), args.Error(1)
+}
+
+// RegisterClients implements types.ClientStore.
+func (m *MockClientStore) RegisterClients(client types.Client, registeredBy int) (string, error) {
+	args := m.Called(client, registeredBy)
+	return args.String(0), args.Error(1)
+}
+
+// GetClient implements types.ClientStore.
+func (m *MockClientStore) GetClient(clientID string) (types.ClientResponse, error) {
+	args := m.Called(clientID)
+	return args.Get(0).(types.ClientResponse), args.Error(1)
+}
+
 // SearchClient implements types.ClientStore.
-func (m *MockClientStore) SearchClient(phonenumber string) (types.ClientResponse, error) {
-	args := m.Called(phonenumber)
+func (m *MockClientStore) SearchClient(phonenumber string, includeArchived bool) (types.ClientResponse, error) {
+	args := m.Called(phonenumber, includeArchived)
 	return args.Get(0).(types.ClientResponse), args.Error(1)
 }
 
+// FindClients implements types.ClientStore.
+func (m *MockClientStore) FindClients(q string) ([]types.ClientResponse, bool, error) {
+	args := m.Called(q)
+	return args.Get(0).([]types.ClientResponse), args.Bool(1), args.Error(2)
+}
+
 // UpdateClient implements types.ClientStore.
-func (m *MockClientStore) UpdateClient(client types.Client) error {
-	args := m.Called(client)
+func (m *MockClientStore) UpdateClient(client types.Client, recordedBy int) error {
+	args := m.Called(client, recordedBy)
+	return args.Error(0)
+}
+
+func (m *MockClientStore) EnrollClient(clientID, programName string, enrolledBy int) error {
+	args := m.Called(clientID, programName, enrolledBy)
+	return args.Error(0)
+}
+
+// AddClientAllergy implements types.ClientStore.
+func (m *MockClientStore) AddClientAllergy(clientID string, allergy types.Allergy, recordedBy int) (int, error) {
+	args := m.Called(clientID, allergy, recordedBy)
+	return args.Int(0), args.Error(1)
+}
+
+// ResolveClientAllergy implements types.ClientStore.
+func (m *MockClientStore) ResolveClientAllergy(clientID string, allergyID int, resolvedBy int) error {
+	args := m.Called(clientID, allergyID, resolvedBy)
 	return args.Error(0)
 }
 
-func (m *MockClientStore) EnrollClient(email, programID string) error {
-	args := m.Called(email, programID)
+// ReplaceInteractionRules implements types.ClientStore.
+func (m *MockClientStore) ReplaceInteractionRules(rules []types.InteractionRule) error {
+	args := m.Called(rules)
 	return args.Error(0)
 }
 
+// doctor is the authenticated doctor of the tests
+var doctor = types.Actor{ID: 1, Email: "stan@rfh.com", Role: "doctor"}
+
+// signedIn stands in for the auth middleware setting the authenticated doctor
+func signedIn(actor types.Actor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth.SetActor(c, actor)
+	}
+}
+
 func TestEnrollClient(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -78,14 +182,15 @@ func TestEnrollClient(t *testing.T) {
 	handler := NewHandler(mockStore)
 
 	router := gin.Default()
+	router.Use(signedIn(doctor))
 	router.POST("/enroll", handler.EnrollClient)
 
 	// Test case: Successful enrollment
-	mockStore.On("EnrollClient", "client@example.com", "program123").Return(nil)
+	mockStore.On("EnrollClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", "program123", doctor.ID).Return(nil)
 
 	payload := map[string]string{
-		"email":     "client@example.com",
-		"programID": "program123",
+		"client_id":   "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11",
+		"programName": "program123",
 	}
 	body, _ := json.Marshal(payload)
 
@@ -96,7 +201,7 @@ func TestEnrollClient(t *testing.T) {
 	router.ServeHTTP(resp, req)
 
 	require.Equal(t, http.StatusOK, resp.Code)
-	mockStore.AssertCalled(t, "EnrollClient", "client@example.com", "program123")
+	mockStore.AssertCalled(t, "EnrollClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", "program123", doctor.ID)
 }
 
 func TestSearchClient(t *testing.T) {
@@ -122,7 +227,7 @@ func TestSearchClient(t *testing.T) {
 		EmergencyNumber:  "0987654321",
 	}
 	// Add mock behavior for SearchClient
-	mockStore.On("SearchClient", "1234567890").Return(mockClient, nil)
+	mockStore.On("SearchClient", "1234567890", false).Return(mockClient, nil)
 
 	payload := map[string]string{
 		"phonenumber": "1234567890",
@@ -136,7 +241,7 @@ func TestSearchClient(t *testing.T) {
 	router.ServeHTTP(resp, req)
 
 	require.Equal(t, http.StatusOK, resp.Code)
-	mockStore.AssertCalled(t, "SearchClient", "1234567890")
+	mockStore.AssertCalled(t, "SearchClient", "1234567890", false)
 }
 func TestGetAllClients(t *testing.T) {
 	gin.SetMode(gin.TestMode)
@@ -147,26 +252,64 @@ func TestGetAllClients(t *testing.T) {
 	router := gin.Default()
 	router.GET("/getall", handler.GetAllClients)
 
-	// Test case: Successful retrieval of all clients
-	mockClients := []types.Client{
-		{
-			ID:               1,
-			FirstName:        "John",
-			LastName:         "Doe",
-			PhoneNumber:      "1234567890",
-			EmergencyContact: "Jane Doe",
-			EmergencyNumber:  "0987654321",
+	// Test case: Successful retrieval of a filtered page of clients
+	mockPage := types.ClientPage{
+		Items: []types.Client{
+			{
+				UUID:             "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11",
+				FirstName:        "John",
+				LastName:         "Doe",
+				PhoneNumber:      "1234567890",
+				EmergencyContact: "Jane Doe",
+				EmergencyNumber:  "0987654321",
+			},
 		},
+		NextCursor: encodeCursor(types.ClientCursor{Sort: "-lastname", Value: "Doe", ID: 1}),
+		Total:      3,
+	}
+	cursor := types.ClientCursor{Sort: "-lastname", Value: "Ali", ID: 7}
+	expectedQuery := types.ClientQuery{
+		Limit:         1,
+		After:         &cursor,
+		MinAge:        18,
+		Program:       "Malaria",
+		EnrolledSince: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		NamePrefix:    "Jo",
+		Sort:          "-lastname",
 	}
-	mockStore.On("GetAllClients").Return(mockClients, nil)
+	mockStore.On("GetAllClients", expectedQuery).Return(mockPage, nil)
 
-	req, _ := http.NewRequest(http.MethodGet, "/getall", nil)
+	url := "/getall?limit=1&min_age=18&program=Malaria&enrolled_since=2025-01-01&name=Jo&sort=-lastname&after=" + encodeCursor(cursor)
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
 	resp := httptest.NewRecorder()
 
 	router.ServeHTTP(resp, req)
 
 	require.Equal(t, http.StatusOK, resp.Code)
-	mockStore.AssertCalled(t, "GetAllClients")
+	var response types.ClientPage
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, mockPage, response)
+	mockStore.AssertCalled(t, "GetAllClients", expectedQuery)
+
+	// Test case: An unknown sort option is rejected
+	req, _ = http.NewRequest(http.MethodGet, "/getall?sort=password", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Test case: A cursor of a listing by surname cannot continue a listing by id or age
+	for _, sort := range []string{"", "id", "age", "lastname"} {
+		req, _ = http.NewRequest(http.MethodGet, "/getall?sort="+sort+"&after="+encodeCursor(cursor), nil)
+		resp = httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, sort)
+		require.JSONEq(t, `{"error": "Cursor does not match the sort option"}`, resp.Body.String())
+	}
+	mockStore.AssertNumberOfCalls(t, "GetAllClients", 1)
 }
 func TestRegisterClients(t *testing.T) {
 	gin.SetMode(gin.TestMode)
@@ -175,17 +318,26 @@ func TestRegisterClients(t *testing.T) {
 	handler := NewHandler(mockStore)
 
 	router := gin.Default()
+	router.Use(signedIn(doctor))
 	router.POST("/register", handler.RegisterClients)
 
 	// Test case: Successful registration
-	mockStore.On("SearchClient", "0115491173").Return(types.ClientResponse{}, nil)
-	mockStore.On("RegisterClients", mock.Anything).Return(nil)
+	mockStore.On("SearchClient", "0115491173", true).Return(types.ClientResponse{}, errors.New("client does not exist"))
+	mockStore.On("RegisterClients", mock.Anything, doctor.ID).Return("3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", nil)
+	// The same person registered earlier under another number is reported
+	mockStore.On("FindDuplicateCandidates", mock.Anything).Return([]types.ClientResponse{
+		{UUID: "7d1c2e4a-0b5f-4a61-8c3e-5e2f9a7b6d20", FirstName: "Jon", LastName: "Doe", Age: 10,
+			EmergencyContact: "father", EmergencyNumber: "+254987654321"},
+		{UUID: "9a8b7c6d-1e2f-4a3b-8c4d-5e6f7a8b9c0d", FirstName: "Mary", LastName: "Doe", Age: 41,
+			EmergencyContact: "husband", EmergencyNumber: "0711111111"},
+	}, nil)
 
 	payload := map[string]interface{}{
 		"firstname":         "John",
 		"lastname":          "Doe",
 		"phonenumber":       "0115491173",
 		"age":               10,
+		"sex":               "male",
 		"height":            180,
 		"weight":            80,
 		"emergency_contact": "father",
@@ -200,6 +352,642 @@ func TestRegisterClients(t *testing.T) {
 	router.ServeHTTP(resp, req)
 
 	require.Equal(t, http.StatusOK, resp.Code)
-	mockStore.AssertCalled(t, "SearchClient", "0115491173")
-	mockStore.AssertCalled(t, "RegisterClients", mock.Anything)
+	mockStore.AssertCalled(t, "SearchClient", "0115491173", true)
+	// Only the age was known so the date of birth is estimated from it
+	mockStore.AssertCalled(t, "RegisterClients", mock.MatchedBy(func(client types.Client) bool {
+		return client.DOBEstimated && client.DateOfBirth == time.Now().AddDate(-10, 0, 0).Format("2006-01-02")
+	}), doctor.ID)
+
+	var response struct {
+		ID         string                     `json:"id"`
+		Duplicates []types.DuplicateCandidate `json:"possible_duplicates"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", response.ID)
+	require.Len(t, response.Duplicates, 1)
+	require.Equal(t, "7d1c2e4a-0b5f-4a61-8c3e-5e2f9a7b6d20", response.Duplicates[0].Client.UUID)
+	require.Contains(t, response.Duplicates[0].Reasons, "same emergency contact number")
+
+	// Test case: A height that is not plausible is refused like any observation
+	payload["height"] = 1800
+	body, _ = json.Marshal(payload)
+	req, _ = http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), "Height is out of range")
+	mockStore.AssertNumberOfCalls(t, "RegisterClients", 1)
+}
+
+func TestMergeClients(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockClientStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.Use(signedIn(doctor))
+	router.POST("/:id/merge", handler.MergeClients)
+
+	// Test case: The duplicate is merged and the survivor is returned
+	survivor := types.ClientResponse{UUID: "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", FirstName: "John", LastName: "Doe"}
+	mockStore.On("MergeClients", survivor.UUID, "7d1c2e4a-0b5f-4a61-8c3e-5e2f9a7b6d20", doctor.ID).Return(nil)
+	mockStore.On("GetClient", survivor.UUID).Return(survivor, nil)
+
+	body, _ := json.Marshal(map[string]string{"duplicate_id": "7d1c2e4a-0b5f-4a61-8c3e-5e2f9a7b6d20"})
+	req, _ := http.NewRequest(http.MethodPost, "/"+survivor.UUID+"/merge", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	mockStore.AssertCalled(t, "MergeClients", survivor.UUID, "7d1c2e4a-0b5f-4a61-8c3e-5e2f9a7b6d20", doctor.ID)
+
+	// Test case: A client cannot be merged into itself
+	body, _ = json.Marshal(map[string]string{"duplicate_id": survivor.UUID})
+	req, _ = http.NewRequest(http.MethodPost, "/"+survivor.UUID+"/merge", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	mockStore.AssertNumberOfCalls(t, "MergeClients", 1)
+}
+
+func TestUpdateClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockClientStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.Use(signedIn(doctor))
+	router.PATCH("/:id", handler.UpdateClient)
+
+	existing := types.ClientResponse{
+		ID:               1,
+		UUID:             "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11",
+		FirstName:        "Jon",
+		LastName:         "Doe",
+		PhoneNumber:      "0115491173",
+		Height:           180,
+		Weight:           75,
+		DateOfBirth:      "1994-03-12",
+		Age:              30,
+		Sex:              "male",
+		EmergencyContact: "Jane Doe",
+		EmergencyNumber:  "0712345678",
+	}
+	updated := existing
+	updated.FirstName = "John"
+	updated.Weight = 72
+
+	// Test case: Successful partial update, only the sent fields change and only the new weight is recorded
+	mockStore.On("GetClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11").Return(existing, nil).Once()
+	mockStore.On("UpdateClient", types.Client{
+		ID:               1,
+		UUID:             "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11",
+		FirstName:        "John",
+		LastName:         "Doe",
+		PhoneNumber:      "0115491173",
+		DateOfBirth:      "1994-03-12",
+		Age:              30,
+		Sex:              "male",
+		Weight:           72,
+		EmergencyContact: "Jane Doe",
+		EmergencyNumber:  "0712345678",
+	}, doctor.ID).Return(nil)
+	mockStore.On("GetClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11").Return(updated, nil).Once()
+
+	payload := map[string]interface{}{
+		"firstname": "John",
+		"weight":    72,
+	}
+	body, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest(http.MethodPatch, "/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var response types.ClientResponse
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, "John", response.FirstName)
+	require.Equal(t, updated.UUID, response.UUID)
+	mockStore.AssertNumberOfCalls(t, "UpdateClient", 1)
+
+	// Test case: A changed phone number is validated before anything is saved
+	mockStore.On("GetClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11").Return(existing, nil).Once()
+
+	body, _ = json.Marshal(map[string]string{"phonenumber": "12345"})
+	req, _ = http.NewRequest(http.MethodPatch, "/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	mockStore.AssertNumberOfCalls(t, "UpdateClient", 1)
+
+	// Test case: An invalid national ID is rejected
+	mockStore.On("GetClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11").Return(existing, nil).Once()
+
+	body, _ = json.Marshal(map[string]string{"national_id": "12AB"})
+	req, _ = http.NewRequest(http.MethodPatch, "/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	mockStore.AssertNumberOfCalls(t, "UpdateClient", 1)
+
+	// Test case: A weight that is not plausible is refused like any observation
+	mockStore.On("GetClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11").Return(existing, nil).Once()
+
+	body, _ = json.Marshal(map[string]float64{"weight": 0.1})
+	req, _ = http.NewRequest(http.MethodPatch, "/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	mockStore.AssertNumberOfCalls(t, "UpdateClient", 1)
+
+	// Test case: A client registered without a date of birth can have other fields updated
+	legacy := existing
+	legacy.UUID = "legacy-client"
+	legacy.DateOfBirth = ""
+	legacy.Age = 0
+	legacy.Sex = ""
+	mockStore.On("GetClient", "legacy-client").Return(legacy, nil)
+	mockStore.On("UpdateClient", mock.MatchedBy(func(client types.Client) bool {
+		return client.UUID == "legacy-client" && client.EmergencyContact == "Mary Doe" && client.DateOfBirth == ""
+	}), doctor.ID).Return(nil).Once()
+
+	body, _ = json.Marshal(map[string]string{"emergency_contact": "Mary Doe"})
+	req, _ = http.NewRequest(http.MethodPatch, "/legacy-client", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: A field that is sent cannot be emptied
+	body, _ = json.Marshal(map[string]string{"lastname": " "})
+	req, _ = http.NewRequest(http.MethodPatch, "/legacy-client", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	mockStore.AssertNumberOfCalls(t, "UpdateClient", 2)
+}
+
+func TestFindClients(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockClientStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.GET("/search", handler.FindClients)
+
+	// Test case: A misspelt name still finds the client, unrelated candidates are dropped
+	candidates := []types.ClientResponse{
+		{UUID: "c1", FirstName: "Mary", LastName: "Wambui", PhoneNumber: "0711000001"},
+		{UUID: "c2", FirstName: "Grace", LastName: "Wanjiku", PhoneNumber: "0711000002"},
+		{UUID: "c3", FirstName: "Wanjuku", LastName: "Kamau", PhoneNumber: "0711000003",
+			PhoneNumbers: []types.ClientPhone{{PhoneNumber: "0711000003", Primary: true}, {PhoneNumber: "0722555666"}}},
+	}
+	mockStore.On("FindClients", "Wanjuku").Return(candidates, false, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/search?q=Wanjuku", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var response types.ClientSearchPage
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, 2, response.Total)
+	require.Equal(t, "c3", response.Items[0].UUID)
+	require.Equal(t, "c2", response.Items[1].UUID)
+
+	// Test case: The last digits of a secondary phone number find the client
+	mockStore.On("FindClients", "5666").Return(candidates, true, nil)
+
+	req, _ = http.NewRequest(http.MethodGet, "/search?q=5666&limit=1", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	response = types.ClientSearchPage{}
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, 1, response.Total)
+	require.Equal(t, "c3", response.Items[0].UUID)
+	require.Zero(t, response.NextOffset)
+
+	// Test case: More clients matched than were ranked, the total is only a lower bound
+	require.True(t, response.Truncated)
+}
+
+func TestAddClientPhone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockClientStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.Use(signedIn(doctor))
+	router.POST("/:id/phones", handler.AddClientPhone)
+	router.DELETE("/:id/phones/:phonenumber", handler.RemoveClientPhone)
+
+	// Test case: A new primary number is added to the client with who added it
+	phone := types.ClientPhone{PhoneNumber: "0722555666", Primary: true}
+	mockStore.On("AddClientPhone", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", phone, doctor.ID).Return(nil)
+
+	body, _ := json.Marshal(phone)
+	req, _ := http.NewRequest(http.MethodPost, "/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11/phones", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	mockStore.AssertCalled(t, "AddClientPhone", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", phone, doctor.ID)
+
+	// Test case: Removing a number records who removed it
+	mockStore.On("RemoveClientPhone", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", "0711222333", doctor.ID).Return(nil).Once()
+
+	req, _ = http.NewRequest(http.MethodDelete, "/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11/phones/0711222333", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestDeleteAndRestoreClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockClientStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.Use(signedIn(doctor))
+	router.DELETE("/:id", handler.DeleteClient)
+	router.POST("/:id/restore", handler.RestoreClient)
+
+	// Test case: Deleting archives the client with who deleted them
+	mockStore.On("DeleteClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", doctor.ID).Return(nil)
+
+	req, _ := http.NewRequest(http.MethodDelete, "/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	mockStore.AssertCalled(t, "DeleteClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", doctor.ID)
+
+	// Test case: The archived client is restored with who restored them
+	mockStore.On("RestoreClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", doctor.ID).Return(nil)
+
+	req, _ = http.NewRequest(http.MethodPost, "/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11/restore", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	mockStore.AssertCalled(t, "RestoreClient", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", doctor.ID)
+}
+
+func TestCreatePrescription(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockClientStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.Use(signedIn(doctor))
+	router.POST("/prescription", handler.CreatePrescription)
+
+	// Test case: The items are kept as sent, route and frequency are normalised.
+	// Formulary drugs get their name from the formulary, other drugs have to be marked as free text.
+	// The prescription is issued by the signed in doctor.
+	mockStore.On("CreatePrescription", types.Prescription{
+		ClientID: "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11",
+		DoctorID: 1,
+		Items: []types.PrescriptionItem{
+			{DrugID: 4, Strength: "500 mg", Dose: 2, Unit: "tablet", Route: "oral", Frequency: "TDS", DurationDays: 5, Quantity: 30, Instructions: "After meals"},
+			{Drug: "Ceftriaxone, compounded", FreeText: true, Strength: "1 g", Dose: 1, Unit: "vial", Route: "im", Frequency: "STAT", Quantity: 1},
+		},
+		DateIssued: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		ValidUntil: time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+	}).Return(12, []types.PrescriptionWarning{}, nil).Once()
+
+	body := `{
+		"client_id": "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11",
+		"date_issued": "01/05/2024",
+		"items": [
+			{"drug_id": 4, "strength": "500 mg", "dose": 2, "unit": "tablet", "route": "Oral", "frequency": "tds", "duration_days": 5, "quantity": 30, "instructions": "After meals"},
+			{"drug": "Ceftriaxone, compounded", "free_text": true, "strength": "1 g", "dose": 1, "unit": "vial", "route": "IM", "frequency": "STAT", "quantity": 1}
+		]
+	}`
+	req, _ := http.NewRequest(http.MethodPost, "/prescription", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var response struct {
+		ID int `json:"id"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, 12, response.ID)
+
+	// Test case: Invalid items are rejected before anything is saved
+	invalid := []string{
+		`[]`,
+		`[{"free_text": true, "dose": 1, "unit": "tablet", "route": "oral", "frequency": "OD", "duration_days": 5, "quantity": 5}]`,
+		`[{"drug": "Amoxicillin", "dose": 1, "unit": "capsule", "route": "oral", "frequency": "TDS", "duration_days": 5, "quantity": 15}]`,
+		`[{"drug_id": 7, "free_text": true, "dose": 1, "unit": "capsule", "route": "oral", "frequency": "TDS", "duration_days": 5, "quantity": 15}]`,
+		`[{"drug_id": 7, "dose": 0, "unit": "capsule", "route": "oral", "frequency": "TDS", "duration_days": 5, "quantity": 15}]`,
+		`[{"drug_id": 7, "dose": 1, "unit": "capsule", "route": "by mouth", "frequency": "TDS", "duration_days": 5, "quantity": 15}]`,
+		`[{"drug_id": 7, "dose": 1, "unit": "capsule", "route": "oral", "frequency": "thrice", "duration_days": 5, "quantity": 15}]`,
+		`[{"drug_id": 7, "dose": 1, "unit": "capsule", "route": "oral", "frequency": "TDS", "quantity": 15}]`,
+	}
+	for _, items := range invalid {
+		body := `{"client_id": "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", "doctor_id": 1, "date_issued": "01/05/2024", "items": ` + items + `}`
+		req, _ := http.NewRequest(http.MethodPost, "/prescription", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, items)
+	}
+	mockStore.AssertNumberOfCalls(t, "CreatePrescription", 1)
+
+	// Test case: A prescription cannot be issued in another doctor's name
+	body = `{"client_id": "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", "doctor_id": 2, "date_issued": "01/05/2024", "items": [{"drug_id": 4, "dose": 1, "unit": "capsule", "route": "oral", "frequency": "TDS", "duration_days": 5, "quantity": 15}]}`
+	req, _ = http.NewRequest(http.MethodPost, "/prescription", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusForbidden, resp.Code)
+	mockStore.AssertNumberOfCalls(t, "CreatePrescription", 1)
+
+	// Test case: Blocking warnings found by the store are sent back with a conflict
+	allergy := []types.PrescriptionWarning{{Type: "allergy", Severity: "contraindicated", Drug: "Amoxicillin", With: "Penicillins", Blocking: true}}
+	mockStore.On("CreatePrescription", mock.MatchedBy(func(p types.Prescription) bool {
+		return p.ClientID == "5b1c2d3e-0000-4000-8000-000000000001"
+	})).Return(0, allergy, fmt.Errorf("prescription has blocking warnings")).Once()
+
+	item := `[{"drug_id": 4, "dose": 1, "unit": "capsule", "route": "oral", "frequency": "TDS", "duration_days": 5, "quantity": 15}]`
+	body = `{"client_id": "5b1c2d3e-0000-4000-8000-000000000001", "doctor_id": 1, "date_issued": "01/05/2024", "items": ` + item + `}`
+	req, _ = http.NewRequest(http.MethodPost, "/prescription", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusConflict, resp.Code)
+	var conflict struct {
+		Warnings []types.PrescriptionWarning `json:"warnings"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &conflict)
+	require.Equal(t, allergy, conflict.Warnings)
+	mockStore.AssertExpectations(t)
+}
+
+func TestUpdatePrescription(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockClientStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.Use(signedIn(doctor))
+	router.PUT("/prescription", handler.UpdatePrescription)
+
+	// Test case: An amendment takes the same body and date format as a new prescription,
+	// the validity is only changed when sent
+	item := `[{"drug_id": 4, "dose": 1, "unit": "capsule", "route": "oral", "frequency": "TDS", "duration_days": 5, "quantity": 15}]`
+	mockStore.On("UpdatePrescription", types.Prescription{
+		ID:         12,
+		Items:      []types.PrescriptionItem{{DrugID: 4, Dose: 1, Unit: "capsule", Route: "oral", Frequency: "TDS", DurationDays: 5, Quantity: 15}},
+		DateIssued: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+	}, doctor.ID).Return([]types.PrescriptionWarning{}, nil).Once()
+
+	body := `{"id": 12, "date_issued": "02/05/2024", "items": ` + item + `}`
+	req, _ := http.NewRequest(http.MethodPut, "/prescription", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: An RFC 3339 date is rejected like it is when creating
+	body = `{"id": 12, "date_issued": "2024-05-02T00:00:00Z", "items": ` + item + `}`
+	req, _ = http.NewRequest(http.MethodPut, "/prescription", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	mockStore.AssertNumberOfCalls(t, "UpdatePrescription", 1)
+}
+
+func TestCheckPrescription(t *testing.T) {
+	warfarin := types.PrescriptionItem{Drug: "Warfarin", ATCCode: "B01AA03"}
+	ibuprofen := types.PrescriptionItem{Drug: "Ibuprofen", ATCCode: "M01AE01"}
+	paracetamol := types.PrescriptionItem{Drug: "Paracetamol", ATCCode: "N02BE01"}
+	rules := []types.InteractionRule{
+		{DrugA: "B01AA03", DrugB: "M01A", Severity: "major", Description: "Bleeding"},
+		{DrugA: "paracetamol", DrugB: "alcohol", Severity: "minor", Description: "Liver"},
+	}
+
+	// Test case: An ATC group rule matches the active prescriptions in either order
+	warnings := checkPrescription([]types.PrescriptionItem{ibuprofen}, types.PrescribingContext{ActiveItems: []types.PrescriptionItem{warfarin}, Rules: rules})
+	require.Len(t, warnings, 1)
+	require.Equal(t, "interaction", warnings[0].Type)
+	require.True(t, warnings[0].Blocking)
+
+	// Test case: Items of the same prescription are checked against each other
+	warnings = checkPrescription([]types.PrescriptionItem{warfarin, paracetamol, ibuprofen}, types.PrescribingContext{Rules: rules})
+	require.Len(t, warnings, 1)
+
+	// Test case: A mild intolerance warns without blocking
+	warnings = checkPrescription([]types.PrescriptionItem{paracetamol}, types.PrescribingContext{
+		Allergies: []types.Allergy{{Substance: "paracetamol", Kind: "intolerance", Severity: "mild"}},
+	})
+	require.Len(t, warnings, 1)
+	require.Equal(t, "moderate", warnings[0].Severity)
+	require.False(t, warnings[0].Blocking)
+
+	// Test case: Free text drugs cannot be matched to the ATC rules, each is flagged to be checked by hand
+	warnings = checkPrescription([]types.PrescriptionItem{{Drug: "Warfarin", FreeText: true}, {Drug: "Aspirin", FreeText: true}},
+		types.PrescribingContext{Rules: []types.InteractionRule{{DrugA: "B01AA03", DrugB: "B01AC06", Severity: "major", Description: "Bleeding"}}})
+	require.Len(t, warnings, 2)
+	for _, warning := range warnings {
+		require.Equal(t, "unchecked", warning.Type)
+		require.False(t, warning.Blocking)
+	}
+}
+
+func TestCheckItems(t *testing.T) {
+	amoxicillin := types.Drug{ID: 4, GenericName: "Amoxicillin", ATCCode: "J01CA04", Form: "capsule", Strength: "500 mg", Active: true}
+	prescribing := types.PrescribingContext{
+		Drugs:     map[int]types.Drug{4: amoxicillin},
+		Allergies: []types.Allergy{{ID: 2, Substance: "Penicillins", ATCCode: "J01C", Kind: "allergy", Severity: "severe", Active: true}},
+	}
+	item := types.PrescriptionItem{DrugID: 4, Dose: 1, Unit: "capsule", Route: "oral", Frequency: "TDS", DurationDays: 5, Quantity: 15}
+
+	// Test case: A formulary drug is checked by its ATC code, an allergy to it needs an override reason
+	prescription := types.Prescription{Items: []types.PrescriptionItem{item}}
+	warnings, err := checkItems(&prescription, prescribing)
+	require.EqualError(t, err, "prescription has blocking warnings")
+	require.Len(t, warnings, 1)
+	require.Equal(t, "allergy", warnings[0].Type)
+
+	// Test case: With an override reason the blocking warnings are kept on the prescription
+	prescription.OverrideReason = "Tolerated amoxicillin before"
+	_, err = checkItems(&prescription, prescribing)
+	require.NoError(t, err)
+	require.Len(t, prescription.OverriddenWarnings, 1)
+
+	// Test case: A drug that is not in the formulary, or inactive, cannot be prescribed by id
+	prescription = types.Prescription{Items: []types.PrescriptionItem{{DrugID: 9}}}
+	_, err = checkItems(&prescription, prescribing)
+	require.EqualError(t, err, "drug is not in the formulary")
+}
+
+func TestAddClientAllergy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockClientStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.Use(signedIn(doctor))
+	router.POST("/clients/:id/allergies", handler.AddClientAllergy)
+
+	// Test case: Kind and severity default to an allergy of moderate severity
+	mockStore.On("AddClientAllergy", "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", types.Allergy{
+		Substance: "Penicillins", ATCCode: "J01C", Kind: "allergy", Severity: "moderate", Reaction: "rash",
+	}, doctor.ID).Return(3, nil).Once()
+
+	body := `{"substance": " Penicillins ", "atc_code": "j01c", "reaction": "rash"}`
+	req, _ := http.NewRequest(http.MethodPost, "/clients/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11/allergies", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: Invalid allergies are rejected
+	invalid := []string{
+		`{"kind": "allergy"}`,
+		`{"substance": "Penicillins", "kind": "side effect"}`,
+		`{"substance": "Penicillins", "severity": "fatal"}`,
+		`{"substance": "Penicillins", "atc_code": "penicillin"}`,
+	}
+	for _, body := range invalid {
+		req, _ := http.NewRequest(http.MethodPost, "/clients/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11/allergies", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, body)
+	}
+	mockStore.AssertNumberOfCalls(t, "AddClientAllergy", 1)
+}
+
+func TestChangePrescriptionStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockClientStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.Use(signedIn(doctor))
+	router.PUT("/prescription/:id/status", handler.ChangePrescriptionStatus)
+
+	// Test case: A prescription is cancelled with a reason
+	mockStore.On("ChangePrescriptionStatus", 12, "cancelled", "Wrong patient", doctor.ID).Return(nil).Once()
+
+	body := `{"status": "Cancelled", "reason": " Wrong patient "}`
+	req, _ := http.NewRequest(http.MethodPut, "/prescription/12/status", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: A status the prescription cannot move to is a conflict
+	mockStore.On("ChangePrescriptionStatus", 12, "completed", "", doctor.ID).Return(errors.New("prescription status cannot be changed")).Once()
+
+	req, _ = http.NewRequest(http.MethodPut, "/prescription/12/status", bytes.NewBufferString(`{"status": "completed"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusConflict, resp.Code)
+
+	// Test case: Unknown statuses and cancellations without a reason are rejected
+	// and dispensing is only recorded by the pharmacy
+	for _, body := range []string{`{"status": "expired"}`, `{"status": "active"}`, `{"status": "cancelled"}`, `{"status": "dispensed"}`, `{"status": "partially_dispensed"}`} {
+		req, _ := http.NewRequest(http.MethodPut, "/prescription/12/status", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, body)
+	}
+	mockStore.AssertExpectations(t)
+}
+
+func TestRefillPrescription(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockClientStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.Use(signedIn(doctor))
+	router.POST("/prescription/:id/refill", handler.RefillPrescription)
+
+	// Test case: A prescription without refills left cannot be refilled
+	mockStore.On("RefillPrescription", 12, doctor.ID).Return(errors.New("no refills remaining")).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/prescription/12/refill", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusConflict, resp.Code)
+	mockStore.AssertExpectations(t)
 }
@@ -0,0 +1,202 @@
+package clients
+
+import (
+	"bytes"
+	"cema_backend/service/formulary"
+	"cema_backend/types"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// bundledInteractions holds the interaction rules used unless INTERACTION_RULES_FILE points at another rule file
+//
+//go:embed data/drug_interactions.csv
+var bundledInteractions []byte
+
+// interactionSeverities ranks the severities of warnings, major and contraindicated ones block prescribing
+var interactionSeverities = map[string]int{"minor": 1, "moderate": 2, "major": 3, "contraindicated": 4}
+
+// LoadInteractionRules replaces the stored interaction rules with the rules of path, or the bundled rules when it is empty.
+// Rule files are CSV with a header and drug_a, drug_b, severity and description columns,
+// each drug being an ATC code (or the start of one) or a generic name.
+func LoadInteractionRules(store types.ClientStore, path string) error {
+	var r io.Reader = bytes.NewReader(bundledInteractions)
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open interaction rules: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	rules, err := parseInteractionRules(r)
+	if err != nil {
+		return err
+	}
+	return store.ReplaceInteractionRules(rules)
+}
+
+// parseInteractionRules reads the rules of a rule file
+func parseInteractionRules(r io.Reader) ([]types.InteractionRule, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interaction rules: %w", err)
+	}
+
+	rules := []types.InteractionRule{}
+	for i, record := range records {
+		// The first line is the header
+		if i == 0 {
+			continue
+		}
+		if len(record) < 4 {
+			return nil, fmt.Errorf("interaction rule on line %d needs drug_a, drug_b, severity and description", i+1)
+		}
+		rule := types.InteractionRule{
+			DrugA:       strings.TrimSpace(record[0]),
+			DrugB:       strings.TrimSpace(record[1]),
+			Severity:    strings.ToLower(strings.TrimSpace(record[2])),
+			Description: strings.TrimSpace(record[3]),
+		}
+		if rule.DrugA == "" || rule.DrugB == "" {
+			return nil, fmt.Errorf("interaction rule on line %d needs both drugs", i+1)
+		}
+		if interactionSeverities[rule.Severity] == 0 {
+			return nil, fmt.Errorf("interaction rule on line %d has an invalid severity", i+1)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// matchesDrug reports whether a side of a rule, or an allergy substance, is the drug of an item.
+// ATC codes match the drugs in their group, names match the generic name ignoring case.
+func matchesDrug(side string, item types.PrescriptionItem) bool {
+	if formulary.ATCCodePattern.MatchString(side) {
+		return item.ATCCode != "" && strings.HasPrefix(item.ATCCode, side)
+	}
+	name := strings.ToLower(strings.TrimSpace(item.Drug))
+	side = strings.ToLower(side)
+	return name == side || strings.HasPrefix(name, side+" ")
+}
+
+// allergyMatches reports whether an item is the drug, or in the drug group, a client is allergic to
+func allergyMatches(allergy types.Allergy, item types.PrescriptionItem) bool {
+	if allergy.DrugID != 0 && allergy.DrugID == item.DrugID {
+		return true
+	}
+	if allergy.ATCCode != "" && item.ATCCode != "" && strings.HasPrefix(item.ATCCode, allergy.ATCCode) {
+		return true
+	}
+	return allergy.Substance != "" && matchesDrug(allergy.Substance, item)
+}
+
+// checkPrescription checks new prescription items against each other, the client's active prescriptions
+// and the client's allergies. It returns the warnings, the most severe first.
+func checkPrescription(items []types.PrescriptionItem, prescribing types.PrescribingContext) []types.PrescriptionWarning {
+	warnings := []types.PrescriptionWarning{}
+	seen := map[string]bool{}
+	add := func(warning types.PrescriptionWarning) {
+		key := warning.Type + "|" + warning.Drug + "|" + warning.With + "|" + warning.Message
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		warning.Blocking = interactionSeverities[warning.Severity] >= interactionSeverities["major"]
+		warnings = append(warnings, warning)
+	}
+
+	for _, item := range items {
+		// The rules name drugs by ATC code, a free text drug or one without a code cannot be fully checked
+		if item.ATCCode == "" {
+			add(types.PrescriptionWarning{Type: "unchecked", Severity: "minor", Drug: item.Drug,
+				Message: item.Drug + " has no ATC code, its interactions have to be checked by hand"})
+		}
+		for _, allergy := range prescribing.Allergies {
+			if !allergyMatches(allergy, item) {
+				continue
+			}
+			// An allergy rules the drug out, an intolerance only does when it is severe
+			severity := "contraindicated"
+			if allergy.Kind == "intolerance" {
+				severity = "moderate"
+				if allergy.Severity == "severe" {
+					severity = "major"
+				}
+			}
+			message := fmt.Sprintf("Client has a recorded %s to %s", allergy.Kind, allergy.Substance)
+			if allergy.Reaction != "" {
+				message += " (" + allergy.Reaction + ")"
+			}
+			add(types.PrescriptionWarning{Type: "allergy", Severity: severity, Drug: item.Drug, With: allergy.Substance, Message: message})
+		}
+	}
+
+	interacts := func(a, b types.PrescriptionItem) {
+		for _, rule := range prescribing.Rules {
+			if matchesDrug(rule.DrugA, a) && matchesDrug(rule.DrugB, b) || matchesDrug(rule.DrugA, b) && matchesDrug(rule.DrugB, a) {
+				add(types.PrescriptionWarning{Type: "interaction", Severity: rule.Severity, Drug: a.Drug, With: b.Drug, Message: rule.Description})
+			}
+		}
+	}
+	for i, item := range items {
+		for _, active := range prescribing.ActiveItems {
+			interacts(item, active)
+		}
+		for _, other := range items[i+1:] {
+			interacts(item, other)
+		}
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return interactionSeverities[warnings[i].Severity] > interactionSeverities[warnings[j].Severity]
+	})
+	return warnings
+}
+
+// checkItems checks the items of a prescription against each other and what the client is prescribed and allergic to.
+// Formulary items are checked by the generic name and ATC code of their drug, an inactive or unknown drug is an error.
+// Blocking warnings need an override reason, without one the warnings are returned with an error,
+// with one they are kept in the OverriddenWarnings of the prescription.
+func checkItems(prescription *types.Prescription, prescribing types.PrescribingContext) ([]types.PrescriptionWarning, error) {
+	checked := make([]types.PrescriptionItem, len(prescription.Items))
+	for i, item := range prescription.Items {
+		if item.DrugID != 0 {
+			drug, found := prescribing.Drugs[item.DrugID]
+			if !found {
+				return nil, fmt.Errorf("drug is not in the formulary")
+			}
+			item.Drug = drug.GenericName
+			item.ATCCode = drug.ATCCode
+		}
+		checked[i] = item
+	}
+
+	warnings := checkPrescription(checked, prescribing)
+	blocking := blockingWarnings(warnings)
+	if len(blocking) > 0 && strings.TrimSpace(prescription.OverrideReason) == "" {
+		return warnings, fmt.Errorf("prescription has blocking warnings")
+	}
+	prescription.OverriddenWarnings = blocking
+	return warnings, nil
+}
+
+// blockingWarnings returns the warnings that need an override reason to prescribe
+func blockingWarnings(warnings []types.PrescriptionWarning) []types.PrescriptionWarning {
+	var blocking []types.PrescriptionWarning
+	for _, warning := range warnings {
+		if warning.Blocking {
+			blocking = append(blocking, warning)
+		}
+	}
+	return blocking
+}
@@ -0,0 +1,44 @@
+package clients
+
+// Prescription statuses, expired is not stored but reported for active and partially dispensed prescriptions past their validity
+const (
+	statusActive             = "active"
+	statusPartiallyDispensed = "partially_dispensed"
+	statusDispensed          = "dispensed"
+	statusCompleted          = "completed"
+	statusCancelled          = "cancelled"
+	statusExpired            = "expired"
+)
+
+// statusTransitions are the statuses a prescription can be changed to by hand from each status.
+// It becomes partially dispensed or dispensed only by dispensing it from the pharmacy, which issues the stock.
+// Completed and cancelled prescriptions are final, refilling a dispensed prescription makes it active again.
+var statusTransitions = map[string][]string{
+	statusActive:             {statusCancelled},
+	statusPartiallyDispensed: {statusCancelled},
+	statusDispensed:          {statusCompleted},
+}
+
+// canChangeStatus reports whether a prescription with the from status can be changed to the to status
+func canChangeStatus(from, to string) bool {
+	for _, status := range statusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Default and longest validity of a prescription, in days from the date it was issued
+const (
+	defaultValidityDays = 30
+	maxValidityDays     = 365
+)
+
+// maxRefills is the most refills a prescription can be written with
+const maxRefills = 12
+
+// activeMedication selects the items i of prescriptions p the client is currently on:
+// waiting to be dispensed and still valid, or dispensed with the course still running
+const activeMedication = `(p.status IN ('active', 'partially_dispensed') AND p.valid_until >= CURRENT_DATE
+	OR p.status = 'dispensed' AND DATE_ADD(DATE(p.dispensed_at), INTERVAL GREATEST(COALESCE(i.duration_days, 0), 1) DAY) > CURRENT_DATE)`
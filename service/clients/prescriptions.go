@@ -0,0 +1,59 @@
+package clients
+
+import (
+	"cema_backend/types"
+	"fmt"
+	"strings"
+)
+
+// routes are the routes of administration a medicine can be prescribed for
+var routes = map[string]bool{
+	"oral": true, "sublingual": true, "iv": true, "im": true, "sc": true, "topical": true, "inhaled": true,
+	"nasal": true, "ophthalmic": true, "otic": true, "rectal": true, "vaginal": true,
+}
+
+// frequencies are the accepted dosing frequency abbreviations
+var frequencies = map[string]bool{
+	"OD": true, "BD": true, "TDS": true, "QID": true, "Q4H": true, "Q6H": true, "Q8H": true,
+	"NOCTE": true, "WEEKLY": true, "PRN": true, "STAT": true,
+}
+
+// validatePrescriptionItems checks the items of a prescription and normalises their route and frequency.
+// It returns the error message to send back if one is invalid.
+func validatePrescriptionItems(items []types.PrescriptionItem) string {
+	if len(items) == 0 {
+		return "At least one item is required"
+	}
+	for i := range items {
+		item := &items[i]
+		item.Drug = strings.TrimSpace(item.Drug)
+		item.Route = strings.ToLower(strings.TrimSpace(item.Route))
+		item.Frequency = strings.ToUpper(strings.TrimSpace(item.Frequency))
+		n := i + 1
+
+		// Drugs are chosen from the formulary, typing one in has to be asked for
+		if item.DrugID != 0 && item.FreeText {
+			return fmt.Sprintf("Item %d: a formulary drug cannot be free text", n)
+		}
+		if item.DrugID == 0 && !item.FreeText {
+			return fmt.Sprintf("Item %d: choose a formulary drug or mark the drug as free text", n)
+		}
+		if item.DrugID == 0 && item.Drug == "" || item.Unit == "" {
+			return fmt.Sprintf("Item %d: drug and unit are required", n)
+		}
+		if item.Dose <= 0 || item.Quantity <= 0 {
+			return fmt.Sprintf("Item %d: dose and quantity must be greater than zero", n)
+		}
+		if !routes[item.Route] {
+			return fmt.Sprintf("Item %d: invalid route", n)
+		}
+		if !frequencies[item.Frequency] {
+			return fmt.Sprintf("Item %d: invalid frequency", n)
+		}
+		// A single dose has no duration, anything else is taken for a number of days
+		if item.DurationDays < 0 || item.DurationDays == 0 && item.Frequency != "STAT" && item.Frequency != "PRN" {
+			return fmt.Sprintf("Item %d: duration is required", n)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+// This file runs the retention purge that permanently removes clients archived for too long.
+package clients
+
+import (
+	"cema_backend/logging"
+	"cema_backend/types"
+	"fmt"
+	"time"
+)
+
+// purgeArchivedClients removes the clients archived more than the given number of days ago
+// and logs what was removed. purgedBy is the doctor who ran the purge, 0 for the retention job.
+func purgeArchivedClients(store types.ClientStore, days int, purgedBy int) (types.PurgeReport, error) {
+	before := time.Now().AddDate(0, 0, -days)
+	report, err := store.PurgeArchivedClients(before, purgedBy)
+	if err != nil {
+		return report, err
+	}
+
+	logging.Info(fmt.Sprintf("Purged %d archived clients archived before %s (%d merged clients, %d enrollments, %d prescriptions, %d phone numbers)",
+		len(report.Clients), before.Format("2006-01-02"), report.Tombstones, report.Enrollments, report.Prescriptions, report.PhoneNumbers))
+	return report, nil
+}
+
+// StartRetentionJob purges the clients archived more than the given number of days ago,
+// once when it starts and then at every interval. It does nothing when days is not positive.
+func StartRetentionJob(store types.ClientStore, days int, interval time.Duration) {
+	if days <= 0 {
+		logging.Info("Client retention purge is disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := purgeArchivedClients(store, days, 0); err != nil {
+				logging.Error("Failed to Purge Archived Clients: " + err.Error())
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -8,18 +8,30 @@ import (
 )
 
 func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
-	// Public routes
-	router.POST("/register", h.RegisterClients)
-	router.POST("/search", h.SearchClient)
-
-	// Protected routes
+	// Protected routes, each needs a permission of the signed in doctor's role
 	protected := router.Group("/")
 	protected.Use(auth.AuthMiddleware())
 	{
-		protected.POST("/program-enroll", h.EnrollClient)
-		protected.GET("/clients", h.GetAllClients)
-		protected.POST("/prescription", h.CreatePrescription)
-		protected.PUT("/prescription", h.UpdatePrescription)
-		protected.DELETE("/delete", h.DeleteClient)
+		protected.POST("/register", auth.RequirePermission("clients:create"), h.RegisterClients)
+		protected.POST("/search", auth.RequirePermission("clients:read"), h.SearchClient)
+		protected.POST("/program-enroll", auth.RequirePermission("enrollments:write"), h.EnrollClient)
+		protected.GET("/clients", auth.RequirePermission("clients:read"), h.GetAllClients)
+		protected.GET("/search", auth.RequirePermission("clients:read"), h.FindClients)
+		protected.POST("/purge", auth.RequirePermission("clients:purge"), h.PurgeArchivedClients)
+		protected.POST("/prescription", auth.RequirePermission("prescriptions:write"), h.CreatePrescription)
+		protected.PUT("/prescription", auth.RequirePermission("prescriptions:write"), h.UpdatePrescription)
+		protected.GET("/prescription/:id", auth.RequirePermission("prescriptions:read"), h.GetPrescription)
+		protected.PUT("/prescription/:id/status", auth.RequirePermission("prescriptions:status"), h.ChangePrescriptionStatus)
+		protected.POST("/prescription/:id/refill", auth.RequirePermission("prescriptions:refill"), h.RefillPrescription)
+		protected.GET("/:id", auth.RequirePermission("clients:read"), h.GetClient)
+		protected.PATCH("/:id", auth.RequirePermission("clients:update"), h.UpdateClient)
+		protected.DELETE("/:id", auth.RequirePermission("clients:delete"), h.DeleteClient)
+		protected.POST("/:id/restore", auth.RequirePermission("clients:delete"), h.RestoreClient)
+		protected.POST("/:id/merge", auth.RequirePermission("clients:merge"), h.MergeClients)
+		protected.POST("/:id/phones", auth.RequirePermission("clients:update"), h.AddClientPhone)
+		protected.DELETE("/:id/phones/:phonenumber", auth.RequirePermission("clients:update"), h.RemoveClientPhone)
+		protected.POST("/:id/allergies", auth.RequirePermission("allergies:write"), h.AddClientAllergy)
+		protected.DELETE("/:id/allergies/:allergy_id", auth.RequirePermission("allergies:write"), h.ResolveClientAllergy)
+		protected.GET("/:id/medications", auth.RequirePermission("prescriptions:read"), h.GetActiveMedications)
 	}
 }
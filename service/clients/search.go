@@ -0,0 +1,144 @@
+// This file ranks the clients found by a free text search.
+// The database narrows down the candidates using its indexes, the ranking here
+// scores how close each candidate is so that small typos still find the right client.
+package clients
+
+import (
+	"cema_backend/types"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// searchCandidateLimit caps how many candidates each index returns for ranking
+	searchCandidateLimit = 200
+	// minSearchScore is the score below which a candidate is not considered a match
+	minSearchScore = 0.6
+	// minPhoneDigits is the number of digits needed before a query is matched against phone numbers
+	minPhoneDigits = 3
+)
+
+// parseSearchQuery splits a search query into lowercase name terms and the phone digits it contains
+func parseSearchQuery(q string) (terms []string, digits string) {
+	for _, field := range strings.Fields(strings.ToLower(q)) {
+		word := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || r == '\'' || r == '-' {
+				return r
+			}
+			return -1
+		}, field)
+		if word != "" {
+			terms = append(terms, word)
+		}
+	}
+
+	digits = strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, q)
+	if len(digits) < minPhoneDigits {
+		digits = ""
+	}
+	return terms, digits
+}
+
+// rankClients scores the candidates against the query and returns the matches, best first
+func rankClients(q string, candidates []types.ClientResponse) []types.ClientMatch {
+	terms, digits := parseSearchQuery(q)
+
+	matches := []types.ClientMatch{}
+	for _, candidate := range candidates {
+		score := scoreClient(terms, digits, candidate)
+		if score >= minSearchScore {
+			matches = append(matches, types.ClientMatch{ClientResponse: candidate, Score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+// scoreClient averages how well every part of the query matches the client
+func scoreClient(terms []string, digits string, client types.ClientResponse) float64 {
+	var total float64
+	parts := 0
+
+	// Names count fully, the emergency contact a little less
+	for _, term := range terms {
+		best := max(similarity(term, client.FirstName), similarity(term, client.LastName))
+		for _, word := range strings.Fields(client.EmergencyContact) {
+			best = max(best, 0.8*similarity(term, word))
+		}
+		total += best
+		parts++
+	}
+
+	if digits != "" {
+		// The best matching of the client's phone numbers counts
+		phones := []string{client.PhoneNumber}
+		for _, phone := range client.PhoneNumbers {
+			phones = append(phones, phone.PhoneNumber)
+		}
+		// Staff search with the last digits of a number, which is also all the phone index can find
+		best := 0.0
+		for _, phone := range phones {
+			if strings.HasSuffix(phone, digits) {
+				best = 1
+			}
+		}
+		total += best
+		parts++
+	}
+
+	if parts == 0 {
+		return 0
+	}
+	return total / float64(parts)
+}
+
+// similarity returns how close a search term is to a word,
+// from 0 (nothing in common) to 1 (the same word)
+func similarity(term, word string) float64 {
+	word = strings.ToLower(word)
+	if term == "" || word == "" {
+		return 0
+	}
+	if term == word {
+		return 1
+	}
+	// Partially typed names are strong matches
+	if strings.HasPrefix(word, term) {
+		return 0.9
+	}
+
+	a, b := []rune(term), []rune(word)
+	longest := max(len(a), len(b))
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// levenshtein returns the number of single character edits needed to turn a into b
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
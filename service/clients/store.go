@@ -2,10 +2,18 @@
 package clients
 
 import (
+	"cema_backend/db"
+	"cema_backend/service/encounters"
 	"cema_backend/types"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 // struct that declares the database connection
@@ -13,6 +21,11 @@ type Store struct {
 	db *sql.DB
 }
 
+// queryer runs queries on the database or in a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
 // NewStore initializes a new Store with the given database connection
 func NewStore(db *sql.DB) *Store {
 	return &Store{
@@ -20,29 +33,144 @@ func NewStore(db *sql.DB) *Store {
 	}
 }
 
-// RegisterClients saves a new client in the database
-func (s *Store) RegisterClients(client types.Client) error {
+// clientColumns are the columns selected for a client, in the order they are scanned by clientRow
+// The height and weight are the latest ones observed.
+const clientColumns = `id, uuid, firstname, lastname, phonenumber,
+	COALESCE((SELECT o.height FROM observations o WHERE o.client_id = clients.id AND o.height IS NOT NULL ORDER BY o.observed_at DESC, o.id DESC LIMIT 1), 0),
+	COALESCE((SELECT o.weight FROM observations o WHERE o.client_id = clients.id AND o.weight IS NOT NULL ORDER BY o.observed_at DESC, o.id DESC LIMIT 1), 0),
+	date_of_birth, dob_estimated,
+	COALESCE(sex, ''), COALESCE(national_id, ''), COALESCE(birth_certificate_number, ''),
+	COALESCE(county, ''), COALESCE(sub_county, ''), COALESCE(ward, ''), emergency_contact, emergency_number`
+
+// activeClient is the condition matching clients that are neither merged nor archived
+const activeClient = `merged_into IS NULL AND archived_at IS NULL`
+
+// clientRow holds a client while the clientColumns are scanned
+type clientRow struct {
+	types.Client
+	dateOfBirth sql.NullTime
+}
+
+// fields returns the destinations for the clientColumns
+func (r *clientRow) fields() []interface{} {
+	return []interface{}{
+		&r.ID, &r.UUID, &r.FirstName, &r.LastName, &r.PhoneNumber, &r.Height, &r.Weight,
+		&r.dateOfBirth, &r.DOBEstimated, &r.Sex, &r.NationalID, &r.BirthCertificateNumber,
+		&r.County, &r.SubCounty, &r.Ward, &r.EmergencyContact, &r.EmergencyNumber,
+	}
+}
+
+// client returns the scanned client with the age computed from the date of birth
+func (r *clientRow) client() types.Client {
+	client := r.Client
+	if r.dateOfBirth.Valid {
+		client.DateOfBirth = r.dateOfBirth.Time.Format(dateLayout)
+		client.Age = ageOn(r.dateOfBirth.Time, time.Now())
+	}
+	return client
+}
+
+// response returns the scanned client as a ClientResponse
+func (r *clientRow) response() types.ClientResponse {
+	client := r.client()
+	return types.ClientResponse{
+		ID:                     client.ID,
+		UUID:                   client.UUID,
+		FirstName:              client.FirstName,
+		LastName:               client.LastName,
+		PhoneNumber:            client.PhoneNumber,
+		Height:                 float64(client.Height),
+		Weight:                 float64(client.Weight),
+		DateOfBirth:            client.DateOfBirth,
+		DOBEstimated:           client.DOBEstimated,
+		Age:                    client.Age,
+		Sex:                    client.Sex,
+		NationalID:             client.NationalID,
+		BirthCertificateNumber: client.BirthCertificateNumber,
+		County:                 client.County,
+		SubCounty:              client.SubCounty,
+		Ward:                   client.Ward,
+		EmergencyContact:       client.EmergencyContact,
+		EmergencyNumber:        client.EmergencyNumber,
+	}
+}
+
+// nullIfZero stores measurements that were not taken as NULL
+func nullIfZero(value float32) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+// recordMeasurements records the client's height and weight as a new observation by the given doctor.
+// Nothing is recorded when neither was given.
+func recordMeasurements(ctx context.Context, tx *sql.Tx, clientID int64, client types.Client, recordedBy int) error {
+	if client.Height == 0 && client.Weight == 0 {
+		return nil
+	}
+	query := `INSERT INTO observations (client_id, doctor_id, height, weight) VALUES (?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, query, clientID, recordedBy, nullIfZero(client.Height), nullIfZero(client.Weight)); err != nil {
+		return fmt.Errorf("failed to save client measurements: %w", err)
+	}
+	return nil
+}
+
+// RegisterClients saves a new client registered by the given doctor in the database along with their primary phone number
+// and returns the identifier given to the client
+func (s *Store) RegisterClients(client types.Client, registeredBy int) (string, error) {
 	// context is used to manage the lifetime of the request
 	ctx := context.Background()
+	clientID := uuid.NewString()
+
+	// The client and their phone number are saved together or not at all
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	// Insert queries are seperated to prevent SQL injection
-	query := `INSERT INTO clients (firstname, lastname, phonenumber, height, weight, age, emergency_contact, emergency_number) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO clients (uuid, firstname, lastname, phonenumber, date_of_birth, dob_estimated,
+		sex, national_id, birth_certificate_number, county, sub_county, ward, emergency_contact, emergency_number, registered_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	// Execute the query with the parametized values
-	_, err := s.db.ExecContext(ctx, query, client.FirstName, client.LastName, client.PhoneNumber, client.Height, client.Weight, client.Age, client.EmergencyContact, client.EmergencyNumber)
+	result, err := tx.ExecContext(ctx, query, clientID, client.FirstName, client.LastName, client.PhoneNumber,
+		client.DateOfBirth, client.DOBEstimated, db.NullIfEmpty(client.Sex), db.NullIfEmpty(client.NationalID), db.NullIfEmpty(client.BirthCertificateNumber),
+		db.NullIfEmpty(client.County), db.NullIfEmpty(client.SubCounty), db.NullIfEmpty(client.Ward), client.EmergencyContact, client.EmergencyNumber, registeredBy)
 	if err != nil {
-		return fmt.Errorf("failed to save client in DB %w", err)
+		return "", fmt.Errorf("failed to save client in DB %w", err)
 	}
-	return nil
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", fmt.Errorf("failed to get client id: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO client_phones (client_id, phonenumber, is_primary) VALUES (?, ?, TRUE)`, id, client.PhoneNumber)
+	if err != nil {
+		return "", fmt.Errorf("failed to save client phone number: %w", err)
+	}
+
+	// The height and weight taken at registration are the client's first observation
+	if err := recordMeasurements(ctx, tx, id, client, registeredBy); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit client: %w", err)
+	}
+	return clientID, nil
 }
 
-// EnrollClient enrolls a client in a program
-func (s *Store) EnrollClient(phoneNumber string, programName string) error {
+// EnrollClient enrolls a client in a program, recording the doctor who enrolled them
+func (s *Store) EnrollClient(clientID string, programName string, enrolledBy int) error {
 	ctx := context.Background()
 
-	var clientID int
-	err := s.db.QueryRowContext(ctx, "SELECT id FROM clients WHERE phonenumber = ?", phoneNumber).Scan(&clientID)
+	var id int
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM clients WHERE uuid = ? AND "+activeClient, clientID).Scan(&id)
 	if err != nil {
-		return fmt.Errorf("could not find client by phone number: %w", err)
+		return fmt.Errorf("could not find client: %w", err)
 	}
 
 	var programID int
@@ -51,34 +179,66 @@ func (s *Store) EnrollClient(phoneNumber string, programName string) error {
 		return fmt.Errorf("could not find program by name: %w", err)
 	}
 
-	query := `INSERT INTO enrollments (program_id, client_id) VALUES (?, ?)`
-	_, err = s.db.ExecContext(ctx, query, programID, clientID)
+	query := `INSERT INTO enrollments (program_id, client_id, enrolled_by) VALUES (?, ?, ?)`
+	_, err = s.db.ExecContext(ctx, query, programID, id, enrolledBy)
 	if err != nil {
 		return fmt.Errorf("failed to enroll client in program %w", err)
 	}
 	return nil
 }
 
-// SearchClient retrieves a client by their phone number (which in this case I assume is unique)
+// GetClient retrieves a client by their identifier
 // and returns their details along with the programs they are enrolled in
-func (s *Store) SearchClient(phonenumber string) (types.ClientResponse, error) {
-	ctx := context.Background()
+func (s *Store) GetClient(clientID string) (types.ClientResponse, error) {
+	return s.loadClient(context.Background(), `uuid = ?`, clientID)
+}
+
+// SearchClient retrieves a client by any of their phone numbers
+// and returns their details along with the programs they are enrolled in.
+// Archived clients are only returned when includeArchived is set.
+func (s *Store) SearchClient(phonenumber string, includeArchived bool) (types.ClientResponse, error) {
+	condition := `id = (SELECT client_id FROM client_phones WHERE phonenumber = ?)`
+	if !includeArchived {
+		condition += ` AND archived_at IS NULL`
+	}
+	return s.loadClient(context.Background(), condition, phonenumber)
+}
 
+// loadClient retrieves the client matching the condition
+// with their phone numbers, programs and prescriptions
+func (s *Store) loadClient(ctx context.Context, condition string, arg interface{}) (types.ClientResponse, error) {
 	var client types.ClientResponse
 
-	// Get client data
-	clientQuery := `SELECT id, firstname, lastname, phonenumber, height, weight, age, emergency_contact, emergency_number FROM clients WHERE phonenumber = ?`
-	err := s.db.QueryRowContext(ctx, clientQuery, phonenumber).Scan(
-		&client.ID, &client.FirstName, &client.LastName,
-		&client.PhoneNumber, &client.Height, &client.Weight,
-		&client.Age, &client.EmergencyContact, &client.EmergencyNumber,
-	)
+	// Get client data, a merged client points at the client it was merged into
+	clientQuery := `SELECT ` + clientColumns + `, COALESCE((SELECT m.uuid FROM clients m WHERE m.id = clients.merged_into), ''),
+		archived_at, COALESCE((SELECT d.email FROM doctors d WHERE d.id = clients.archived_by), '')
+		FROM clients WHERE ` + condition
+	var row clientRow
+	var mergedInto, archivedBy string
+	var archivedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, clientQuery, arg).Scan(append(row.fields(), &mergedInto, &archivedAt, &archivedBy)...)
 	// if the client is not found, return an error
 	if err == sql.ErrNoRows {
 		return client, fmt.Errorf("client does not exist")
 	} else if err != nil {
 		return client, fmt.Errorf("failed to retrieve client: %w", err)
 	}
+	client = row.response()
+	client.MergedInto, client.ArchivedBy = mergedInto, archivedBy
+	if archivedAt.Valid {
+		client.ArchivedAt = &archivedAt.Time
+	}
+
+	phones, err := s.phonesFor(ctx, client.ID)
+	if err != nil {
+		return client, err
+	}
+	client.PhoneNumbers = phones[client.ID]
+
+	client.Vitals, err = s.latestVitals(ctx, client.ID)
+	if err != nil {
+		return client, err
+	}
 
 	// Get program related to the client
 	programQuery := `
@@ -102,112 +262,1378 @@ func (s *Store) SearchClient(phonenumber string) (types.ClientResponse, error) {
 		client.Programs = append(client.Programs, program)
 	}
 
-	client.Prescriptions, err = s.GetPrescriptionsByClient(phonenumber)
+	client.Prescriptions, err = s.GetPrescriptionsByClient(client.UUID)
 	if err != nil {
 		return client, fmt.Errorf("failed to retrieve prescriptions: %w", err)
 	}
 
+	client.Encounters, err = encounters.ClientEncounters(ctx, s.db, client.ID)
+	if err != nil {
+		return client, err
+	}
+
+	client.Diagnoses, err = s.diagnosesFor(ctx, client.ID)
+	if err != nil {
+		return client, err
+	}
+
+	client.Allergies, err = allergiesFor(ctx, s.db, client.ID)
+	if err != nil {
+		return client, err
+	}
+
 	return client, nil
 }
 
-// GetAllClients retrieves all clients from the database
-// and returns them as a slice of Client structs
-func (s *Store) GetAllClients() ([]types.Client, error) {
-	// context is used to manage the lifetime of the request
+// diagnosesFor retrieves the diagnoses of a client, those of the most recent encounter first
+func (s *Store) diagnosesFor(ctx context.Context, id int) ([]types.Diagnosis, error) {
+	query := `
+		SELECT d.id, d.encounter_id, c.uuid, d.doctor_id, d.code_system, d.code, d.description, d.is_primary, d.certainty, d.diagnosed_at
+		FROM diagnoses d
+		JOIN encounters e ON e.id = d.encounter_id
+		JOIN clients c ON c.id = e.client_id
+		WHERE e.client_id = ?
+		ORDER BY e.encountered_at DESC, d.is_primary DESC, d.id
+	`
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve diagnoses: %w", err)
+	}
+	defer rows.Close()
+
+	diagnoses := []types.Diagnosis{}
+	for rows.Next() {
+		var diagnosis types.Diagnosis
+		var doctorID sql.NullInt64
+		err := rows.Scan(&diagnosis.ID, &diagnosis.EncounterID, &diagnosis.ClientID, &doctorID, &diagnosis.System, &diagnosis.Code,
+			&diagnosis.Description, &diagnosis.Primary, &diagnosis.Certainty, &diagnosis.DiagnosedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan diagnosis: %w", err)
+		}
+		diagnosis.DoctorID = int(doctorID.Int64)
+		diagnoses = append(diagnoses, diagnosis)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve diagnoses: %w", err)
+	}
+	return diagnoses, nil
+}
+
+// latestVitals retrieves the latest value of each measurement taken from a client.
+// It returns nil when nothing has been observed yet.
+func (s *Store) latestVitals(ctx context.Context, id int) (*types.Vitals, error) {
+	latest := func(column string) string {
+		return `(SELECT o.` + column + ` FROM observations o WHERE o.client_id = ? AND o.` + column + ` IS NOT NULL ORDER BY o.observed_at DESC, o.id DESC LIMIT 1)`
+	}
+	columns := []string{"height", "weight", "systolic_bp", "diastolic_bp", "temperature", "pulse", "spo2", "muac"}
+	selects := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		selects[i] = latest(column)
+		args[i] = id
+	}
+
+	var vitals types.Vitals
+	err := s.db.QueryRowContext(ctx, `SELECT `+strings.Join(selects, ", "), args...).Scan(
+		&vitals.Height, &vitals.Weight, &vitals.SystolicBP, &vitals.DiastolicBP,
+		&vitals.Temperature, &vitals.Pulse, &vitals.SpO2, &vitals.MUAC,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve vitals: %w", err)
+	}
+	if vitals == (types.Vitals{}) {
+		return nil, nil
+	}
+
+	if vitals.Height != nil && vitals.Weight != nil && *vitals.Height > 0 {
+		meters := *vitals.Height / 100
+		bmi := math.Round(*vitals.Weight/(meters*meters)*10) / 10
+		vitals.BMI = &bmi
+	}
+	return &vitals, nil
+}
+
+// phonesFor retrieves the phone numbers of the given clients, primary number first,
+// grouped by the client's database id
+func (s *Store) phonesFor(ctx context.Context, ids ...int) (map[int][]types.ClientPhone, error) {
+	phones := map[int][]types.ClientPhone{}
+	if len(ids) == 0 {
+		return phones, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	query := `SELECT client_id, phonenumber, is_primary FROM client_phones WHERE client_id IN (` + placeholders + `) ORDER BY is_primary DESC, id`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve phone numbers: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var phone types.ClientPhone
+		if err := rows.Scan(&id, &phone.PhoneNumber, &phone.Primary); err != nil {
+			return nil, err
+		}
+		phones[id] = append(phones[id], phone)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return phones, nil
+}
+
+// FindClients returns the clients that may match a free text search.
+// Each way of matching, the full text index on the names, the soundex indexes and the reversed phone index,
+// finds its own candidates with its best first, and the candidates are ranked by the caller.
+// truncated is set when one of them found more candidates than searchCandidateLimit.
+func (s *Store) FindClients(q string) (clients []types.ClientResponse, truncated bool, err error) {
+	ctx := context.Background()
+	terms, digits := parseSearchQuery(q)
+	if len(terms) == 0 && digits == "" {
+		return []types.ClientResponse{}, false, nil
+	}
+
+	// Separate selects let each use its index, an OR of them would scan the table.
+	// One more row than the limit tells whether candidates were left out.
+	var selects []string
+	var args []interface{}
+	if len(terms) > 0 {
+		// Full text prefix search on the names and emergency contact, the most relevant first
+		words := make([]string, len(terms))
+		for i, term := range terms {
+			words[i] = strings.Trim(term, "'-") + "*"
+		}
+		selects = append(selects, `(SELECT id, 'names' AS source FROM clients
+			WHERE MATCH(firstname, lastname, emergency_contact) AGAINST (? IN BOOLEAN MODE) AND `+activeClient+`
+			ORDER BY MATCH(firstname, lastname, emergency_contact) AGAINST (? IN BOOLEAN MODE) DESC, id DESC LIMIT ?)`)
+		args = append(args, strings.Join(words, " "), strings.Join(words, " "), searchCandidateLimit+1)
+
+		// Names that sound the same catch typos the full text search misses, the most recent clients first
+		placeholders := strings.TrimSuffix(strings.Repeat("SOUNDEX(?), ", len(terms)), ", ")
+		for _, column := range []string{"firstname_soundex", "lastname_soundex"} {
+			selects = append(selects, `(SELECT id, '`+column+`' AS source FROM clients
+				WHERE `+column+` IN (`+placeholders+`) AND `+activeClient+` ORDER BY id DESC LIMIT ?)`)
+			for _, term := range terms {
+				args = append(args, term)
+			}
+			args = append(args, searchCandidateLimit+1)
+		}
+	}
+	if digits != "" {
+		// Phone numbers are stored reversed so that their last digits can use the index
+		selects = append(selects, `(SELECT DISTINCT clients.id, 'phones' AS source FROM client_phones
+			JOIN clients ON clients.id = client_phones.client_id
+			WHERE client_phones.phonenumber_reversed LIKE ? AND `+activeClient+` ORDER BY clients.id DESC LIMIT ?)`)
+		args = append(args, reverse(digits)+"%", searchCandidateLimit+1)
+	}
+
+	rows, err := s.db.QueryContext(ctx, strings.Join(selects, " UNION ALL "), args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to search clients: %w", err)
+	}
+	defer rows.Close()
+
+	found := map[string]int{}
+	seen := map[int]bool{}
+	var ids []interface{}
+	for rows.Next() {
+		var id int
+		var source string
+		if err := rows.Scan(&id, &source); err != nil {
+			return nil, false, err
+		}
+		found[source]++
+		if found[source] > searchCandidateLimit {
+			truncated = true
+			continue
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(ids) == 0 {
+		return []types.ClientResponse{}, truncated, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	clients, err = s.queryClients(ctx, `SELECT `+clientColumns+` FROM clients WHERE id IN (`+placeholders+`)`, ids...)
+	return clients, truncated, err
+}
+
+// FindDuplicateCandidates returns the clients that could be the same person as the given client,
+// those with a name that sounds the same or with the same emergency contact number.
+// The candidates are scored by the caller.
+func (s *Store) FindDuplicateCandidates(client types.Client) ([]types.ClientResponse, error) {
 	ctx := context.Background()
-	query := `SELECT id, firstname, lastname, phonenumber, height, weight, age, emergency_contact, emergency_number FROM clients`
-	rows, err := s.db.QueryContext(ctx, query)
+	query := `SELECT ` + clientColumns + ` FROM clients
+		WHERE ` + activeClient + ` AND (
+			firstname_soundex IN (SOUNDEX(?), SOUNDEX(?))
+			OR lastname_soundex IN (SOUNDEX(?), SOUNDEX(?))
+			OR emergency_number = ?
+			OR national_id = ?
+		) LIMIT ?`
+	return s.queryClients(ctx, query,
+		client.FirstName, client.LastName, client.FirstName, client.LastName,
+		client.EmergencyNumber, db.NullIfEmpty(client.NationalID), searchCandidateLimit)
+}
+
+// queryClients runs a query selecting clientColumns and returns the clients with their phone numbers
+func (s *Store) queryClients(ctx context.Context, query string, args ...interface{}) ([]types.ClientResponse, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve clients: %w", err)
+		return nil, fmt.Errorf("failed to search clients: %w", err)
 	}
 	defer rows.Close()
 
-	// Scan the rows and loop through them appending them to the clients slice
-	var clients []types.Client
+	clients := []types.ClientResponse{}
+	var ids []int
 	for rows.Next() {
-		var client types.Client
-		if err := rows.Scan(&client.ID, &client.FirstName, &client.LastName,
-			&client.PhoneNumber, &client.Height, &client.Weight,
-			&client.Age, &client.EmergencyContact, &client.EmergencyNumber); err != nil {
+		var row clientRow
+		if err := rows.Scan(row.fields()...); err != nil {
 			return nil, err
 		}
+		client := row.response()
 		clients = append(clients, client)
+		ids = append(ids, client.ID)
 	}
-	// Check for any errors encountered during iteration if any
-	// and return the clients slice otherwise, we will have looped through all the rows
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+
+	phones, err := s.phonesFor(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range clients {
+		clients[i].PhoneNumbers = phones[clients[i].ID]
+	}
 	return clients, nil
 }
 
-// UpdateClient updates the details of a client in the database
-func (s *Store) UpdateClient(client types.Client) error {
+// reverse returns the string with its characters in reverse order
+func reverse(value string) string {
+	runes := []rune(value)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// GetAllClients retrieves one page of clients matching the query filters
+// along with the cursor for the next page and the total number of matches
+func (s *Store) GetAllClients(query types.ClientQuery) (types.ClientPage, error) {
+	// context is used to manage the lifetime of the request
+	ctx := context.Background()
+	page := types.ClientPage{Items: []types.Client{}}
+
+	column, desc, ok := parseSort(query.Sort)
+	if !ok {
+		return page, fmt.Errorf("unknown sort option %q", query.Sort)
+	}
+	limit := query.Limit
+	if limit <= 0 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	// The filters are shared by the count and the page query
+	where, args := clientFilters(query)
+	countQuery := `SELECT COUNT(*) FROM clients` + whereClause(where)
+	if err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&page.Total); err != nil {
+		return page, fmt.Errorf("failed to count clients: %w", err)
+	}
+
+	// Continue after the cursor, ties on the sort column are broken by id
+	op, direction := ">", "ASC"
+	if desc {
+		op, direction = "<", "DESC"
+	}
+	if query.After != nil {
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, op, column, op))
+		args = append(args, query.After.Value, query.After.Value, query.After.ID)
+	}
+
+	// One extra row is fetched to know if there is a next page
+	listQuery := `SELECT ` + clientColumns + ` FROM clients` +
+		whereClause(where) + fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT ?", column, direction, direction)
+	args = append(args, limit+1)
+
+	rows, err := s.db.QueryContext(ctx, listQuery, args...)
+	if err != nil {
+		return page, fmt.Errorf("failed to retrieve clients: %w", err)
+	}
+	defer rows.Close()
+
+	// Scan the rows and loop through them appending them to the page
+	for rows.Next() {
+		var row clientRow
+		if err := rows.Scan(row.fields()...); err != nil {
+			return page, err
+		}
+		page.Items = append(page.Items, row.client())
+	}
+	// Check for any errors encountered during iteration if any
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = encodeCursor(cursorFor(page.Items[limit-1], query.Sort, column))
+	}
+	return page, nil
+}
+
+// clientFilters builds the WHERE conditions and their arguments for a client query
+func clientFilters(query types.ClientQuery) ([]string, []interface{}) {
+	// Merged clients only remain as tombstones and are never listed
+	where := []string{"merged_into IS NULL"}
+	var args []interface{}
+
+	if !query.IncludeArchived {
+		where = append(where, "archived_at IS NULL")
+	}
+
+	// Ages are turned into date of birth ranges so that the index can be used
+	today := time.Now()
+	if query.MinAge > 0 {
+		where = append(where, "date_of_birth <= ?")
+		args = append(args, today.AddDate(-query.MinAge, 0, 0).Format(dateLayout))
+	}
+	if query.MaxAge > 0 {
+		where = append(where, "date_of_birth > ?")
+		args = append(args, today.AddDate(-query.MaxAge-1, 0, 0).Format(dateLayout))
+	}
+	if query.NamePrefix != "" {
+		prefix := db.EscapeLike(query.NamePrefix) + "%"
+		where = append(where, "(firstname LIKE ? OR lastname LIKE ?)")
+		args = append(args, prefix, prefix)
+	}
+
+	// Program and enrollment date filters both look at the client's enrollments
+	if query.Program != "" || !query.EnrolledSince.IsZero() {
+		enrollment := `EXISTS (SELECT 1 FROM enrollments e JOIN programs p ON e.program_id = p.id WHERE e.client_id = clients.id`
+		if query.Program != "" {
+			enrollment += " AND p.name = ?"
+			args = append(args, query.Program)
+		}
+		if !query.EnrolledSince.IsZero() {
+			enrollment += " AND e.enrolled_at >= ?"
+			args = append(args, query.EnrolledSince)
+		}
+		where = append(where, enrollment+")")
+	}
+	return where, args
+}
+
+// whereClause joins conditions into a WHERE clause, it is empty when there are no conditions
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// UpdateClient updates the details of a client in the database.
+// A changed phone number replaces the client's primary number.
+// A height or weight is recorded as a new observation by the given doctor instead of overwriting the previous one.
+func (s *Store) UpdateClient(client types.Client, recordedBy int) error {
 	// context is used to manage the lifetime of the request
 	ctx := context.Background()
-	query := `UPDATE clients SET firstname = ?, lastname = ?, phonenumber = ?, height = ?, weight = ?, age = ?, emergency_contact = ?, emergency_number = ? WHERE id = ?`
 
-	_, err := s.db.ExecContext(ctx, query, client.FirstName, client.LastName, client.PhoneNumber, client.Height, client.Weight, client.Age, client.EmergencyContact, client.EmergencyNumber, client.ID)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE clients SET firstname = ?, lastname = ?, phonenumber = ?, date_of_birth = ?, dob_estimated = ?,
+		sex = ?, national_id = ?, birth_certificate_number = ?, county = ?, sub_county = ?, ward = ?, emergency_contact = ?, emergency_number = ?
+		WHERE id = ?`
+	_, err = tx.ExecContext(ctx, query, client.FirstName, client.LastName, client.PhoneNumber,
+		db.NullIfEmpty(client.DateOfBirth), client.DOBEstimated, db.NullIfEmpty(client.Sex), db.NullIfEmpty(client.NationalID), db.NullIfEmpty(client.BirthCertificateNumber),
+		db.NullIfEmpty(client.County), db.NullIfEmpty(client.SubCounty), db.NullIfEmpty(client.Ward), client.EmergencyContact, client.EmergencyNumber, client.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update client %w", err)
 	}
+
+	// If the new primary number was one of the client's other numbers it is promoted instead of duplicated
+	_, err = tx.ExecContext(ctx, `DELETE FROM client_phones WHERE client_id = ? AND phonenumber = ? AND NOT is_primary`, client.ID, client.PhoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to update client phone numbers: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE client_phones SET phonenumber = ? WHERE client_id = ? AND is_primary`, client.PhoneNumber, client.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update client phone numbers: %w", err)
+	}
+
+	if err := recordMeasurements(ctx, tx, int64(client.ID), client, recordedBy); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit client update: %w", err)
+	}
 	return nil
 }
 
-// DeleteClient deletes a client from the database
-func (s *Store) DeleteClient(phonenumber string) error {
+// DeleteClient archives a client, recording who archived them and when.
+// Archived clients keep all their records until they are restored or purged.
+func (s *Store) DeleteClient(clientID string, archivedBy int) error {
 	// context is used to manage the lifetime of the request
 	ctx := context.Background()
-	query := `DELETE FROM clients WHERE phonenumber = ?`
-	_, err := s.db.ExecContext(ctx, query, phonenumber)
+	query := `UPDATE clients SET archived_at = CURRENT_TIMESTAMP, archived_by = ? WHERE uuid = ? AND ` + activeClient
+	result, err := s.db.ExecContext(ctx, query, archivedBy, clientID)
 	if err != nil {
 		return fmt.Errorf("failed to delete client %w", err)
 	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("client does not exist")
+	}
 	return nil
 }
 
-// CreatePrescription saves a new prescription in the database
-func (s *Store) CreatePrescription(prescription types.Prescription) error {
+// RestoreClient brings back an archived client, recording who restored them and when
+func (s *Store) RestoreClient(clientID string, restoredBy int) error {
 	ctx := context.Background()
-	query := `INSERT INTO prescriptions (client_phone, doctor_id, medicines, date_issued) VALUES (?, ?, ?, ?)`
-	_, err := s.db.ExecContext(ctx, query, prescription.ClientPhone, prescription.DoctorID, prescription.Medicines, prescription.DateIssued)
+	query := `UPDATE clients SET archived_at = NULL, archived_by = NULL, restored_at = CURRENT_TIMESTAMP, restored_by = ?
+		WHERE uuid = ? AND archived_at IS NOT NULL`
+	result, err := s.db.ExecContext(ctx, query, restoredBy, clientID)
 	if err != nil {
-		return fmt.Errorf("failed to save prescription in DB: %w", err)
+		return fmt.Errorf("failed to restore client: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("client does not exist")
 	}
 	return nil
 }
 
-// UpdatePrescription updates an existing prescription in the database
-func (s *Store) UpdatePrescription(prescription types.Prescription) error {
+// PurgeArchivedClients permanently removes the clients archived before the given time
+// together with their enrollments, prescriptions, phone numbers and the tombstones merged into them.
+// Each purged client is recorded in client_events with the doctor who ran the purge, none for the retention job.
+// It returns a report of what was removed.
+func (s *Store) PurgeArchivedClients(before time.Time, purgedBy int) (types.PurgeReport, error) {
+	ctx := context.Background()
+	report := types.PurgeReport{Before: before, Clients: []string{}}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return report, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, uuid FROM clients WHERE archived_at < ? FOR UPDATE`, before)
+	if err != nil {
+		return report, fmt.Errorf("failed to retrieve archived clients: %w", err)
+	}
+	var args []interface{}
+	for rows.Next() {
+		var id int
+		var clientID string
+		if err := rows.Scan(&id, &clientID); err != nil {
+			rows.Close()
+			return report, err
+		}
+		args = append(args, id)
+		report.Clients = append(report.Clients, clientID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	if len(args) == 0 {
+		return report, nil
+	}
+	ids := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+
+	// Count what goes with the clients before it is removed by the cascading deletes
+	counts := []struct {
+		query string
+		count *int
+	}{
+		{`SELECT COUNT(*) FROM enrollments WHERE client_id IN (` + ids + `)`, &report.Enrollments},
+		{`SELECT COUNT(*) FROM prescriptions WHERE client_id IN (` + ids + `)`, &report.Prescriptions},
+		{`SELECT COUNT(*) FROM client_phones WHERE client_id IN (` + ids + `)`, &report.PhoneNumbers},
+		{`SELECT COUNT(*) FROM observations WHERE client_id IN (` + ids + `)`, &report.Observations},
+		{`SELECT COUNT(*) FROM encounters WHERE client_id IN (` + ids + `)`, &report.Encounters},
+		{`SELECT COUNT(*) FROM diagnoses d JOIN encounters e ON e.id = d.encounter_id WHERE e.client_id IN (` + ids + `)`, &report.Diagnoses},
+		{`SELECT COUNT(*) FROM client_allergies WHERE client_id IN (` + ids + `)`, &report.Allergies},
+	}
+	for _, count := range counts {
+		if err := tx.QueryRowContext(ctx, count.query, args...).Scan(count.count); err != nil {
+			return report, fmt.Errorf("failed to count purged records: %w", err)
+		}
+	}
+
+	// Tombstones only point at the purged clients, they go first to satisfy the foreign key
+	result, err := tx.ExecContext(ctx, `DELETE FROM clients WHERE merged_into IN (`+ids+`)`, args...)
+	if err != nil {
+		return report, fmt.Errorf("failed to purge merged clients: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil {
+		report.Tombstones = int(affected)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM clients WHERE id IN (`+ids+`)`, args...); err != nil {
+		return report, fmt.Errorf("failed to purge archived clients: %w", err)
+	}
+
+	for _, clientID := range report.Clients {
+		query := `INSERT INTO client_events (client_uuid, event, detail, recorded_by) VALUES (?, 'purged', ?, ?)`
+		if _, err := tx.ExecContext(ctx, query, clientID, "archived before "+before.Format("2006-01-02"), db.NullIfZero(purgedBy)); err != nil {
+			return report, fmt.Errorf("failed to record purge: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return report, fmt.Errorf("failed to commit purge: %w", err)
+	}
+	return report, nil
+}
+
+// MergeClients moves the enrollments, prescriptions and phone numbers of the duplicate client
+// into the surviving client in one transaction. The duplicate is kept as a tombstone pointing at the survivor
+// with the doctor who merged it.
+func (s *Store) MergeClients(survivorID string, duplicateID string, mergedBy int) error {
 	ctx := context.Background()
-	query := `UPDATE prescriptions SET medicines = ?, date_issued = ? WHERE id = ?`
-	_, err := s.db.ExecContext(ctx, query, prescription.Medicines, prescription.DateIssued, prescription.ID)
+	if survivorID == duplicateID {
+		return fmt.Errorf("a client cannot be merged into itself")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to update prescription in DB: %w", err)
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock both clients so nothing is added to the duplicate while it is merged
+	lock := `SELECT id FROM clients WHERE uuid = ? AND ` + activeClient + ` FOR UPDATE`
+	var survivor, duplicate int
+	if err := tx.QueryRowContext(ctx, lock, survivorID).Scan(&survivor); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("client does not exist")
+		}
+		return fmt.Errorf("failed to retrieve client: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx, lock, duplicateID).Scan(&duplicate); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("client does not exist")
+		}
+		return fmt.Errorf("failed to retrieve client: %w", err)
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		// Programs both clients are enrolled in are only kept once
+		{`INSERT IGNORE INTO enrollments (client_id, program_id, enrolled_at, enrolled_by) SELECT ?, program_id, enrolled_at, enrolled_by FROM enrollments WHERE client_id = ?`, []interface{}{survivor, duplicate}},
+		{`DELETE FROM enrollments WHERE client_id = ?`, []interface{}{duplicate}},
+		{`UPDATE prescriptions SET client_id = ? WHERE client_id = ?`, []interface{}{survivor, duplicate}},
+		{`UPDATE observations SET client_id = ? WHERE client_id = ?`, []interface{}{survivor, duplicate}},
+		{`UPDATE encounters SET client_id = ? WHERE client_id = ?`, []interface{}{survivor, duplicate}},
+		{`UPDATE client_allergies SET client_id = ? WHERE client_id = ?`, []interface{}{survivor, duplicate}},
+		// The survivor keeps its primary number, the duplicate's numbers become secondary numbers
+		{`UPDATE client_phones SET client_id = ?, is_primary = FALSE WHERE client_id = ?`, []interface{}{survivor, duplicate}},
+		// Clients merged into the duplicate earlier now point at the survivor
+		{`UPDATE clients SET merged_into = ? WHERE merged_into = ?`, []interface{}{survivor, duplicate}},
+		{`UPDATE clients SET merged_into = ?, merged_at = CURRENT_TIMESTAMP, merged_by = ? WHERE id = ?`, []interface{}{survivor, mergedBy, duplicate}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return fmt.Errorf("failed to merge clients: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit client merge: %w", err)
 	}
 	return nil
 }
 
-// GetPrescriptionsByClient retrieves all prescriptions for a specific client
-func (s *Store) GetPrescriptionsByClient(client_phone string) ([]types.Prescription, error) {
+// AddClientPhone adds a phone number to a client.
+// Adding a number the client already has only changes whether it is the primary number.
+func (s *Store) AddClientPhone(clientID string, phone types.ClientPhone, addedBy int) error {
 	ctx := context.Background()
-	query := `SELECT id, client_phone, doctor_id, medicines, date_issued FROM prescriptions WHERE client_phone = ?`
-	rows, err := s.db.QueryContext(ctx, query, client_phone)
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve prescriptions: %w", err)
+		return fmt.Errorf("failed to start transaction: %w", err)
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var prescriptions []types.Prescription
-	for rows.Next() {
-		var prescription types.Prescription
-		if err := rows.Scan(&prescription.ID, &prescription.ClientPhone, &prescription.DoctorID, &prescription.Medicines, &prescription.DateIssued); err != nil {
-			return nil, err
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM clients WHERE uuid = ? AND `+activeClient+` FOR UPDATE`, clientID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("client does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve client: %w", err)
+	}
+
+	// A phone number can only belong to one client
+	var owner int
+	err = tx.QueryRowContext(ctx, `SELECT client_id FROM client_phones WHERE phonenumber = ?`, phone.PhoneNumber).Scan(&owner)
+	if err == nil && owner != id {
+		return fmt.Errorf("phone number belongs to another client")
+	} else if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check phone number: %w", err)
+	}
+
+	if phone.Primary {
+		// There is only one primary number, which is mirrored on the client
+		if _, err := tx.ExecContext(ctx, `UPDATE client_phones SET is_primary = FALSE WHERE client_id = ?`, id); err != nil {
+			return fmt.Errorf("failed to update client phone numbers: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE clients SET phonenumber = ? WHERE id = ?`, phone.PhoneNumber, id); err != nil {
+			return fmt.Errorf("failed to update client phone number: %w", err)
 		}
-		prescriptions = append(prescriptions, prescription)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+
+	query := `INSERT INTO client_phones (client_id, phonenumber, is_primary, added_by) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE is_primary = is_primary OR VALUES(is_primary)`
+	if _, err := tx.ExecContext(ctx, query, id, phone.PhoneNumber, phone.Primary, addedBy); err != nil {
+		return fmt.Errorf("failed to save client phone number: %w", err)
 	}
-	return prescriptions, nil
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit client phone number: %w", err)
+	}
+	return nil
+}
+
+// RemoveClientPhone removes one of the client's phone numbers, the primary number cannot be removed.
+// The removed number is recorded in client_events with the doctor who removed it.
+func (s *Store) RemoveClientPhone(clientID string, phonenumber string, removedBy int) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM client_phones WHERE phonenumber = ? AND NOT is_primary AND client_id = (SELECT id FROM clients WHERE uuid = ?)`
+	result, err := tx.ExecContext(ctx, query, phonenumber, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to remove client phone number: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("phone number not found or is the primary number")
+	}
+
+	query = `INSERT INTO client_events (client_uuid, event, detail, recorded_by) VALUES (?, 'phone_removed', ?, ?)`
+	if _, err := tx.ExecContext(ctx, query, clientID, phonenumber, removedBy); err != nil {
+		return fmt.Errorf("failed to record phone number removal: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit phone number removal: %w", err)
+	}
+	return nil
+}
+
+// CreatePrescription saves a new prescription with its items in the database.
+// The encounter it is attached to, if any, must be one of the client's.
+// The items are checked for interactions and allergies in the same transaction, with the client locked,
+// so that two prescriptions written at once are checked against each other.
+// It returns the id of the new prescription and the warnings, which come with an error when they block it.
+func (s *Store) CreatePrescription(prescription types.Prescription) (int, []types.PrescriptionWarning, error) {
+	ctx := context.Background()
+
+	var encounterID interface{}
+	if prescription.EncounterID != 0 {
+		var id int
+		err := s.db.QueryRowContext(ctx, `SELECT e.id FROM encounters e JOIN clients c ON c.id = e.client_id WHERE e.id = ? AND c.uuid = ?`,
+			prescription.EncounterID, prescription.ClientID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return 0, nil, fmt.Errorf("encounter does not exist")
+		} else if err != nil {
+			return 0, nil, fmt.Errorf("failed to retrieve encounter: %w", err)
+		}
+		encounterID = id
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var clientID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM clients WHERE uuid = ? AND `+activeClient+` FOR UPDATE`, prescription.ClientID).Scan(&clientID)
+	if err == sql.ErrNoRows {
+		return 0, nil, fmt.Errorf("client does not exist")
+	} else if err != nil {
+		return 0, nil, fmt.Errorf("failed to retrieve client: %w", err)
+	}
+
+	prescribing, err := prescribingContext(ctx, tx, clientID, prescription.Items, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	warnings, err := checkItems(&prescription, prescribing)
+	if err != nil {
+		return 0, warnings, err
+	}
+
+	overriddenWarnings, err := warningsJSON(prescription.OverriddenWarnings)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	query := `INSERT INTO prescriptions (client_id, encounter_id, doctor_id, date_issued, override_reason, overridden_warnings, status, refills, valid_until)
+		VALUES (?, ?, ?, ?, ?, ?, 'active', ?, ?)`
+	result, err := tx.ExecContext(ctx, query, clientID, encounterID, prescription.DoctorID, prescription.DateIssued,
+		db.NullIfEmpty(prescription.OverrideReason), overriddenWarnings, prescription.Refills, prescription.ValidUntil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to save prescription in DB: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to retrieve prescription id: %w", err)
+	}
+
+	if err := insertPrescriptionItems(ctx, tx, id, prescription.Items); err != nil {
+		return 0, nil, err
+	}
+	query = `INSERT INTO prescription_events (prescription_id, event, recorded_by) VALUES (?, 'created', ?)`
+	if _, err := tx.ExecContext(ctx, query, id, prescription.DoctorID); err != nil {
+		return 0, nil, fmt.Errorf("failed to save prescription history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit prescription: %w", err)
+	}
+	return int(id), warnings, nil
+}
+
+// insertPrescriptionItems saves the items of a prescription in the order they were given.
+// The name of a formulary drug, and its strength unless another one is given, are taken from the formulary.
+func insertPrescriptionItems(ctx context.Context, tx *sql.Tx, prescriptionID int64, items []types.PrescriptionItem) error {
+	query := `INSERT INTO prescription_items (prescription_id, position, drug_id, drug, free_text, strength, dose, unit, route, frequency, duration_days, quantity, instructions)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for i, item := range items {
+		var drugID interface{}
+		if item.DrugID != 0 {
+			var strength string
+			err := tx.QueryRowContext(ctx, `SELECT generic_name, strength FROM formulary WHERE id = ? AND active`, item.DrugID).Scan(&item.Drug, &strength)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("drug is not in the formulary")
+			} else if err != nil {
+				return fmt.Errorf("failed to retrieve formulary drug: %w", err)
+			}
+			if item.Strength == "" {
+				item.Strength = strength
+			}
+			drugID = item.DrugID
+		}
+
+		_, err := tx.ExecContext(ctx, query, prescriptionID, i+1, drugID, item.Drug, item.FreeText, db.NullIfEmpty(item.Strength), item.Dose, item.Unit, item.Route,
+			item.Frequency, item.DurationDays, item.Quantity, db.NullIfEmpty(item.Instructions))
+		if err != nil {
+			return fmt.Errorf("failed to save prescription item: %w", err)
+		}
+	}
+	return nil
+}
+
+// UpdatePrescription amends a prescription that has not been dispensed yet, its items are replaced by the given ones.
+// The items are checked like those of a new prescription, in the same transaction.
+// The amendment is recorded in the prescription history with the doctor whose id is recordedBy.
+// Unless a new ValidUntil is given the validity window keeps its length from the new issue date.
+// It returns the warnings, which come with an error when they block the amendment.
+func (s *Store) UpdatePrescription(prescription types.Prescription, recordedBy int) ([]types.PrescriptionWarning, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The client is locked before the prescription, in the same order as creating one
+	var clientID int
+	query := `SELECT c.id FROM clients c JOIN prescriptions p ON p.client_id = c.id WHERE p.id = ? FOR UPDATE OF c`
+	err = tx.QueryRowContext(ctx, query, prescription.ID).Scan(&clientID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("prescription does not exist")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve prescription: %w", err)
+	}
+
+	var id int64
+	var status string
+	var refillsUsed int
+	query = `SELECT id, ` + db.PrescriptionStatus + `, refills_used FROM prescriptions p WHERE id = ? FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, prescription.ID).Scan(&id, &status, &refillsUsed)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("prescription does not exist")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve prescription: %w", err)
+	}
+	// Once anything has been handed out the prescription is history, changes are made with a new one
+	if status != statusActive || refillsUsed > 0 {
+		return nil, fmt.Errorf("prescription cannot be amended")
+	}
+
+	prescribing, err := prescribingContext(ctx, tx, clientID, prescription.Items, prescription.ID)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := checkItems(&prescription, prescribing)
+	if err != nil {
+		return warnings, err
+	}
+
+	overriddenWarnings, err := warningsJSON(prescription.OverriddenWarnings)
+	if err != nil {
+		return nil, err
+	}
+	// Without a new ValidUntil the prescription stays valid for as many days as before.
+	// valid_until is set first, MySQL uses the values already assigned for the columns that follow.
+	var validUntil interface{}
+	if !prescription.ValidUntil.IsZero() {
+		validUntil = prescription.ValidUntil
+	}
+	query = `UPDATE prescriptions SET valid_until = COALESCE(?, DATE_ADD(?, INTERVAL DATEDIFF(valid_until, date_issued) DAY)),
+		date_issued = ?, refills = ?, override_reason = ?, overridden_warnings = ? WHERE id = ?`
+	_, err = tx.ExecContext(ctx, query, validUntil, prescription.DateIssued, prescription.DateIssued, prescription.Refills,
+		db.NullIfEmpty(prescription.OverrideReason), overriddenWarnings, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update prescription in DB: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM prescription_items WHERE prescription_id = ?`, id); err != nil {
+		return nil, fmt.Errorf("failed to update prescription items: %w", err)
+	}
+	if err := insertPrescriptionItems(ctx, tx, id, prescription.Items); err != nil {
+		return nil, err
+	}
+	query = `INSERT INTO prescription_events (prescription_id, event, recorded_by) VALUES (?, 'amended', ?)`
+	if _, err := tx.ExecContext(ctx, query, id, recordedBy); err != nil {
+		return nil, fmt.Errorf("failed to save prescription history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit prescription update: %w", err)
+	}
+	return warnings, nil
+}
+
+// prescriptionColumns are the columns scanned by scanPrescription
+const prescriptionColumns = `p.id, c.uuid, p.encounter_id, p.doctor_id, p.date_issued, COALESCE(p.override_reason, ''), p.overridden_warnings,
+	` + db.PrescriptionStatus + `, COALESCE(p.cancel_reason, ''), p.refills, p.refills - p.refills_used, p.valid_until`
+
+// scanPrescription scans a row selected with prescriptionColumns
+func scanPrescription(row interface{ Scan(...interface{}) error }) (types.Prescription, error) {
+	var prescription types.Prescription
+	var encounterID sql.NullInt64
+	var overriddenWarnings []byte
+	err := row.Scan(&prescription.ID, &prescription.ClientID, &encounterID, &prescription.DoctorID, &prescription.DateIssued,
+		&prescription.OverrideReason, &overriddenWarnings, &prescription.Status, &prescription.CancelReason,
+		&prescription.Refills, &prescription.RefillsRemaining, &prescription.ValidUntil)
+	if err != nil {
+		return prescription, err
+	}
+	prescription.EncounterID = int(encounterID.Int64)
+	if overriddenWarnings != nil {
+		if err := json.Unmarshal(overriddenWarnings, &prescription.OverriddenWarnings); err != nil {
+			return prescription, fmt.Errorf("failed to read overridden warnings: %w", err)
+		}
+	}
+	return prescription, nil
+}
+
+// GetPrescription retrieves a prescription with its items and history
+func (s *Store) GetPrescription(id int) (types.Prescription, error) {
+	ctx := context.Background()
+
+	query := `SELECT ` + prescriptionColumns + ` FROM prescriptions p JOIN clients c ON c.id = p.client_id WHERE p.id = ?`
+	prescription, err := scanPrescription(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return prescription, fmt.Errorf("prescription does not exist")
+	} else if err != nil {
+		return prescription, fmt.Errorf("failed to retrieve prescription: %w", err)
+	}
+
+	items, err := s.prescriptionItemsFor(ctx, id)
+	if err != nil {
+		return prescription, err
+	}
+	prescription.Items = items[id]
+
+	query = `SELECT event, COALESCE(reason, ''), recorded_by, recorded_at FROM prescription_events WHERE prescription_id = ? ORDER BY recorded_at, id`
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return prescription, fmt.Errorf("failed to retrieve prescription history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event types.PrescriptionEvent
+		var doctorID sql.NullInt64
+		if err := rows.Scan(&event.Event, &event.Reason, &doctorID, &event.RecordedAt); err != nil {
+			return prescription, err
+		}
+		event.DoctorID = int(doctorID.Int64)
+		prescription.History = append(prescription.History, event)
+	}
+	if err := rows.Err(); err != nil {
+		return prescription, err
+	}
+	return prescription, nil
+}
+
+// ChangePrescriptionStatus moves a prescription to another status, changed by the doctor whose id is changedBy.
+// Expired prescriptions can only be cancelled, a cancellation needs a reason.
+func (s *Store) ChangePrescriptionStatus(id int, status string, reason string, changedBy int) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT `+db.PrescriptionStatus+` FROM prescriptions p WHERE id = ? FOR UPDATE`, id).Scan(&current)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("prescription does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve prescription: %w", err)
+	}
+	if current == statusExpired && status != statusCancelled {
+		return fmt.Errorf("prescription has expired")
+	}
+	if current == statusExpired {
+		current = statusActive
+	}
+	if !canChangeStatus(current, status) {
+		return fmt.Errorf("prescription status cannot be changed")
+	}
+
+	query := `UPDATE prescriptions SET status = ?, cancel_reason = ? WHERE id = ?`
+	var cancelReason interface{}
+	if status == statusCancelled {
+		cancelReason = reason
+	}
+	if _, err := tx.ExecContext(ctx, query, status, cancelReason, id); err != nil {
+		return fmt.Errorf("failed to update prescription status: %w", err)
+	}
+	query = `INSERT INTO prescription_events (prescription_id, event, reason, recorded_by) VALUES (?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, query, id, status, db.NullIfEmpty(reason), changedBy); err != nil {
+		return fmt.Errorf("failed to save prescription history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit prescription status: %w", err)
+	}
+	return nil
+}
+
+// RefillPrescription uses one of the refills of a dispensed prescription, making it active to be dispensed again.
+// The refill is recorded with the doctor whose id is refilledBy.
+func (s *Store) RefillPrescription(id int, refilledBy int) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var status string
+	var remaining int
+	var expired bool
+	query := `SELECT status, refills - refills_used, valid_until < CURRENT_DATE FROM prescriptions WHERE id = ? FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&status, &remaining, &expired)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("prescription does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve prescription: %w", err)
+	}
+	if status != statusDispensed {
+		return fmt.Errorf("prescription status cannot be changed")
+	}
+	if expired {
+		return fmt.Errorf("prescription has expired")
+	}
+	if remaining <= 0 {
+		return fmt.Errorf("no refills remaining")
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE prescriptions SET status = 'active', refills_used = refills_used + 1 WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to refill prescription: %w", err)
+	}
+	query = `INSERT INTO prescription_events (prescription_id, event, recorded_by) VALUES (?, 'refilled', ?)`
+	if _, err := tx.ExecContext(ctx, query, id, refilledBy); err != nil {
+		return fmt.Errorf("failed to save prescription history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit prescription refill: %w", err)
+	}
+	return nil
+}
+
+// GetActiveMedications retrieves the medications a client is currently on, the most recently prescribed first
+func (s *Store) GetActiveMedications(clientID string) ([]types.Medication, error) {
+	ctx := context.Background()
+
+	var id int
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM clients WHERE uuid = ?`, clientID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("client does not exist")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve client: %w", err)
+	}
+
+	query := `
+		SELECT p.id, p.status, p.date_issued, p.valid_until, p.refills - p.refills_used, ` + prescriptionItemColumns + `
+		FROM prescription_items i
+		JOIN prescriptions p ON p.id = i.prescription_id
+		LEFT JOIN formulary f ON f.id = i.drug_id
+		WHERE p.client_id = ? AND ` + activeMedication + `
+		ORDER BY p.date_issued DESC, p.id DESC, i.position
+	`
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve active medications: %w", err)
+	}
+	defer rows.Close()
+
+	medications := []types.Medication{}
+	for rows.Next() {
+		var medication types.Medication
+		fields := append([]interface{}{&medication.PrescriptionID, &medication.Status, &medication.DateIssued, &medication.ValidUntil,
+			&medication.RefillsRemaining}, prescriptionItemFields(&medication.PrescriptionItem)...)
+		if err := rows.Scan(fields...); err != nil {
+			return nil, fmt.Errorf("failed to scan medication: %w", err)
+		}
+		medications = append(medications, medication)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve active medications: %w", err)
+	}
+	return medications, nil
+}
+
+// GetPrescriptionsByClient retrieves all prescriptions for a specific client with their items
+func (s *Store) GetPrescriptionsByClient(clientID string) ([]types.Prescription, error) {
+	ctx := context.Background()
+	query := `
+		SELECT ` + prescriptionColumns + `
+		FROM prescriptions p
+		JOIN clients c ON p.client_id = c.id
+		WHERE c.uuid = ?
+		ORDER BY p.date_issued DESC, p.id DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve prescriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var prescriptions []types.Prescription
+	var ids []int
+	for rows.Next() {
+		prescription, err := scanPrescription(rows)
+		if err != nil {
+			return nil, err
+		}
+		prescriptions = append(prescriptions, prescription)
+		ids = append(ids, prescription.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items, err := s.prescriptionItemsFor(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range prescriptions {
+		prescriptions[i].Items = items[prescriptions[i].ID]
+	}
+	return prescriptions, nil
+}
+
+// prescriptionItemColumns are the columns of a prescription item i joined with its formulary drug f
+const prescriptionItemColumns = `COALESCE(i.drug_id, 0), i.drug, COALESCE(f.atc_code, ''), i.free_text, COALESCE(i.strength, ''),
+	COALESCE(i.dose, 0), COALESCE(i.unit, ''), COALESCE(i.route, ''), COALESCE(i.frequency, ''),
+	COALESCE(i.duration_days, 0), COALESCE(i.quantity, 0), COALESCE(i.instructions, '')`
+
+// prescriptionItemFields returns the fields to scan prescriptionItemColumns into
+func prescriptionItemFields(item *types.PrescriptionItem) []interface{} {
+	return []interface{}{&item.DrugID, &item.Drug, &item.ATCCode, &item.FreeText, &item.Strength,
+		&item.Dose, &item.Unit, &item.Route, &item.Frequency, &item.DurationDays, &item.Quantity, &item.Instructions}
+}
+
+// prescriptionItemsFor retrieves the items of the given prescriptions keyed by prescription id
+func (s *Store) prescriptionItemsFor(ctx context.Context, ids ...int) (map[int][]types.PrescriptionItem, error) {
+	items := map[int][]types.PrescriptionItem{}
+	if len(ids) == 0 {
+		return items, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	query := `SELECT i.prescription_id, ` + prescriptionItemColumns + `
+		FROM prescription_items i
+		LEFT JOIN formulary f ON f.id = i.drug_id
+		WHERE i.prescription_id IN (` + placeholders + `) ORDER BY i.position`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve prescription items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var item types.PrescriptionItem
+		if err := rows.Scan(append([]interface{}{&id}, prescriptionItemFields(&item)...)...); err != nil {
+			return nil, err
+		}
+		items[id] = append(items[id], item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// warningsJSON encodes overridden warnings for storage, no warnings are stored as NULL
+func warningsJSON(warnings []types.PrescriptionWarning) (interface{}, error) {
+	if len(warnings) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(warnings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode overridden warnings: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// prescribingContext retrieves what the items of a prescription of the client id are checked against:
+// the formulary drugs being prescribed, the items of the client's active prescriptions,
+// the client's active allergies and the interaction rules.
+// The prescription being updated is excluded from the active ones.
+// It reads in the transaction writing the prescription, after the client has been locked.
+func prescribingContext(ctx context.Context, tx *sql.Tx, id int, items []types.PrescriptionItem, excludePrescriptionID int) (types.PrescribingContext, error) {
+	prescribing := types.PrescribingContext{Drugs: map[int]types.Drug{}}
+
+	var drugIDs []int
+	for _, item := range items {
+		if item.DrugID != 0 {
+			drugIDs = append(drugIDs, item.DrugID)
+		}
+	}
+
+	// The formulary drugs being prescribed
+	if len(drugIDs) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(drugIDs)), ", ")
+		args := make([]interface{}, len(drugIDs))
+		for i, drugID := range drugIDs {
+			args[i] = drugID
+		}
+		query := `SELECT id, generic_name, COALESCE(atc_code, ''), form, strength, active FROM formulary WHERE active AND id IN (` + placeholders + `)`
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return prescribing, fmt.Errorf("failed to retrieve formulary drugs: %w", err)
+		}
+		for rows.Next() {
+			var drug types.Drug
+			if err := rows.Scan(&drug.ID, &drug.GenericName, &drug.ATCCode, &drug.Form, &drug.Strength, &drug.Active); err != nil {
+				rows.Close()
+				return prescribing, err
+			}
+			prescribing.Drugs[drug.ID] = drug
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return prescribing, err
+		}
+	}
+
+	// The items of the active prescriptions
+	query := `
+		SELECT ` + prescriptionItemColumns + `
+		FROM prescription_items i
+		JOIN prescriptions p ON p.id = i.prescription_id
+		LEFT JOIN formulary f ON f.id = i.drug_id
+		WHERE p.client_id = ? AND p.id <> ? AND ` + activeMedication + `
+	`
+	rows, err := tx.QueryContext(ctx, query, id, excludePrescriptionID)
+	if err != nil {
+		return prescribing, fmt.Errorf("failed to retrieve active prescriptions: %w", err)
+	}
+	for rows.Next() {
+		var item types.PrescriptionItem
+		if err := rows.Scan(prescriptionItemFields(&item)...); err != nil {
+			rows.Close()
+			return prescribing, err
+		}
+		prescribing.ActiveItems = append(prescribing.ActiveItems, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return prescribing, err
+	}
+
+	prescribing.Allergies, err = allergiesFor(ctx, tx, id)
+	if err != nil {
+		return prescribing, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT drug_a, drug_b, severity, description FROM drug_interactions`)
+	if err != nil {
+		return prescribing, fmt.Errorf("failed to retrieve interaction rules: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rule types.InteractionRule
+		if err := rows.Scan(&rule.DrugA, &rule.DrugB, &rule.Severity, &rule.Description); err != nil {
+			return prescribing, err
+		}
+		prescribing.Rules = append(prescribing.Rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return prescribing, err
+	}
+	return prescribing, nil
+}
+
+// allergiesFor retrieves the active allergies of a client, from the database or in a transaction
+func allergiesFor(ctx context.Context, q queryer, id int) ([]types.Allergy, error) {
+	query := `
+		SELECT a.id, c.uuid, a.substance, COALESCE(a.drug_id, 0), COALESCE(a.atc_code, ''), a.kind, COALESCE(a.reaction, ''),
+			a.severity, a.active, a.recorded_at
+		FROM client_allergies a
+		JOIN clients c ON c.id = a.client_id
+		WHERE a.client_id = ? AND a.active
+		ORDER BY a.recorded_at, a.id
+	`
+	rows, err := q.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve allergies: %w", err)
+	}
+	defer rows.Close()
+
+	allergies := []types.Allergy{}
+	for rows.Next() {
+		var allergy types.Allergy
+		err := rows.Scan(&allergy.ID, &allergy.ClientID, &allergy.Substance, &allergy.DrugID, &allergy.ATCCode, &allergy.Kind,
+			&allergy.Reaction, &allergy.Severity, &allergy.Active, &allergy.RecordedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan allergy: %w", err)
+		}
+		allergies = append(allergies, allergy)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve allergies: %w", err)
+	}
+	return allergies, nil
+}
+
+// AddClientAllergy records an allergy or intolerance of a client, recorded by the doctor whose id is recordedBy.
+// An allergy to a formulary drug takes its name and ATC code from the formulary unless they are given.
+// It returns the id of the new allergy.
+func (s *Store) AddClientAllergy(clientID string, allergy types.Allergy, recordedBy int) (int, error) {
+	ctx := context.Background()
+
+	var id int
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM clients WHERE uuid = ? AND `+activeClient, clientID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("client does not exist")
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to retrieve client: %w", err)
+	}
+
+	var drugID interface{}
+	if allergy.DrugID != 0 {
+		var name, atcCode string
+		err := s.db.QueryRowContext(ctx, `SELECT generic_name, COALESCE(atc_code, '') FROM formulary WHERE id = ?`, allergy.DrugID).Scan(&name, &atcCode)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("drug is not in the formulary")
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to retrieve formulary drug: %w", err)
+		}
+		if allergy.Substance == "" {
+			allergy.Substance = name
+		}
+		if allergy.ATCCode == "" {
+			allergy.ATCCode = atcCode
+		}
+		drugID = allergy.DrugID
+	}
+
+	query := `INSERT INTO client_allergies (client_id, substance, drug_id, atc_code, kind, reaction, severity, recorded_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := s.db.ExecContext(ctx, query, id, allergy.Substance, drugID, db.NullIfEmpty(allergy.ATCCode), allergy.Kind,
+		db.NullIfEmpty(allergy.Reaction), allergy.Severity, recordedBy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save allergy: %w", err)
+	}
+	allergyID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve allergy id: %w", err)
+	}
+	return int(allergyID), nil
+}
+
+// ResolveClientAllergy marks an allergy of a client as no longer active, it is kept in the record
+// with the doctor who resolved it
+func (s *Store) ResolveClientAllergy(clientID string, allergyID int, resolvedBy int) error {
+	ctx := context.Background()
+
+	query := `UPDATE client_allergies a JOIN clients c ON c.id = a.client_id
+		SET a.active = FALSE, a.resolved_by = ?, a.resolved_at = CURRENT_TIMESTAMP
+		WHERE a.id = ? AND c.uuid = ? AND a.active`
+	result, err := s.db.ExecContext(ctx, query, resolvedBy, allergyID, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to resolve allergy: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("allergy does not exist")
+	}
+	return nil
+}
+
+// ReplaceInteractionRules replaces all the drug interaction rules in one transaction
+func (s *Store) ReplaceInteractionRules(rules []types.InteractionRule) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM drug_interactions`); err != nil {
+		return fmt.Errorf("failed to remove interaction rules: %w", err)
+	}
+	query := `INSERT INTO drug_interactions (drug_a, drug_b, severity, description) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE severity = VALUES(severity), description = VALUES(description)`
+	for _, rule := range rules {
+		if _, err := tx.ExecContext(ctx, query, rule.DrugA, rule.DrugB, rule.Severity, rule.Description); err != nil {
+			return fmt.Errorf("failed to save interaction rule: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit interaction rules: %w", err)
+	}
+	return nil
 }
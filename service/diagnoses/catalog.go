@@ -0,0 +1,180 @@
+package diagnoses
+
+import (
+	"bytes"
+	"cema_backend/types"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Code systems of the catalog
+const (
+	ICD10 = "ICD-10"
+	ICD11 = "ICD-11"
+)
+
+// bundledICD10 holds common ICD-10 codes, a full code file can replace it with ICD10_CODES_FILE
+//
+//go:embed codes/icd10.csv
+var bundledICD10 []byte
+
+// Catalog holds the codes diagnoses can be made with
+type Catalog struct {
+	codes []types.ICDCode
+	index map[string]types.ICDCode
+}
+
+// LoadCatalog loads the ICD-10 codes from icd10File, or the bundled codes when it is empty,
+// and the ICD-11 codes from icd11File when it is set.
+// Code files are CSV with a header and the code and description in the first two columns.
+func LoadCatalog(icd10File, icd11File string) (*Catalog, error) {
+	catalog := &Catalog{index: map[string]types.ICDCode{}}
+
+	var icd10 io.Reader = bytes.NewReader(bundledICD10)
+	if icd10File != "" {
+		file, err := os.Open(icd10File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open ICD-10 codes: %w", err)
+		}
+		defer file.Close()
+		icd10 = file
+	}
+	if err := catalog.load(ICD10, icd10); err != nil {
+		return nil, err
+	}
+
+	if icd11File != "" {
+		file, err := os.Open(icd11File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open ICD-11 codes: %w", err)
+		}
+		defer file.Close()
+		if err := catalog.load(ICD11, file); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(catalog.codes, func(i, j int) bool {
+		if catalog.codes[i].System != catalog.codes[j].System {
+			return catalog.codes[i].System < catalog.codes[j].System
+		}
+		return catalog.codes[i].Code < catalog.codes[j].Code
+	})
+	return catalog, nil
+}
+
+// load adds the codes of a code file to the catalog
+func (c *Catalog) load(system string, r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read %s codes: %w", system, err)
+	}
+	for i, record := range records {
+		// Skip the header
+		if i == 0 {
+			continue
+		}
+		if len(record) < 2 || strings.TrimSpace(record[0]) == "" {
+			return fmt.Errorf("invalid %s code on line %d", system, i+1)
+		}
+		code := types.ICDCode{
+			System:      system,
+			Code:        normalizeCode(record[0]),
+			Description: strings.TrimSpace(record[1]),
+		}
+		c.codes = append(c.codes, code)
+		c.index[system+" "+code.Code] = code
+	}
+	return nil
+}
+
+// normalizeCode formats a code the way the catalog stores it
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Lookup finds a code of the given system
+func (c *Catalog) Lookup(system, code string) (types.ICDCode, bool) {
+	entry, ok := c.index[system+" "+normalizeCode(code)]
+	return entry, ok
+}
+
+// Search returns the codes matching q for autocompletion, best matches first.
+// A code matching q exactly comes first, then codes starting with q,
+// then descriptions with words starting with every word of q, then descriptions containing q.
+// An empty system searches all of them.
+func (c *Catalog) Search(q, system string, limit int) []types.ICDCode {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if q == "" {
+		return []types.ICDCode{}
+	}
+	code := strings.ReplaceAll(q, ".", "")
+	words := strings.Fields(q)
+
+	type match struct {
+		code types.ICDCode
+		rank int
+	}
+	var matches []match
+	for _, entry := range c.codes {
+		if system != "" && entry.System != system {
+			continue
+		}
+		entryCode := strings.ToLower(strings.ReplaceAll(entry.Code, ".", ""))
+		description := strings.ToLower(entry.Description)
+
+		rank := -1
+		switch {
+		case entryCode == code:
+			rank = 0
+		case strings.HasPrefix(entryCode, code):
+			rank = 1
+		case hasWordPrefixes(description, words):
+			rank = 2
+		case strings.Contains(description, q):
+			rank = 3
+		}
+		if rank >= 0 {
+			matches = append(matches, match{entry, rank})
+		}
+	}
+
+	// The catalog is sorted, a stable sort keeps codes in order within a rank
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].rank < matches[j].rank })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	codes := make([]types.ICDCode, len(matches))
+	for i, m := range matches {
+		codes[i] = m.code
+	}
+	return codes
+}
+
+// hasWordPrefixes checks that every word starts one of the words of the text
+func hasWordPrefixes(text string, words []string) bool {
+	textWords := strings.FieldsFunc(text, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	})
+	for _, word := range words {
+		found := false
+		for _, textWord := range textWords {
+			if strings.HasPrefix(textWord, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,226 @@
+package diagnoses
+
+import (
+	"cema_backend/auth"
+	"cema_backend/logging"
+	"cema_backend/types"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// dateLayout is the format of the dates used to filter reports
+	dateLayout = "2006-01-02"
+
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+// certainties a diagnosis can have
+var certainties = map[string]bool{"provisional": true, "confirmed": true}
+
+// Handler struct contains the store for clients' diagnoses and the catalog they are coded from
+type Handler struct {
+	store   types.DiagnosisStore
+	catalog *Catalog
+}
+
+// NewHandler initializes a new Handler instance with the given DiagnosisStore and code catalog.
+func NewHandler(store types.DiagnosisStore, catalog *Catalog) *Handler {
+	return &Handler{store: store, catalog: catalog}
+}
+
+// SearchCodes handles the autocompletion of diagnosis codes from a code or words of the description
+func (h *Handler) SearchCodes(c *gin.Context) {
+	limit := defaultSearchLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = n
+	}
+	system := c.Query("system")
+	if system != "" && system != ICD10 && system != ICD11 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code system, expected ICD-10 or ICD-11"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.catalog.Search(c.Query("q"), system, limit))
+}
+
+// AddDiagnoses handles attaching coded diagnoses to an encounter.
+// Diagnoses are ICD-10 and provisional unless said otherwise, at most one can be primary.
+func (h *Handler) AddDiagnoses(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		EncounterID int `json:"encounter_id" binding:"required"`
+		Diagnoses   []struct {
+			System    string `json:"system"`
+			Code      string `json:"code"`
+			Primary   bool   `json:"primary"`
+			Certainty string `json:"certainty"`
+		} `json:"diagnoses" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	if len(request.Diagnoses) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one diagnosis is required"})
+		return
+	}
+
+	// Validate the diagnoses against the catalog
+	diagnoses := make([]types.Diagnosis, 0, len(request.Diagnoses))
+	seen := map[string]bool{}
+	primaries := 0
+	for _, d := range request.Diagnoses {
+		if d.System == "" {
+			d.System = ICD10
+		}
+		code, ok := h.catalog.Lookup(d.System, d.Code)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown " + d.System + " code: " + d.Code})
+			return
+		}
+		if seen[code.System+" "+code.Code] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate diagnosis: " + code.Code})
+			return
+		}
+		seen[code.System+" "+code.Code] = true
+
+		if d.Certainty == "" {
+			d.Certainty = "provisional"
+		}
+		if !certainties[d.Certainty] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Certainty must be provisional or confirmed"})
+			return
+		}
+		if d.Primary {
+			primaries++
+		}
+		diagnoses = append(diagnoses, types.Diagnosis{ICDCode: code, Primary: d.Primary, Certainty: d.Certainty})
+	}
+	if primaries > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only one diagnosis can be primary"})
+		return
+	}
+
+	if err := h.store.AddDiagnoses(request.EncounterID, diagnoses, actor.ID); err != nil {
+		if err.Error() == "encounter does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Encounter not Found"})
+			return
+		}
+		logging.Error("Failed to Add Diagnoses: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error adding diagnoses"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Diagnoses added successfully"})
+}
+
+// GetDiagnosesByClient handles the request for the diagnoses of the client in client_id
+func (h *Handler) GetDiagnosesByClient(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Client ID is required"})
+		return
+	}
+
+	diagnoses, err := h.store.GetDiagnosesByClient(clientID)
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		logging.Error("Failed to Get Diagnoses: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving diagnoses"})
+		return
+	}
+	c.JSON(http.StatusOK, diagnoses)
+}
+
+// UpdateDiagnosis handles marking a diagnosis primary or secondary and provisional or confirmed
+func (h *Handler) UpdateDiagnosis(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid diagnosis ID"})
+		return
+	}
+
+	// primary is a pointer so that leaving it out is not read as making the diagnosis secondary
+	var request struct {
+		Primary   *bool  `json:"primary"`
+		Certainty string `json:"certainty" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	if request.Primary == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Primary is required"})
+		return
+	}
+	if !certainties[request.Certainty] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Certainty must be provisional or confirmed"})
+		return
+	}
+
+	err = h.store.UpdateDiagnosis(types.Diagnosis{ID: id, Primary: *request.Primary, Certainty: request.Certainty})
+	if err != nil {
+		if err.Error() == "diagnosis does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Diagnosis not Found"})
+			return
+		}
+		logging.Error("Failed to Update Diagnosis: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating diagnosis"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Diagnosis updated successfully"})
+}
+
+// ReportDiagnoses handles the request for how often each code was diagnosed across the facility.
+// from and to are optional dates, both days are included.
+func (h *Handler) ReportDiagnoses(c *gin.Context) {
+	query := types.DiagnosisReportQuery{
+		System:        c.Query("system"),
+		CodePrefix:    normalizeCode(c.Query("code")),
+		ConfirmedOnly: c.Query("confirmed") == "true",
+	}
+	if query.System != "" && query.System != ICD10 && query.System != ICD11 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code system, expected ICD-10 or ICD-11"})
+		return
+	}
+	if value := c.Query("from"); value != "" {
+		date, err := time.Parse(dateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		query.From = date
+	}
+	if value := c.Query("to"); value != "" {
+		date, err := time.Parse(dateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		// The store excludes the end, so the range runs up to the start of the next day
+		query.To = date.AddDate(0, 0, 1)
+	}
+
+	report, err := h.store.ReportDiagnoses(query)
+	if err != nil {
+		logging.Error("Failed to Report Diagnoses: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reporting diagnoses"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,211 @@
+package diagnoses
+
+import (
+	"bytes"
+	"cema_backend/auth"
+	"cema_backend/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockDiagnosisStore is a mock implementation of the DiagnosisStore interface.
+type MockDiagnosisStore struct {
+	mock.Mock
+}
+
+func (m *MockDiagnosisStore) AddDiagnoses(encounterID int, diagnoses []types.Diagnosis, recordedBy int) error {
+	args := m.Called(encounterID, diagnoses, recordedBy)
+	return args.Error(0)
+}
+
+func (m *MockDiagnosisStore) GetDiagnosesByClient(clientID string) ([]types.Diagnosis, error) {
+	args := m.Called(clientID)
+	return args.Get(0).([]types.Diagnosis), args.Error(1)
+}
+
+func (m *MockDiagnosisStore) UpdateDiagnosis(diagnosis types.Diagnosis) error {
+	args := m.Called(diagnosis)
+	return args.Error(0)
+}
+
+func (m *MockDiagnosisStore) ReportDiagnoses(query types.DiagnosisReportQuery) ([]types.DiagnosisCount, error) {
+	args := m.Called(query)
+	return args.Get(0).([]types.DiagnosisCount), args.Error(1)
+}
+
+func newTestHandler(t *testing.T, store types.DiagnosisStore) *Handler {
+	catalog, err := LoadCatalog("", "")
+	require.NoError(t, err)
+	return NewHandler(store, catalog)
+}
+
+func TestSearchCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := newTestHandler(t, new(MockDiagnosisStore))
+
+	router := gin.Default()
+	router.GET("/codes", handler.SearchCodes)
+
+	search := func(query string) []types.ICDCode {
+		req, _ := http.NewRequest(http.MethodGet, "/codes?"+query, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		var codes []types.ICDCode
+		json.Unmarshal(resp.Body.Bytes(), &codes)
+		return codes
+	}
+
+	// Test case: A code is found with or without its dot, the exact code first
+	codes := search("q=b509")
+	require.NotEmpty(t, codes)
+	require.Equal(t, "B50.9", codes[0].Code)
+	require.Equal(t, ICD10, codes[0].System)
+
+	codes = search("q=B5")
+	require.Equal(t, "B50.9", codes[0].Code)
+
+	// Test case: Descriptions are matched by word prefixes
+	codes = search("q=diab+type+2")
+	require.NotEmpty(t, codes)
+	require.Equal(t, "E11.9", codes[0].Code)
+
+	// Test case: The limit is applied
+	require.Len(t, search("q=unspecified&limit=3"), 3)
+
+	// Test case: Unknown systems are rejected
+	req, _ := http.NewRequest(http.MethodGet, "/codes?q=malaria&system=ICD-9", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestAddDiagnoses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDiagnosisStore)
+	handler := newTestHandler(t, mockStore)
+
+	router := gin.Default()
+	// Stands in for the auth middleware setting the authenticated doctor
+	router.Use(func(c *gin.Context) {
+		auth.SetActor(c, types.Actor{ID: 1, Email: "stan@rfh.com", Role: "doctor"})
+	})
+	router.POST("/", handler.AddDiagnoses)
+
+	// Test case: Codes are completed from the catalog, diagnoses are provisional unless confirmed
+	mockStore.On("AddDiagnoses", 3, []types.Diagnosis{
+		{ICDCode: types.ICDCode{System: ICD10, Code: "B50.9", Description: "Plasmodium falciparum malaria, unspecified"}, Primary: true, Certainty: "confirmed"},
+		{ICDCode: types.ICDCode{System: ICD10, Code: "D64.9", Description: "Anaemia, unspecified"}, Certainty: "provisional"},
+	}, 1).Return(nil).Once()
+
+	body := `{"encounter_id": 3, "diagnoses": [{"code": "b50.9", "primary": true, "certainty": "confirmed"}, {"code": "D64.9"}]}`
+	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: Invalid diagnoses are rejected before anything is saved
+	invalid := []string{
+		`{"encounter_id": 3, "diagnoses": []}`,
+		`{"encounter_id": 3, "diagnoses": [{"code": "XYZ"}]}`,
+		`{"encounter_id": 3, "diagnoses": [{"code": "B54", "certainty": "likely"}]}`,
+		`{"encounter_id": 3, "diagnoses": [{"code": "B54", "primary": true}, {"code": "I10", "primary": true}]}`,
+		`{"encounter_id": 3, "diagnoses": [{"code": "B54"}, {"code": "b54"}]}`,
+	}
+	for _, payload := range invalid {
+		req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, payload)
+	}
+	mockStore.AssertNumberOfCalls(t, "AddDiagnoses", 1)
+
+	// Test case: Encounter does not exist
+	mockStore.On("AddDiagnoses", 4, mock.Anything, 1).Return(fmt.Errorf("encounter does not exist")).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"encounter_id": 4, "diagnoses": [{"code": "I10"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestUpdateDiagnosis(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDiagnosisStore)
+	handler := newTestHandler(t, mockStore)
+
+	router := gin.Default()
+	router.PUT("/:id", handler.UpdateDiagnosis)
+
+	// Test case: A diagnosis is confirmed and made secondary
+	mockStore.On("UpdateDiagnosis", types.Diagnosis{ID: 5, Primary: false, Certainty: "confirmed"}).Return(nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPut, "/5", bytes.NewBufferString(`{"primary": false, "certainty": "confirmed"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: Leaving primary out does not make a primary diagnosis secondary
+	req, _ = http.NewRequest(http.MethodPut, "/5", bytes.NewBufferString(`{"certainty": "confirmed"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	mockStore.AssertNumberOfCalls(t, "UpdateDiagnosis", 1)
+}
+
+func TestReportDiagnoses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDiagnosisStore)
+	handler := newTestHandler(t, mockStore)
+
+	router := gin.Default()
+	router.GET("/report", handler.ReportDiagnoses)
+
+	counts := []types.DiagnosisCount{
+		{ICDCode: types.ICDCode{System: ICD10, Code: "B50.9"}, Diagnoses: 12, Confirmed: 10, Clients: 11},
+	}
+	mockStore.On("ReportDiagnoses", types.DiagnosisReportQuery{
+		From:          time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:            time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		CodePrefix:    "B5",
+		ConfirmedOnly: true,
+	}).Return(counts, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodGet, "/report?from=2024-01-01&to=2024-01-31&code=b5&confirmed=true", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var response []types.DiagnosisCount
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, counts, response)
+}
@@ -0,0 +1,18 @@
+// This file contains the endpoints for the diagnoses service.
+package diagnoses
+
+import (
+	"cema_backend/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	// Nurses can read diagnoses, only doctors code them
+	router.Use(auth.AuthMiddleware())
+	router.GET("/codes", auth.RequirePermission("diagnoses:read"), h.SearchCodes)
+	router.GET("/report", auth.RequirePermission("diagnoses:read"), h.ReportDiagnoses)
+	router.POST("/", auth.RequirePermission("diagnoses:write"), h.AddDiagnoses)
+	router.GET("/", auth.RequirePermission("diagnoses:read"), h.GetDiagnosesByClient)
+	router.PUT("/:id", auth.RequirePermission("diagnoses:write"), h.UpdateDiagnosis)
+}
@@ -0,0 +1,204 @@
+package diagnoses
+
+import (
+	"cema_backend/db"
+	"cema_backend/types"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore initializes a new Store instance with the given database connection.
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// AddDiagnoses attaches coded diagnoses to an encounter, made by the doctor whose id is recordedBy.
+// A code already on the encounter is updated instead of added twice,
+// and a new primary diagnosis makes the previous one secondary.
+func (s *Store) AddDiagnoses(encounterID int, diagnoses []types.Diagnosis, recordedBy int) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM encounters WHERE id = ? FOR UPDATE`, encounterID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("encounter does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve encounter: %w", err)
+	}
+
+	for _, diagnosis := range diagnoses {
+		if diagnosis.Primary {
+			if _, err := tx.ExecContext(ctx, `UPDATE diagnoses SET is_primary = FALSE WHERE encounter_id = ?`, encounterID); err != nil {
+				return fmt.Errorf("failed to update primary diagnosis: %w", err)
+			}
+			break
+		}
+	}
+
+	query := `INSERT INTO diagnoses (encounter_id, doctor_id, code_system, code, description, is_primary, certainty)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE description = VALUES(description), is_primary = VALUES(is_primary), certainty = VALUES(certainty)`
+	for _, diagnosis := range diagnoses {
+		_, err := tx.ExecContext(ctx, query, encounterID, recordedBy, diagnosis.System, diagnosis.Code, diagnosis.Description,
+			diagnosis.Primary, diagnosis.Certainty)
+		if err != nil {
+			return fmt.Errorf("failed to save diagnosis: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit diagnoses: %w", err)
+	}
+	return nil
+}
+
+// GetDiagnosesByClient retrieves the diagnoses of a client, those of the most recent encounter first
+func (s *Store) GetDiagnosesByClient(clientID string) ([]types.Diagnosis, error) {
+	ctx := context.Background()
+
+	var id int
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM clients WHERE uuid = ?`, clientID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("client does not exist")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve client: %w", err)
+	}
+
+	query := `
+		SELECT d.id, d.encounter_id, d.doctor_id, d.code_system, d.code, d.description, d.is_primary, d.certainty, d.diagnosed_at
+		FROM diagnoses d
+		JOIN encounters e ON e.id = d.encounter_id
+		WHERE e.client_id = ?
+		ORDER BY e.encountered_at DESC, d.is_primary DESC, d.id
+	`
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve diagnoses: %w", err)
+	}
+	defer rows.Close()
+
+	diagnoses := []types.Diagnosis{}
+	for rows.Next() {
+		diagnosis := types.Diagnosis{ClientID: clientID}
+		var doctorID sql.NullInt64
+		err := rows.Scan(&diagnosis.ID, &diagnosis.EncounterID, &doctorID, &diagnosis.System, &diagnosis.Code,
+			&diagnosis.Description, &diagnosis.Primary, &diagnosis.Certainty, &diagnosis.DiagnosedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan diagnosis: %w", err)
+		}
+		diagnosis.DoctorID = int(doctorID.Int64)
+		diagnoses = append(diagnoses, diagnosis)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve diagnoses: %w", err)
+	}
+	return diagnoses, nil
+}
+
+// UpdateDiagnosis changes whether a diagnosis is primary and its certainty.
+// Making it primary makes the previous primary diagnosis of the encounter secondary.
+func (s *Store) UpdateDiagnosis(diagnosis types.Diagnosis) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var encounterID int
+	err = tx.QueryRowContext(ctx, `SELECT encounter_id FROM diagnoses WHERE id = ? FOR UPDATE`, diagnosis.ID).Scan(&encounterID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("diagnosis does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve diagnosis: %w", err)
+	}
+
+	if diagnosis.Primary {
+		if _, err := tx.ExecContext(ctx, `UPDATE diagnoses SET is_primary = FALSE WHERE encounter_id = ? AND id <> ?`, encounterID, diagnosis.ID); err != nil {
+			return fmt.Errorf("failed to update primary diagnosis: %w", err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE diagnoses SET is_primary = ?, certainty = ? WHERE id = ?`, diagnosis.Primary, diagnosis.Certainty, diagnosis.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update diagnosis: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit diagnosis update: %w", err)
+	}
+	return nil
+}
+
+// ReportDiagnoses counts the diagnoses made with each code across the facility, the most frequent first.
+// The date range applies to when the encounters took place.
+func (s *Store) ReportDiagnoses(query types.DiagnosisReportQuery) ([]types.DiagnosisCount, error) {
+	ctx := context.Background()
+
+	var conditions []string
+	var args []interface{}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "e.encountered_at >= ?")
+		args = append(args, query.From)
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "e.encountered_at < ?")
+		args = append(args, query.To)
+	}
+	if query.System != "" {
+		conditions = append(conditions, "d.code_system = ?")
+		args = append(args, query.System)
+	}
+	if query.CodePrefix != "" {
+		conditions = append(conditions, "d.code LIKE ?")
+		args = append(args, db.EscapeLike(query.CodePrefix)+"%")
+	}
+	if query.ConfirmedOnly {
+		conditions = append(conditions, "d.certainty = 'confirmed'")
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	report := `
+		SELECT d.code_system, d.code, MAX(d.description), COUNT(*), SUM(d.certainty = 'confirmed'), COUNT(DISTINCT e.client_id)
+		FROM diagnoses d
+		JOIN encounters e ON e.id = d.encounter_id
+		` + where + `
+		GROUP BY d.code_system, d.code
+		ORDER BY COUNT(*) DESC, d.code_system, d.code
+	`
+	rows, err := s.db.QueryContext(ctx, report, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to report diagnoses: %w", err)
+	}
+	defer rows.Close()
+
+	counts := []types.DiagnosisCount{}
+	for rows.Next() {
+		var count types.DiagnosisCount
+		if err := rows.Scan(&count.System, &count.Code, &count.Description, &count.Diagnoses, &count.Confirmed, &count.Clients); err != nil {
+			return nil, fmt.Errorf("failed to scan diagnosis count: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to report diagnoses: %w", err)
+	}
+	return counts, nil
+}
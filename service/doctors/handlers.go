@@ -2,25 +2,41 @@ package doctors
 
 import (
 	"cema_backend/auth"
+	"cema_backend/config"
 	"cema_backend/logging"
 	"cema_backend/types"
+	"encoding/base64"
+	"fmt"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
 )
 
 // Handler struct acts as a bridge between the HTTP layer and the store layer.
+// New passwords have to meet the password policy, reset links are sent with the notifier.
 type Handler struct {
-	store types.DoctorStore
+	store     types.DoctorStore
+	passwords *PasswordPolicy
+	notifier  types.Notifier
 }
 
-// NewHandler initializes a new Handler instance with the given DoctorStore.
-func NewHandler(store types.DoctorStore) *Handler {
-	return &Handler{store: store}
+// NewHandler initializes a new Handler instance with the given DoctorStore, password policy and Notifier.
+func NewHandler(store types.DoctorStore, passwords *PasswordPolicy, notifier types.Notifier) *Handler {
+	return &Handler{store: store, passwords: passwords, notifier: notifier}
 }
 
+// Default and longest time an invitation can be used for, in hours
+const (
+	defaultInvitationHours = 72
+	maxInvitationHours     = 30 * 24
+)
+
 // RegisterDoctors handles the request to register a new doctor.
+// The account waits for an admin's approval unless it is registered with an invitation.
 func (h *Handler) RegisterDoctors(c *gin.Context) {
 	var request types.DoctorRegistration
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -28,27 +44,42 @@ func (h *Handler) RegisterDoctors(c *gin.Context) {
 		return
 	}
 	// Validate the request
-	if request.FirstName == "" || request.LastName == "" || request.PhoneNumber == "" || request.Department == "" || request.Password == "" {
+	if request.FirstName == "" || request.LastName == "" || request.Email == "" || request.PhoneNumber == "" || request.Department == "" || request.Password == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "All fields are required"})
 		return
 	}
+	if err := h.passwords.Check(request.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password: " + err.Error()})
+		return
+	}
 
 	// Register the doctor
-	err := h.store.RegisterDoctors(types.DoctorRegistration{
+	status, err := h.store.RegisterDoctors(types.DoctorRegistration{
 		FirstName:   request.FirstName,
 		LastName:    request.LastName,
 		Email:       request.Email,
 		PhoneNumber: request.PhoneNumber,
 		Department:  request.Department,
 		Password:    request.Password,
+
+		RegistrationNumber: strings.TrimSpace(request.RegistrationNumber),
+		InvitationToken:    strings.TrimSpace(request.InvitationToken),
 	})
 	if err != nil {
+		if err.Error() == "invitation is invalid" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invitation is invalid, expired, already used or for another email"})
+			return
+		}
 		logging.Error("Failed to Register Doctor: " + err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error registering doctor"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Doctor registered successfully"})
+	if status == "pending" {
+		c.JSON(http.StatusOK, gin.H{"message": "Doctor registered successfully, the account can be used once an admin approves it", "status": status})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Doctor registered successfully", "status": status})
 }
 
 // LoginDoctor handles the request to log in a doctor.
@@ -64,24 +95,1091 @@ func (h *Handler) LoginDoctor(c *gin.Context) {
 		return
 	}
 
-	err := h.store.LoginDoctor(request.Email, request.Password)
+	// Failed logins of the account, or from the address, block the next ones for a while.
+	// Emails that do not exist are throttled the same so the response does not tell them apart.
+	email := strings.ToLower(strings.TrimSpace(request.Email))
+	ipAddress := c.ClientIP()
+	if h.loginBlocked(c, email, ipAddress) {
+		return
+	}
+
+	actor, err := h.store.LoginDoctor(request.Email, request.Password)
 	if err != nil {
+		// The status of an account is only told to someone who knows its password
+		if err.Error() == "account is pending approval" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is waiting for an admin's approval"})
+			return
+		}
+		if err.Error() == "account has been rejected" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account registration was rejected"})
+			return
+		}
 		logging.Error("Failed to Login Doctor: " + err.Error())
+		if err.Error() == "invalid email or password" {
+			if err := h.store.RecordLoginFailure(email, ipAddress, loginPolicy()); err != nil {
+				logging.Error("Failed to record failed login: " + err.Error())
+			}
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
+	// Staff with MFA enabled, or whose role requires it, answer a challenge before the session starts
+	mfa, err := h.store.GetMFAStatus(actor.ID)
+	if err != nil {
+		logging.Error("Failed to Get MFA Status: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	if mfa.Enabled || mfa.Required {
+		h.challenge(c, actor.ID, mfa)
+		return
+	}
+
+	h.startSession(c, gin.H{"message": "Doctor logged in successfully"}, actor)
+}
+
+// loginBlocked responds and returns true when the account with the email, or the IP address,
+// is blocked from logging in after failed logins
+func (h *Handler) loginBlocked(c *gin.Context, email, ipAddress string) bool {
+	wait, err := h.store.LoginBlocked(email, ipAddress)
+	if err != nil {
+		logging.Error("Failed to check login throttle: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error logging in"})
+		return true
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("Too many failed logins, try again in %d seconds", wait)})
+		return true
+	}
+	return false
+}
+
+// loginPolicy is how failed logins are throttled, from the configuration
+func loginPolicy() types.LoginPolicy {
+	return types.LoginPolicy{
+		AccountThreshold: config.Envs.LoginLockoutThreshold,
+		IPThreshold:      config.Envs.LoginIPLockoutThreshold,
+		LockoutMinutes:   config.Envs.LoginLockoutMinutes,
+	}
+}
+
+// startSession starts a session for a doctor who logged in with its first refresh token
+// and sends back the tokens along with the rest of the response body.
+// The failed logins of the account are only cleared here, once any MFA challenge has been answered.
+func (h *Handler) startSession(c *gin.Context, body gin.H, actor types.Actor) {
+	if err := h.store.RecordLoginSuccess(actor.ID, strings.ToLower(actor.Email), c.ClientIP()); err != nil {
+		logging.Error("Failed to record login: " + err.Error())
+	}
+
+	refreshToken, refreshHash, err := auth.NewToken()
+	if err != nil {
+		logging.Error("Failed to create refresh token: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	session, err := h.store.CreateSession(types.Session{
+		DoctorID:  actor.ID,
+		UserAgent: truncate(c.Request.UserAgent(), 255),
+		IPAddress: c.ClientIP(),
+		ValidDays: config.Envs.RefreshTokenDays,
+	}, refreshHash)
+	if err != nil {
+		logging.Error("Failed to create session: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	actor.SessionID = session.ID
+
+	sendTokens(c, body, actor, refreshToken)
+}
+
+// RefreshToken handles exchanging a refresh token for a new access token and refresh token.
+// Each refresh token can only be used once, using one again revokes the session.
+func (h *Handler) RefreshToken(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token is required"})
+		return
+	}
+
+	refreshToken, refreshHash, err := auth.NewToken()
+	if err != nil {
+		logging.Error("Failed to create refresh token: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	actor, err := h.store.RefreshSession(auth.HashToken(request.RefreshToken), refreshHash, config.Envs.RefreshTokenDays)
+	if err != nil {
+		switch err.Error() {
+		case "refresh token is invalid", "session has expired":
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token is invalid or the session has ended"})
+		case "refresh token was reused":
+			logging.Error("Refresh token reused, session revoked")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used, the session has been revoked"})
+		default:
+			logging.Error("Failed to refresh session: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		}
+		return
+	}
+
+	sendTokens(c, gin.H{"message": "Token refreshed successfully"}, actor, refreshToken)
+}
+
+// sendTokens sends back a new access token for the actor's session along with its refresh token
+// and the rest of the response body
+func sendTokens(c *gin.Context, body gin.H, actor types.Actor, refreshToken string) {
 	// Generate JWT token
-	secret := []byte(os.Getenv("JWT_SECRET"))
-	token, err := auth.CreateJWT(secret, request.Email)
+	ttl := time.Duration(config.Envs.AccessTokenMinutes) * time.Minute
+	token, err := auth.CreateJWT(actor, ttl)
 	if err != nil {
 		logging.Error("Failed to create JWT token: " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
+	body["token"] = token
+	body["expires_in"] = int(ttl.Seconds())
+	body["refresh_token"] = refreshToken
+	c.JSON(http.StatusOK, body)
+}
+
+// truncate shortens a value to fit a column of the given length
+func truncate(value string, length int) string {
+	if len(value) > length {
+		return value[:length]
+	}
+	return value
+}
+
+// LogoutDoctor handles ending the session of the signed in doctor,
+// its access token and refresh token stop working straight away
+func (h *Handler) LogoutDoctor(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+
+	if err := h.store.RevokeSession(actor.SessionID); err != nil {
+		if err.Error() == "session does not exist" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has already ended"})
+			return
+		}
+		logging.Error("Failed to Logout Doctor: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error logging out"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Doctor logged out successfully"})
+}
+
+// RevokeDoctorSessions handles ending every session of the doctor identified in the path,
+// such as when a device is lost or someone leaves
+func (h *Handler) RevokeDoctorSessions(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid doctor id"})
+		return
+	}
+
+	revoked, err := h.store.RevokeDoctorSessions(id, actor.ID)
+	if err != nil {
+		if err.Error() == "doctor does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not Found"})
+			return
+		}
+		logging.Error("Failed to Revoke Doctor Sessions: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked successfully", "revoked": revoked})
+}
+
+// GetRoles handles listing the roles staff can be assigned with the permissions each grants
+func (h *Handler) GetRoles(c *gin.Context) {
+	roles, err := h.store.GetRoles()
+	if err != nil {
+		logging.Error("Failed to Get Roles: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving roles"})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// SetDoctorRole handles assigning a role to the doctor identified in the path.
+// The new permissions apply from the doctor's next login.
+func (h *Handler) SetDoctorRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid doctor id"})
+		return
+	}
+
+	var request struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	request.Role = strings.ToLower(strings.TrimSpace(request.Role))
+	if request.Role == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role is required"})
+		return
+	}
+
+	err = h.store.SetDoctorRole(id, request.Role)
+	if err != nil {
+		switch err.Error() {
+		case "doctor does not exist":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not Found"})
+		case "role does not exist":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role: " + request.Role})
+		case "cannot remove the last admin":
+			c.JSON(http.StatusConflict, gin.H{"error": "The last admin cannot be given another role"})
+		default:
+			logging.Error("Failed to Set Doctor Role: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error assigning role"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Role assigned successfully"})
+}
+
+// GetPendingDoctors handles listing the registrations waiting for approval
+func (h *Handler) GetPendingDoctors(c *gin.Context) {
+	accounts, err := h.store.GetPendingDoctors()
+	if err != nil {
+		logging.Error("Failed to Get Pending Doctors: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving pending registrations"})
+		return
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+// ApproveDoctor handles approving the pending registration identified in the path,
+// with the role given or doctor by default
+func (h *Handler) ApproveDoctor(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid doctor id"})
+		return
+	}
+
+	var request struct {
+		Role string `json:"role"`
+	}
+	// The body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+			return
+		}
+	}
+	request.Role = strings.ToLower(strings.TrimSpace(request.Role))
+	if request.Role == "" {
+		request.Role = "doctor"
+	}
+
+	err = h.store.ApproveDoctor(id, request.Role, actor.ID)
+	if err != nil {
+		reviewError(c, err, "Failed to Approve Doctor: ", "Error approving registration")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Registration approved successfully"})
+}
+
+// RejectDoctor handles rejecting the pending registration identified in the path with a reason
+func (h *Handler) RejectDoctor(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid doctor id"})
+		return
+	}
+
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to reject a registration"})
+		return
+	}
+
+	err = h.store.RejectDoctor(id, request.Reason, actor.ID)
+	if err != nil {
+		reviewError(c, err, "Failed to Reject Doctor: ", "Error rejecting registration")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Registration rejected successfully"})
+}
+
+// reviewError sends back the error of an approval or rejection
+func reviewError(c *gin.Context, err error, logMessage string, message string) {
+	switch err.Error() {
+	case "doctor does not exist":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not Found"})
+	case "doctor is not pending":
+		c.JSON(http.StatusConflict, gin.H{"error": "Registration has already been reviewed"})
+	case "role does not exist":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+	default:
+		logging.Error(logMessage + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// InviteDoctor handles inviting a member of staff to register with a role without waiting for approval.
+// The signup token is only returned here, it is for the admin to pass on to the person invited.
+func (h *Handler) InviteDoctor(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+
+	var request types.Invitation
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	request.Email = strings.TrimSpace(request.Email)
+	request.Role = strings.ToLower(strings.TrimSpace(request.Role))
+	if request.Email == "" || request.Role == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email and role are required"})
+		return
+	}
+	if request.ValidHours == 0 {
+		request.ValidHours = defaultInvitationHours
+	}
+	if request.ValidHours < 0 || request.ValidHours > maxInvitationHours {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Valid hours must be between 1 and %d", maxInvitationHours)})
+		return
+	}
+
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		logging.Error("Failed to create invitation token: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating invitation"})
+		return
+	}
+
+	invitation, err := h.store.CreateInvitation(types.Invitation{Email: request.Email, Role: request.Role, ValidHours: request.ValidHours}, hash, actor.ID)
+	if err != nil {
+		switch err.Error() {
+		case "role does not exist":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role: " + request.Role})
+		case "email is already registered":
+			c.JSON(http.StatusConflict, gin.H{"error": "A member of staff is already registered with this email"})
+		default:
+			logging.Error("Failed to Create Invitation: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating invitation"})
+		}
+		return
+	}
+	invitation.Token = token
+	c.JSON(http.StatusOK, invitation)
+}
+
+// MFA login challenges are valid for a few minutes, and MFA comes with a set of recovery codes
+const (
+	mfaChallengeMinutes = 5
+	recoveryCodeCount   = 10
+)
+
+// challenge sends a doctor who logged in with their password a challenge to answer with a code from their
+// authenticator app. Doctors whose role requires MFA and who have not set it up enroll with the challenge first.
+func (h *Handler) challenge(c *gin.Context, doctorID int, mfa types.MFAStatus) {
+	token, tokenHash, err := auth.NewToken()
+	if err != nil {
+		logging.Error("Failed to create mfa challenge: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	if err := h.store.CreateMFAChallenge(doctorID, tokenHash, mfaChallengeMinutes); err != nil {
+		logging.Error("Failed to create mfa challenge: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	message := "Enter the code from your authenticator app or a recovery code"
+	if !mfa.Enabled {
+		message = "MFA is required for your role, set up an authenticator app to continue"
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Doctor logged in successfully",
-		"token":   token,
+		"message":         message,
+		"mfa_required":    true,
+		"mfa_enrolled":    mfa.Enabled,
+		"challenge_token": token,
+		"expires_in":      mfaChallengeMinutes * 60,
 	})
 }
+
+// LoginMFA handles answering the MFA challenge of a login with a code from the authenticator app
+// or a recovery code, starting the session. A doctor enrolling during login confirms the authenticator
+// with their first code and gets their recovery codes along with the tokens.
+func (h *Handler) LoginMFA(c *gin.Context) {
+	var request struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	request.Code = strings.TrimSpace(request.Code)
+	if request.ChallengeToken == "" || (request.Code == "" && request.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Challenge token and a code or recovery code are required"})
+		return
+	}
+
+	challengeHash := auth.HashToken(request.ChallengeToken)
+	doctor, ok := h.mfaChallenge(c, challengeHash)
+	if !ok {
+		return
+	}
+
+	// Wrong codes are throttled like wrong passwords, and every code tried uses up one of the attempts
+	// of the challenge before it is checked, so codes sent at once cannot get past the limit
+	email := strings.ToLower(doctor.Email)
+	ipAddress := c.ClientIP()
+	if h.loginBlocked(c, email, ipAddress) {
+		return
+	}
+	if err := h.store.CountMFAAttempt(challengeHash); err != nil {
+		if err.Error() == "mfa challenge is invalid" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "MFA challenge is invalid or has expired, log in again"})
+			return
+		}
+		logging.Error("Failed to record mfa attempt: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	mfa, err := h.store.GetMFAStatus(doctor.ID)
+	if err != nil {
+		logging.Error("Failed to Get MFA Status: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	body := gin.H{"message": "Doctor logged in successfully"}
+	switch {
+	case request.RecoveryCode != "":
+		err = h.store.UseRecoveryCode(doctor.ID, auth.HashRecoveryCode(request.RecoveryCode))
+	case mfa.Enabled:
+		err = h.store.VerifyMFACode(doctor.ID, request.Code)
+	default:
+		codes, hashes, codesErr := auth.NewRecoveryCodes(recoveryCodeCount)
+		if codesErr != nil {
+			logging.Error("Failed to create recovery codes: " + codesErr.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		err = h.store.ConfirmMFAEnrollment(doctor.ID, request.Code, hashes)
+		body["message"] = "MFA enabled and logged in successfully, keep the recovery codes somewhere safe, they are only shown once"
+		body["recovery_codes"] = codes
+	}
+	if err != nil {
+		switch err.Error() {
+		case "mfa code is invalid", "recovery code is invalid":
+			if err := h.store.RecordLoginFailure(email, ipAddress, loginPolicy()); err != nil {
+				logging.Error("Failed to record failed login: " + err.Error())
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		case "mfa enrollment was not started":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Set up an authenticator app with /doctors/login/mfa/enroll first"})
+		default:
+			logging.Error("Failed to verify mfa code: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		}
+		return
+	}
+
+	actor, err := h.store.CompleteMFAChallenge(challengeHash)
+	if err != nil {
+		if err.Error() == "mfa challenge is invalid" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "MFA challenge is invalid or has expired, log in again"})
+			return
+		}
+		logging.Error("Failed to complete mfa challenge: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	h.startSession(c, body, actor)
+}
+
+// LoginMFAEnroll handles setting up an authenticator app during login,
+// for a doctor whose role requires MFA and who has not set it up yet
+func (h *Handler) LoginMFAEnroll(c *gin.Context) {
+	var request struct {
+		ChallengeToken string `json:"challenge_token"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.ChallengeToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Challenge token is required"})
+		return
+	}
+
+	doctor, ok := h.mfaChallenge(c, auth.HashToken(request.ChallengeToken))
+	if !ok {
+		return
+	}
+	h.enroll(c, doctor)
+}
+
+// mfaChallenge retrieves the doctor a login challenge is for, responding when it cannot be answered
+func (h *Handler) mfaChallenge(c *gin.Context, challengeHash string) (types.Actor, bool) {
+	doctor, err := h.store.GetMFAChallenge(challengeHash)
+	if err != nil {
+		if err.Error() == "mfa challenge is invalid" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "MFA challenge is invalid or has expired, log in again"})
+			return doctor, false
+		}
+		logging.Error("Failed to Get MFA Challenge: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving mfa challenge"})
+		return doctor, false
+	}
+	return doctor, true
+}
+
+// enroll starts setting up an authenticator app for a doctor, sending back its secret as an otpauth URI and QR code.
+// MFA is enabled once a first code from the app is verified.
+func (h *Handler) enroll(c *gin.Context, doctor types.Actor) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		logging.Error("Failed to create mfa secret: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error setting up mfa"})
+		return
+	}
+	if err := h.store.StartMFAEnrollment(doctor.ID, secret); err != nil {
+		if err.Error() == "mfa is already enabled" {
+			c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+			return
+		}
+		logging.Error("Failed to Start MFA Enrollment: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error setting up mfa"})
+		return
+	}
+
+	uri := auth.TOTPURI(config.Envs.FacilityName, doctor.Email, secret)
+	qr, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		logging.Error("Failed to render mfa qr code: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error setting up mfa"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Scan the QR code with an authenticator app and verify a code from it to enable MFA",
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr),
+	})
+}
+
+// GetMFAStatus handles telling the signed in doctor whether MFA is enabled and whether their role requires it
+func (h *Handler) GetMFAStatus(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+
+	mfa, err := h.store.GetMFAStatus(actor.ID)
+	if err != nil {
+		logging.Error("Failed to Get MFA Status: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving mfa status"})
+		return
+	}
+	c.JSON(http.StatusOK, mfa)
+}
+
+// EnrollMFA handles the signed in doctor setting up an authenticator app
+func (h *Handler) EnrollMFA(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	h.enroll(c, actor)
+}
+
+// VerifyMFA handles confirming the authenticator app of the signed in doctor with its first code,
+// enabling MFA. The recovery codes are only sent back here.
+func (h *Handler) VerifyMFA(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	code, ok := mfaCode(c)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		logging.Error("Failed to create recovery codes: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enabling mfa"})
+		return
+	}
+	if err := h.store.ConfirmMFAEnrollment(actor.ID, code, hashes); err != nil {
+		switch err.Error() {
+		case "mfa code is invalid":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		case "mfa enrollment was not started":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Set up an authenticator app with /doctors/mfa/enroll first"})
+		case "mfa is already enabled":
+			c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+		default:
+			logging.Error("Failed to Confirm MFA Enrollment: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enabling mfa"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "MFA enabled successfully, keep the recovery codes somewhere safe, they are only shown once",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes handles replacing the recovery codes of the signed in doctor,
+// confirmed with a code from their authenticator app
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	code, ok := mfaCode(c)
+	if !ok {
+		return
+	}
+
+	if err := h.store.VerifyMFACode(actor.ID, code); err != nil {
+		if err.Error() == "mfa code is invalid" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code, or MFA is not enabled"})
+			return
+		}
+		logging.Error("Failed to Verify MFA Code: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error replacing recovery codes"})
+		return
+	}
+	codes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		logging.Error("Failed to create recovery codes: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error replacing recovery codes"})
+		return
+	}
+	if err := h.store.ReplaceRecoveryCodes(actor.ID, hashes); err != nil {
+		logging.Error("Failed to Replace Recovery Codes: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error replacing recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Recovery codes replaced successfully, the old ones no longer work",
+		"recovery_codes": codes,
+	})
+}
+
+// mfaCode reads the code from the authenticator app in the request body
+func mfaCode(c *gin.Context) (string, bool) {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || strings.TrimSpace(request.Code) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return "", false
+	}
+	return strings.TrimSpace(request.Code), true
+}
+
+// SetRoleMFA handles requiring MFA, or not, for everyone with the role identified in the path.
+// It applies from their next login, staff without MFA are signed out at their next token refresh.
+func (h *Handler) SetRoleMFA(c *gin.Context) {
+	var request struct {
+		Required *bool `json:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.Required == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Required must be true or false"})
+		return
+	}
+
+	if err := h.store.SetRoleMFA(c.Param("name"), *request.Required); err != nil {
+		if err.Error() == "role does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not Found"})
+			return
+		}
+		logging.Error("Failed to Set Role MFA: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating role"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated successfully", "mfa_required": *request.Required})
+}
+
+// Number of security events listed by default and at most
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 500
+)
+
+// UnlockDoctor handles clearing the failed logins of the doctor identified in the path,
+// so an account that was locked out can log in straight away
+func (h *Handler) UnlockDoctor(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid doctor id"})
+		return
+	}
+
+	if err := h.store.UnlockDoctor(id, actor.ID); err != nil {
+		if err.Error() == "doctor does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not Found"})
+			return
+		}
+		logging.Error("Failed to Unlock Doctor: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unlocking account"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked successfully"})
+}
+
+// ResetMFA handles turning off the MFA of a doctor who lost their authenticator and recovery codes.
+// They are signed out everywhere and set MFA up again at their next login when their role requires it.
+func (h *Handler) ResetMFA(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid doctor id"})
+		return
+	}
+
+	revoked, err := h.store.ResetMFA(id, actor.ID)
+	if err != nil {
+		if err.Error() == "doctor does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not Found"})
+			return
+		}
+		logging.Error("Failed to Reset MFA: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting MFA"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "MFA reset successfully", "revoked": revoked})
+}
+
+// GetSecurityEvents handles listing the most recent lockouts, suspicious logins, unlocks and MFA resets,
+// of one kind with kind set
+func (h *Handler) GetSecurityEvents(c *gin.Context) {
+	kind := c.Query("kind")
+	switch kind {
+	case "", "lockout", "ip_lockout", "suspicious_login", "unlock", "mfa_reset":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid kind, expected lockout, ip_lockout, suspicious_login, unlock or mfa_reset"})
+		return
+	}
+	limit := defaultEventLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxEventLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	events, err := h.store.GetSecurityEvents(kind, limit)
+	if err != nil {
+		logging.Error("Failed to Get Security Events: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving security events"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// Password reset tokens are valid for half an hour
+const resetTokenMinutes = 30
+
+// ChangePassword handles the signed in doctor changing their password, confirmed with the current one.
+// Their other sessions end. Wrong current passwords count as failed logins.
+func (h *Handler) ChangePassword(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.CurrentPassword == "" || request.NewPassword == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password and new password are required"})
+		return
+	}
+	if err := h.passwords.Check(request.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password: " + err.Error()})
+		return
+	}
+
+	email := strings.ToLower(actor.Email)
+	ipAddress := c.ClientIP()
+	wait, err := h.store.LoginBlocked(email, ipAddress)
+	if err != nil {
+		logging.Error("Failed to check login throttle: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error changing password"})
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("Too many failed logins, try again in %d seconds", wait)})
+		return
+	}
+
+	err = h.store.ChangePassword(actor.ID, request.CurrentPassword, request.NewPassword, h.passwords.History, actor.SessionID)
+	if err != nil {
+		switch err.Error() {
+		case "current password is incorrect":
+			if err := h.store.RecordLoginFailure(email, ipAddress, loginPolicy()); err != nil {
+				logging.Error("Failed to record failed login: " + err.Error())
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+		case "password was used recently":
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid password: it cannot be any of your last %d passwords", h.passwords.History)})
+		case "doctor does not exist":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not Found"})
+		default:
+			logging.Error("Failed to Change Password: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error changing password"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully, your other sessions have been signed out"})
+}
+
+// ForgotPassword handles sending a password reset link to the email of an account.
+// The response is the same whether or not the email is registered.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || strings.TrimSpace(request.Email) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+	const sent = "If the email belongs to an active account, a password reset link has been sent to it"
+
+	token, tokenHash, err := auth.NewToken()
+	if err != nil {
+		logging.Error("Failed to create reset token: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error sending password reset"})
+		return
+	}
+	account, err := h.store.CreatePasswordReset(strings.ToLower(strings.TrimSpace(request.Email)), tokenHash, resetTokenMinutes)
+	if err != nil {
+		if err.Error() == "doctor does not exist" {
+			c.JSON(http.StatusOK, gin.H{"message": sent})
+			return
+		}
+		logging.Error("Failed to Create Password Reset: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error sending password reset"})
+		return
+	}
+
+	err = h.notifier.Notify(types.Notification{
+		To:      account.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nUse this link within %d minutes to reset your password:\n%s%s\n\n"+
+			"If you did not ask to reset your password, ignore this message.",
+			account.FirstName, resetTokenMinutes, config.Envs.PasswordResetURL, token),
+	})
+	if err != nil {
+		// Not telling the requester keeps the response the same as for an unknown email
+		logging.Error("Failed to send password reset: " + err.Error())
+	}
+	c.JSON(http.StatusOK, gin.H{"message": sent})
+}
+
+// ResetPassword handles setting a new password with the token of a reset link.
+// All the doctor's sessions end and a lockout of the account is cleared.
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.Token == "" || request.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token and password are required"})
+		return
+	}
+	if err := h.passwords.Check(request.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password: " + err.Error()})
+		return
+	}
+
+	if err := h.store.ResetPassword(auth.HashToken(request.Token), request.Password, h.passwords.History); err != nil {
+		switch err.Error() {
+		case "reset token is invalid":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is invalid, expired or already used"})
+		case "password was used recently":
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid password: it cannot be any of your last %d passwords", h.passwords.History)})
+		default:
+			logging.Error("Failed to Reset Password: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting password"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully, log in with the new password"})
+}
+
+// Longest time an API key can be used for in days, most entries of its IP allowlist and longest name
+const (
+	maxAPIKeyDays = 5 * 365
+	maxAllowedIPs = 20
+	maxAPIKeyName = 100
+)
+
+// adminPermissions are never granted to API keys, administration stays with staff who log in
+var adminPermissions = map[string]bool{"roles:manage": true, "staff:manage": true, "apikeys:manage": true}
+
+// CreateAPIKey handles creating an API key for an integration with the permissions it needs.
+// An admin can only grant permissions they have. The key is only returned here.
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+
+	var request types.APIKey
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Permissions) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and permissions are required"})
+		return
+	}
+	if len(request.Name) > maxAPIKeyName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Name must be at most %d characters", maxAPIKeyName)})
+		return
+	}
+	if request.ValidDays < 0 || request.ValidDays > maxAPIKeyDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Valid days must be between 1 and %d, or 0 for a key that does not expire", maxAPIKeyDays)})
+		return
+	}
+
+	permissions := []string{}
+	seen := map[string]bool{}
+	for _, permission := range request.Permissions {
+		permission = strings.TrimSpace(permission)
+		if permission == "" || seen[permission] {
+			continue
+		}
+		seen[permission] = true
+		if adminPermissions[permission] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "API keys cannot be granted " + permission})
+			return
+		}
+		if !auth.HasPermission(actor, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied: you cannot grant " + permission})
+			return
+		}
+		permissions = append(permissions, permission)
+	}
+
+	allowedIPs := []string{}
+	for _, entry := range request.AllowedIPs {
+		entry = strings.TrimSpace(entry)
+		if !auth.ValidAllowedIP(entry) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address or CIDR range: " + entry})
+			return
+		}
+		allowedIPs = append(allowedIPs, entry)
+	}
+	if len(allowedIPs) > maxAllowedIPs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d IP addresses or ranges can be allowed", maxAllowedIPs)})
+		return
+	}
+
+	key, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		logging.Error("Failed to create API key: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating API key"})
+		return
+	}
+
+	apiKey, err := h.store.CreateAPIKey(types.APIKey{
+		Name:        request.Name,
+		Prefix:      prefix,
+		Permissions: permissions,
+		AllowedIPs:  allowedIPs,
+		ValidDays:   request.ValidDays,
+	}, hash, actor.ID)
+	if err != nil {
+		if err.Error() == "permission does not exist" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown permission"})
+			return
+		}
+		logging.Error("Failed to Create API Key: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating API key"})
+		return
+	}
+	apiKey.Key = key
+	c.JSON(http.StatusOK, apiKey)
+}
+
+// GetAPIKeys handles listing the API keys with when and from where each was last used
+func (h *Handler) GetAPIKeys(c *gin.Context) {
+	apiKeys, err := h.store.GetAPIKeys()
+	if err != nil {
+		logging.Error("Failed to Get API Keys: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, apiKeys)
+}
+
+// RevokeAPIKey handles revoking the API key identified in the path, it is refused from the next request
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key id"})
+		return
+	}
+
+	if err := h.store.RevokeAPIKey(id, actor.ID); err != nil {
+		switch err.Error() {
+		case "api key does not exist":
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not Found"})
+		case "api key is already revoked":
+			c.JSON(http.StatusConflict, gin.H{"error": "API key has already been revoked"})
+		default:
+			logging.Error("Failed to Revoke API Key: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking API key"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
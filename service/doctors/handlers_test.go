@@ -2,10 +2,14 @@ package doctors
 
 import (
 	"bytes"
+	"cema_backend/auth"
+	"cema_backend/logging"
 	"cema_backend/types"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -18,27 +22,239 @@ type MockDoctorStore struct {
 	mock.Mock
 }
 
-func (m *MockDoctorStore) RegisterDoctors(doctor types.DoctorRegistration) error {
+func (m *MockDoctorStore) RegisterDoctors(doctor types.DoctorRegistration) (string, error) {
 	args := m.Called(doctor)
-	return args.Error(0)
+	return args.String(0), args.Error(1)
 }
 
-func (m *MockDoctorStore) LoginDoctor(email, password string) error {
+func (m *MockDoctorStore) LoginDoctor(email, password string) (types.Actor, error) {
 	args := m.Called(email, password)
+	return args.Get(0).(types.Actor), args.Error(1)
+}
+
+func (m *MockDoctorStore) GetRoles() ([]types.Role, error) {
+	args := m.Called()
+	return args.Get(0).([]types.Role), args.Error(1)
+}
+
+func (m *MockDoctorStore) SetDoctorRole(doctorID int, role string) error {
+	args := m.Called(doctorID, role)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) GetPendingDoctors() ([]types.StaffAccount, error) {
+	args := m.Called()
+	return args.Get(0).([]types.StaffAccount), args.Error(1)
+}
+
+func (m *MockDoctorStore) ApproveDoctor(doctorID int, role string, approvedBy int) error {
+	args := m.Called(doctorID, role, approvedBy)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) RejectDoctor(doctorID int, reason string, rejectedBy int) error {
+	args := m.Called(doctorID, reason, rejectedBy)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) CreateInvitation(invitation types.Invitation, tokenHash string, invitedBy int) (types.Invitation, error) {
+	args := m.Called(invitation, tokenHash, invitedBy)
+	return args.Get(0).(types.Invitation), args.Error(1)
+}
+
+func (m *MockDoctorStore) CreateSession(session types.Session, refreshTokenHash string) (types.Session, error) {
+	args := m.Called(session, refreshTokenHash)
+	return args.Get(0).(types.Session), args.Error(1)
+}
+
+func (m *MockDoctorStore) RefreshSession(refreshTokenHash string, newRefreshTokenHash string, validDays int) (types.Actor, error) {
+	args := m.Called(refreshTokenHash, newRefreshTokenHash, validDays)
+	return args.Get(0).(types.Actor), args.Error(1)
+}
+
+func (m *MockDoctorStore) RevokeSession(sessionID string) error {
+	args := m.Called(sessionID)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) RevokeDoctorSessions(doctorID int, revokedBy int) (int, error) {
+	args := m.Called(doctorID, revokedBy)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDoctorStore) SessionActive(sessionID string) (bool, error) {
+	args := m.Called(sessionID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDoctorStore) GetMFAStatus(doctorID int) (types.MFAStatus, error) {
+	args := m.Called(doctorID)
+	return args.Get(0).(types.MFAStatus), args.Error(1)
+}
+
+func (m *MockDoctorStore) StartMFAEnrollment(doctorID int, secret string) error {
+	args := m.Called(doctorID, secret)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) ConfirmMFAEnrollment(doctorID int, code string, recoveryCodeHashes []string) error {
+	args := m.Called(doctorID, code, recoveryCodeHashes)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) VerifyMFACode(doctorID int, code string) error {
+	args := m.Called(doctorID, code)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) UseRecoveryCode(doctorID int, codeHash string) error {
+	args := m.Called(doctorID, codeHash)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) ReplaceRecoveryCodes(doctorID int, recoveryCodeHashes []string) error {
+	args := m.Called(doctorID, recoveryCodeHashes)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) CreateMFAChallenge(doctorID int, tokenHash string, validMinutes int) error {
+	args := m.Called(doctorID, tokenHash, validMinutes)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) GetMFAChallenge(tokenHash string) (types.Actor, error) {
+	args := m.Called(tokenHash)
+	return args.Get(0).(types.Actor), args.Error(1)
+}
+
+func (m *MockDoctorStore) CountMFAAttempt(tokenHash string) error {
+	args := m.Called(tokenHash)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) CompleteMFAChallenge(tokenHash string) (types.Actor, error) {
+	args := m.Called(tokenHash)
+	return args.Get(0).(types.Actor), args.Error(1)
+}
+
+func (m *MockDoctorStore) SetRoleMFA(role string, required bool) error {
+	args := m.Called(role, required)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) LoginBlocked(email, ipAddress string) (int, error) {
+	args := m.Called(email, ipAddress)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDoctorStore) RecordLoginFailure(email, ipAddress string, policy types.LoginPolicy) error {
+	args := m.Called(email, ipAddress, policy)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) RecordLoginSuccess(doctorID int, email, ipAddress string) error {
+	args := m.Called(doctorID, email, ipAddress)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) ChangePassword(doctorID int, currentPassword, newPassword string, history int, keepSessionID string) error {
+	args := m.Called(doctorID, currentPassword, newPassword, history, keepSessionID)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) CreatePasswordReset(email, tokenHash string, validMinutes int) (types.StaffAccount, error) {
+	args := m.Called(email, tokenHash, validMinutes)
+	return args.Get(0).(types.StaffAccount), args.Error(1)
+}
+
+func (m *MockDoctorStore) ResetPassword(tokenHash, newPassword string, history int) error {
+	args := m.Called(tokenHash, newPassword, history)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) UnlockDoctor(doctorID int, unlockedBy int) error {
+	args := m.Called(doctorID, unlockedBy)
 	return args.Error(0)
 }
 
+func (m *MockDoctorStore) ResetMFA(doctorID int, resetBy int) (int, error) {
+	args := m.Called(doctorID, resetBy)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDoctorStore) GetSecurityEvents(kind string, limit int) ([]types.SecurityEvent, error) {
+	args := m.Called(kind, limit)
+	return args.Get(0).([]types.SecurityEvent), args.Error(1)
+}
+
+func (m *MockDoctorStore) CreateAPIKey(apiKey types.APIKey, keyHash string, createdBy int) (types.APIKey, error) {
+	args := m.Called(apiKey, keyHash, createdBy)
+	return args.Get(0).(types.APIKey), args.Error(1)
+}
+
+func (m *MockDoctorStore) GetAPIKeys() ([]types.APIKey, error) {
+	args := m.Called()
+	return args.Get(0).([]types.APIKey), args.Error(1)
+}
+
+func (m *MockDoctorStore) RevokeAPIKey(apiKeyID int, revokedBy int) error {
+	args := m.Called(apiKeyID, revokedBy)
+	return args.Error(0)
+}
+
+func (m *MockDoctorStore) AuthenticateAPIKey(keyHash string) (types.APIKey, types.Actor, error) {
+	args := m.Called(keyHash)
+	return args.Get(0).(types.APIKey), args.Get(1).(types.Actor), args.Error(2)
+}
+
+func (m *MockDoctorStore) MarkAPIKeyUsed(apiKeyID int, ipAddress string) error {
+	args := m.Called(apiKeyID, ipAddress)
+	return args.Error(0)
+}
+
+// MockNotifier records the notifications sent
+type MockNotifier struct {
+	mock.Mock
+}
+
+func (m *MockNotifier) Notify(notification types.Notification) error {
+	args := m.Called(notification)
+	return args.Error(0)
+}
+
+// policy is the password policy of the tests, with the bundled blocklist
+var policy, _ = LoadPasswordPolicy(10, 5, "")
+
+// The tokens of the tests are signed with a test secret
+func init() {
+	keys, err := auth.LoadKeys(auth.KeyConfig{Issuer: "cema_backend", Audience: "cema_backend", KeyID: "test", Secret: "a-test-secret-of-at-least-32-bytes"})
+	if err != nil {
+		panic(err)
+	}
+	auth.UseKeys(keys)
+}
+
+// admin is the authenticated admin of the tests
+var admin = types.Actor{ID: 1, Email: "admin@rfh.com", Role: "admin"}
+
+// signedIn stands in for the auth middleware setting the authenticated doctor
+func signedIn(actor types.Actor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth.SetActor(c, actor)
+	}
+}
+
 func TestRegisterDoctors(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	mockStore := new(MockDoctorStore)
-	handler := NewHandler(mockStore)
+	handler := NewHandler(mockStore, policy, nil)
 
 	router := gin.Default()
 	router.POST("/register", handler.RegisterDoctors)
 
 	// Test case: Successful registration
-	mockStore.On("RegisterDoctors", mock.Anything).Return(nil)
+	mockStore.On("RegisterDoctors", mock.Anything).Return("pending", nil).Once()
 
 	payload := types.DoctorRegistration{
 		FirstName:   "John",
@@ -46,7 +262,7 @@ func TestRegisterDoctors(t *testing.T) {
 		Email:       "john.doe@example.com",
 		PhoneNumber: "1234567890",
 		Department:  "Cardiology",
-		Password:    "password123",
+		Password:    "cardiology-ward-7",
 	}
 	body, _ := json.Marshal(payload)
 
@@ -57,20 +273,56 @@ func TestRegisterDoctors(t *testing.T) {
 	router.ServeHTTP(resp, req)
 
 	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), `"status":"pending"`)
 	mockStore.AssertCalled(t, "RegisterDoctors", payload)
+
+	// Test case: An invitation that cannot be used is rejected
+	payload.InvitationToken = "expired-token"
+	mockStore.On("RegisterDoctors", payload).Return("", errors.New("invitation is invalid")).Once()
+	body, _ = json.Marshal(payload)
+
+	req, _ = http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Test case: Passwords that are too short or too common are refused before anything is saved
+	for _, password := range []string{"short", "password123", "QWERTYUIOP"} {
+		payload.Password = password
+		body, _ = json.Marshal(payload)
+
+		req, _ = http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp = httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, password)
+		require.Contains(t, resp.Body.String(), "Invalid password")
+	}
+	mockStore.AssertNumberOfCalls(t, "RegisterDoctors", 2)
 }
 
 func TestLoginDoctor(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	mockStore := new(MockDoctorStore)
-	handler := NewHandler(mockStore)
+	handler := NewHandler(mockStore, policy, nil)
 
 	router := gin.Default()
 	router.POST("/login", handler.LoginDoctor)
 
-	// Test case: Successful login
-	mockStore.On("LoginDoctor", "john.doe@example.com", "password123").Return(nil)
+	// Test case: Successful login starts a session with a refresh token
+	mockStore.On("LoginDoctor", "john.doe@example.com", "password123").Return(types.Actor{ID: 1, Email: "john.doe@example.com", Role: "doctor"}, nil)
+	mockStore.On("LoginBlocked", mock.Anything, mock.Anything).Return(0, nil)
+	mockStore.On("RecordLoginSuccess", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockStore.On("GetMFAStatus", 1).Return(types.MFAStatus{}, nil)
+	mockStore.On("CreateSession", mock.MatchedBy(func(session types.Session) bool {
+		return session.DoctorID == 1 && session.ValidDays > 0
+	}), mock.Anything).Return(types.Session{ID: "0d9a6c1e-1b2f-4c3d-8e4f-5a6b7c8d9e0f", DoctorID: 1}, nil).Once()
 
 	payload := types.DocLogInRequest{
 		Email:    "john.doe@example.com",
@@ -86,4 +338,745 @@ func TestLoginDoctor(t *testing.T) {
 
 	require.Equal(t, http.StatusOK, resp.Code)
 	mockStore.AssertCalled(t, "LoginDoctor", "john.doe@example.com", "password123")
+	var tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &tokens)
+	require.NotEmpty(t, tokens.Token)
+	require.NotEmpty(t, tokens.RefreshToken)
+	// Only the hash of the refresh token is saved
+	mockStore.AssertCalled(t, "CreateSession", mock.Anything, auth.HashToken(tokens.RefreshToken))
+
+	// Test case: Accounts waiting for approval do not get a token
+	mockStore.On("LoginDoctor", "jane.doe@example.com", "password123").Return(types.Actor{}, errors.New("account is pending approval"))
+
+	body, _ = json.Marshal(types.DocLogInRequest{Email: "jane.doe@example.com", Password: "password123"})
+	req, _ = http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusForbidden, resp.Code)
+	require.NotContains(t, resp.Body.String(), "token")
+}
+
+func TestSetDoctorRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	router.PUT("/:id/role", handler.SetDoctorRole)
+
+	// Test case: The role is assigned, normalised to lower case
+	mockStore.On("SetDoctorRole", 3, "pharmacist").Return(nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPut, "/3/role", bytes.NewBufferString(`{"role": " Pharmacist "}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: Unknown roles are rejected
+	mockStore.On("SetDoctorRole", 3, "surgeon").Return(errors.New("role does not exist")).Once()
+
+	req, _ = http.NewRequest(http.MethodPut, "/3/role", bytes.NewBufferString(`{"role": "surgeon"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Test case: The last admin keeps their role
+	mockStore.On("SetDoctorRole", 1, "doctor").Return(errors.New("cannot remove the last admin")).Once()
+
+	req, _ = http.NewRequest(http.MethodPut, "/1/role", bytes.NewBufferString(`{"role": "doctor"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusConflict, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestReviewRegistrations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	router.Use(signedIn(admin))
+	router.POST("/:id/approve", handler.ApproveDoctor)
+	router.POST("/:id/reject", handler.RejectDoctor)
+
+	// Test case: Approving without a role makes the account a doctor
+	mockStore.On("ApproveDoctor", 5, "doctor", admin.ID).Return(nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/5/approve", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: A registration is only reviewed once
+	mockStore.On("ApproveDoctor", 5, "nurse", admin.ID).Return(errors.New("doctor is not pending")).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/5/approve", bytes.NewBufferString(`{"role": "nurse"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusConflict, resp.Code)
+
+	// Test case: A rejection needs a reason
+	req, _ = http.NewRequest(http.MethodPost, "/6/reject", bytes.NewBufferString(`{"reason": " "}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	mockStore.On("RejectDoctor", 6, "Not on the staff list", admin.ID).Return(nil).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/6/reject", bytes.NewBufferString(`{"reason": "Not on the staff list"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestInviteDoctor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	router.Use(signedIn(admin))
+	router.POST("/invitations", handler.InviteDoctor)
+
+	// Test case: The token is returned once, only its hash is saved
+	var savedHash string
+	mockStore.On("CreateInvitation", types.Invitation{Email: "nurse@rfh.com", Role: "nurse", ValidHours: 72}, mock.Anything, admin.ID).
+		Run(func(args mock.Arguments) { savedHash = args.String(1) }).
+		Return(types.Invitation{ID: 3, Email: "nurse@rfh.com", Role: "nurse", ValidHours: 72}, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/invitations", bytes.NewBufferString(`{"email": "nurse@rfh.com", "role": "Nurse"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var invitation types.Invitation
+	json.Unmarshal(resp.Body.Bytes(), &invitation)
+	require.NotEmpty(t, invitation.Token)
+	require.Equal(t, auth.HashToken(invitation.Token), savedHash)
+	require.NotEqual(t, invitation.Token, savedHash)
+
+	// Test case: Invitations cannot be valid for longer than 30 days
+	req, _ = http.NewRequest(http.MethodPost, "/invitations", bytes.NewBufferString(`{"email": "nurse@rfh.com", "role": "nurse", "valid_hours": 1000}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestRefreshToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// A reused refresh token is logged
+	logging.Initialize()
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	router.POST("/refresh", handler.RefreshToken)
+
+	// Test case: The refresh token is exchanged for a new pair
+	mockStore.On("RefreshSession", auth.HashToken("current-token"), mock.Anything, 7).
+		Return(types.Actor{ID: 1, Email: "john.doe@example.com", Role: "nurse", SessionID: "0d9a6c1e-1b2f-4c3d-8e4f-5a6b7c8d9e0f"}, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/refresh", bytes.NewBufferString(`{"refresh_token": "current-token"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var tokens struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &tokens)
+	require.NotEqual(t, "current-token", tokens.RefreshToken)
+
+	// Test case: A refresh token used a second time is refused
+	mockStore.On("RefreshSession", auth.HashToken("current-token"), mock.Anything, 7).Return(types.Actor{}, errors.New("refresh token was reused")).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/refresh", bytes.NewBufferString(`{"refresh_token": "current-token"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestLogoutDoctor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	router.Use(signedIn(types.Actor{ID: 2, Email: "stan@rfh.com", Role: "doctor", SessionID: "5e4d3c2b-1a09-4f8e-9d7c-6b5a4f3e2d1c"}))
+	router.POST("/logout", handler.LogoutDoctor)
+	router.POST("/:id/revoke-sessions", handler.RevokeDoctorSessions)
+
+	// Test case: Logging out revokes the session of the token
+	mockStore.On("RevokeSession", "5e4d3c2b-1a09-4f8e-9d7c-6b5a4f3e2d1c").Return(nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/logout", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: All the sessions of a doctor are revoked
+	mockStore.On("RevokeDoctorSessions", 3, 2).Return(2, nil).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/3/revoke-sessions", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), `"revoked":2`)
+	mockStore.AssertExpectations(t)
+}
+
+func TestLoginMFA(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// A wrong code is logged
+	logging.Initialize()
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	router.POST("/login", handler.LoginDoctor)
+	router.POST("/login/mfa", handler.LoginMFA)
+
+	nurse := types.Actor{ID: 4, Email: "nurse@rfh.com", Role: "nurse"}
+
+	// Test case: A doctor with MFA enabled gets a challenge instead of a token
+	mockStore.On("LoginBlocked", "nurse@rfh.com", mock.Anything).Return(0, nil)
+	mockStore.On("RecordLoginSuccess", 4, "nurse@rfh.com", mock.Anything).Return(nil)
+	mockStore.On("LoginDoctor", "nurse@rfh.com", "password123").Return(nurse, nil)
+	mockStore.On("GetMFAStatus", 4).Return(types.MFAStatus{Enabled: true}, nil)
+	mockStore.On("CreateMFAChallenge", 4, mock.Anything, 5).Return(nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(`{"email": "nurse@rfh.com", "password": "password123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var challenge struct {
+		Token          string `json:"token"`
+		ChallengeToken string `json:"challenge_token"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &challenge)
+	require.Empty(t, challenge.Token)
+	require.NotEmpty(t, challenge.ChallengeToken)
+	challengeHash := auth.HashToken(challenge.ChallengeToken)
+	mockStore.AssertCalled(t, "CreateMFAChallenge", 4, challengeHash, 5)
+	// The failed logins of the account are not cleared by the password alone
+	mockStore.AssertNotCalled(t, "RecordLoginSuccess", mock.Anything, mock.Anything, mock.Anything)
+	mockStore.On("GetMFAChallenge", challengeHash).Return(nurse, nil)
+
+	// Test case: A wrong code uses up an attempt and counts as a failed login of the account and the address
+	mockStore.On("CountMFAAttempt", challengeHash).Return(nil).Once()
+	mockStore.On("VerifyMFACode", 4, "000000").Return(errors.New("mfa code is invalid")).Once()
+	mockStore.On("RecordLoginFailure", "nurse@rfh.com", mock.Anything, mock.Anything).Return(nil).Once()
+
+	body, _ := json.Marshal(map[string]string{"challenge_token": challenge.ChallengeToken, "code": "000000"})
+	req, _ = http.NewRequest(http.MethodPost, "/login/mfa", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// Test case: No code is checked once the attempts of the challenge are used up
+	mockStore.On("CountMFAAttempt", challengeHash).Return(errors.New("mfa challenge is invalid")).Once()
+
+	body, _ = json.Marshal(map[string]string{"challenge_token": challenge.ChallengeToken, "code": "123456"})
+	req, _ = http.NewRequest(http.MethodPost, "/login/mfa", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+	mockStore.AssertNotCalled(t, "VerifyMFACode", 4, "123456")
+
+	// Test case: The right code starts the session and clears the failed logins
+	mockStore.On("CountMFAAttempt", challengeHash).Return(nil).Once()
+	mockStore.On("VerifyMFACode", 4, "123456").Return(nil).Once()
+	mockStore.On("CompleteMFAChallenge", challengeHash).Return(nurse, nil).Once()
+	mockStore.On("CreateSession", mock.Anything, mock.Anything).Return(types.Session{ID: "9f8e7d6c-5b4a-4392-8170-6f5e4d3c2b1a", DoctorID: 4}, nil).Once()
+
+	body, _ = json.Marshal(map[string]string{"challenge_token": challenge.ChallengeToken, "code": "123456"})
+	req, _ = http.NewRequest(http.MethodPost, "/login/mfa", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), "refresh_token")
+
+	mockStore.AssertCalled(t, "RecordLoginSuccess", 4, "nurse@rfh.com", mock.Anything)
+
+	// Test case: A recovery code is looked up by its hash
+	mockStore.On("GetMFAChallenge", auth.HashToken("second-challenge")).Return(nurse, nil)
+	mockStore.On("CountMFAAttempt", auth.HashToken("second-challenge")).Return(nil).Once()
+	mockStore.On("UseRecoveryCode", 4, auth.HashRecoveryCode("abcdefgh-ijklmnop")).Return(nil).Once()
+	mockStore.On("CompleteMFAChallenge", auth.HashToken("second-challenge")).Return(nurse, nil).Once()
+	mockStore.On("CreateSession", mock.Anything, mock.Anything).Return(types.Session{ID: "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d", DoctorID: 4}, nil).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/login/mfa", bytes.NewBufferString(`{"challenge_token": "second-challenge", "recovery_code": "ABCDEFGH IJKLMNOP"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: An expired challenge is refused
+	mockStore.On("GetMFAChallenge", auth.HashToken("expired-challenge")).Return(types.Actor{}, errors.New("mfa challenge is invalid"))
+
+	req, _ = http.NewRequest(http.MethodPost, "/login/mfa", bytes.NewBufferString(`{"challenge_token": "expired-challenge", "code": "123456"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestEnrollMFA(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	router.Use(signedIn(admin))
+	router.POST("/mfa/enroll", handler.EnrollMFA)
+	router.POST("/mfa/verify", handler.VerifyMFA)
+	router.PUT("/roles/:name/mfa", handler.SetRoleMFA)
+
+	// Test case: Enrolling sends back the secret as an otpauth URI and a QR code
+	mockStore.On("StartMFAEnrollment", 1, mock.Anything).Return(nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/mfa/enroll", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var enrollment struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+		QRCode     string `json:"qr_code"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &enrollment)
+	require.Contains(t, enrollment.OTPAuthURI, "otpauth://totp/")
+	require.Contains(t, enrollment.OTPAuthURI, "secret="+enrollment.Secret)
+	require.Contains(t, enrollment.QRCode, "data:image/png;base64,")
+	mockStore.AssertCalled(t, "StartMFAEnrollment", 1, enrollment.Secret)
+
+	// Test case: The first code enables MFA and sends back the recovery codes, only their hashes are saved
+	var savedHashes []string
+	mockStore.On("ConfirmMFAEnrollment", 1, "123456", mock.Anything).Run(func(args mock.Arguments) {
+		savedHashes = args.Get(2).([]string)
+	}).Return(nil).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/mfa/verify", bytes.NewBufferString(`{"code": "123456"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var verified struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &verified)
+	require.Len(t, verified.RecoveryCodes, 10)
+	require.Equal(t, auth.HashRecoveryCode(verified.RecoveryCodes[0]), savedHashes[0])
+
+	// Test case: Requiring MFA for an unknown role
+	mockStore.On("SetRoleMFA", "surgeon", true).Return(errors.New("role does not exist")).Once()
+
+	req, _ = http.NewRequest(http.MethodPut, "/roles/surgeon/mfa", bytes.NewBufferString(`{"required": true}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestLoginThrottle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// Failed logins are logged
+	logging.Initialize()
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	require.NoError(t, auth.TrustProxies(router, ""))
+	router.POST("/login", handler.LoginDoctor)
+
+	login := func(email string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(types.DocLogInRequest{Email: email, Password: "wrong-password"})
+		req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		// A forged header does not move the attempt to another address
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		req.RemoteAddr = "10.0.0.7:51234"
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// Test case: A wrong password is counted against the account and the address
+	mockStore.On("LoginBlocked", "stan@rfh.com", "10.0.0.7").Return(0, nil).Once()
+	mockStore.On("LoginDoctor", "Stan@rfh.com", "wrong-password").Return(types.Actor{}, errors.New("invalid email or password")).Once()
+	mockStore.On("RecordLoginFailure", "stan@rfh.com", "10.0.0.7", types.LoginPolicy{AccountThreshold: 5, IPThreshold: 20, LockoutMinutes: 15}).Return(nil).Once()
+
+	resp := login("Stan@rfh.com")
+
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// Test case: A blocked login is turned away before the password is checked
+	mockStore.On("LoginBlocked", "stan@rfh.com", "10.0.0.7").Return(900, nil).Once()
+
+	resp = login("stan@rfh.com")
+
+	require.Equal(t, http.StatusTooManyRequests, resp.Code)
+	require.Equal(t, "900", resp.Header().Get("Retry-After"))
+	mockStore.AssertNumberOfCalls(t, "LoginDoctor", 1)
+
+	// Test case: An email that does not exist fails the same as a wrong password
+	mockStore.On("LoginBlocked", "nobody@rfh.com", "10.0.0.7").Return(0, nil).Once()
+	mockStore.On("LoginDoctor", "nobody@rfh.com", "wrong-password").Return(types.Actor{}, errors.New("invalid email or password")).Once()
+	mockStore.On("RecordLoginFailure", "nobody@rfh.com", "10.0.0.7", mock.Anything).Return(nil).Once()
+
+	resp = login("nobody@rfh.com")
+
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+	require.JSONEq(t, `{"error": "Invalid email or password"}`, resp.Body.String())
+	mockStore.AssertExpectations(t)
+}
+
+func TestUnlockDoctor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	router.Use(signedIn(admin))
+	router.POST("/:id/unlock", handler.UnlockDoctor)
+	router.GET("/security-events", handler.GetSecurityEvents)
+
+	// Test case: The admin who unlocks the account is recorded
+	mockStore.On("UnlockDoctor", 3, admin.ID).Return(nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/3/unlock", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: Security events of one kind
+	mockStore.On("GetSecurityEvents", "lockout", 100).Return([]types.SecurityEvent{{ID: 1, Kind: "lockout", DoctorID: 3, Email: "stan@rfh.com"}}, nil).Once()
+
+	req, _ = http.NewRequest(http.MethodGet, "/security-events?kind=lockout", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), `"kind":"lockout"`)
+
+	// Test case: Unknown kinds are refused
+	req, _ = http.NewRequest(http.MethodGet, "/security-events?kind=everything", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestResetMFA(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	router.Use(signedIn(admin))
+	router.POST("/:id/mfa/reset", handler.ResetMFA)
+
+	// Test case: The admin who resets MFA is recorded and the doctor's sessions are revoked
+	mockStore.On("ResetMFA", 3, admin.ID).Return(2, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/3/mfa/reset", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), `"revoked":2`)
+
+	// Test case: Unknown doctors are not found
+	mockStore.On("ResetMFA", 9, admin.ID).Return(0, errors.New("doctor does not exist")).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/9/mfa/reset", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestChangePassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	router := gin.Default()
+	router.Use(signedIn(types.Actor{ID: 2, Email: "Stan@rfh.com", Role: "doctor", SessionID: "5e4d3c2b-1a09-4f8e-9d7c-6b5a4f3e2d1c"}))
+	router.PUT("/password", handler.ChangePassword)
+
+	change := func(current, new string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"current_password": current, "new_password": new})
+		req, _ := http.NewRequest(http.MethodPut, "/password", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "10.0.0.7:51234"
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	mockStore.On("LoginBlocked", "stan@rfh.com", "10.0.0.7").Return(0, nil)
+
+	// Test case: The password is changed, keeping the current session
+	mockStore.On("ChangePassword", 2, "old-ward-password", "new-ward-password", 5, "5e4d3c2b-1a09-4f8e-9d7c-6b5a4f3e2d1c").Return(nil).Once()
+
+	resp := change("old-ward-password", "new-ward-password")
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: A wrong current password counts as a failed login
+	mockStore.On("ChangePassword", 2, "guessed-password", "new-ward-password", 5, mock.Anything).Return(errors.New("current password is incorrect")).Once()
+	mockStore.On("RecordLoginFailure", "stan@rfh.com", "10.0.0.7", mock.Anything).Return(nil).Once()
+
+	resp = change("guessed-password", "new-ward-password")
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), "Current password is incorrect")
+
+	// Test case: A recent password cannot be used again
+	mockStore.On("ChangePassword", 2, "old-ward-password", "older-ward-password", 5, mock.Anything).Return(errors.New("password was used recently")).Once()
+
+	resp = change("old-ward-password", "older-ward-password")
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), "last 5 passwords")
+	mockStore.AssertExpectations(t)
+}
+
+func TestPasswordReset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDoctorStore)
+	notifier := new(MockNotifier)
+	handler := NewHandler(mockStore, policy, notifier)
+
+	router := gin.Default()
+	router.POST("/password/forgot", handler.ForgotPassword)
+	router.POST("/password/reset", handler.ResetPassword)
+
+	// Test case: A reset link is sent for a registered email, only the hash of its token is saved
+	var tokenHash string
+	mockStore.On("CreatePasswordReset", "stan@rfh.com", mock.Anything, 30).Run(func(args mock.Arguments) {
+		tokenHash = args.String(1)
+	}).Return(types.StaffAccount{ID: 2, FirstName: "Stan", Email: "stan@rfh.com"}, nil).Once()
+	var sent types.Notification
+	notifier.On("Notify", mock.Anything).Run(func(args mock.Arguments) {
+		sent = args.Get(0).(types.Notification)
+	}).Return(nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/password/forgot", bytes.NewBufferString(`{"email": " Stan@rfh.com "}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	known := resp.Body.String()
+	require.Equal(t, "stan@rfh.com", sent.To)
+	require.NotContains(t, sent.Body, tokenHash)
+
+	// Test case: An unknown email gets the same response and nothing is sent
+	mockStore.On("CreatePasswordReset", "nobody@rfh.com", mock.Anything, 30).Return(types.StaffAccount{}, errors.New("doctor does not exist")).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/password/forgot", bytes.NewBufferString(`{"email": "nobody@rfh.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, known, resp.Body.String())
+	notifier.AssertNumberOfCalls(t, "Notify", 1)
+
+	// Test case: The token from the link resets the password
+	token := sent.Body[strings.LastIndex(sent.Body, "reset your password:\n")+len("reset your password:\n"):]
+	token = token[:strings.Index(token, "\n")]
+	require.Equal(t, tokenHash, auth.HashToken(token))
+	mockStore.On("ResetPassword", tokenHash, "new-ward-password", 5).Return(nil).Once()
+
+	body, _ := json.Marshal(map[string]string{"token": token, "password": "new-ward-password"})
+	req, _ = http.NewRequest(http.MethodPost, "/password/reset", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: A used or expired token is refused
+	mockStore.On("ResetPassword", tokenHash, "another-ward-password", 5).Return(errors.New("reset token is invalid")).Once()
+
+	body, _ = json.Marshal(map[string]string{"token": token, "password": "another-ward-password"})
+	req, _ = http.NewRequest(http.MethodPost, "/password/reset", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Test case: The new password has to meet the policy
+	body, _ = json.Marshal(map[string]string{"token": token, "password": "letmein"})
+	req, _ = http.NewRequest(http.MethodPost, "/password/reset", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDoctorStore)
+	handler := NewHandler(mockStore, policy, nil)
+
+	keyAdmin := types.Actor{ID: 1, Email: "admin@rfh.com", Role: "admin", Permissions: []string{"apikeys:manage", "staff:manage", "observations:write", "clients:read"}}
+	router := gin.Default()
+	router.Use(signedIn(keyAdmin))
+	router.POST("/api-keys", handler.CreateAPIKey)
+	router.POST("/api-keys/:id/revoke", handler.RevokeAPIKey)
+
+	// Test case: The key is returned once, only its hash is saved
+	var savedHash string
+	mockStore.On("CreateAPIKey", mock.MatchedBy(func(apiKey types.APIKey) bool {
+		return apiKey.Name == "Lab analyser bridge" && strings.HasPrefix(apiKey.Prefix, "cema_") &&
+			len(apiKey.Permissions) == 1 && apiKey.Permissions[0] == "observations:write" &&
+			len(apiKey.AllowedIPs) == 1 && apiKey.AllowedIPs[0] == "10.0.4.0/24" && apiKey.ValidDays == 365
+	}), mock.Anything, keyAdmin.ID).
+		Run(func(args mock.Arguments) { savedHash = args.String(1) }).
+		Return(types.APIKey{ID: 2, Name: "Lab analyser bridge", AccountID: 40}, nil).Once()
+
+	body := `{"name": " Lab analyser bridge ", "permissions": ["observations:write", "observations:write"], "allowed_ips": ["10.0.4.0/24"], "valid_days": 365}`
+	req, _ := http.NewRequest(http.MethodPost, "/api-keys", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var apiKey types.APIKey
+	json.Unmarshal(resp.Body.Bytes(), &apiKey)
+	require.True(t, strings.HasPrefix(apiKey.Key, "cema_"))
+	require.Equal(t, auth.HashToken(apiKey.Key), savedHash)
+
+	// Test case: Keys cannot be granted admin permissions, permissions the admin does not have
+	// or be used from something that is not an IP address
+	for _, body := range []string{
+		`{"name": "Reporting", "permissions": ["staff:manage"]}`,
+		`{"name": "Reporting", "permissions": ["prescriptions:write"]}`,
+		`{"name": "Reporting", "permissions": ["clients:read"], "allowed_ips": ["reports.rfh.local"]}`,
+		`{"name": "Reporting", "permissions": []}`,
+	} {
+		req, _ = http.NewRequest(http.MethodPost, "/api-keys", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp = httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Contains(t, []int{http.StatusBadRequest, http.StatusForbidden}, resp.Code, body)
+	}
+
+	// Test case: The admin who revokes the key is recorded
+	mockStore.On("RevokeAPIKey", 2, admin.ID).Return(nil).Once()
+	mockStore.On("RevokeAPIKey", 2, admin.ID).Return(errors.New("api key is already revoked")).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/api-keys/2/revoke", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req, _ = http.NewRequest(http.MethodPost, "/api-keys/2/revoke", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusConflict, resp.Code)
+	mockStore.AssertExpectations(t)
 }
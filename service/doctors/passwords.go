@@ -0,0 +1,72 @@
+package doctors
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// bundledBlocklist holds common and breached passwords, a fuller list can replace it with PASSWORD_BLOCKLIST_FILE
+//
+//go:embed data/common_passwords.txt
+var bundledBlocklist []byte
+
+// maxPasswordBytes is the longest password bcrypt hashes, anything after it would be ignored
+const maxPasswordBytes = 72
+
+// PasswordPolicy is what a new password has to meet at registration, change and reset
+type PasswordPolicy struct {
+	// MinLength is the fewest characters a password can have
+	MinLength int
+	// History is the number of the previous passwords, including the current one, that cannot be used again
+	History   int
+	blocklist map[string]bool
+}
+
+// LoadPasswordPolicy loads the passwords to refuse from blocklistFile, or the bundled list when it is empty.
+// Blocklists have a password on each line, lines starting with # are comments.
+func LoadPasswordPolicy(minLength, history int, blocklistFile string) (*PasswordPolicy, error) {
+	var blocklist io.Reader = bytes.NewReader(bundledBlocklist)
+	if blocklistFile != "" {
+		file, err := os.Open(blocklistFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open password blocklist: %w", err)
+		}
+		defer file.Close()
+		blocklist = file
+	}
+
+	policy := &PasswordPolicy{MinLength: minLength, History: history, blocklist: map[string]bool{}}
+	scanner := bufio.NewScanner(blocklist)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.blocklist[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read password blocklist: %w", err)
+	}
+	return policy, nil
+}
+
+// Check tells why a password cannot be used, or returns nil when it meets the policy.
+// Whether it was used before is checked against the stored passwords by the store.
+func (p *PasswordPolicy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+	if p.blocklist[strings.ToLower(password)] {
+		return fmt.Errorf("password is too common, it appears in lists of breached passwords")
+	}
+	return nil
+}
@@ -1,10 +1,63 @@
 // This file maps the HTTP endpoints to handler functions in the doctors service.
 package doctors
 
-import "github.com/gin-gonic/gin"
+import (
+	"cema_backend/auth"
+
+	"github.com/gin-gonic/gin"
+)
 
 // RegisterRoutes registers the routes for doctor-related operations.
 func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
 	router.POST("/register", h.RegisterDoctors)
 	router.POST("/login", h.LoginDoctor)
+	router.POST("/login/mfa", h.LoginMFA)
+	router.POST("/login/mfa/enroll", h.LoginMFAEnroll)
+	router.POST("/refresh", h.RefreshToken)
+	router.POST("/password/forgot", h.ForgotPassword)
+	router.POST("/password/reset", h.ResetPassword)
+
+	// Protected routes
+	protected := router.Group("/")
+	protected.Use(auth.AuthMiddleware(), auth.RejectAPIKeys())
+	{
+		protected.POST("/logout", h.LogoutDoctor)
+		protected.PUT("/password", h.ChangePassword)
+		protected.GET("/mfa", h.GetMFAStatus)
+		protected.POST("/mfa/enroll", h.EnrollMFA)
+		protected.POST("/mfa/verify", h.VerifyMFA)
+		protected.POST("/mfa/recovery-codes", h.RegenerateRecoveryCodes)
+	}
+
+	// Admin routes, managing the roles of staff
+	admin := router.Group("/")
+	admin.Use(auth.AuthMiddleware(), auth.RequirePermission("roles:manage"))
+	{
+		admin.GET("/roles", h.GetRoles)
+		admin.PUT("/:id/role", h.SetDoctorRole)
+		admin.PUT("/roles/:name/mfa", h.SetRoleMFA)
+	}
+
+	// Admin routes, reviewing registrations and inviting staff
+	staff := router.Group("/")
+	staff.Use(auth.AuthMiddleware(), auth.RequirePermission("staff:manage"))
+	{
+		staff.GET("/pending", h.GetPendingDoctors)
+		staff.POST("/:id/approve", h.ApproveDoctor)
+		staff.POST("/:id/reject", h.RejectDoctor)
+		staff.POST("/invitations", h.InviteDoctor)
+		staff.POST("/:id/revoke-sessions", h.RevokeDoctorSessions)
+		staff.POST("/:id/unlock", h.UnlockDoctor)
+		staff.POST("/:id/mfa/reset", h.ResetMFA)
+		staff.GET("/security-events", h.GetSecurityEvents)
+	}
+
+	// Admin routes, managing the API keys of integrations
+	apiKeys := router.Group("/api-keys")
+	apiKeys.Use(auth.AuthMiddleware(), auth.RequirePermission("apikeys:manage"))
+	{
+		apiKeys.POST("", h.CreateAPIKey)
+		apiKeys.GET("", h.GetAPIKeys)
+		apiKeys.POST("/:id/revoke", h.RevokeAPIKey)
+	}
 }
@@ -6,6 +6,10 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type Store struct {
@@ -19,50 +23,1378 @@ func NewStore(db *sql.DB) *Store {
 	}
 }
 
-// RegisterDoctors saves a new doctor's details in the database.
-func (s *Store) RegisterDoctors(doctor types.DoctorRegistration) error {
+// RegisterDoctors saves a new doctor's details in the database and returns the status of the account.
+// A self registered account is pending until an admin approves it. Registering with an invitation
+// for the doctor's email uses it up and makes the account active with the role of the invitation.
+func (s *Store) RegisterDoctors(doctor types.DoctorRegistration) (string, error) {
 	// context is used to manage the lifetime of the request
 	ctx := context.Background()
 
 	// Hash the password
 	hashedPassword, err := auth.HashPassword(doctor.Password)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	status, role := "pending", "doctor"
+	var invitationID int
+	if doctor.InvitationToken != "" {
+		query := `SELECT id, role FROM staff_invitations
+			WHERE token_hash = ? AND email = ? AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP FOR UPDATE`
+		err := tx.QueryRowContext(ctx, query, auth.HashToken(doctor.InvitationToken), doctor.Email).Scan(&invitationID, &role)
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("invitation is invalid")
+		} else if err != nil {
+			return "", fmt.Errorf("failed to retrieve invitation: %w", err)
+		}
+		status = "active"
 	}
 
 	// Update the query to use the hashed password
-	query := `INSERT INTO doctors (firstname, lastname, email, phonenumber, department, password) VALUES (?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO doctors (firstname, lastname, email, phonenumber, department, password, registration_number, role, status)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?)`
 
 	// Execute the query with the hashed password
-	_, err = s.db.ExecContext(ctx, query, doctor.FirstName, doctor.LastName, doctor.Email, doctor.PhoneNumber, doctor.Department, hashedPassword)
+	result, err := tx.ExecContext(ctx, query, doctor.FirstName, doctor.LastName, doctor.Email, doctor.PhoneNumber, doctor.Department, hashedPassword,
+		doctor.RegistrationNumber, role, status)
 	if err != nil {
-		return fmt.Errorf("failed to save doctor in DB %w", err)
+		return "", fmt.Errorf("failed to save doctor in DB %w", err)
 	}
-	// If successful, return nil otherwise return an error
-	return nil
+
+	if invitationID != 0 {
+		id, err := result.LastInsertId()
+		if err != nil {
+			return "", fmt.Errorf("failed to get doctor id: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE staff_invitations SET used_at = CURRENT_TIMESTAMP, used_by = ? WHERE id = ?`, id, invitationID)
+		if err != nil {
+			return "", fmt.Errorf("failed to use invitation: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit doctor: %w", err)
+	}
+	// If successful, return the status of the account otherwise return an error
+	return status, nil
 }
 
-// LoginDoctor verifies a doctor's credentials in the database.
-func (s *Store) LoginDoctor(email, password string) error {
+// missingHash is checked against when the email of a login does not exist
+var missingHash, _ = auth.HashPassword("password of an account that does not exist")
+
+// LoginDoctor verifies a doctor's credentials in the database
+// and returns the identity the doctor's token is issued for.
+func (s *Store) LoginDoctor(email, password string) (types.Actor, error) {
 	// context is used to manage the lifetime of the request
 	ctx := context.Background()
 
 	// Retrieve the hashed password from the database
-	query := `SELECT password FROM doctors WHERE email = ?`
-	var storedHashedPassword string
-	err := s.db.QueryRowContext(ctx, query, email).Scan(&storedHashedPassword)
+	query := `SELECT id, email, role, status, password FROM doctors WHERE email = ?`
+	var actor types.Actor
+	var status, storedHashedPassword string
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&actor.ID, &actor.Email, &actor.Role, &status, &storedHashedPassword)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return fmt.Errorf("invalid email or password")
+			// Check the password anyway so an email that does not exist takes as long as a wrong password
+			auth.CheckPasswordHash(password, missingHash)
+			return types.Actor{}, fmt.Errorf("invalid email or password")
 		}
-		return fmt.Errorf("failed to query doctor: %w", err)
+		return types.Actor{}, fmt.Errorf("failed to query doctor: %w", err)
 	}
 
 	// Verify the provided password against the stored hash
 	if !auth.CheckPasswordHash(password, storedHashedPassword) {
-		return fmt.Errorf("invalid email or password")
+		return types.Actor{}, fmt.Errorf("invalid email or password")
+	}
+
+	// Only approved accounts get a token
+	switch status {
+	case "pending":
+		return types.Actor{}, fmt.Errorf("account is pending approval")
+	case "rejected":
+		return types.Actor{}, fmt.Errorf("account has been rejected")
+	case "service":
+		// Service accounts of API keys have no password, this only guards against one being set
+		return types.Actor{}, fmt.Errorf("invalid email or password")
+	}
+
+	// The token carries the permissions of the doctor's role
+	actor.Permissions, err = s.permissionsOf(ctx, actor.Role)
+	if err != nil {
+		return types.Actor{}, err
+	}
+
+	// If successful, return the doctor's identity
+	return actor, nil
+}
+
+// permissionsOf retrieves the permissions granted by a role
+func (s *Store) permissionsOf(ctx context.Context, role string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT permission FROM role_permissions WHERE role = ? ORDER BY permission`, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query role permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, fmt.Errorf("failed to scan role permission: %w", err)
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// GetRoles retrieves the roles staff can be assigned with the permissions each grants
+func (s *Store) GetRoles() ([]types.Role, error) {
+	ctx := context.Background()
+
+	query := `SELECT r.name, r.description, r.mfa_required, COALESCE(rp.permission, '')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		ORDER BY r.name, rp.permission`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []types.Role{}
+	for rows.Next() {
+		var role types.Role
+		var permission string
+		if err := rows.Scan(&role.Name, &role.Description, &role.MFARequired, &permission); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != role.Name {
+			role.Permissions = []string{}
+			roles = append(roles, role)
+		}
+		if permission != "" {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission)
+		}
+	}
+	return roles, rows.Err()
+}
+
+// SetDoctorRole assigns a role to a doctor, it applies from the doctor's next login.
+// The last admin cannot be given another role so the roles can still be managed.
+func (s *Store) SetDoctorRole(doctorID int, role string) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM roles WHERE name = ?)`, role).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check role: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("role does not exist")
+	}
+
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT role FROM doctors WHERE id = ? FOR UPDATE`, doctorID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("doctor does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve doctor: %w", err)
+	}
+
+	if current == "admin" && role != "admin" {
+		// Lock the admins so two of them cannot demote each other at the same time
+		rows, err := tx.QueryContext(ctx, `SELECT id FROM doctors WHERE role = 'admin' FOR UPDATE`)
+		if err != nil {
+			return fmt.Errorf("failed to count admins: %w", err)
+		}
+		admins := 0
+		for rows.Next() {
+			admins++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to count admins: %w", err)
+		}
+		if admins <= 1 {
+			return fmt.Errorf("cannot remove the last admin")
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE doctors SET role = ? WHERE id = ?`, role, doctorID); err != nil {
+		return fmt.Errorf("failed to update doctor role: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit doctor role: %w", err)
+	}
+	return nil
+}
+
+// SetRoleMFA sets whether everyone with a role has to sign in with an authenticator app.
+// It applies from their next login, sessions of staff without MFA end at their next refresh.
+func (s *Store) SetRoleMFA(role string, required bool) error {
+	ctx := context.Background()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE roles SET mfa_required = ? WHERE name = ?`, required, role)
+	if err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		// Nothing changed either because the role does not exist or it was already set
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM roles WHERE name = ?)`, role).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to check role: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("role does not exist")
+		}
+	}
+	return nil
+}
+
+// GetPendingDoctors retrieves the self registered accounts waiting for approval, the oldest first
+func (s *Store) GetPendingDoctors() ([]types.StaffAccount, error) {
+	ctx := context.Background()
+
+	query := `SELECT id, firstname, lastname, email, COALESCE(phonenumber, ''), COALESCE(department, ''), role, status, created_at
+		FROM doctors WHERE status = 'pending' ORDER BY created_at, id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending doctors: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := []types.StaffAccount{}
+	for rows.Next() {
+		var account types.StaffAccount
+		err := rows.Scan(&account.ID, &account.FirstName, &account.LastName, &account.Email, &account.PhoneNumber,
+			&account.Department, &account.Role, &account.Status, &account.RegisteredAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pending doctor: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// ApproveDoctor makes a pending account active with the given role, recording the admin who approved it
+func (s *Store) ApproveDoctor(doctorID int, role string, approvedBy int) error {
+	ctx := context.Background()
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM roles WHERE name = ?)`, role).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check role: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("role does not exist")
+	}
+
+	query := `UPDATE doctors SET status = 'active', role = ?, reviewed_by = ?,
+		reviewed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'pending'`
+	result, err := s.db.ExecContext(ctx, query, role, approvedBy, doctorID)
+	if err != nil {
+		return fmt.Errorf("failed to approve doctor: %w", err)
+	}
+	return s.reviewed(ctx, result, doctorID)
+}
+
+// RejectDoctor rejects a pending account with the reason, recording the admin who rejected it
+func (s *Store) RejectDoctor(doctorID int, reason string, rejectedBy int) error {
+	ctx := context.Background()
+
+	query := `UPDATE doctors SET status = 'rejected', rejection_reason = ?, reviewed_by = ?,
+		reviewed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'pending'`
+	result, err := s.db.ExecContext(ctx, query, reason, rejectedBy, doctorID)
+	if err != nil {
+		return fmt.Errorf("failed to reject doctor: %w", err)
+	}
+	return s.reviewed(ctx, result, doctorID)
+}
+
+// reviewed checks that an approval or rejection changed the account,
+// telling an unknown account apart from one that was not pending
+func (s *Store) reviewed(ctx context.Context, result sql.Result, doctorID int) error {
+	if affected, err := result.RowsAffected(); err != nil || affected > 0 {
+		return nil
+	}
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM doctors WHERE id = ?)`, doctorID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check doctor: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("doctor does not exist")
+	}
+	return fmt.Errorf("doctor is not pending")
+}
+
+// CreateInvitation saves an invitation with the hash of its signup token,
+// valid for its number of hours from now. It returns the invitation with its id and expiry.
+func (s *Store) CreateInvitation(invitation types.Invitation, tokenHash string, invitedBy int) (types.Invitation, error) {
+	ctx := context.Background()
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM roles WHERE name = ?)`, invitation.Role).Scan(&exists); err != nil {
+		return invitation, fmt.Errorf("failed to check role: %w", err)
+	}
+	if !exists {
+		return invitation, fmt.Errorf("role does not exist")
+	}
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM doctors WHERE email = ?)`, invitation.Email).Scan(&exists); err != nil {
+		return invitation, fmt.Errorf("failed to check doctor: %w", err)
+	}
+	if exists {
+		return invitation, fmt.Errorf("email is already registered")
+	}
+
+	query := `INSERT INTO staff_invitations (token_hash, email, role, invited_by, expires_at)
+		VALUES (?, ?, ?, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? HOUR))`
+	result, err := s.db.ExecContext(ctx, query, tokenHash, invitation.Email, invitation.Role, invitedBy, invitation.ValidHours)
+	if err != nil {
+		return invitation, fmt.Errorf("failed to save invitation: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return invitation, fmt.Errorf("failed to get invitation id: %w", err)
+	}
+	invitation.ID = int(id)
+
+	err = s.db.QueryRowContext(ctx, `SELECT expires_at FROM staff_invitations WHERE id = ?`, id).Scan(&invitation.ExpiresAt)
+	if err != nil {
+		return invitation, fmt.Errorf("failed to retrieve invitation: %w", err)
+	}
+	return invitation, nil
+}
+
+// CreateSession starts a session for a doctor who logged in with its first refresh token,
+// valid for its number of days. It returns the session with its id and expiry.
+func (s *Store) CreateSession(session types.Session, refreshTokenHash string) (types.Session, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return session, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	session.ID = uuid.NewString()
+	query := `INSERT INTO sessions (id, doctor_id, user_agent, ip_address, expires_at)
+		VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? DAY))`
+	_, err = tx.ExecContext(ctx, query, session.ID, session.DoctorID, session.UserAgent, session.IPAddress, session.ValidDays)
+	if err != nil {
+		return session, fmt.Errorf("failed to save session: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO refresh_tokens (token_hash, session_id) VALUES (?, ?)`, refreshTokenHash, session.ID); err != nil {
+		return session, fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT created_at, expires_at FROM sessions WHERE id = ?`, session.ID).Scan(&session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		return session, fmt.Errorf("failed to retrieve session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return session, fmt.Errorf("failed to commit session: %w", err)
+	}
+	return session, nil
+}
+
+// RefreshSession uses up a refresh token, replacing it with a new one and extending the session by the number of days.
+// It returns the doctor's identity with the current permissions of their role to issue a new access token for.
+// A refresh token that was already used means it was stolen, the whole session is revoked.
+func (s *Store) RefreshSession(refreshTokenHash string, newRefreshTokenHash string, validDays int) (types.Actor, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return types.Actor{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `SELECT s.id, rt.used_at IS NOT NULL, s.revoked_at IS NULL AND s.expires_at > CURRENT_TIMESTAMP,
+			d.id, d.email, d.role, d.status, r.mfa_required AND d.mfa_enabled_at IS NULL
+		FROM refresh_tokens rt
+		JOIN sessions s ON s.id = rt.session_id
+		JOIN doctors d ON d.id = s.doctor_id
+		JOIN roles r ON r.name = d.role
+		WHERE rt.token_hash = ? FOR UPDATE`
+	var actor types.Actor
+	var used, active, mfaMissing bool
+	var status string
+	err = tx.QueryRowContext(ctx, query, refreshTokenHash).Scan(&actor.SessionID, &used, &active, &actor.ID, &actor.Email, &actor.Role, &status, &mfaMissing)
+	if err == sql.ErrNoRows {
+		return types.Actor{}, fmt.Errorf("refresh token is invalid")
+	} else if err != nil {
+		return types.Actor{}, fmt.Errorf("failed to retrieve refresh token: %w", err)
+	}
+	if !active {
+		return types.Actor{}, fmt.Errorf("session has expired")
+	}
+
+	// A reused token, an account that is no longer active or a role that came to require MFA ends the session
+	revokeReason := ""
+	if used {
+		revokeReason = "reuse"
+	} else if status != "active" {
+		revokeReason = "inactive"
+	} else if mfaMissing {
+		revokeReason = "mfa"
+	}
+	if revokeReason != "" {
+		_, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = ? WHERE id = ?`, revokeReason, actor.SessionID)
+		if err != nil {
+			return types.Actor{}, fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return types.Actor{}, fmt.Errorf("failed to commit session revocation: %w", err)
+		}
+		if used {
+			return types.Actor{}, fmt.Errorf("refresh token was reused")
+		}
+		return types.Actor{}, fmt.Errorf("session has expired")
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = ?`, []interface{}{refreshTokenHash}},
+		{`INSERT INTO refresh_tokens (token_hash, session_id) VALUES (?, ?)`, []interface{}{newRefreshTokenHash, actor.SessionID}},
+		{`UPDATE sessions SET refreshed_at = CURRENT_TIMESTAMP, expires_at = DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? DAY) WHERE id = ?`, []interface{}{validDays, actor.SessionID}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return types.Actor{}, fmt.Errorf("failed to refresh session: %w", err)
+		}
+	}
+
+	// A role changed since the last token applies from this one
+	actor.Permissions, err = s.permissionsOf(ctx, actor.Role)
+	if err != nil {
+		return types.Actor{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return types.Actor{}, fmt.Errorf("failed to commit session refresh: %w", err)
+	}
+	return actor, nil
+}
+
+// RevokeSession ends a session when the doctor logs out
+func (s *Store) RevokeSession(sessionID string) error {
+	ctx := context.Background()
+
+	query := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = 'logout' WHERE id = ? AND revoked_at IS NULL`
+	result, err := s.db.ExecContext(ctx, query, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("session does not exist")
+	}
+	return nil
+}
+
+// RevokeDoctorSessions ends all the sessions of a doctor, recording the admin who revoked them.
+// It returns the number of sessions revoked.
+func (s *Store) RevokeDoctorSessions(doctorID int, revokedBy int) (int, error) {
+	ctx := context.Background()
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM doctors WHERE id = ?)`, doctorID).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("failed to check doctor: %w", err)
+	}
+	if !exists {
+		return 0, fmt.Errorf("doctor does not exist")
+	}
+
+	query := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = 'admin', revoked_by = ?
+		WHERE doctor_id = ? AND revoked_at IS NULL`
+	result, err := s.db.ExecContext(ctx, query, revokedBy, doctorID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count revoked sessions: %w", err)
+	}
+	return int(revoked), nil
+}
+
+// SessionActive reports whether a session has not been revoked and has not expired
+func (s *Store) SessionActive(sessionID string) (bool, error) {
+	var active bool
+	query := `SELECT EXISTS(SELECT 1 FROM sessions WHERE id = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP)`
+	if err := s.db.QueryRowContext(context.Background(), query, sessionID).Scan(&active); err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+	return active, nil
+}
+
+// Codes a login challenge can be answered with before it has to be started again by logging in
+const maxMFAAttempts = 5
+
+// GetMFAStatus retrieves whether a doctor has MFA enabled and whether their role requires it
+func (s *Store) GetMFAStatus(doctorID int) (types.MFAStatus, error) {
+	var status types.MFAStatus
+	query := `SELECT d.mfa_enabled_at IS NOT NULL, r.mfa_required FROM doctors d JOIN roles r ON r.name = d.role WHERE d.id = ?`
+	err := s.db.QueryRowContext(context.Background(), query, doctorID).Scan(&status.Enabled, &status.Required)
+	if err == sql.ErrNoRows {
+		return status, fmt.Errorf("doctor does not exist")
+	} else if err != nil {
+		return status, fmt.Errorf("failed to retrieve mfa status: %w", err)
+	}
+	return status, nil
+}
+
+// StartMFAEnrollment keeps a new authenticator secret for a doctor until it is confirmed with a first code,
+// replacing the secret of an enrollment that was never confirmed
+func (s *Store) StartMFAEnrollment(doctorID int, secret string) error {
+	ctx := context.Background()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE doctors SET mfa_secret = ?, mfa_last_step = 0 WHERE id = ? AND mfa_enabled_at IS NULL`, secret, doctorID)
+	if err != nil {
+		return fmt.Errorf("failed to save mfa secret: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("mfa is already enabled")
+	}
+	return nil
+}
+
+// ConfirmMFAEnrollment enables MFA for a doctor once a code from the new authenticator is verified,
+// saving the hashes of their recovery codes
+func (s *Store) ConfirmMFAEnrollment(doctorID int, code string, recoveryCodeHashes []string) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkCode(ctx, tx, doctorID, code, false); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE doctors SET mfa_enabled_at = CURRENT_TIMESTAMP WHERE id = ?`, doctorID); err != nil {
+		return fmt.Errorf("failed to enable mfa: %w", err)
+	}
+	if err := saveRecoveryCodes(ctx, tx, doctorID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit mfa enrollment: %w", err)
+	}
+	return nil
+}
+
+// VerifyMFACode verifies a code from the authenticator of a doctor with MFA enabled
+func (s *Store) VerifyMFACode(doctorID int, code string) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkCode(ctx, tx, doctorID, code, true); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit mfa code: %w", err)
+	}
+	return nil
+}
+
+// checkCode verifies a code against the secret of a doctor's confirmed or started enrollment,
+// recording its time step so the same code cannot be used again
+func checkCode(ctx context.Context, tx *sql.Tx, doctorID int, code string, enabled bool) error {
+	var secret sql.NullString
+	var isEnabled bool
+	var lastStep int64
+	query := `SELECT mfa_secret, mfa_enabled_at IS NOT NULL, mfa_last_step FROM doctors WHERE id = ? FOR UPDATE`
+	err := tx.QueryRowContext(ctx, query, doctorID).Scan(&secret, &isEnabled, &lastStep)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("doctor does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve mfa secret: %w", err)
+	}
+
+	switch {
+	case isEnabled && !enabled:
+		return fmt.Errorf("mfa is already enabled")
+	case !isEnabled && enabled:
+		return fmt.Errorf("mfa code is invalid")
+	case !secret.Valid:
+		return fmt.Errorf("mfa enrollment was not started")
+	}
+
+	step, ok := auth.ValidateTOTP(secret.String, code, time.Now(), lastStep)
+	if !ok {
+		return fmt.Errorf("mfa code is invalid")
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE doctors SET mfa_last_step = ? WHERE id = ?`, step, doctorID); err != nil {
+		return fmt.Errorf("failed to record mfa code: %w", err)
+	}
+	return nil
+}
+
+// saveRecoveryCodes replaces the recovery codes of a doctor
+func saveRecoveryCodes(ctx context.Context, tx *sql.Tx, doctorID int, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE doctor_id = ?`, doctorID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (doctor_id, code_hash) VALUES (?, ?)`, doctorID, hash); err != nil {
+			return fmt.Errorf("failed to save recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode uses up one of the recovery codes of a doctor with MFA enabled
+func (s *Store) UseRecoveryCode(doctorID int, codeHash string) error {
+	ctx := context.Background()
+
+	query := `UPDATE mfa_recovery_codes rc JOIN doctors d ON d.id = rc.doctor_id
+		SET rc.used_at = CURRENT_TIMESTAMP
+		WHERE rc.doctor_id = ? AND rc.code_hash = ? AND rc.used_at IS NULL AND d.mfa_enabled_at IS NOT NULL`
+	result, err := s.db.ExecContext(ctx, query, doctorID, codeHash)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("recovery code is invalid")
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a doctor with MFA enabled, the old ones stop working
+func (s *Store) ReplaceRecoveryCodes(doctorID int, recoveryCodeHashes []string) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := saveRecoveryCodes(ctx, tx, doctorID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit recovery codes: %w", err)
+	}
+	return nil
+}
+
+// CreateMFAChallenge saves the challenge a doctor who logged in with their password answers with a code,
+// valid for the number of minutes
+func (s *Store) CreateMFAChallenge(doctorID int, tokenHash string, validMinutes int) error {
+	query := `INSERT INTO mfa_challenges (token_hash, doctor_id, expires_at)
+		VALUES (?, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? MINUTE))`
+	if _, err := s.db.ExecContext(context.Background(), query, tokenHash, doctorID, validMinutes); err != nil {
+		return fmt.Errorf("failed to save mfa challenge: %w", err)
+	}
+	return nil
+}
+
+// GetMFAChallenge retrieves the doctor a challenge is for while it can still be answered
+func (s *Store) GetMFAChallenge(tokenHash string) (types.Actor, error) {
+	query := `SELECT d.id, d.email FROM mfa_challenges ch JOIN doctors d ON d.id = ch.doctor_id
+		WHERE ch.token_hash = ? AND ch.used_at IS NULL AND ch.expires_at > CURRENT_TIMESTAMP AND ch.attempts < ?`
+	var actor types.Actor
+	err := s.db.QueryRowContext(context.Background(), query, tokenHash, maxMFAAttempts).Scan(&actor.ID, &actor.Email)
+	if err == sql.ErrNoRows {
+		return actor, fmt.Errorf("mfa challenge is invalid")
+	} else if err != nil {
+		return actor, fmt.Errorf("failed to retrieve mfa challenge: %w", err)
+	}
+	return actor, nil
+}
+
+// CountMFAAttempt uses up one of the attempts of a challenge before a code is checked.
+// The attempts are checked and counted in one statement, so codes sent at once cannot exceed them.
+func (s *Store) CountMFAAttempt(tokenHash string) error {
+	query := `UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP AND attempts < ?`
+	result, err := s.db.ExecContext(context.Background(), query, tokenHash, maxMFAAttempts)
+	if err != nil {
+		return fmt.Errorf("failed to record mfa attempt: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("mfa challenge is invalid")
+	}
+	return nil
+}
+
+// CompleteMFAChallenge uses up a challenge that was answered,
+// returning the identity of the doctor to start the session for.
+// The attempt of the code that answered it has already been counted.
+func (s *Store) CompleteMFAChallenge(tokenHash string) (types.Actor, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return types.Actor{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE mfa_challenges SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP AND attempts <= ?`
+	result, err := tx.ExecContext(ctx, query, tokenHash, maxMFAAttempts)
+	if err != nil {
+		return types.Actor{}, fmt.Errorf("failed to use mfa challenge: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return types.Actor{}, fmt.Errorf("mfa challenge is invalid")
+	}
+
+	// The account may have been deactivated since the password was checked
+	var actor types.Actor
+	query = `SELECT d.id, d.email, d.role FROM mfa_challenges ch JOIN doctors d ON d.id = ch.doctor_id
+		WHERE ch.token_hash = ? AND d.status = 'active'`
+	err = tx.QueryRowContext(ctx, query, tokenHash).Scan(&actor.ID, &actor.Email, &actor.Role)
+	if err == sql.ErrNoRows {
+		return types.Actor{}, fmt.Errorf("mfa challenge is invalid")
+	} else if err != nil {
+		return types.Actor{}, fmt.Errorf("failed to retrieve doctor: %w", err)
+	}
+	actor.Permissions, err = s.permissionsOf(ctx, actor.Role)
+	if err != nil {
+		return types.Actor{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return types.Actor{}, fmt.Errorf("failed to commit mfa challenge: %w", err)
+	}
+	return actor, nil
+}
+
+// Failed logins are forgotten after a day without any, and a login after this many failures is suspicious
+const (
+	throttleResetHours = 24
+	suspiciousFailures = 3
+)
+
+// LoginBlocked returns the number of seconds until the account with the email, or the IP address,
+// can try to log in again after failed logins, 0 when it can now
+func (s *Store) LoginBlocked(email, ipAddress string) (int, error) {
+	query := `SELECT COALESCE(MAX(GREATEST(TIMESTAMPDIFF(SECOND, CURRENT_TIMESTAMP, blocked_until), 1)), 0)
+		FROM login_throttles
+		WHERE ((kind = 'account' AND subject = ?) OR (kind = 'ip' AND subject = ?)) AND blocked_until > CURRENT_TIMESTAMP`
+	var wait int
+	if err := s.db.QueryRowContext(context.Background(), query, email, ipAddress).Scan(&wait); err != nil {
+		return 0, fmt.Errorf("failed to check login throttle: %w", err)
+	}
+	return wait, nil
+}
+
+// RecordLoginFailure counts a failed login against the account with the email, whether or not it exists,
+// and against the IP address. Each blocks its next login according to the policy, reaching the threshold
+// of failures locks it out and records a lockout event.
+func (s *Store) RecordLoginFailure(email, ipAddress string, policy types.LoginPolicy) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	throttles := []struct {
+		kind, subject, event string
+		threshold            int
+	}{
+		{"account", email, "lockout", policy.AccountThreshold},
+		{"ip", ipAddress, "ip_lockout", policy.IPThreshold},
+	}
+	lockout := time.Duration(policy.LockoutMinutes) * time.Minute
+	for _, throttle := range throttles {
+		if throttle.subject == "" {
+			continue
+		}
+
+		query := `INSERT INTO login_throttles (kind, subject, failures) VALUES (?, ?, 1)
+			ON DUPLICATE KEY UPDATE
+				failures = IF(last_failed_at < DATE_SUB(CURRENT_TIMESTAMP, INTERVAL ? HOUR), 1, failures + 1),
+				last_failed_at = CURRENT_TIMESTAMP`
+		if _, err := tx.ExecContext(ctx, query, throttle.kind, throttle.subject, throttleResetHours); err != nil {
+			return fmt.Errorf("failed to record failed login: %w", err)
+		}
+		var failures int
+		err := tx.QueryRowContext(ctx, `SELECT failures FROM login_throttles WHERE kind = ? AND subject = ?`, throttle.kind, throttle.subject).Scan(&failures)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve failed logins: %w", err)
+		}
+
+		wait := auth.LoginBackoff(failures, throttle.threshold, lockout)
+		query = `UPDATE login_throttles SET blocked_until = DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? SECOND) WHERE kind = ? AND subject = ?`
+		if _, err := tx.ExecContext(ctx, query, int(wait.Seconds()), throttle.kind, throttle.subject); err != nil {
+			return fmt.Errorf("failed to block login: %w", err)
+		}
+
+		if failures == throttle.threshold {
+			query = `INSERT INTO security_events (kind, doctor_id, email, ip_address, detail)
+				VALUES (?, (SELECT id FROM doctors WHERE email = ?), ?, NULLIF(?, ''), ?)`
+			detail := fmt.Sprintf("Locked out for %d minutes after %d failed logins", policy.LockoutMinutes, failures)
+			if _, err := tx.ExecContext(ctx, query, throttle.event, email, email, ipAddress, detail); err != nil {
+				return fmt.Errorf("failed to record lockout: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit failed login: %w", err)
+	}
+	return nil
+}
+
+// RecordLoginSuccess clears the failed logins of an account once its password is right.
+// A login after several failures, or from an IP address the doctor has never had a session from,
+// is recorded as suspicious.
+func (s *Store) RecordLoginSuccess(doctorID int, email, ipAddress string) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var failures int
+	query := `SELECT failures FROM login_throttles
+		WHERE kind = 'account' AND subject = ? AND last_failed_at >= DATE_SUB(CURRENT_TIMESTAMP, INTERVAL ? HOUR) FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, email, throttleResetHours).Scan(&failures)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to retrieve failed logins: %w", err)
+	}
+	var hasSessions, knownAddress bool
+	query = `SELECT EXISTS(SELECT 1 FROM sessions WHERE doctor_id = ?), EXISTS(SELECT 1 FROM sessions WHERE doctor_id = ? AND ip_address = ?)`
+	if err := tx.QueryRowContext(ctx, query, doctorID, doctorID, ipAddress).Scan(&hasSessions, &knownAddress); err != nil {
+		return fmt.Errorf("failed to check login address: %w", err)
+	}
+
+	detail := ""
+	if failures >= suspiciousFailures {
+		detail = fmt.Sprintf("Logged in after %d failed logins", failures)
+	} else if hasSessions && !knownAddress {
+		detail = "Logged in from a new IP address"
+	}
+	if detail != "" {
+		query := `INSERT INTO security_events (kind, doctor_id, email, ip_address, detail) VALUES ('suspicious_login', ?, ?, NULLIF(?, ''), ?)`
+		if _, err := tx.ExecContext(ctx, query, doctorID, email, ipAddress, detail); err != nil {
+			return fmt.Errorf("failed to record suspicious login: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_throttles WHERE kind = 'account' AND subject = ?`, email); err != nil {
+		return fmt.Errorf("failed to clear failed logins: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit login: %w", err)
+	}
+	return nil
+}
+
+// UnlockDoctor clears the failed logins of a doctor's account so they can log in straight away,
+// recording the admin who unlocked it
+func (s *Store) UnlockDoctor(doctorID int, unlockedBy int) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRowContext(ctx, `SELECT LOWER(email) FROM doctors WHERE id = ?`, doctorID).Scan(&email)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("doctor does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve doctor: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_throttles WHERE kind = 'account' AND subject = ?`, email); err != nil {
+		return fmt.Errorf("failed to clear failed logins: %w", err)
+	}
+	query := `INSERT INTO security_events (kind, doctor_id, email, recorded_by) VALUES ('unlock', ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, query, doctorID, email, unlockedBy); err != nil {
+		return fmt.Errorf("failed to record unlock: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit unlock: %w", err)
+	}
+	return nil
+}
+
+// ResetMFA turns off the MFA of a doctor who lost their authenticator and recovery codes, recording who reset it.
+// Their recovery codes and open login challenges are removed and all of their sessions are revoked,
+// so they log in again with their password and set MFA up anew. It returns the number of sessions revoked.
+func (s *Store) ResetMFA(doctorID int, resetBy int) (int, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRowContext(ctx, `SELECT LOWER(email) FROM doctors WHERE id = ? FOR UPDATE`, doctorID).Scan(&email)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("doctor does not exist")
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to retrieve doctor: %w", err)
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE doctors SET mfa_secret = NULL, mfa_enabled_at = NULL, mfa_last_step = 0 WHERE id = ?`, []interface{}{doctorID}},
+		{`DELETE FROM mfa_recovery_codes WHERE doctor_id = ?`, []interface{}{doctorID}},
+		{`DELETE FROM mfa_challenges WHERE doctor_id = ?`, []interface{}{doctorID}},
+		{`INSERT INTO security_events (kind, doctor_id, email, recorded_by) VALUES ('mfa_reset', ?, ?, ?)`, []interface{}{doctorID, email, resetBy}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return 0, fmt.Errorf("failed to reset mfa: %w", err)
+		}
+	}
+
+	query := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = 'admin', revoked_by = ?
+		WHERE doctor_id = ? AND revoked_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, resetBy, doctorID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count revoked sessions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit mfa reset: %w", err)
+	}
+	return int(revoked), nil
+}
+
+// GetSecurityEvents retrieves the most recent security events, of one kind when it is given
+func (s *Store) GetSecurityEvents(kind string, limit int) ([]types.SecurityEvent, error) {
+	ctx := context.Background()
+
+	query := `SELECT id, kind, doctor_id, COALESCE(email, ''), COALESCE(ip_address, ''), COALESCE(detail, ''), recorded_by, created_at
+		FROM security_events
+		WHERE (? = '' OR kind = ?)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?`
+	rows, err := s.db.QueryContext(ctx, query, kind, kind, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query security events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []types.SecurityEvent{}
+	for rows.Next() {
+		var event types.SecurityEvent
+		var doctorID, recordedBy sql.NullInt64
+		err := rows.Scan(&event.ID, &event.Kind, &doctorID, &event.Email, &event.IPAddress, &event.Detail, &recordedBy, &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan security event: %w", err)
+		}
+		event.DoctorID = int(doctorID.Int64)
+		event.RecordedBy = int(recordedBy.Int64)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// ChangePassword replaces the password of a doctor who knows their current one. The new password cannot be
+// any of the number of previous passwords in history. The doctor's other sessions end, keepSessionID stays.
+func (s *Store) ChangePassword(doctorID int, currentPassword, newPassword string, history int, keepSessionID string) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var storedHashedPassword string
+	err = tx.QueryRowContext(ctx, `SELECT password FROM doctors WHERE id = ? FOR UPDATE`, doctorID).Scan(&storedHashedPassword)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("doctor does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve doctor: %w", err)
+	}
+	if !auth.CheckPasswordHash(currentPassword, storedHashedPassword) {
+		return fmt.Errorf("current password is incorrect")
+	}
+
+	if err := setPassword(ctx, tx, doctorID, storedHashedPassword, newPassword, history); err != nil {
+		return err
+	}
+	query := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = 'password'
+		WHERE doctor_id = ? AND id <> ? AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, doctorID, keepSessionID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit password: %w", err)
+	}
+	return nil
+}
+
+// setPassword replaces the password of a doctor, keeping the current one in the history.
+// It refuses the current password and the rest of the number of previous passwords in history.
+func setPassword(ctx context.Context, tx *sql.Tx, doctorID int, currentHash, newPassword string, history int) error {
+	if history > 0 {
+		previous := []string{currentHash}
+		query := `SELECT password_hash FROM password_history WHERE doctor_id = ? ORDER BY created_at DESC, id DESC LIMIT ?`
+		rows, err := tx.QueryContext(ctx, query, doctorID, history-1)
+		if err != nil {
+			return fmt.Errorf("failed to query password history: %w", err)
+		}
+		for rows.Next() {
+			var hash string
+			if err := rows.Scan(&hash); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan password history: %w", err)
+			}
+			previous = append(previous, hash)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to query password history: %w", err)
+		}
+
+		for _, hash := range previous {
+			if auth.CheckPasswordHash(newPassword, hash) {
+				return fmt.Errorf("password was used recently")
+			}
+		}
+	}
+
+	hashedPassword, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO password_history (doctor_id, password_hash) VALUES (?, ?)`, doctorID, currentHash); err != nil {
+		return fmt.Errorf("failed to save password history: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE doctors SET password = ? WHERE id = ?`, hashedPassword, doctorID); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	return nil
+}
+
+// CreatePasswordReset saves a reset token for the active account with the email, valid for the number of minutes.
+// Earlier reset tokens of the account stop working. It returns the account to send the token to.
+func (s *Store) CreatePasswordReset(email, tokenHash string, validMinutes int) (types.StaffAccount, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return types.StaffAccount{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var account types.StaffAccount
+	query := `SELECT id, firstname, lastname, email FROM doctors WHERE email = ? AND status = 'active'`
+	err = tx.QueryRowContext(ctx, query, email).Scan(&account.ID, &account.FirstName, &account.LastName, &account.Email)
+	if err == sql.ErrNoRows {
+		return types.StaffAccount{}, fmt.Errorf("doctor does not exist")
+	} else if err != nil {
+		return types.StaffAccount{}, fmt.Errorf("failed to retrieve doctor: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE doctor_id = ? AND used_at IS NULL`, account.ID); err != nil {
+		return types.StaffAccount{}, fmt.Errorf("failed to delete password resets: %w", err)
+	}
+	query = `INSERT INTO password_resets (token_hash, doctor_id, expires_at) VALUES (?, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? MINUTE))`
+	if _, err := tx.ExecContext(ctx, query, tokenHash, account.ID, validMinutes); err != nil {
+		return types.StaffAccount{}, fmt.Errorf("failed to save password reset: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return types.StaffAccount{}, fmt.Errorf("failed to commit password reset: %w", err)
+	}
+	return account, nil
+}
+
+// ResetPassword uses up a reset token, replacing the password of its doctor. The new password cannot be
+// any of the number of previous passwords in history. All the doctor's sessions end and a lockout is cleared.
+func (s *Store) ResetPassword(tokenHash, newPassword string, history int) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `SELECT d.id, LOWER(d.email), d.password FROM password_resets pr JOIN doctors d ON d.id = pr.doctor_id
+		WHERE pr.token_hash = ? AND pr.used_at IS NULL AND pr.expires_at > CURRENT_TIMESTAMP AND d.status = 'active'
+		FOR UPDATE`
+	var doctorID int
+	var email, storedHashedPassword string
+	err = tx.QueryRowContext(ctx, query, tokenHash).Scan(&doctorID, &email, &storedHashedPassword)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("reset token is invalid")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve password reset: %w", err)
 	}
 
-	// If successful, return nil
+	if err := setPassword(ctx, tx, doctorID, storedHashedPassword, newPassword, history); err != nil {
+		return err
+	}
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE token_hash = ?`, []interface{}{tokenHash}},
+		{`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = 'password' WHERE doctor_id = ? AND revoked_at IS NULL`, []interface{}{doctorID}},
+		{`DELETE FROM login_throttles WHERE kind = 'account' AND subject = ?`, []interface{}{email}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return fmt.Errorf("failed to reset password: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit password reset: %w", err)
+	}
 	return nil
 }
+
+// CreateAPIKey saves an API key with its permissions along with the service account its changes are recorded
+// against. It returns the key with its id, account and expiry.
+func (s *Store) CreateAPIKey(apiKey types.APIKey, keyHash string, createdBy int) (types.APIKey, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return apiKey, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, permission := range apiKey.Permissions {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM permissions WHERE name = ?)`, permission).Scan(&exists); err != nil {
+			return apiKey, fmt.Errorf("failed to check permission: %w", err)
+		}
+		if !exists {
+			return apiKey, fmt.Errorf("permission does not exist")
+		}
+	}
+
+	// The service account cannot log in, it has no password
+	query := `INSERT INTO doctors (firstname, lastname, email, password, role, status)
+		VALUES (?, 'API key', ?, '', 'integration', 'service')`
+	result, err := tx.ExecContext(ctx, query, apiKey.Name, apiKey.Prefix+"@api-keys.invalid")
+	if err != nil {
+		return apiKey, fmt.Errorf("failed to save service account: %w", err)
+	}
+	accountID, err := result.LastInsertId()
+	if err != nil {
+		return apiKey, fmt.Errorf("failed to get service account id: %w", err)
+	}
+	apiKey.AccountID = int(accountID)
+
+	query = `INSERT INTO api_keys (name, prefix, key_hash, account_id, allowed_ips, expires_at, created_by)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), IF(? > 0, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? DAY), NULL),
+		?)`
+	result, err = tx.ExecContext(ctx, query, apiKey.Name, apiKey.Prefix, keyHash, apiKey.AccountID,
+		strings.Join(apiKey.AllowedIPs, ","), apiKey.ValidDays, apiKey.ValidDays, createdBy)
+	if err != nil {
+		return apiKey, fmt.Errorf("failed to save api key: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return apiKey, fmt.Errorf("failed to get api key id: %w", err)
+	}
+	apiKey.ID = int(id)
+
+	for _, permission := range apiKey.Permissions {
+		_, err := tx.ExecContext(ctx, `INSERT INTO api_key_permissions (api_key_id, permission) VALUES (?, ?)`, apiKey.ID, permission)
+		if err != nil {
+			return apiKey, fmt.Errorf("failed to save api key permission: %w", err)
+		}
+	}
+
+	var expiresAt sql.NullTime
+	var createdByID sql.NullInt64
+	query = `SELECT expires_at, created_by, created_at FROM api_keys WHERE id = ?`
+	if err := tx.QueryRowContext(ctx, query, apiKey.ID).Scan(&expiresAt, &createdByID, &apiKey.CreatedAt); err != nil {
+		return apiKey, fmt.Errorf("failed to retrieve api key: %w", err)
+	}
+	if expiresAt.Valid {
+		apiKey.ExpiresAt = &expiresAt.Time
+	}
+	apiKey.CreatedBy = int(createdByID.Int64)
+
+	if err := tx.Commit(); err != nil {
+		return apiKey, fmt.Errorf("failed to commit api key: %w", err)
+	}
+	return apiKey, nil
+}
+
+// GetAPIKeys retrieves every API key with its permissions, newest first, including revoked and expired keys
+func (s *Store) GetAPIKeys() ([]types.APIKey, error) {
+	ctx := context.Background()
+
+	query := `SELECT k.id, k.name, k.prefix, k.account_id, COALESCE(k.allowed_ips, ''), k.expires_at, k.last_used_at,
+			COALESCE(k.last_used_ip, ''), k.created_by, k.created_at, k.revoked_at, COALESCE(kp.permission, '')
+		FROM api_keys k
+		LEFT JOIN api_key_permissions kp ON kp.api_key_id = k.id
+		ORDER BY k.created_at DESC, k.id DESC, kp.permission`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	apiKeys := []types.APIKey{}
+	for rows.Next() {
+		var apiKey types.APIKey
+		var allowedIPs, permission string
+		var expiresAt, lastUsedAt, revokedAt sql.NullTime
+		var createdBy sql.NullInt64
+		err := rows.Scan(&apiKey.ID, &apiKey.Name, &apiKey.Prefix, &apiKey.AccountID, &allowedIPs, &expiresAt, &lastUsedAt,
+			&apiKey.LastUsedIP, &createdBy, &apiKey.CreatedAt, &revokedAt, &permission)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		if len(apiKeys) == 0 || apiKeys[len(apiKeys)-1].ID != apiKey.ID {
+			apiKey.Permissions = []string{}
+			apiKey.AllowedIPs = splitAllowedIPs(allowedIPs)
+			apiKey.CreatedBy = int(createdBy.Int64)
+			if expiresAt.Valid {
+				apiKey.ExpiresAt = &expiresAt.Time
+			}
+			if lastUsedAt.Valid {
+				apiKey.LastUsedAt = &lastUsedAt.Time
+			}
+			if revokedAt.Valid {
+				apiKey.RevokedAt = &revokedAt.Time
+			}
+			apiKeys = append(apiKeys, apiKey)
+		}
+		if permission != "" {
+			last := &apiKeys[len(apiKeys)-1]
+			last.Permissions = append(last.Permissions, permission)
+		}
+	}
+	return apiKeys, rows.Err()
+}
+
+// RevokeAPIKey stops an API key from being used. Its service account is kept for the changes recorded against it.
+func (s *Store) RevokeAPIKey(apiKeyID int, revokedBy int) error {
+	ctx := context.Background()
+
+	query := `UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP, revoked_by = ?
+		WHERE id = ? AND revoked_at IS NULL`
+	result, err := s.db.ExecContext(ctx, query, revokedBy, apiKeyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check revoked api key: %w", err)
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM api_keys WHERE id = ?)`, apiKeyID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check api key: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("api key does not exist")
+	}
+	return fmt.Errorf("api key is already revoked")
+}
+
+// AuthenticateAPIKey finds the API key with the hash, when it has not been revoked or expired, and returns it
+// with the service account it acts as, carrying the permissions of the key
+func (s *Store) AuthenticateAPIKey(keyHash string) (types.APIKey, types.Actor, error) {
+	ctx := context.Background()
+
+	query := `SELECT k.id, k.name, k.prefix, k.account_id, COALESCE(k.allowed_ips, ''), d.email
+		FROM api_keys k
+		JOIN doctors d ON d.id = k.account_id
+		WHERE k.key_hash = ? AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > CURRENT_TIMESTAMP)`
+	var apiKey types.APIKey
+	var allowedIPs string
+	actor := types.Actor{Role: "integration", Permissions: []string{}}
+	err := s.db.QueryRowContext(ctx, query, keyHash).Scan(&apiKey.ID, &apiKey.Name, &apiKey.Prefix, &apiKey.AccountID, &allowedIPs, &actor.Email)
+	if err == sql.ErrNoRows {
+		return apiKey, types.Actor{}, fmt.Errorf("api key is invalid")
+	} else if err != nil {
+		return apiKey, types.Actor{}, fmt.Errorf("failed to query api key: %w", err)
+	}
+	apiKey.AllowedIPs = splitAllowedIPs(allowedIPs)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT permission FROM api_key_permissions WHERE api_key_id = ? ORDER BY permission`, apiKey.ID)
+	if err != nil {
+		return apiKey, types.Actor{}, fmt.Errorf("failed to query api key permissions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return apiKey, types.Actor{}, fmt.Errorf("failed to scan api key permission: %w", err)
+		}
+		actor.Permissions = append(actor.Permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return apiKey, types.Actor{}, fmt.Errorf("failed to read api key permissions: %w", err)
+	}
+	apiKey.Permissions = actor.Permissions
+
+	actor.ID = apiKey.AccountID
+	actor.APIKeyID = apiKey.ID
+	return apiKey, actor, nil
+}
+
+// MarkAPIKeyUsed records when and from where an API key was last used. It is written at most once a minute
+// for each address, so integrations making many requests do not write on each of them.
+func (s *Store) MarkAPIKeyUsed(apiKeyID int, ipAddress string) error {
+	query := `UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = NULLIF(?, '')
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < DATE_SUB(CURRENT_TIMESTAMP, INTERVAL 1 MINUTE)
+		OR NOT (last_used_ip <=> NULLIF(?, '')))`
+	if _, err := s.db.ExecContext(context.Background(), query, ipAddress, apiKeyID, ipAddress); err != nil {
+		return fmt.Errorf("failed to record api key use: %w", err)
+	}
+	return nil
+}
+
+// splitAllowedIPs splits the comma separated IP allowlist of an API key
+func splitAllowedIPs(allowedIPs string) []string {
+	if allowedIPs == "" {
+		return []string{}
+	}
+	return strings.Split(allowedIPs, ",")
+}
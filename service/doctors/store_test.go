@@ -0,0 +1,39 @@
+package doctors
+
+import (
+	"cema_backend/db/dbtest"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionActive(t *testing.T) {
+	db := dbtest.Open(t)
+	store := NewStore(db)
+	doctorID := dbtest.Doctor(t, db, fmt.Sprintf("session-%d@example.com", time.Now().UnixNano()))
+
+	// Test case: Only a session that is neither revoked nor past its expiry is active
+	for name, session := range map[string]struct {
+		// expiresIn is in minutes from now
+		expiresIn int
+		revoked   bool
+		active    bool
+	}{
+		"active":  {expiresIn: 60, active: true},
+		"expired": {expiresIn: -1},
+		"revoked": {expiresIn: 60, revoked: true},
+	} {
+		id := uuid.NewString()
+		_, err := db.Exec(`INSERT INTO sessions (id, doctor_id, expires_at, revoked_at)
+			VALUES (?, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? MINUTE), IF(?, CURRENT_TIMESTAMP, NULL))`,
+			id, doctorID, session.expiresIn, session.revoked)
+		require.NoError(t, err)
+
+		active, err := store.SessionActive(id)
+		require.NoError(t, err)
+		require.Equal(t, session.active, active, name)
+	}
+}
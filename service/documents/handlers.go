@@ -0,0 +1,151 @@
+package documents
+
+import (
+	"bytes"
+	"cema_backend/auth"
+	"cema_backend/logging"
+	"cema_backend/types"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kinds of documents
+const (
+	kindPrescription  = "prescription"
+	kindClientSummary = "client_summary"
+)
+
+// Handler struct contains the store for documents issued to clients and the letterhead they are printed on
+// Documents are rendered from what the clients store returns.
+type Handler struct {
+	store      types.DocumentStore
+	clients    types.ClientStore
+	letterhead Letterhead
+}
+
+// NewHandler initializes a new Handler instance with the given DocumentStore, ClientStore and facility letterhead.
+func NewHandler(store types.DocumentStore, clients types.ClientStore, letterhead Letterhead) *Handler {
+	return &Handler{store: store, clients: clients, letterhead: letterhead}
+}
+
+// sendPDF sends a rendered document to be shown in the browser
+func sendPDF(c *gin.Context, filename string, pdf *bytes.Buffer) {
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	c.Data(http.StatusOK, "application/pdf", pdf.Bytes())
+}
+
+// PrescriptionPDF handles printing a prescription, signed by the doctor who wrote it
+func (h *Handler) PrescriptionPDF(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prescription id"})
+		return
+	}
+
+	prescription, err := h.clients.GetPrescription(id)
+	if err != nil {
+		if err.Error() == "prescription does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not Found"})
+			return
+		}
+		logging.Error("Failed to Get Prescription: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving prescription"})
+		return
+	}
+	client, err := h.clients.GetClient(prescription.ClientID)
+	if err != nil {
+		logging.Error("Failed to Get Client: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving client"})
+		return
+	}
+	doctor, err := h.store.GetDoctor(prescription.DoctorID)
+	if err != nil {
+		logging.Error("Failed to Get Doctor: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving prescribing doctor"})
+		return
+	}
+
+	documentID, err := h.store.RecordDocument(types.IssuedDocument{
+		Kind: kindPrescription, ClientID: prescription.ClientID, PrescriptionID: prescription.ID, DoctorID: doctor.ID,
+	})
+	if err != nil {
+		logging.Error("Failed to Record Document: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error issuing document"})
+		return
+	}
+
+	var pdf bytes.Buffer
+	if err := renderPrescription(&pdf, h.letterhead, documentID, time.Now(), prescription, client, doctor); err != nil {
+		logging.Error("Failed to Render Prescription: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rendering prescription"})
+		return
+	}
+	sendPDF(c, fmt.Sprintf("prescription-%d.pdf", prescription.ID), &pdf)
+}
+
+// ClientSummaryPDF handles printing a summary of a client for a referral, signed by the signed in doctor
+func (h *Handler) ClientSummaryPDF(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	clientID := c.Param("id")
+
+	client, err := h.clients.GetClient(clientID)
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		logging.Error("Failed to Get Client: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving client"})
+		return
+	}
+	medications, err := h.clients.GetActiveMedications(clientID)
+	if err != nil {
+		logging.Error("Failed to Get Active Medications: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving active medications"})
+		return
+	}
+	doctor, err := h.store.GetDoctor(actor.ID)
+	if err != nil {
+		logging.Error("Failed to Get Doctor: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving doctor"})
+		return
+	}
+
+	documentID, err := h.store.RecordDocument(types.IssuedDocument{Kind: kindClientSummary, ClientID: client.UUID, DoctorID: doctor.ID})
+	if err != nil {
+		logging.Error("Failed to Record Document: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error issuing document"})
+		return
+	}
+
+	var pdf bytes.Buffer
+	if err := renderClientSummary(&pdf, h.letterhead, documentID, time.Now(), client, medications, doctor); err != nil {
+		logging.Error("Failed to Render Client Summary: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rendering client summary"})
+		return
+	}
+	sendPDF(c, "client-summary-"+client.UUID+".pdf", &pdf)
+}
+
+// VerifyDocument handles checking the id from the QR code of a printed document.
+// It only tells what was issued, when and by whom, nothing about the client.
+func (h *Handler) VerifyDocument(c *gin.Context) {
+	document, err := h.store.GetIssuedDocument(c.Param("id"))
+	if err != nil {
+		if err.Error() == "document does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not Found, it was not issued by this facility"})
+			return
+		}
+		logging.Error("Failed to Verify Document: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error verifying document"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"facility": h.letterhead.Name, "document": document})
+}
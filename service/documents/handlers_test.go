@@ -0,0 +1,193 @@
+package documents
+
+import (
+	"cema_backend/auth"
+	"cema_backend/types"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockDocumentStore is a mock implementation of the DocumentStore interface.
+type MockDocumentStore struct {
+	mock.Mock
+}
+
+func (m *MockDocumentStore) GetDoctor(id int) (types.Doctor, error) {
+	args := m.Called(id)
+	return args.Get(0).(types.Doctor), args.Error(1)
+}
+
+func (m *MockDocumentStore) RecordDocument(document types.IssuedDocument) (string, error) {
+	args := m.Called(document)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockDocumentStore) GetIssuedDocument(id string) (types.IssuedDocument, error) {
+	args := m.Called(id)
+	return args.Get(0).(types.IssuedDocument), args.Error(1)
+}
+
+// MockClientStore mocks the reads of the ClientStore documents are rendered from,
+// the other methods are not called by the documents handler.
+type MockClientStore struct {
+	mock.Mock
+	types.ClientStore
+}
+
+func (m *MockClientStore) GetPrescription(id int) (types.Prescription, error) {
+	args := m.Called(id)
+	return args.Get(0).(types.Prescription), args.Error(1)
+}
+
+func (m *MockClientStore) GetClient(clientID string) (types.ClientResponse, error) {
+	args := m.Called(clientID)
+	return args.Get(0).(types.ClientResponse), args.Error(1)
+}
+
+func (m *MockClientStore) GetActiveMedications(clientID string) ([]types.Medication, error) {
+	args := m.Called(clientID)
+	return args.Get(0).([]types.Medication), args.Error(1)
+}
+
+func TestPrescriptionPDF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDocumentStore)
+	mockClients := new(MockClientStore)
+	handler := NewHandler(mockStore, mockClients, Letterhead{Name: "CEMA Health Facility", Address: "Moi Avenue, Nairobi", VerifyURL: "https://cema.example/verify/"})
+
+	router := gin.Default()
+	router.GET("/prescriptions/:id", handler.PrescriptionPDF)
+
+	// Test case: The prescription is issued and rendered as a PDF
+	clientID := "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11"
+	mockClients.On("GetPrescription", 12).Return(types.Prescription{
+		ID: 12, ClientID: clientID, DoctorID: 1, Status: "active", Refills: 1, RefillsRemaining: 1,
+		DateIssued: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), ValidUntil: time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+		Items: []types.PrescriptionItem{
+			{DrugID: 4, Drug: "Amoxicillin", Strength: "500 mg", Dose: 1, Unit: "capsule", Route: "oral", Frequency: "TDS", DurationDays: 5, Quantity: 15, Instructions: "After meals"},
+		},
+	}, nil).Once()
+	mockClients.On("GetClient", clientID).Return(types.ClientResponse{UUID: clientID, FirstName: "Wanjiku", LastName: "Kamau", Age: 34, Sex: "female"}, nil).Once()
+	mockStore.On("GetDoctor", 1).Return(types.Doctor{ID: 1, FirstName: "Achieng", LastName: "Otieno", RegistrationNumber: "A12345"}, nil).Once()
+	mockStore.On("RecordDocument", types.IssuedDocument{Kind: "prescription", ClientID: clientID, PrescriptionID: 12, DoctorID: 1}).
+		Return("9b2e4c1a-5d6f-4a8b-9c0d-1e2f3a4b5c6d", nil).Once()
+
+	req, _ := http.NewRequest(http.MethodGet, "/prescriptions/12", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "application/pdf", resp.Header().Get("Content-Type"))
+	require.True(t, strings.HasPrefix(resp.Body.String(), "%PDF-"))
+	mockStore.AssertExpectations(t)
+	mockClients.AssertExpectations(t)
+
+	// Test case: Prescription not found
+	mockClients.On("GetPrescription", 99).Return(types.Prescription{}, errors.New("prescription does not exist")).Once()
+
+	req, _ = http.NewRequest(http.MethodGet, "/prescriptions/99", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	mockStore.AssertNumberOfCalls(t, "RecordDocument", 1)
+}
+
+func TestVerifyDocument(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDocumentStore)
+	handler := NewHandler(mockStore, new(MockClientStore), Letterhead{Name: "CEMA Health Facility"})
+
+	router := gin.Default()
+	router.GET("/verify/:id", handler.VerifyDocument)
+
+	// Test case: A document that was issued here is confirmed without the client's details
+	mockStore.On("GetIssuedDocument", "9b2e4c1a-5d6f-4a8b-9c0d-1e2f3a4b5c6d").Return(types.IssuedDocument{
+		ID: "9b2e4c1a-5d6f-4a8b-9c0d-1e2f3a4b5c6d", Kind: "prescription", ClientID: "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11",
+		PrescriptionID: 12, Doctor: "Achieng Otieno", RegistrationNumber: "A12345",
+	}, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodGet, "/verify/9b2e4c1a-5d6f-4a8b-9c0d-1e2f3a4b5c6d", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), "A12345")
+	require.NotContains(t, resp.Body.String(), "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11")
+
+	// Test case: Unknown documents were not issued here
+	mockStore.On("GetIssuedDocument", "forged").Return(types.IssuedDocument{}, errors.New("document does not exist")).Once()
+
+	req, _ = http.NewRequest(http.MethodGet, "/verify/forged", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+// documentKeys is an APIKeyStore with one key, granted the permissions
+type documentKeys struct {
+	hash        string
+	permissions []string
+}
+
+func (s documentKeys) AuthenticateAPIKey(keyHash string) (types.APIKey, types.Actor, error) {
+	if keyHash != s.hash {
+		return types.APIKey{}, types.Actor{}, errors.New("api key is invalid")
+	}
+	apiKey := types.APIKey{ID: 1, AccountID: 40, Permissions: s.permissions}
+	return apiKey, types.Actor{ID: 40, Role: "integration", Permissions: apiKey.Permissions, APIKeyID: apiKey.ID}, nil
+}
+
+func (s documentKeys) MarkAPIKeyUsed(apiKeyID int, ipAddress string) error {
+	return nil
+}
+
+func TestClientSummaryNeedsClinician(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockDocumentStore)
+	mockClients := new(MockClientStore)
+	router := gin.New()
+	NewHandler(mockStore, mockClients, Letterhead{Name: "CEMA Health Facility"}).RegisterRoutes(router.Group("/documents"))
+
+	summary := func(permissions []string) *httptest.ResponseRecorder {
+		key, _, hash, err := auth.NewAPIKey()
+		require.NoError(t, err)
+		auth.UseAPIKeys(documentKeys{hash: hash, permissions: permissions})
+
+		req, _ := http.NewRequest(http.MethodGet, "/documents/clients/3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11/summary", nil)
+		req.Header.Set(auth.APIKeyHeader, key)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	defer auth.UseAPIKeys(nil)
+
+	// Test case: Printing documents does not extend to issuing a summary, as a records clerk cannot
+	resp := summary([]string{"documents:read"})
+
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	// Test case: A summary is never issued in the name of an API key's service account
+	resp = summary([]string{"documents:read", "documents:summary"})
+
+	require.Equal(t, http.StatusForbidden, resp.Code)
+	require.JSONEq(t, `{"error": "API keys cannot be used here"}`, resp.Body.String())
+	mockClients.AssertNotCalled(t, "GetClient", mock.Anything)
+	mockStore.AssertNotCalled(t, "RecordDocument", mock.Anything)
+}
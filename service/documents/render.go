@@ -0,0 +1,303 @@
+package documents
+
+import (
+	"bytes"
+	"cema_backend/types"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/skip2/go-qrcode"
+)
+
+// Letterhead is the facility printed at the top of every document
+type Letterhead struct {
+	Name    string
+	Address string
+	Phone   string
+	Email   string
+	// VerifyURL is the URL the QR code links to with the document id appended, empty encodes the id only
+	VerifyURL string
+}
+
+// verification is what the QR code of a document encodes
+func (l Letterhead) verification(id string) string {
+	if l.VerifyURL == "" {
+		return id
+	}
+	return strings.TrimSuffix(l.VerifyURL, "/") + "/" + id
+}
+
+// Page layout in millimetres, A4 portrait
+const (
+	pageMargin   = 15
+	contentWidth = 180
+	qrSize       = 28
+	lineHeight   = 6
+)
+
+// document is a PDF being written with the facility letterhead
+type document struct {
+	pdf *fpdf.Fpdf
+	// tr converts UTF-8 text to the encoding of the core fonts
+	tr func(string) string
+}
+
+// newDocument starts a document with the letterhead, the title and the QR code verifying it
+func newDocument(letterhead Letterhead, title string, id string, issuedAt time.Time) (*document, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(true, pageMargin+10)
+	d := &document{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor("")}
+
+	qr, err := qrcode.Encode(letterhead.verification(id), qrcode.Medium, 256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	pdf.RegisterImageOptionsReader("qr", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qr))
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pageMargin - 5)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.SetTextColor(100, 100, 100)
+		footer := fmt.Sprintf("Document %s issued %s, scan the QR code to verify it. Page %d/{nb}", id, issuedAt.Format("02/01/2006 15:04"), pdf.PageNo())
+		pdf.CellFormat(0, 5, d.tr(footer), "", 0, "C", false, 0, "")
+	})
+	pdf.AliasNbPages("")
+	pdf.AddPage()
+
+	// Letterhead on the left, QR code on the right
+	pdf.ImageOptions("qr", pageMargin+contentWidth-qrSize, pageMargin, qrSize, qrSize, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(contentWidth-qrSize, 8, d.tr(letterhead.Name), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	for _, line := range []string{letterhead.Address, letterhead.Phone, letterhead.Email} {
+		if line != "" {
+			pdf.CellFormat(contentWidth-qrSize, 5, d.tr(line), "", 1, "L", false, 0, "")
+		}
+	}
+	pdf.SetY(pageMargin + qrSize + 2)
+	pdf.Line(pageMargin, pdf.GetY(), pageMargin+contentWidth, pdf.GetY())
+	pdf.Ln(4)
+
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(contentWidth, 8, d.tr(title), "", 1, "C", false, 0, "")
+	pdf.Ln(2)
+	return d, nil
+}
+
+// heading writes a section heading
+func (d *document) heading(text string) {
+	d.pdf.Ln(3)
+	d.pdf.SetFont("Helvetica", "B", 11)
+	d.pdf.SetFillColor(230, 230, 230)
+	d.pdf.CellFormat(contentWidth, 7, d.tr(text), "", 1, "L", true, 0, "")
+	d.pdf.Ln(1)
+}
+
+// field writes a label and its value on one line, empty values are left out
+func (d *document) field(label, value string) {
+	if value == "" {
+		return
+	}
+	d.pdf.SetFont("Helvetica", "B", 10)
+	d.pdf.CellFormat(45, lineHeight, d.tr(label), "", 0, "L", false, 0, "")
+	d.pdf.SetFont("Helvetica", "", 10)
+	d.pdf.MultiCell(contentWidth-45, lineHeight, d.tr(value), "", "L", false)
+}
+
+// text writes a paragraph
+func (d *document) text(value string) {
+	d.pdf.SetFont("Helvetica", "", 10)
+	d.pdf.MultiCell(contentWidth, lineHeight, d.tr(value), "", "L", false)
+}
+
+// signature writes the issuing doctor with space to sign
+func (d *document) signature(doctor types.Doctor) {
+	d.pdf.Ln(12)
+	d.pdf.Line(pageMargin, d.pdf.GetY(), pageMargin+70, d.pdf.GetY())
+	d.pdf.Ln(1)
+	d.pdf.SetFont("Helvetica", "B", 10)
+	d.pdf.CellFormat(contentWidth, 5, d.tr("Dr. "+doctor.FirstName+" "+doctor.LastName), "", 1, "L", false, 0, "")
+	d.pdf.SetFont("Helvetica", "", 9)
+	if doctor.RegistrationNumber != "" {
+		d.pdf.CellFormat(contentWidth, 5, d.tr("Registration No. "+doctor.RegistrationNumber), "", 1, "L", false, 0, "")
+	}
+	if doctor.Department != "" {
+		d.pdf.CellFormat(contentWidth, 5, d.tr(doctor.Department), "", 1, "L", false, 0, "")
+	}
+}
+
+// clientDetails writes who the document is about
+func (d *document) clientDetails(client types.ClientResponse) {
+	d.field("Name", client.FirstName+" "+client.LastName)
+	age := fmt.Sprintf("%d years", client.Age)
+	if client.DOBEstimated {
+		age += " (estimated)"
+	}
+	d.field("Age", age)
+	d.field("Sex", client.Sex)
+	d.field("Phone number", client.PhoneNumber)
+}
+
+// describeItem is how an item of a prescription is printed, e.g. "Amoxicillin 500 mg - 1 capsule oral TDS for 5 days"
+func describeItem(item types.PrescriptionItem) string {
+	drug := strings.TrimSpace(item.Drug + " " + item.Strength)
+	dosing := fmt.Sprintf("%s %s %s %s", formatAmount(item.Dose), item.Unit, item.Route, item.Frequency)
+	if item.DurationDays > 0 {
+		dosing += fmt.Sprintf(" for %d days", item.DurationDays)
+	}
+	return drug + " - " + strings.Join(strings.Fields(dosing), " ")
+}
+
+// formatAmount prints a dose without trailing zeros
+func formatAmount(value float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", value), "0"), ".")
+}
+
+// renderPrescription writes a prescription as a PDF
+func renderPrescription(w io.Writer, letterhead Letterhead, id string, issuedAt time.Time,
+	prescription types.Prescription, client types.ClientResponse, doctor types.Doctor) error {
+	d, err := newDocument(letterhead, "Prescription", id, issuedAt)
+	if err != nil {
+		return err
+	}
+
+	d.heading("Patient")
+	d.clientDetails(client)
+	if len(client.Allergies) > 0 {
+		var allergies []string
+		for _, allergy := range client.Allergies {
+			allergies = append(allergies, allergy.Substance)
+		}
+		d.field("Allergies", strings.Join(allergies, ", "))
+	}
+
+	d.heading("Prescription")
+	d.field("Prescription No.", fmt.Sprintf("%d", prescription.ID))
+	d.field("Date issued", prescription.DateIssued.Format("02/01/2006"))
+	d.field("Valid until", prescription.ValidUntil.Format("02/01/2006"))
+	if prescription.Refills > 0 {
+		d.field("Refills", fmt.Sprintf("%d (%d remaining)", prescription.Refills, prescription.RefillsRemaining))
+	}
+	d.field("Status", strings.ReplaceAll(prescription.Status, "_", " "))
+	d.pdf.Ln(2)
+
+	// Items as a table
+	d.pdf.SetFont("Helvetica", "B", 10)
+	d.pdf.CellFormat(10, 7, "#", "B", 0, "L", false, 0, "")
+	d.pdf.CellFormat(140, 7, d.tr("Medicine and directions"), "B", 0, "L", false, 0, "")
+	d.pdf.CellFormat(30, 7, d.tr("Quantity"), "B", 1, "R", false, 0, "")
+	for i, item := range prescription.Items {
+		d.pdf.SetFont("Helvetica", "", 10)
+		d.pdf.CellFormat(10, lineHeight, fmt.Sprintf("%d", i+1), "", 0, "L", false, 0, "")
+		x, y := d.pdf.GetXY()
+		d.pdf.MultiCell(140, lineHeight, d.tr(describeItem(item)), "", "L", false)
+		if item.Instructions != "" {
+			d.pdf.SetX(x)
+			d.pdf.SetFont("Helvetica", "I", 9)
+			d.pdf.MultiCell(140, 5, d.tr(item.Instructions), "", "L", false)
+		}
+		bottom := d.pdf.GetY()
+		d.pdf.SetXY(x+140, y)
+		d.pdf.SetFont("Helvetica", "", 10)
+		d.pdf.CellFormat(30, lineHeight, d.tr(fmt.Sprintf("%d %s", item.Quantity, item.Unit)), "", 0, "R", false, 0, "")
+		d.pdf.SetXY(pageMargin, bottom+1)
+	}
+
+	d.signature(doctor)
+	return d.pdf.Output(w)
+}
+
+// renderClientSummary writes a summary of a client for referrals as a PDF
+func renderClientSummary(w io.Writer, letterhead Letterhead, id string, issuedAt time.Time,
+	client types.ClientResponse, medications []types.Medication, doctor types.Doctor) error {
+	d, err := newDocument(letterhead, "Client Summary", id, issuedAt)
+	if err != nil {
+		return err
+	}
+
+	d.heading("Demographics")
+	d.clientDetails(client)
+	d.field("Date of birth", client.DateOfBirth)
+	d.field("National ID", client.NationalID)
+	d.field("Birth certificate", client.BirthCertificateNumber)
+	var location []string
+	for _, part := range []string{client.Ward, client.SubCounty, client.County} {
+		if part != "" {
+			location = append(location, part)
+		}
+	}
+	d.field("Location", strings.Join(location, ", "))
+	if client.EmergencyContact != "" {
+		d.field("Emergency contact", strings.TrimSpace(client.EmergencyContact+" "+client.EmergencyNumber))
+	}
+
+	if vitals := client.Vitals; vitals != nil {
+		d.heading("Latest vitals")
+		if vitals.Height != nil {
+			d.field("Height", formatAmount(*vitals.Height)+" cm")
+		}
+		if vitals.Weight != nil {
+			d.field("Weight", formatAmount(*vitals.Weight)+" kg")
+		}
+		if vitals.BMI != nil {
+			d.field("BMI", fmt.Sprintf("%.1f", *vitals.BMI))
+		}
+		if vitals.SystolicBP != nil && vitals.DiastolicBP != nil {
+			d.field("Blood pressure", fmt.Sprintf("%d/%d mmHg", *vitals.SystolicBP, *vitals.DiastolicBP))
+		}
+		if vitals.Temperature != nil {
+			d.field("Temperature", formatAmount(*vitals.Temperature)+" °C")
+		}
+		if vitals.Pulse != nil {
+			d.field("Pulse", fmt.Sprintf("%d bpm", *vitals.Pulse))
+		}
+		if vitals.SpO2 != nil {
+			d.field("SpO2", fmt.Sprintf("%d %%", *vitals.SpO2))
+		}
+	}
+
+	d.heading("Programs")
+	if len(client.Programs) == 0 {
+		d.text("Not enrolled in any program")
+	}
+	for _, program := range client.Programs {
+		d.text("- " + program.Name)
+	}
+
+	d.heading("Allergies")
+	if len(client.Allergies) == 0 {
+		d.text("No known allergies")
+	}
+	for _, allergy := range client.Allergies {
+		line := fmt.Sprintf("- %s: %s, %s", allergy.Substance, allergy.Kind, allergy.Severity)
+		if allergy.Reaction != "" {
+			line += " (" + allergy.Reaction + ")"
+		}
+		d.text(line)
+	}
+
+	d.heading("Diagnoses")
+	if len(client.Diagnoses) == 0 {
+		d.text("No diagnoses recorded")
+	}
+	for _, diagnosis := range client.Diagnoses {
+		d.text(fmt.Sprintf("- %s %s %s, %s on %s", diagnosis.System, diagnosis.Code, diagnosis.Description,
+			diagnosis.Certainty, diagnosis.DiagnosedAt.Format("02/01/2006")))
+	}
+
+	d.heading("Active medications")
+	if len(medications) == 0 {
+		d.text("No active medications")
+	}
+	for _, medication := range medications {
+		d.text(fmt.Sprintf("- %s (prescribed %s, %s)", describeItem(medication.PrescriptionItem),
+			medication.DateIssued.Format("02/01/2006"), strings.ReplaceAll(medication.Status, "_", " ")))
+	}
+
+	d.signature(doctor)
+	return d.pdf.Output(w)
+}
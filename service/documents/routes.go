@@ -0,0 +1,22 @@
+// This file contains the endpoints for the documents service.
+package documents
+
+import (
+	"cema_backend/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	// Public routes, anyone holding a printed document can verify it
+	router.GET("/verify/:id", h.VerifyDocument)
+
+	// Protected routes, every clinical role can print prescriptions. A client summary is signed by whoever issues it,
+	// so only clinicians can and never with an API key.
+	protected := router.Group("/")
+	protected.Use(auth.AuthMiddleware())
+	{
+		protected.GET("/prescriptions/:id", auth.RequirePermission("documents:read"), h.PrescriptionPDF)
+		protected.GET("/clients/:id/summary", auth.RequirePermission("documents:summary"), auth.RejectAPIKeys(), h.ClientSummaryPDF)
+	}
+}
@@ -0,0 +1,86 @@
+package documents
+
+import (
+	"cema_backend/types"
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore initializes a new Store instance with the given database connection.
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// GetDoctor retrieves the details printed about a doctor
+func (s *Store) GetDoctor(id int) (types.Doctor, error) {
+	ctx := context.Background()
+
+	query := `SELECT id, firstname, lastname, COALESCE(department, ''), COALESCE(registration_number, '') FROM doctors WHERE id = ?`
+	var doctor types.Doctor
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&doctor.ID, &doctor.FirstName, &doctor.LastName, &doctor.Department, &doctor.RegistrationNumber)
+	if err == sql.ErrNoRows {
+		return doctor, fmt.Errorf("doctor does not exist")
+	} else if err != nil {
+		return doctor, fmt.Errorf("failed to retrieve doctor: %w", err)
+	}
+	return doctor, nil
+}
+
+// RecordDocument registers a document being printed so it can be verified later.
+// It returns the id to encode in the document.
+func (s *Store) RecordDocument(document types.IssuedDocument) (string, error) {
+	ctx := context.Background()
+
+	id := uuid.New().String()
+	var prescriptionID interface{}
+	if document.PrescriptionID != 0 {
+		prescriptionID = document.PrescriptionID
+	}
+	var doctorID interface{}
+	if document.DoctorID != 0 {
+		doctorID = document.DoctorID
+	}
+
+	query := `INSERT INTO issued_documents (id, kind, client_id, prescription_id, doctor_id)
+		SELECT ?, ?, id, ?, ? FROM clients WHERE uuid = ?`
+	result, err := s.db.ExecContext(ctx, query, id, document.Kind, prescriptionID, doctorID, document.ClientID)
+	if err != nil {
+		return "", fmt.Errorf("failed to save issued document: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return "", fmt.Errorf("client does not exist")
+	}
+	return id, nil
+}
+
+// GetIssuedDocument retrieves a document that was printed with the doctor who issued it
+func (s *Store) GetIssuedDocument(id string) (types.IssuedDocument, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT d.id, d.kind, c.uuid, COALESCE(d.prescription_id, 0), COALESCE(d.doctor_id, 0),
+			COALESCE(CONCAT(doc.firstname, ' ', doc.lastname), ''), COALESCE(doc.registration_number, ''), d.issued_at
+		FROM issued_documents d
+		JOIN clients c ON c.id = d.client_id
+		LEFT JOIN doctors doc ON doc.id = d.doctor_id
+		WHERE d.id = ?
+	`
+	var document types.IssuedDocument
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&document.ID, &document.Kind, &document.ClientID, &document.PrescriptionID,
+		&document.DoctorID, &document.Doctor, &document.RegistrationNumber, &document.IssuedAt)
+	if err == sql.ErrNoRows {
+		return document, fmt.Errorf("document does not exist")
+	} else if err != nil {
+		return document, fmt.Errorf("failed to retrieve issued document: %w", err)
+	}
+	return document, nil
+}
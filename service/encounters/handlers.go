@@ -0,0 +1,145 @@
+package encounters
+
+import (
+	"cema_backend/auth"
+	"cema_backend/logging"
+	"cema_backend/types"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler struct contains the store for the encounters of clients' visits
+type Handler struct {
+	store types.EncounterStore
+}
+
+// NewHandler initializes a new Handler instance with the given EncounterStore.
+func NewHandler(store types.EncounterStore) *Handler {
+	return &Handler{store: store}
+}
+
+// CreateEncounter handles recording a visit of a client by the signed in doctor.
+// encountered_at defaults to now and cannot be in the future.
+func (h *Handler) CreateEncounter(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	var request types.Encounter
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	if request.ClientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Client ID is required"})
+		return
+	}
+
+	now := time.Now()
+	if request.EncounteredAt.IsZero() {
+		request.EncounteredAt = now
+	} else if request.EncounteredAt.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Encounter time cannot be in the future"})
+		return
+	}
+
+	id, err := h.store.CreateEncounter(types.Encounter{
+		ClientID:      request.ClientID,
+		Program:       request.Program,
+		EncounteredAt: request.EncounteredAt,
+		Notes:         request.Notes,
+	}, actor.ID)
+	if err != nil {
+		switch err.Error() {
+		case "client does not exist":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+		case "program does not exist":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Program not Found"})
+		default:
+			logging.Error("Failed to Create Encounter: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating encounter"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Encounter created successfully", "id": id})
+}
+
+// GetEncounter handles the request for a single encounter with its prescriptions
+func (h *Handler) GetEncounter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid encounter ID"})
+		return
+	}
+
+	encounter, err := h.store.GetEncounter(id)
+	if err != nil {
+		if err.Error() == "encounter does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Encounter not Found"})
+			return
+		}
+		logging.Error("Failed to Get Encounter: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving encounter"})
+		return
+	}
+	c.JSON(http.StatusOK, encounter)
+}
+
+// GetEncountersByClient handles the request for the encounter timeline of the client in client_id
+func (h *Handler) GetEncountersByClient(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Client ID is required"})
+		return
+	}
+
+	encounters, err := h.store.GetEncountersByClient(clientID)
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		logging.Error("Failed to Get Encounters: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving encounters"})
+		return
+	}
+	c.JSON(http.StatusOK, encounters)
+}
+
+// UpdateEncounterNotes handles the doctor who recorded an encounter replacing its SOAP notes,
+// the previous notes are kept as an amendment
+func (h *Handler) UpdateEncounterNotes(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid encounter ID"})
+		return
+	}
+
+	var notes types.SOAPNotes
+	if err := c.ShouldBindJSON(&notes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+
+	if err := h.store.UpdateEncounterNotes(id, notes, actor.ID); err != nil {
+		switch err.Error() {
+		case "encounter does not exist":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Encounter not Found"})
+		case "encounter was recorded by another doctor":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the doctor who recorded the encounter can edit its notes"})
+		default:
+			logging.Error("Failed to Update Encounter Notes: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating encounter notes"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Encounter notes updated successfully"})
+}
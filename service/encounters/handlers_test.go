@@ -0,0 +1,196 @@
+package encounters
+
+import (
+	"bytes"
+	"cema_backend/auth"
+	"cema_backend/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockEncounterStore is a mock implementation of the EncounterStore interface.
+type MockEncounterStore struct {
+	mock.Mock
+}
+
+func (m *MockEncounterStore) CreateEncounter(encounter types.Encounter, recordedBy int) (int, error) {
+	args := m.Called(encounter, recordedBy)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockEncounterStore) GetEncounter(id int) (types.Encounter, error) {
+	args := m.Called(id)
+	return args.Get(0).(types.Encounter), args.Error(1)
+}
+
+func (m *MockEncounterStore) GetEncountersByClient(clientID string) ([]types.Encounter, error) {
+	args := m.Called(clientID)
+	return args.Get(0).([]types.Encounter), args.Error(1)
+}
+
+func (m *MockEncounterStore) UpdateEncounterNotes(id int, notes types.SOAPNotes, amendedBy int) error {
+	args := m.Called(id, notes, amendedBy)
+	return args.Error(0)
+}
+
+func TestCreateEncounter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockEncounterStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	// Stands in for the auth middleware setting the authenticated doctor
+	router.Use(func(c *gin.Context) {
+		auth.SetActor(c, types.Actor{ID: 1, Email: "stan@rfh.com", Role: "doctor"})
+	})
+	router.POST("/", handler.CreateEncounter)
+
+	clientID := "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11"
+	notes := types.SOAPNotes{
+		Subjective: "Cough for two weeks",
+		Objective:  "Temperature 38.1",
+		Assessment: "Suspected TB",
+		Plan:       "Sputum test",
+	}
+
+	// Test case: Successful encounter
+	mockStore.On("CreateEncounter", types.Encounter{
+		ClientID:      clientID,
+		Program:       "TB",
+		EncounteredAt: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC),
+		Notes:         notes,
+	}, 1).Return(3, nil).Once()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"client_id":      clientID,
+		"program":        "TB",
+		"encountered_at": "2024-05-01T09:30:00Z",
+		"notes":          notes,
+	})
+	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var response struct {
+		ID int `json:"id"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, 3, response.ID)
+
+	// Test case: Missing client and future encounters are rejected before anything is saved
+	for _, payload := range []string{`{"notes": {"plan": "Review"}}`, `{"client_id": "` + clientID + `", "encountered_at": "2999-01-01T00:00:00Z"}`} {
+		req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, payload)
+	}
+	mockStore.AssertNumberOfCalls(t, "CreateEncounter", 1)
+
+	// Test case: Unknown program
+	mockStore.On("CreateEncounter", mock.Anything, 1).Return(0, fmt.Errorf("program does not exist")).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"client_id": "`+clientID+`", "program": "Unknown"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestGetEncounter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockEncounterStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.GET("/:id", handler.GetEncounter)
+
+	encounter := types.Encounter{
+		ID:       3,
+		ClientID: "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11",
+		Notes:    types.SOAPNotes{Assessment: "Malaria"},
+		Prescriptions: []types.Prescription{
+			{ID: 9, ClientID: "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11", EncounterID: 3, Items: []types.PrescriptionItem{{Drug: "Artemether/Lumefantrine", Dose: 4, Unit: "tablet", Route: "oral", Frequency: "BD", DurationDays: 3, Quantity: 24}}},
+		},
+	}
+
+	// Test case: The encounter is returned with its prescriptions
+	mockStore.On("GetEncounter", 3).Return(encounter, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodGet, "/3", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var response types.Encounter
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, "Malaria", response.Notes.Assessment)
+	require.Len(t, response.Prescriptions, 1)
+
+	// Test case: Encounter does not exist
+	mockStore.On("GetEncounter", 4).Return(types.Encounter{}, fmt.Errorf("encounter does not exist")).Once()
+
+	req, _ = http.NewRequest(http.MethodGet, "/4", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestUpdateEncounterNotes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockEncounterStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		auth.SetActor(c, types.Actor{ID: 1, Email: "stan@rfh.com", Role: "doctor"})
+	})
+	router.PUT("/:id/notes", handler.UpdateEncounterNotes)
+
+	notes := types.SOAPNotes{Assessment: "Malaria, confirmed by RDT", Plan: "Artemether/Lumefantrine"}
+
+	// Test case: The author edits the notes, the store keeps the previous ones
+	mockStore.On("UpdateEncounterNotes", 3, notes, 1).Return(nil).Once()
+
+	body, _ := json.Marshal(notes)
+	req, _ := http.NewRequest(http.MethodPut, "/3/notes", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: Another doctor cannot overwrite the notes
+	mockStore.On("UpdateEncounterNotes", 4, notes, 1).Return(fmt.Errorf("encounter was recorded by another doctor")).Once()
+
+	req, _ = http.NewRequest(http.MethodPut, "/4/notes", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusForbidden, resp.Code)
+	mockStore.AssertExpectations(t)
+}
@@ -0,0 +1,17 @@
+// This file contains the endpoints for the encounters service.
+package encounters
+
+import (
+	"cema_backend/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	// Nurses can read encounters, only doctors open them and write their notes
+	router.Use(auth.AuthMiddleware())
+	router.POST("/", auth.RequirePermission("encounters:write"), h.CreateEncounter)
+	router.GET("/", auth.RequirePermission("encounters:read"), h.GetEncountersByClient)
+	router.GET("/:id", auth.RequirePermission("encounters:read"), h.GetEncounter)
+	router.PUT("/:id/notes", auth.RequirePermission("encounters:write"), h.UpdateEncounterNotes)
+}
@@ -0,0 +1,269 @@
+package encounters
+
+import (
+	"cema_backend/db"
+	"cema_backend/types"
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore initializes a new Store instance with the given database connection.
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// encounterColumns are the columns scanned by scanEncounter
+const encounterColumns = `e.id, c.uuid, e.doctor_id, COALESCE(p.name, ''), e.encountered_at,
+	COALESCE(e.subjective, ''), COALESCE(e.objective, ''), COALESCE(e.assessment, ''), COALESCE(e.plan, '')`
+
+// encounterJoins joins the client and program of an encounter
+const encounterJoins = `FROM encounters e
+	JOIN clients c ON c.id = e.client_id
+	LEFT JOIN programs p ON p.id = e.program_id`
+
+// scanEncounter scans a row selected with encounterColumns
+func scanEncounter(row interface{ Scan(...interface{}) error }) (types.Encounter, error) {
+	var encounter types.Encounter
+	var doctorID sql.NullInt64
+	err := row.Scan(&encounter.ID, &encounter.ClientID, &doctorID, &encounter.Program, &encounter.EncounteredAt,
+		&encounter.Notes.Subjective, &encounter.Notes.Objective, &encounter.Notes.Assessment, &encounter.Notes.Plan)
+	encounter.DoctorID = int(doctorID.Int64)
+	return encounter, err
+}
+
+// CreateEncounter saves a visit of a client, seen by the doctor whose id is recordedBy.
+// Merged and archived clients cannot be seen, the program is optional but must exist when given.
+// It returns the id of the new encounter.
+func (s *Store) CreateEncounter(encounter types.Encounter, recordedBy int) (int, error) {
+	ctx := context.Background()
+
+	var programID interface{}
+	if encounter.Program != "" {
+		var id int
+		err := s.db.QueryRowContext(ctx, `SELECT id FROM programs WHERE name = ?`, encounter.Program).Scan(&id)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("program does not exist")
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to retrieve program: %w", err)
+		}
+		programID = id
+	}
+
+	query := `INSERT INTO encounters (client_id, doctor_id, program_id, encountered_at, subjective, objective, assessment, plan)
+		SELECT id, ?, ?, ?, ?, ?, ?, ?
+		FROM clients WHERE uuid = ? AND merged_into IS NULL AND archived_at IS NULL`
+	notes := encounter.Notes
+	result, err := s.db.ExecContext(ctx, query, recordedBy, programID, encounter.EncounteredAt,
+		notes.Subjective, notes.Objective, notes.Assessment, notes.Plan, encounter.ClientID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save encounter: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("failed to save encounter: %w", err)
+	} else if rows == 0 {
+		return 0, fmt.Errorf("client does not exist")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve encounter id: %w", err)
+	}
+	return int(id), nil
+}
+
+// GetEncounter retrieves an encounter with the diagnoses and prescriptions made during it
+func (s *Store) GetEncounter(id int) (types.Encounter, error) {
+	ctx := context.Background()
+
+	row := s.db.QueryRowContext(ctx, `SELECT `+encounterColumns+` `+encounterJoins+` WHERE e.id = ?`, id)
+	encounter, err := scanEncounter(row)
+	if err == sql.ErrNoRows {
+		return encounter, fmt.Errorf("encounter does not exist")
+	} else if err != nil {
+		return encounter, fmt.Errorf("failed to retrieve encounter: %w", err)
+	}
+
+	// The notes the current ones replaced, the most recent first
+	query := `SELECT subjective, objective, assessment, plan, amended_by, amended_at
+		FROM encounter_amendments WHERE encounter_id = ? ORDER BY amended_at DESC, id DESC`
+	amendments, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return encounter, fmt.Errorf("failed to retrieve encounter amendments: %w", err)
+	}
+	defer amendments.Close()
+
+	for amendments.Next() {
+		var amendment types.NotesAmendment
+		var amendedBy sql.NullInt64
+		err := amendments.Scan(&amendment.Notes.Subjective, &amendment.Notes.Objective, &amendment.Notes.Assessment,
+			&amendment.Notes.Plan, &amendedBy, &amendment.AmendedAt)
+		if err != nil {
+			return encounter, err
+		}
+		amendment.AmendedBy = int(amendedBy.Int64)
+		encounter.Amendments = append(encounter.Amendments, amendment)
+	}
+	if err := amendments.Err(); err != nil {
+		return encounter, err
+	}
+
+	query = `SELECT id, doctor_id, code_system, code, description, is_primary, certainty, diagnosed_at
+		FROM diagnoses WHERE encounter_id = ? ORDER BY is_primary DESC, id`
+	diagnoses, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return encounter, fmt.Errorf("failed to retrieve diagnoses: %w", err)
+	}
+	defer diagnoses.Close()
+
+	for diagnoses.Next() {
+		diagnosis := types.Diagnosis{ClientID: encounter.ClientID, EncounterID: id}
+		var doctorID sql.NullInt64
+		err := diagnoses.Scan(&diagnosis.ID, &doctorID, &diagnosis.System, &diagnosis.Code, &diagnosis.Description,
+			&diagnosis.Primary, &diagnosis.Certainty, &diagnosis.DiagnosedAt)
+		if err != nil {
+			return encounter, err
+		}
+		diagnosis.DoctorID = int(doctorID.Int64)
+		encounter.Diagnoses = append(encounter.Diagnoses, diagnosis)
+	}
+	if err := diagnoses.Err(); err != nil {
+		return encounter, err
+	}
+
+	// Each row is an item, the items of a prescription follow each other
+	query = `
+		SELECT p.id, p.doctor_id, p.date_issued,
+			` + db.PrescriptionStatus + `,
+			p.refills, p.refills - p.refills_used, p.valid_until, COALESCE(i.drug_id, 0), i.drug, COALESCE(i.free_text, FALSE), COALESCE(i.strength, ''), COALESCE(i.dose, 0), COALESCE(i.unit, ''),
+			COALESCE(i.route, ''), COALESCE(i.frequency, ''), COALESCE(i.duration_days, 0), COALESCE(i.quantity, 0), COALESCE(i.instructions, '')
+		FROM prescriptions p
+		LEFT JOIN prescription_items i ON i.prescription_id = p.id
+		WHERE p.encounter_id = ?
+		ORDER BY p.id, i.position
+	`
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return encounter, fmt.Errorf("failed to retrieve prescriptions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		prescription := types.Prescription{ClientID: encounter.ClientID, EncounterID: id}
+		var drug sql.NullString
+		var item types.PrescriptionItem
+		err := rows.Scan(&prescription.ID, &prescription.DoctorID, &prescription.DateIssued, &prescription.Status,
+			&prescription.Refills, &prescription.RefillsRemaining, &prescription.ValidUntil, &item.DrugID, &drug, &item.FreeText, &item.Strength, &item.Dose, &item.Unit,
+			&item.Route, &item.Frequency, &item.DurationDays, &item.Quantity, &item.Instructions)
+		if err != nil {
+			return encounter, err
+		}
+
+		last := len(encounter.Prescriptions) - 1
+		if last < 0 || encounter.Prescriptions[last].ID != prescription.ID {
+			encounter.Prescriptions = append(encounter.Prescriptions, prescription)
+			last++
+		}
+		if drug.Valid {
+			item.Drug = drug.String
+			encounter.Prescriptions[last].Items = append(encounter.Prescriptions[last].Items, item)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return encounter, err
+	}
+	return encounter, nil
+}
+
+// GetEncountersByClient retrieves the encounter timeline of a client, the most recent first
+func (s *Store) GetEncountersByClient(clientID string) ([]types.Encounter, error) {
+	ctx := context.Background()
+
+	var id int
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM clients WHERE uuid = ?`, clientID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("client does not exist")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve client: %w", err)
+	}
+
+	return ClientEncounters(ctx, s.db, id)
+}
+
+// ClientEncounters retrieves the encounter timeline of the client with the database id, the most recent first.
+// The clients service lists it with the other records of a client.
+func ClientEncounters(ctx context.Context, db *sql.DB, clientID int) ([]types.Encounter, error) {
+	query := `SELECT ` + encounterColumns + ` ` + encounterJoins + ` WHERE e.client_id = ? ORDER BY e.encountered_at DESC, e.id DESC`
+	rows, err := db.QueryContext(ctx, query, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve encounters: %w", err)
+	}
+	defer rows.Close()
+
+	encounters := []types.Encounter{}
+	for rows.Next() {
+		encounter, err := scanEncounter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan encounter: %w", err)
+		}
+		encounters = append(encounters, encounter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve encounters: %w", err)
+	}
+	return encounters, nil
+}
+
+// UpdateEncounterNotes replaces the SOAP notes of an encounter. Only the doctor who recorded the encounter
+// can edit its notes, the notes they replace are kept as an amendment.
+func (s *Store) UpdateEncounterNotes(id int, notes types.SOAPNotes, amendedBy int) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var author bool
+	var previous types.SOAPNotes
+	query := `SELECT doctor_id <=> ?,
+			COALESCE(subjective, ''), COALESCE(objective, ''), COALESCE(assessment, ''), COALESCE(plan, '')
+		FROM encounters WHERE id = ? FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, amendedBy, id).
+		Scan(&author, &previous.Subjective, &previous.Objective, &previous.Assessment, &previous.Plan)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("encounter does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve encounter: %w", err)
+	}
+	if !author {
+		return fmt.Errorf("encounter was recorded by another doctor")
+	}
+	if previous == notes {
+		return nil
+	}
+
+	query = `INSERT INTO encounter_amendments (encounter_id, subjective, objective, assessment, plan, amended_by)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, query, id, previous.Subjective, previous.Objective, previous.Assessment, previous.Plan, amendedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save encounter amendment: %w", err)
+	}
+
+	query = `UPDATE encounters SET subjective = ?, objective = ?, assessment = ?, plan = ? WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, query, notes.Subjective, notes.Objective, notes.Assessment, notes.Plan, id); err != nil {
+		return fmt.Errorf("failed to update encounter notes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit encounter notes: %w", err)
+	}
+	return nil
+}
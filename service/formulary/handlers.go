@@ -0,0 +1,258 @@
+package formulary
+
+import (
+	"cema_backend/logging"
+	"cema_backend/types"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+	// maxImportSize is the largest import file accepted, far more than a national formulary
+	maxImportSize = 10 << 20
+)
+
+// ATCCodePattern matches an ATC code down to any of its levels, e.g. J01 or J01CA04
+var ATCCodePattern = regexp.MustCompile(`^[A-Z](\d{2}([A-Z]([A-Z](\d{2})?)?)?)?$`)
+
+// Handler struct contains the store for the drugs of the formulary
+type Handler struct {
+	store types.FormularyStore
+}
+
+// NewHandler initializes a new Handler instance with the given FormularyStore.
+func NewHandler(store types.FormularyStore) *Handler {
+	return &Handler{store: store}
+}
+
+// normalizeDrug trims the details of a drug and checks the required ones are set.
+// It returns the error message to send back if they are not valid.
+func normalizeDrug(drug *types.Drug) string {
+	drug.GenericName = strings.TrimSpace(drug.GenericName)
+	drug.Form = strings.ToLower(strings.TrimSpace(drug.Form))
+	drug.Strength = strings.TrimSpace(drug.Strength)
+	drug.ATCCode = strings.ToUpper(strings.TrimSpace(drug.ATCCode))
+	if drug.GenericName == "" || drug.Form == "" {
+		return "Generic name and form are required"
+	}
+	if drug.ATCCode != "" && !ATCCodePattern.MatchString(drug.ATCCode) {
+		return "Invalid ATC code"
+	}
+
+	// Drop empty and repeated brand names
+	brands := []string{}
+	seen := map[string]bool{}
+	for _, name := range drug.BrandNames {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		brands = append(brands, name)
+	}
+	drug.BrandNames = brands
+	return ""
+}
+
+// CreateDrug handles adding a drug to the formulary, new drugs are active unless said otherwise
+func (h *Handler) CreateDrug(c *gin.Context) {
+	var request struct {
+		types.Drug
+		Active *bool `json:"active"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	drug := request.Drug
+	drug.Active = request.Active == nil || *request.Active
+	if msg := normalizeDrug(&drug); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	id, err := h.store.CreateDrug(drug)
+	if err != nil {
+		if err.Error() == "drug already exists" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Drug already exists"})
+			return
+		}
+		logging.Error("Failed to Create Drug: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating drug"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Drug created successfully", "id": id})
+}
+
+// GetDrug handles the request for a drug of the formulary
+func (h *Handler) GetDrug(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drug ID"})
+		return
+	}
+
+	drug, err := h.store.GetDrug(id)
+	if err != nil {
+		if err.Error() == "drug does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drug not Found"})
+			return
+		}
+		logging.Error("Failed to Get Drug: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving drug"})
+		return
+	}
+	c.JSON(http.StatusOK, drug)
+}
+
+// ListDrugs handles listing the formulary, or autocompleting drug names when q is set.
+// Inactive drugs are listed with inactive=true, they are never suggested.
+func (h *Handler) ListDrugs(c *gin.Context) {
+	if q := c.Query("q"); q != "" {
+		limit := defaultSearchLimit
+		if value := c.Query("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+			limit = n
+		}
+
+		drugs, err := h.store.SearchDrugs(q, limit)
+		if err != nil {
+			logging.Error("Failed to Search Drugs: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error searching drugs"})
+			return
+		}
+		c.JSON(http.StatusOK, drugs)
+		return
+	}
+
+	drugs, err := h.store.ListDrugs(c.Query("inactive") == "true")
+	if err != nil {
+		logging.Error("Failed to List Drugs: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing drugs"})
+		return
+	}
+	c.JSON(http.StatusOK, drugs)
+}
+
+// UpdateDrug handles replacing the details of a drug, it stays active unless said otherwise
+func (h *Handler) UpdateDrug(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drug ID"})
+		return
+	}
+
+	var request struct {
+		types.Drug
+		Active *bool `json:"active"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	drug := request.Drug
+	drug.ID = id
+	drug.Active = request.Active == nil || *request.Active
+	if msg := normalizeDrug(&drug); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	h.saveDrug(c, drug, "Drug updated successfully")
+}
+
+// DeactivateDrug handles removing a drug from prescribing, it stays on existing prescriptions
+func (h *Handler) DeactivateDrug(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drug ID"})
+		return
+	}
+
+	drug, err := h.store.GetDrug(id)
+	if err != nil {
+		if err.Error() == "drug does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drug not Found"})
+			return
+		}
+		logging.Error("Failed to Get Drug: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving drug"})
+		return
+	}
+	drug.Active = false
+
+	h.saveDrug(c, drug, "Drug deactivated successfully")
+}
+
+// saveDrug updates a drug and responds with the message on success
+func (h *Handler) saveDrug(c *gin.Context, drug types.Drug, message string) {
+	if err := h.store.UpdateDrug(drug); err != nil {
+		switch err.Error() {
+		case "drug does not exist":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drug not Found"})
+		case "drug already exists":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Drug already exists"})
+		default:
+			logging.Error("Failed to Update Drug: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating drug"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// ImportDrugs handles importing drugs from a CSV file, sent as the file field of a form or as the request body.
+// Lines that are not valid are reported and skipped.
+func (h *Handler) ImportDrugs(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	body := c.Request.Body
+	file, err := c.FormFile("file")
+	if err == nil {
+		opened, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import file"})
+			return
+		}
+		defer opened.Close()
+		body = opened
+	} else if tooLarge(err) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Import file is too large"})
+		return
+	}
+
+	drugs, lineErrors, err := parseImport(body)
+	if err != nil {
+		if tooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Import file is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.store.ImportDrugs(drugs)
+	if err != nil {
+		logging.Error("Failed to Import Drugs: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error importing drugs"})
+		return
+	}
+	report.Errors = lineErrors
+	c.JSON(http.StatusOK, report)
+}
+
+// tooLarge reports whether reading the request failed because it is over its size limit
+func tooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
@@ -0,0 +1,212 @@
+package formulary
+
+import (
+	"bytes"
+	"cema_backend/types"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockFormularyStore is a mock implementation of the FormularyStore interface.
+type MockFormularyStore struct {
+	mock.Mock
+}
+
+func (m *MockFormularyStore) CreateDrug(drug types.Drug) (int, error) {
+	args := m.Called(drug)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockFormularyStore) GetDrug(id int) (types.Drug, error) {
+	args := m.Called(id)
+	return args.Get(0).(types.Drug), args.Error(1)
+}
+
+func (m *MockFormularyStore) ListDrugs(includeInactive bool) ([]types.Drug, error) {
+	args := m.Called(includeInactive)
+	return args.Get(0).([]types.Drug), args.Error(1)
+}
+
+func (m *MockFormularyStore) SearchDrugs(q string, limit int) ([]types.Drug, error) {
+	args := m.Called(q, limit)
+	return args.Get(0).([]types.Drug), args.Error(1)
+}
+
+func (m *MockFormularyStore) UpdateDrug(drug types.Drug) error {
+	args := m.Called(drug)
+	return args.Error(0)
+}
+
+func (m *MockFormularyStore) ImportDrugs(drugs []types.Drug) (types.FormularyImportReport, error) {
+	args := m.Called(drugs)
+	return args.Get(0).(types.FormularyImportReport), args.Error(1)
+}
+
+func TestCreateDrug(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockFormularyStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.POST("/", handler.CreateDrug)
+
+	// Test case: The drug is normalised and active by default
+	mockStore.On("CreateDrug", types.Drug{
+		GenericName: "Amoxicillin",
+		BrandNames:  []string{"Amoxil", "Moxacil"},
+		ATCCode:     "J01CA04",
+		Form:        "capsule",
+		Strength:    "500 mg",
+		Active:      true,
+	}).Return(4, nil).Once()
+
+	body := `{"generic_name": " Amoxicillin ", "brand_names": ["Amoxil", "", "Moxacil", "amoxil"], "atc_code": "j01ca04", "form": "Capsule", "strength": "500 mg"}`
+	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: Invalid drugs are rejected before anything is saved
+	for _, payload := range []string{`{"form": "tablet"}`, `{"generic_name": "Amoxicillin", "form": "capsule", "atc_code": "AMX"}`} {
+		req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, payload)
+	}
+	mockStore.AssertNumberOfCalls(t, "CreateDrug", 1)
+
+	// Test case: Drug already exists
+	mockStore.On("CreateDrug", mock.Anything).Return(0, fmt.Errorf("drug already exists")).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestListDrugs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockFormularyStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.GET("/", handler.ListDrugs)
+
+	drugs := []types.Drug{{ID: 4, GenericName: "Amoxicillin", BrandNames: []string{"Amoxil"}, Form: "capsule", Strength: "500 mg", Active: true}}
+
+	// Test case: q autocompletes active drugs
+	mockStore.On("SearchDrugs", "amox", 5).Return(drugs, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodGet, "/?q=amox&limit=5", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var response []types.Drug
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, drugs, response)
+
+	// Test case: Without q the formulary is listed
+	mockStore.On("ListDrugs", true).Return(drugs, nil).Once()
+
+	req, _ = http.NewRequest(http.MethodGet, "/?inactive=true", nil)
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestImportDrugs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockFormularyStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.POST("/import", handler.ImportDrugs)
+
+	file := "Medicine,Dosage Form,Strength,ATC Code,Brand Names,Status\n" +
+		"Amoxicillin,Capsule,250 mg,J01CA04,Amoxil;Moxacil,active\n" +
+		"\"Artemether/Lumefantrine\",Tablet,20 mg/120 mg,P01BF01,Coartem,\n" +
+		",Tablet,500 mg,,,\n" +
+		"Chloroquine,Tablet,150 mg,P01BA01,,withdrawn\n" +
+		"Amoxicillin,Capsule,250 mg,J01CA04,Amoxil,inactive\n"
+
+	// Test case: Valid lines are imported, a drug listed twice keeps its last line, invalid lines are reported
+	mockStore.On("ImportDrugs", []types.Drug{
+		{GenericName: "Amoxicillin", BrandNames: []string{"Amoxil"}, ATCCode: "J01CA04", Form: "capsule", Strength: "250 mg", Active: false},
+		{GenericName: "Artemether/Lumefantrine", BrandNames: []string{"Coartem"}, ATCCode: "P01BF01", Form: "tablet", Strength: "20 mg/120 mg", Active: true},
+	}).Return(types.FormularyImportReport{Created: 1, Updated: 1}, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodPost, "/import", bytes.NewBufferString(file))
+	req.Header.Set("Content-Type", "text/csv")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var report types.FormularyImportReport
+	json.Unmarshal(resp.Body.Bytes(), &report)
+	require.Equal(t, 1, report.Created)
+	require.Equal(t, 1, report.Updated)
+	require.Equal(t, []types.FormularyImportError{
+		{Line: 4, Error: "Generic name and form are required"},
+		{Line: 5, Error: "Invalid active status"},
+	}, report.Errors)
+
+	// Test case: A file without the required columns is rejected
+	req, _ = http.NewRequest(http.MethodPost, "/import", bytes.NewBufferString("Name,Strength\nAmoxicillin,250 mg\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Test case: A file over the size limit is refused, sent as the body or in a form
+	large := "Medicine,Dosage Form\n" + strings.Repeat("Amoxicillin,Capsule\n", maxImportSize/20+1)
+	req, _ = http.NewRequest(http.MethodPost, "/import", bytes.NewBufferString(large))
+	req.Header.Set("Content-Type", "text/csv")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "formulary.csv")
+	part.Write([]byte(large))
+	writer.Close()
+	req, _ = http.NewRequest(http.MethodPost, "/import", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	mockStore.AssertNumberOfCalls(t, "ImportDrugs", 1)
+}
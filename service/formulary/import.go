@@ -0,0 +1,97 @@
+package formulary
+
+import (
+	"cema_backend/types"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// importColumns maps the accepted header names of an import file to the drug fields,
+// so lists such as the Kenya Essential Medicines List can be imported without renaming their columns
+var importColumns = map[string]string{
+	"generic_name": "generic_name", "generic name": "generic_name", "medicine": "generic_name", "name": "generic_name",
+	"brand_names": "brand_names", "brand names": "brand_names", "brands": "brand_names",
+	"atc_code": "atc_code", "atc code": "atc_code", "atc": "atc_code",
+	"form": "form", "dosage form": "form",
+	"strength": "strength",
+	"active":   "active", "status": "active",
+}
+
+// parseImport reads the drugs of a CSV import file.
+// Brand names are separated by semicolons, the active column is true/false or active/inactive and defaults to active.
+// Lines that are not valid are returned as errors with their line number, the other lines are still imported.
+func parseImport(r io.Reader) ([]types.Drug, []types.FormularyImportError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("import file is empty")
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to read import file: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		if field, ok := importColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[field] = i
+		}
+	}
+	if _, ok := columns["generic_name"]; !ok {
+		return nil, nil, fmt.Errorf("import file has no generic name column")
+	}
+	if _, ok := columns["form"]; !ok {
+		return nil, nil, fmt.Errorf("import file has no form column")
+	}
+
+	var drugs []types.Drug
+	errors := []types.FormularyImportError{}
+	// A drug listed twice is imported once, with the details of its last line
+	seen := map[string]int{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to read import file: %w", err)
+		}
+		value := func(field string) string {
+			if i, ok := columns[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		drug := types.Drug{
+			GenericName: value("generic_name"),
+			BrandNames:  strings.Split(value("brand_names"), ";"),
+			ATCCode:     value("atc_code"),
+			Form:        value("form"),
+			Strength:    value("strength"),
+			Active:      true,
+		}
+		switch strings.ToLower(value("active")) {
+		case "", "true", "yes", "active":
+		case "false", "no", "inactive":
+			drug.Active = false
+		default:
+			errors = append(errors, types.FormularyImportError{Line: line, Error: "Invalid active status"})
+			continue
+		}
+		if msg := normalizeDrug(&drug); msg != "" {
+			errors = append(errors, types.FormularyImportError{Line: line, Error: msg})
+			continue
+		}
+
+		key := strings.ToLower(drug.GenericName + "|" + drug.Form + "|" + drug.Strength)
+		if i, ok := seen[key]; ok {
+			drugs[i] = drug
+			continue
+		}
+		seen[key] = len(drugs)
+		drugs = append(drugs, drug)
+	}
+	return drugs, errors, nil
+}
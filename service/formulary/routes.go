@@ -0,0 +1,19 @@
+// This file contains the endpoints for the formulary service.
+package formulary
+
+import (
+	"cema_backend/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	// Drugs are looked up by those who read prescriptions, only formulary managers change them
+	router.Use(auth.AuthMiddleware())
+	router.GET("/", auth.RequirePermission("prescriptions:read"), h.ListDrugs)
+	router.POST("/", auth.RequirePermission("formulary:manage"), h.CreateDrug)
+	router.POST("/import", auth.RequirePermission("formulary:manage"), h.ImportDrugs)
+	router.GET("/:id", auth.RequirePermission("prescriptions:read"), h.GetDrug)
+	router.PUT("/:id", auth.RequirePermission("formulary:manage"), h.UpdateDrug)
+	router.DELETE("/:id", auth.RequirePermission("formulary:manage"), h.DeactivateDrug)
+}
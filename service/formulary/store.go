@@ -0,0 +1,307 @@
+package formulary
+
+import (
+	"cema_backend/db"
+	"cema_backend/types"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore initializes a new Store instance with the given database connection.
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// drugColumns are the columns scanned by scanDrug, the stock on hand leaves out expired batches
+const drugColumns = `f.id, f.generic_name, COALESCE(f.atc_code, ''), f.form, f.strength, f.active,
+	(SELECT COALESCE(SUM(m.quantity), 0) FROM stock_movements m JOIN stock_batches b ON b.id = m.batch_id
+		WHERE m.drug_id = f.id AND b.expiry_date >= CURRENT_DATE)`
+
+// scanDrug scans a row selected with drugColumns
+func scanDrug(row interface{ Scan(...interface{}) error }) (types.Drug, error) {
+	var drug types.Drug
+	err := row.Scan(&drug.ID, &drug.GenericName, &drug.ATCCode, &drug.Form, &drug.Strength, &drug.Active, &drug.StockOnHand)
+	return drug, err
+}
+
+// CreateDrug adds a drug to the formulary, a drug with the same name, form and strength cannot be added twice.
+// It returns the id of the new drug.
+func (s *Store) CreateDrug(drug types.Drug) (int, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	id, err := findDrug(ctx, tx, drug)
+	if err != nil {
+		return 0, err
+	} else if id != 0 {
+		return 0, fmt.Errorf("drug already exists")
+	}
+
+	id, err = insertDrug(ctx, tx, drug)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit drug: %w", err)
+	}
+	return id, nil
+}
+
+// findDrug returns the id of the drug with the same name, form and strength, or 0 if there is none
+func findDrug(ctx context.Context, tx *sql.Tx, drug types.Drug) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, `SELECT id FROM formulary WHERE generic_name = ? AND form = ? AND strength = ? FOR UPDATE`,
+		drug.GenericName, drug.Form, drug.Strength).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to retrieve drug: %w", err)
+	}
+	return id, nil
+}
+
+// insertDrug saves a new drug with its brand names
+func insertDrug(ctx context.Context, tx *sql.Tx, drug types.Drug) (int, error) {
+	query := `INSERT INTO formulary (generic_name, atc_code, form, strength, active) VALUES (?, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, drug.GenericName, db.NullIfEmpty(drug.ATCCode), drug.Form, drug.Strength, drug.Active)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save drug: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve drug id: %w", err)
+	}
+	if err := replaceBrandNames(ctx, tx, int(id), drug.BrandNames); err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// replaceBrandNames sets the brand names of a drug
+func replaceBrandNames(ctx context.Context, tx *sql.Tx, id int, brandNames []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM formulary_brands WHERE drug_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to update brand names: %w", err)
+	}
+	for _, name := range brandNames {
+		if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO formulary_brands (drug_id, name) VALUES (?, ?)`, id, name); err != nil {
+			return fmt.Errorf("failed to save brand name: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetDrug retrieves a drug of the formulary
+func (s *Store) GetDrug(id int) (types.Drug, error) {
+	ctx := context.Background()
+
+	drug, err := scanDrug(s.db.QueryRowContext(ctx, `SELECT `+drugColumns+` FROM formulary f WHERE f.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return drug, fmt.Errorf("drug does not exist")
+	} else if err != nil {
+		return drug, fmt.Errorf("failed to retrieve drug: %w", err)
+	}
+
+	brands, err := s.brandNamesFor(ctx, id)
+	if err != nil {
+		return drug, err
+	}
+	drug.BrandNames = brands[id]
+	if drug.BrandNames == nil {
+		drug.BrandNames = []string{}
+	}
+	return drug, nil
+}
+
+// ListDrugs retrieves the formulary sorted by name, inactive drugs are only listed when asked for
+func (s *Store) ListDrugs(includeInactive bool) ([]types.Drug, error) {
+	query := `SELECT ` + drugColumns + ` FROM formulary f`
+	if !includeInactive {
+		query += ` WHERE f.active`
+	}
+	query += ` ORDER BY f.generic_name, f.form, f.strength`
+	return s.queryDrugs(context.Background(), query)
+}
+
+// SearchDrugs returns the active drugs whose generic name, brand name or ATC code starts with q,
+// or whose generic name contains it, generic name matches first
+func (s *Store) SearchDrugs(q string, limit int) ([]types.Drug, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return []types.Drug{}, nil
+	}
+	prefix := db.EscapeLike(q) + "%"
+	contains := "%" + db.EscapeLike(q) + "%"
+
+	query := `
+		SELECT ` + drugColumns + `
+		FROM formulary f
+		WHERE f.active AND (
+			f.generic_name LIKE ? OR f.atc_code LIKE ? OR f.generic_name LIKE ?
+			OR EXISTS (SELECT 1 FROM formulary_brands b WHERE b.drug_id = f.id AND b.name LIKE ?)
+		)
+		ORDER BY f.generic_name LIKE ? DESC, f.generic_name, f.form, f.strength
+		LIMIT ?
+	`
+	return s.queryDrugs(context.Background(), query, prefix, prefix, contains, prefix, prefix, limit)
+}
+
+// queryDrugs runs a query selecting drugColumns and loads the brand names of the drugs
+func (s *Store) queryDrugs(ctx context.Context, query string, args ...interface{}) ([]types.Drug, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve drugs: %w", err)
+	}
+	defer rows.Close()
+
+	drugs := []types.Drug{}
+	var ids []int
+	for rows.Next() {
+		drug, err := scanDrug(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan drug: %w", err)
+		}
+		drugs = append(drugs, drug)
+		ids = append(ids, drug.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve drugs: %w", err)
+	}
+
+	brands, err := s.brandNamesFor(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range drugs {
+		drugs[i].BrandNames = brands[drugs[i].ID]
+		if drugs[i].BrandNames == nil {
+			drugs[i].BrandNames = []string{}
+		}
+	}
+	return drugs, nil
+}
+
+// brandNamesFor retrieves the brand names of the given drugs keyed by drug id
+func (s *Store) brandNamesFor(ctx context.Context, ids ...int) (map[int][]string, error) {
+	brands := map[int][]string{}
+	if len(ids) == 0 {
+		return brands, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT drug_id, name FROM formulary_brands WHERE drug_id IN (`+placeholders+`) ORDER BY name`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve brand names: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		brands[id] = append(brands[id], name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return brands, nil
+}
+
+// UpdateDrug replaces the details of a drug, deactivating it stops it from being prescribed
+func (s *Store) UpdateDrug(drug types.Drug) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM formulary WHERE id = ? FOR UPDATE`, drug.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("drug does not exist")
+	} else if err != nil {
+		return fmt.Errorf("failed to retrieve drug: %w", err)
+	}
+	if existing, err := findDrug(ctx, tx, drug); err != nil {
+		return err
+	} else if existing != 0 && existing != drug.ID {
+		return fmt.Errorf("drug already exists")
+	}
+
+	query := `UPDATE formulary SET generic_name = ?, atc_code = ?, form = ?, strength = ?, active = ? WHERE id = ?`
+	_, err = tx.ExecContext(ctx, query, drug.GenericName, db.NullIfEmpty(drug.ATCCode), drug.Form, drug.Strength, drug.Active, drug.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update drug: %w", err)
+	}
+	if err := replaceBrandNames(ctx, tx, drug.ID, drug.BrandNames); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit drug update: %w", err)
+	}
+	return nil
+}
+
+// ImportDrugs adds the drugs to the formulary in one transaction,
+// a drug with the same name, form and strength as an existing one updates it.
+func (s *Store) ImportDrugs(drugs []types.Drug) (types.FormularyImportReport, error) {
+	ctx := context.Background()
+	report := types.FormularyImportReport{Errors: []types.FormularyImportError{}}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return report, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, drug := range drugs {
+		id, err := findDrug(ctx, tx, drug)
+		if err != nil {
+			return report, err
+		}
+		if id == 0 {
+			if _, err := insertDrug(ctx, tx, drug); err != nil {
+				return report, err
+			}
+			report.Created++
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE formulary SET atc_code = ?, active = ? WHERE id = ?`, db.NullIfEmpty(drug.ATCCode), drug.Active, id)
+		if err != nil {
+			return report, fmt.Errorf("failed to update drug: %w", err)
+		}
+		if err := replaceBrandNames(ctx, tx, id, drug.BrandNames); err != nil {
+			return report, err
+		}
+		report.Updated++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return report, fmt.Errorf("failed to commit import: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,162 @@
+package observations
+
+import (
+	"cema_backend/auth"
+	"cema_backend/logging"
+	"cema_backend/types"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dateLayout is the format of the dates used to filter the history of a client
+const dateLayout = "2006-01-02"
+
+// Handler struct contains the store for the vital signs and measurements recorded during encounters
+type Handler struct {
+	store types.ObservationStore
+}
+
+// NewHandler initializes a new Handler instance with the given ObservationStore.
+func NewHandler(store types.ObservationStore) *Handler {
+	return &Handler{store: store}
+}
+
+// ValidateVitals checks that at least one measurement was taken and that each one is plausible,
+// values outside the ranges are most likely typing mistakes. The clients service checks the height
+// and weight taken at registration with it too.
+// It returns the error message to send back if they are not.
+func ValidateVitals(vitals types.Vitals) string {
+	measurements := []struct {
+		name     string
+		value    *float64
+		min, max float64
+	}{
+		{"Height", vitals.Height, 20, 250},
+		{"Weight", vitals.Weight, 0.5, 400},
+		{"Systolic blood pressure", intValue(vitals.SystolicBP), 50, 300},
+		{"Diastolic blood pressure", intValue(vitals.DiastolicBP), 20, 200},
+		{"Temperature", vitals.Temperature, 30, 45},
+		{"Pulse", intValue(vitals.Pulse), 20, 250},
+		{"SpO2", intValue(vitals.SpO2), 50, 100},
+		{"MUAC", vitals.MUAC, 5, 50},
+	}
+
+	taken := false
+	for _, m := range measurements {
+		if m.value == nil {
+			continue
+		}
+		taken = true
+		if *m.value < m.min || *m.value > m.max {
+			return m.name + " is out of range"
+		}
+	}
+	if !taken {
+		return "At least one measurement is required"
+	}
+
+	// Blood pressure is taken as a pair
+	if (vitals.SystolicBP == nil) != (vitals.DiastolicBP == nil) {
+		return "Systolic and diastolic blood pressure are required together"
+	}
+	if vitals.SystolicBP != nil && *vitals.DiastolicBP >= *vitals.SystolicBP {
+		return "Diastolic blood pressure must be lower than systolic"
+	}
+	return ""
+}
+
+// intValue converts an optional whole number measurement so it can be checked with the others
+func intValue(value *int) *float64 {
+	if value == nil {
+		return nil
+	}
+	f := float64(*value)
+	return &f
+}
+
+// RecordObservation handles recording the vitals taken from a client.
+// observed_at defaults to now and cannot be in the future.
+func (h *Handler) RecordObservation(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	var request types.Observation
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+
+	// BMI is derived, it is never recorded
+	request.BMI = nil
+	if msg := ValidateVitals(request.Vitals); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	now := time.Now()
+	if request.ObservedAt.IsZero() {
+		request.ObservedAt = now
+	} else if request.ObservedAt.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Observation time cannot be in the future"})
+		return
+	}
+
+	id, err := h.store.RecordObservation(types.Observation{
+		ClientID:   c.Param("client_id"),
+		ObservedAt: request.ObservedAt,
+		Vitals:     request.Vitals,
+	}, actor.ID)
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		logging.Error("Failed to Record Observation: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recording observation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Observation recorded successfully", "id": id})
+}
+
+// GetObservations handles the request for the observation history of a client.
+// from and to are optional dates, both days are included.
+func (h *Handler) GetObservations(c *gin.Context) {
+	var from, to time.Time
+	if value := c.Query("from"); value != "" {
+		date, err := time.Parse(dateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		from = date
+	}
+	if value := c.Query("to"); value != "" {
+		date, err := time.Parse(dateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		// The store excludes the end, so the range runs up to the start of the next day
+		to = date.AddDate(0, 0, 1)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+
+	observations, err := h.store.GetObservations(c.Param("client_id"), from, to)
+	if err != nil {
+		if err.Error() == "client does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not Found"})
+			return
+		}
+		logging.Error("Failed to Get Observations: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving observations"})
+		return
+	}
+	c.JSON(http.StatusOK, observations)
+}
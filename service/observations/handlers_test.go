@@ -0,0 +1,146 @@
+package observations
+
+import (
+	"bytes"
+	"cema_backend/auth"
+	"cema_backend/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockObservationStore is a mock implementation of the ObservationStore interface.
+type MockObservationStore struct {
+	mock.Mock
+}
+
+func (m *MockObservationStore) RecordObservation(observation types.Observation, recordedBy int) (int, error) {
+	args := m.Called(observation, recordedBy)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockObservationStore) GetObservations(clientID string, from, to time.Time) ([]types.Observation, error) {
+	args := m.Called(clientID, from, to)
+	return args.Get(0).([]types.Observation), args.Error(1)
+}
+
+func TestRecordObservation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockObservationStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	// Stands in for the auth middleware setting the authenticated doctor
+	router.Use(func(c *gin.Context) {
+		auth.SetActor(c, types.Actor{ID: 1, Email: "stan@rfh.com", Role: "doctor"})
+	})
+	router.POST("/:client_id", handler.RecordObservation)
+
+	clientID := "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11"
+	observedAt := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	weight := 72.5
+	systolic, diastolic := 120, 80
+
+	// Test case: Successful observation
+	mockStore.On("RecordObservation", types.Observation{
+		ClientID:   clientID,
+		ObservedAt: observedAt,
+		Vitals:     types.Vitals{Weight: &weight, SystolicBP: &systolic, DiastolicBP: &diastolic},
+	}, 1).Return(7, nil).Once()
+
+	body := []byte(`{"observed_at": "2024-05-01T09:30:00Z", "weight": 72.5, "systolic_bp": 120, "diastolic_bp": 80}`)
+	req, _ := http.NewRequest(http.MethodPost, "/"+clientID, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var response struct {
+		ID int `json:"id"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Equal(t, 7, response.ID)
+
+	// Test case: Invalid measurements are rejected before anything is saved
+	invalid := []string{
+		`{}`,
+		`{"height": 500}`,
+		`{"spo2": 101}`,
+		`{"systolic_bp": 120}`,
+		`{"systolic_bp": 80, "diastolic_bp": 90}`,
+		`{"weight": 70, "observed_at": "2999-01-01T00:00:00Z"}`,
+	}
+	for _, payload := range invalid {
+		req, _ := http.NewRequest(http.MethodPost, "/"+clientID, bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, payload)
+	}
+	mockStore.AssertNumberOfCalls(t, "RecordObservation", 1)
+
+	// Test case: Client does not exist
+	mockStore.On("RecordObservation", mock.Anything, 1).Return(0, fmt.Errorf("client does not exist")).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/unknown", bytes.NewBufferString(`{"temperature": 37.2}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestGetObservations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockObservationStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	router.GET("/:client_id", handler.GetObservations)
+
+	clientID := "3f0a9b8e-6a4f-4d43-9d8e-0f6f1d2b7c11"
+	height, weight, bmi := 180.0, 72.0, 22.2
+	observations := []types.Observation{
+		{ID: 1, ClientID: clientID, ObservedAt: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC), Vitals: types.Vitals{Height: &height, Weight: &weight, BMI: &bmi}},
+	}
+
+	// Test case: Both days of the range are included
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	mockStore.On("GetObservations", clientID, from, to).Return(observations, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodGet, "/"+clientID+"?from=2024-05-01&to=2024-05-31", nil)
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	var response []types.Observation
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	require.Len(t, response, 1)
+	require.Equal(t, 22.2, *response[0].BMI)
+
+	// Test case: Invalid ranges are rejected
+	for _, query := range []string{"?from=01-05-2024", "?from=2024-06-01&to=2024-05-01"} {
+		req, _ := http.NewRequest(http.MethodGet, "/"+clientID+query, nil)
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+	mockStore.AssertNumberOfCalls(t, "GetObservations", 1)
+}
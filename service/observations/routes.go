@@ -0,0 +1,15 @@
+// This file contains the endpoints for the observations service.
+package observations
+
+import (
+	"cema_backend/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	// Nurses record observations alongside doctors
+	router.Use(auth.AuthMiddleware())
+	router.POST("/:client_id", auth.RequirePermission("observations:write"), h.RecordObservation)
+	router.GET("/:client_id", auth.RequirePermission("observations:read"), h.GetObservations)
+}
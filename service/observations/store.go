@@ -0,0 +1,122 @@
+package observations
+
+import (
+	"cema_backend/types"
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore initializes a new Store instance with the given database connection.
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// RecordObservation saves the vitals taken from a client, attributed to the doctor whose id is recordedBy.
+// Merged and archived clients cannot get new observations.
+// It returns the id of the new observation.
+func (s *Store) RecordObservation(observation types.Observation, recordedBy int) (int, error) {
+	ctx := context.Background()
+
+	query := `INSERT INTO observations (client_id, doctor_id, observed_at, height, weight, systolic_bp, diastolic_bp, temperature, pulse, spo2, muac)
+		SELECT id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		FROM clients WHERE uuid = ? AND merged_into IS NULL AND archived_at IS NULL`
+	vitals := observation.Vitals
+	result, err := s.db.ExecContext(ctx, query, recordedBy, observation.ObservedAt,
+		vitals.Height, vitals.Weight, vitals.SystolicBP, vitals.DiastolicBP, vitals.Temperature, vitals.Pulse, vitals.SpO2, vitals.MUAC,
+		observation.ClientID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save observation: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("failed to save observation: %w", err)
+	} else if rows == 0 {
+		return 0, fmt.Errorf("client does not exist")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve observation id: %w", err)
+	}
+	return int(id), nil
+}
+
+// GetObservations retrieves the observations of a client from the oldest to the newest.
+// Only those observed at or after from and before to are returned, a zero time leaves that end open.
+// The BMI of each observation uses the latest height known when it was taken.
+func (s *Store) GetObservations(clientID string, from, to time.Time) ([]types.Observation, error) {
+	ctx := context.Background()
+
+	var id int
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM clients WHERE uuid = ?`, clientID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("client does not exist")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve client: %w", err)
+	}
+
+	conditions := []string{"o.client_id = ?"}
+	args := []interface{}{id}
+	if !from.IsZero() {
+		conditions = append(conditions, "o.observed_at >= ?")
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		conditions = append(conditions, "o.observed_at < ?")
+		args = append(args, to)
+	}
+
+	query := `
+		SELECT o.id, o.doctor_id, o.observed_at, o.height, o.weight, o.systolic_bp, o.diastolic_bp, o.temperature, o.pulse, o.spo2, o.muac,
+			(SELECT h.height FROM observations h
+			 WHERE h.client_id = o.client_id AND h.height IS NOT NULL AND h.observed_at <= o.observed_at
+			 ORDER BY h.observed_at DESC, h.id DESC LIMIT 1)
+		FROM observations o
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY o.observed_at, o.id`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve observations: %w", err)
+	}
+	defer rows.Close()
+
+	observations := []types.Observation{}
+	for rows.Next() {
+		observation := types.Observation{ClientID: clientID}
+		var doctorID sql.NullInt64
+		var height *float64
+		err := rows.Scan(&observation.ID, &doctorID, &observation.ObservedAt,
+			&observation.Height, &observation.Weight, &observation.SystolicBP, &observation.DiastolicBP,
+			&observation.Temperature, &observation.Pulse, &observation.SpO2, &observation.MUAC, &height)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan observation: %w", err)
+		}
+		observation.DoctorID = int(doctorID.Int64)
+		observation.BMI = bmi(height, observation.Weight)
+		observations = append(observations, observation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve observations: %w", err)
+	}
+
+	return observations, nil
+}
+
+// bmi computes the body mass index rounded to one decimal, it is nil unless both measurements are known
+func bmi(height, weight *float64) *float64 {
+	if height == nil || weight == nil || *height <= 0 {
+		return nil
+	}
+	meters := *height / 100
+	value := math.Round(*weight/(meters*meters)*10) / 10
+	return &value
+}
@@ -0,0 +1,344 @@
+package pharmacy
+
+import (
+	"cema_backend/auth"
+	"cema_backend/logging"
+	"cema_backend/types"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// dateLayout is the format of expiry dates and of the dates used to filter the ledger
+	dateLayout = "2006-01-02"
+
+	// defaultExpiryDays is how far ahead the near expiry report looks by default
+	defaultExpiryDays = 90
+)
+
+// Handler struct contains the store for dispensing prescriptions and the stock they are dispensed from
+type Handler struct {
+	store types.PharmacyStore
+}
+
+// NewHandler initializes a new Handler instance with the given PharmacyStore.
+func NewHandler(store types.PharmacyStore) *Handler {
+	return &Handler{store: store}
+}
+
+// GetPrescription handles pulling up a prescription to dispense it
+func (h *Handler) GetPrescription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prescription id"})
+		return
+	}
+
+	prescription, err := h.store.GetDispensingPrescription(id)
+	if err != nil {
+		if err.Error() == "prescription does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not Found"})
+			return
+		}
+		logging.Error("Failed to Get Prescription: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving prescription"})
+		return
+	}
+	c.JSON(http.StatusOK, prescription)
+}
+
+// Dispense handles recording what the signed in user handed out for a prescription
+func (h *Handler) Dispense(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prescription id"})
+		return
+	}
+
+	var request struct {
+		Items []types.DispenseRequestItem `json:"items"`
+		Notes string                      `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	if len(request.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one item is required"})
+		return
+	}
+	for i := range request.Items {
+		request.Items[i].BatchNumber = strings.TrimSpace(request.Items[i].BatchNumber)
+		if request.Items[i].Item <= 0 || request.Items[i].Quantity <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Each item needs its item number and a quantity greater than zero"})
+			return
+		}
+	}
+
+	dispensing, err := h.store.Dispense(id, request.Items, strings.TrimSpace(request.Notes), actor.ID)
+	if err != nil {
+		switch err.Error() {
+		case "prescription does not exist":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not Found"})
+		case "prescription cannot be dispensed":
+			c.JSON(http.StatusConflict, gin.H{"error": "Prescription has been dispensed, completed or cancelled"})
+		case "prescription has expired":
+			c.JSON(http.StatusConflict, gin.H{"error": "Prescription has expired"})
+		case "prescription item does not exist":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Item not Found on the prescription"})
+		case "quantity is more than is left to dispense":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity is more than is left to dispense"})
+		case "batch does not exist":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Batch not Found for this drug"})
+		case "batch has expired":
+			c.JSON(http.StatusConflict, gin.H{"error": "Batch has expired"})
+		case "insufficient stock":
+			c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock to dispense this quantity"})
+		default:
+			logging.Error("Failed to Dispense: " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error dispensing prescription"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Dispensing recorded successfully", "dispensing": dispensing})
+}
+
+// bindMovement reads a stock receipt or adjustment, it returns the error message to send back if it is invalid
+func bindMovement(c *gin.Context) (types.StockMovement, string) {
+	var request struct {
+		DrugID      int    `json:"drug_id"`
+		BatchNumber string `json:"batch_number"`
+		ExpiryDate  string `json:"expiry_date"`
+		Quantity    int    `json:"quantity"`
+		Reason      string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		return types.StockMovement{}, "Invalid request payload"
+	}
+
+	movement := types.StockMovement{
+		DrugID:      request.DrugID,
+		BatchNumber: strings.TrimSpace(request.BatchNumber),
+		Quantity:    request.Quantity,
+		Reason:      strings.TrimSpace(request.Reason),
+	}
+	if movement.DrugID == 0 || movement.BatchNumber == "" {
+		return movement, "drug_id and batch_number are required"
+	}
+	if request.ExpiryDate != "" {
+		date, err := time.Parse(dateLayout, request.ExpiryDate)
+		if err != nil {
+			return movement, "Invalid expiry date, expected YYYY-MM-DD"
+		}
+		movement.ExpiryDate = date
+	}
+	return movement, ""
+}
+
+// ReceiveStock handles recording stock received into a batch
+func (h *Handler) ReceiveStock(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	movement, msg := bindMovement(c)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if movement.ExpiryDate.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiry_date is required"})
+		return
+	}
+	if movement.Quantity <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be greater than zero"})
+		return
+	}
+
+	id, err := h.store.ReceiveStock(movement, actor.ID)
+	if err != nil {
+		if err.Error() == "drug does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drug not Found"})
+			return
+		}
+		if err.Error() == "batch has a different expiry date" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Batch was received before with a different expiry date"})
+			return
+		}
+		logging.Error("Failed to Receive Stock: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error receiving stock"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Stock received successfully", "id": id})
+}
+
+// AdjustStock handles correcting the balance of a batch, the quantity is added to it and a reason is required
+func (h *Handler) AdjustStock(c *gin.Context) {
+	actor, ok := auth.RequireActor(c)
+	if !ok {
+		return
+	}
+	movement, msg := bindMovement(c)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if movement.Quantity == 0 || movement.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A quantity other than zero and a reason are required"})
+		return
+	}
+
+	id, err := h.store.AdjustStock(movement, actor.ID)
+	if err != nil {
+		if err.Error() == "batch does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Batch not Found for this drug"})
+			return
+		}
+		if err.Error() == "insufficient stock" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Adjustment would leave the batch below zero"})
+			return
+		}
+		logging.Error("Failed to Adjust Stock: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error adjusting stock"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Stock adjusted successfully", "id": id})
+}
+
+// GetStockBalances handles the request for the stock of every drug, or of one drug with drug_id
+func (h *Handler) GetStockBalances(c *gin.Context) {
+	drugID := 0
+	if value := c.Query("drug_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drug id"})
+			return
+		}
+		drugID = id
+	}
+
+	balances, err := h.store.GetStockBalances(drugID)
+	if err != nil {
+		if err.Error() == "drug does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drug not Found"})
+			return
+		}
+		logging.Error("Failed to Get Stock Balances: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving stock balances"})
+		return
+	}
+	c.JSON(http.StatusOK, balances)
+}
+
+// GetStockMovements handles the request for the stock ledger of a drug.
+// from and to are optional dates, both days are included.
+func (h *Handler) GetStockMovements(c *gin.Context) {
+	drugID, err := strconv.Atoi(c.Param("drug_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drug id"})
+		return
+	}
+
+	var from, to time.Time
+	if value := c.Query("from"); value != "" {
+		from, err = time.Parse(dateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		to, err = time.Parse(dateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		// The store excludes the end, so the range runs up to the start of the next day
+		to = to.AddDate(0, 0, 1)
+	}
+
+	movements, err := h.store.GetStockMovements(drugID, from, to)
+	if err != nil {
+		if err.Error() == "drug does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drug not Found"})
+			return
+		}
+		logging.Error("Failed to Get Stock Movements: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving stock movements"})
+		return
+	}
+	c.JSON(http.StatusOK, movements)
+}
+
+// SetReorderLevel handles setting the stock level at which a drug is reported as low on stock
+func (h *Handler) SetReorderLevel(c *gin.Context) {
+	drugID, err := strconv.Atoi(c.Param("drug_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drug id"})
+		return
+	}
+
+	var request struct {
+		ReorderLevel int `json:"reorder_level"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	if request.ReorderLevel < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reorder level cannot be negative"})
+		return
+	}
+
+	if err := h.store.SetReorderLevel(drugID, request.ReorderLevel); err != nil {
+		if err.Error() == "drug does not exist" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Drug not Found"})
+			return
+		}
+		logging.Error("Failed to Set Reorder Level: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error setting reorder level"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Reorder level updated successfully"})
+}
+
+// LowStockReport handles the request for the drugs at or below their reorder level
+func (h *Handler) LowStockReport(c *gin.Context) {
+	report, err := h.store.LowStockReport()
+	if err != nil {
+		logging.Error("Failed to Report Low Stock: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reporting low stock"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// NearExpiryReport handles the request for the batches in stock expiring within the given number of days, 90 by default
+func (h *Handler) NearExpiryReport(c *gin.Context) {
+	days := defaultExpiryDays
+	if value := c.Query("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid number of days"})
+			return
+		}
+		days = n
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	report, err := h.store.NearExpiryReport(today.AddDate(0, 0, days+1))
+	if err != nil {
+		logging.Error("Failed to Report Near Expiry: " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reporting expiring stock"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,211 @@
+package pharmacy
+
+import (
+	"bytes"
+	"cema_backend/auth"
+	"cema_backend/types"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockPharmacyStore is a mock implementation of the PharmacyStore interface.
+type MockPharmacyStore struct {
+	mock.Mock
+}
+
+func (m *MockPharmacyStore) GetDispensingPrescription(id int) (types.DispensingPrescription, error) {
+	args := m.Called(id)
+	return args.Get(0).(types.DispensingPrescription), args.Error(1)
+}
+
+func (m *MockPharmacyStore) Dispense(prescriptionID int, items []types.DispenseRequestItem, notes string, dispensedBy int) (types.Dispensing, error) {
+	args := m.Called(prescriptionID, items, notes, dispensedBy)
+	return args.Get(0).(types.Dispensing), args.Error(1)
+}
+
+func (m *MockPharmacyStore) ReceiveStock(movement types.StockMovement, recordedBy int) (int, error) {
+	args := m.Called(movement, recordedBy)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockPharmacyStore) AdjustStock(movement types.StockMovement, recordedBy int) (int, error) {
+	args := m.Called(movement, recordedBy)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockPharmacyStore) GetStockBalances(drugID int) ([]types.StockBalance, error) {
+	args := m.Called(drugID)
+	return args.Get(0).([]types.StockBalance), args.Error(1)
+}
+
+func (m *MockPharmacyStore) GetStockMovements(drugID int, from, to time.Time) ([]types.StockMovement, error) {
+	args := m.Called(drugID, from, to)
+	return args.Get(0).([]types.StockMovement), args.Error(1)
+}
+
+func (m *MockPharmacyStore) SetReorderLevel(drugID int, level int) error {
+	args := m.Called(drugID, level)
+	return args.Error(0)
+}
+
+func (m *MockPharmacyStore) LowStockReport() ([]types.StockBalance, error) {
+	args := m.Called()
+	return args.Get(0).([]types.StockBalance), args.Error(1)
+}
+
+func (m *MockPharmacyStore) NearExpiryReport(before time.Time) ([]types.StockBatch, error) {
+	args := m.Called(before)
+	return args.Get(0).([]types.StockBatch), args.Error(1)
+}
+
+func TestDispense(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockPharmacyStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	// Stands in for the auth middleware setting the authenticated doctor
+	router.Use(func(c *gin.Context) {
+		auth.SetActor(c, types.Actor{ID: 1, Email: "stan@rfh.com", Role: "doctor"})
+	})
+	router.POST("/prescriptions/:id/dispense", handler.Dispense)
+
+	// Test case: The quantities are dispensed and the new prescription status returned
+	items := []types.DispenseRequestItem{{Item: 1, Quantity: 10, BatchNumber: "AMX-2291"}, {Item: 2, Quantity: 1}}
+	mockStore.On("Dispense", 12, items, "Second item out of stock", 1).Return(types.Dispensing{
+		ID: 3, PrescriptionID: 12, Fill: 1, Status: "partially_dispensed",
+	}, nil).Once()
+
+	body := `{"items": [{"item": 1, "quantity": 10, "batch_number": " AMX-2291 "}, {"item": 2, "quantity": 1}], "notes": "Second item out of stock"}`
+	req, _ := http.NewRequest(http.MethodPost, "/prescriptions/12/dispense", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), `"status":"partially_dispensed"`)
+
+	// Test case: Not enough stock is a conflict
+	mockStore.On("Dispense", 12, []types.DispenseRequestItem{{Item: 1, Quantity: 500}}, "", 1).Return(types.Dispensing{}, errors.New("insufficient stock")).Once()
+
+	req, _ = http.NewRequest(http.MethodPost, "/prescriptions/12/dispense", bytes.NewBufferString(`{"items": [{"item": 1, "quantity": 500}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusConflict, resp.Code)
+
+	// Test case: Invalid dispensings are rejected before anything is saved
+	for _, body := range []string{`{"items": []}`, `{"items": [{"item": 1, "quantity": 0}]}`, `{"items": [{"quantity": 5}]}`} {
+		req, _ := http.NewRequest(http.MethodPost, "/prescriptions/12/dispense", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, body)
+	}
+	mockStore.AssertExpectations(t)
+}
+
+func TestReceiveStock(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := new(MockPharmacyStore)
+	handler := NewHandler(mockStore)
+
+	router := gin.Default()
+	// Stands in for the auth middleware setting the authenticated doctor
+	router.Use(func(c *gin.Context) {
+		auth.SetActor(c, types.Actor{ID: 1, Email: "stan@rfh.com", Role: "doctor"})
+	})
+	router.POST("/stock/receipts", handler.ReceiveStock)
+	router.POST("/stock/adjustments", handler.AdjustStock)
+
+	// Test case: A receipt is recorded against its batch
+	mockStore.On("ReceiveStock", types.StockMovement{
+		DrugID: 4, BatchNumber: "AMX-2291", ExpiryDate: time.Date(2027, 3, 31, 0, 0, 0, 0, time.UTC), Quantity: 500, Reason: "KEMSA delivery",
+	}, 1).Return(7, nil).Once()
+
+	body := `{"drug_id": 4, "batch_number": "AMX-2291", "expiry_date": "2027-03-31", "quantity": 500, "reason": "KEMSA delivery"}`
+	req, _ := http.NewRequest(http.MethodPost, "/stock/receipts", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	router.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// Test case: Receipts need an expiry date and a positive quantity, adjustments a reason
+	invalid := map[string]string{
+		`{"drug_id": 4, "batch_number": "AMX-2291", "quantity": 500}`:                              "/stock/receipts",
+		`{"drug_id": 4, "batch_number": "AMX-2291", "expiry_date": "31/03/2027", "quantity": 500}`: "/stock/receipts",
+		`{"drug_id": 4, "batch_number": "AMX-2291", "expiry_date": "2027-03-31", "quantity": -5}`:  "/stock/receipts",
+		`{"drug_id": 4, "quantity": -5, "reason": "Damaged"}`:                                      "/stock/adjustments",
+		`{"drug_id": 4, "batch_number": "AMX-2291", "quantity": -5}`:                               "/stock/adjustments",
+	}
+	for body, path := range invalid {
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code, body)
+	}
+	mockStore.AssertNumberOfCalls(t, "ReceiveStock", 1)
+	mockStore.AssertNumberOfCalls(t, "AdjustStock", 0)
+}
+
+// readOnlyKeys is an APIKeyStore with one key, granted clients:read only
+type readOnlyKeys struct {
+	hash string
+}
+
+func (s readOnlyKeys) AuthenticateAPIKey(keyHash string) (types.APIKey, types.Actor, error) {
+	if keyHash != s.hash {
+		return types.APIKey{}, types.Actor{}, errors.New("api key is invalid")
+	}
+	apiKey := types.APIKey{ID: 1, AccountID: 40, Permissions: []string{"clients:read"}}
+	return apiKey, types.Actor{ID: 40, Role: "integration", Permissions: apiKey.Permissions, APIKeyID: apiKey.ID}, nil
+}
+
+func (s readOnlyKeys) MarkAPIKeyUsed(apiKeyID int, ipAddress string) error {
+	return nil
+}
+
+func TestRoutesNeedPermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, _, hash, err := auth.NewAPIKey()
+	require.NoError(t, err)
+	auth.UseAPIKeys(readOnlyKeys{hash: hash})
+	defer auth.UseAPIKeys(nil)
+
+	mockStore := new(MockPharmacyStore)
+	router := gin.New()
+	NewHandler(mockStore).RegisterRoutes(router.Group("/pharmacy"))
+
+	// Test case: A key that can only read clients cannot dispense or change stock
+	for _, path := range []string{"/pharmacy/prescriptions/12/dispense", "/pharmacy/stock/receipts", "/pharmacy/stock/adjustments"} {
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(`{"items": [{"item": 1, "quantity": 15}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(auth.APIKeyHeader, key)
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusForbidden, resp.Code, path)
+	}
+	mockStore.AssertNotCalled(t, "Dispense", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,22 @@
+// This file contains the endpoints for the pharmacy service.
+package pharmacy
+
+import (
+	"cema_backend/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	// Pharmacists dispense and manage stock, reading the prescription to dispense needs prescriptions:read
+	router.Use(auth.AuthMiddleware())
+	router.GET("/prescriptions/:id", auth.RequirePermission("prescriptions:read"), h.GetPrescription)
+	router.POST("/prescriptions/:id/dispense", auth.RequirePermission("pharmacy:dispense"), h.Dispense)
+	router.GET("/stock", auth.RequirePermission("stock:manage"), h.GetStockBalances)
+	router.POST("/stock/receipts", auth.RequirePermission("stock:manage"), h.ReceiveStock)
+	router.POST("/stock/adjustments", auth.RequirePermission("stock:manage"), h.AdjustStock)
+	router.GET("/stock/:drug_id/movements", auth.RequirePermission("stock:manage"), h.GetStockMovements)
+	router.PUT("/stock/:drug_id/reorder-level", auth.RequirePermission("stock:manage"), h.SetReorderLevel)
+	router.GET("/reports/low-stock", auth.RequirePermission("stock:manage"), h.LowStockReport)
+	router.GET("/reports/near-expiry", auth.RequirePermission("stock:manage"), h.NearExpiryReport)
+}
@@ -0,0 +1,622 @@
+package pharmacy
+
+import (
+	"cema_backend/db"
+	"cema_backend/types"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore initializes a new Store instance with the given database connection.
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// dispensingItem holds what the store needs of a prescription item to dispense it
+type dispensingItem struct {
+	id        int
+	drugID    int
+	drug      string
+	remaining int
+}
+
+// GetDispensingPrescription retrieves a prescription for dispensing: what is left of each item in the current fill
+// and the batches of each formulary drug it can be dispensed from, the soonest to expire first
+func (s *Store) GetDispensingPrescription(id int) (types.DispensingPrescription, error) {
+	ctx := context.Background()
+
+	var prescription types.DispensingPrescription
+	query := `
+		SELECT p.id, c.uuid, ` + db.PrescriptionStatus + `,
+			p.date_issued, p.valid_until, p.refills - p.refills_used, p.refills_used + 1
+		FROM prescriptions p
+		JOIN clients c ON c.id = p.client_id
+		WHERE p.id = ?
+	`
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&prescription.ID, &prescription.ClientID, &prescription.Status,
+		&prescription.DateIssued, &prescription.ValidUntil, &prescription.RefillsRemaining, &prescription.Fill)
+	if err == sql.ErrNoRows {
+		return prescription, fmt.Errorf("prescription does not exist")
+	} else if err != nil {
+		return prescription, fmt.Errorf("failed to retrieve prescription: %w", err)
+	}
+
+	query = `
+		SELECT i.position, COALESCE(i.drug_id, 0), i.drug, i.free_text, COALESCE(i.strength, ''), COALESCE(i.dose, 0), COALESCE(i.unit, ''),
+			COALESCE(i.route, ''), COALESCE(i.frequency, ''), COALESCE(i.duration_days, 0), COALESCE(i.quantity, 0), COALESCE(i.instructions, ''),
+			COALESCE((SELECT SUM(di.quantity) FROM dispensing_items di JOIN dispensings d ON d.id = di.dispensing_id
+				WHERE di.prescription_item_id = i.id AND d.fill = ?), 0)
+		FROM prescription_items i
+		WHERE i.prescription_id = ?
+		ORDER BY i.position
+	`
+	rows, err := s.db.QueryContext(ctx, query, prescription.Fill, id)
+	if err != nil {
+		return prescription, fmt.Errorf("failed to retrieve prescription items: %w", err)
+	}
+	defer rows.Close()
+
+	var drugIDs []int
+	for rows.Next() {
+		var item types.DispensingItem
+		err := rows.Scan(&item.Item, &item.DrugID, &item.Drug, &item.FreeText, &item.Strength, &item.Dose, &item.Unit,
+			&item.Route, &item.Frequency, &item.DurationDays, &item.Quantity, &item.Instructions, &item.Dispensed)
+		if err != nil {
+			return prescription, err
+		}
+		item.Remaining = max(item.Quantity-item.Dispensed, 0)
+		prescription.Items = append(prescription.Items, item)
+		if item.DrugID != 0 {
+			drugIDs = append(drugIDs, item.DrugID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return prescription, err
+	}
+
+	batches, err := s.batchesFor(ctx, false, drugIDs...)
+	if err != nil {
+		return prescription, err
+	}
+	for i := range prescription.Items {
+		prescription.Items[i].Batches = batches[prescription.Items[i].DrugID]
+	}
+	return prescription, nil
+}
+
+// batchesFor retrieves the batches of the given drugs that are in stock keyed by drug id, the soonest to expire first.
+// Expired batches are only included when asked for.
+func (s *Store) batchesFor(ctx context.Context, includeExpired bool, ids ...int) (map[int][]types.StockBatch, error) {
+	batches := map[int][]types.StockBatch{}
+	if len(ids) == 0 {
+		return batches, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	expiry := ""
+	if !includeExpired {
+		expiry = `AND b.expiry_date >= CURRENT_DATE`
+	}
+	query := `
+		SELECT b.id, b.drug_id, b.batch_number, b.expiry_date, b.balance
+		FROM stock_batches b
+		WHERE b.drug_id IN (` + placeholders + `) AND b.balance <> 0 ` + expiry + `
+		ORDER BY b.expiry_date, b.id
+	`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve stock batches: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var batch types.StockBatch
+		if err := rows.Scan(&batch.ID, &batch.DrugID, &batch.BatchNumber, &batch.ExpiryDate, &batch.Balance); err != nil {
+			return nil, err
+		}
+		batches[batch.DrugID] = append(batches[batch.DrugID], batch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// Dispense records the quantities handed out for items of a prescription by the doctor whose id is dispensedBy.
+// Formulary drugs are issued from the stock of the given batch, or of the batches expiring first.
+// The prescription becomes dispensed once every item of the current fill has been handed out, partially dispensed until then.
+func (s *Store) Dispense(prescriptionID int, items []types.DispenseRequestItem, notes string, dispensedBy int) (types.Dispensing, error) {
+	ctx := context.Background()
+	dispensing := types.Dispensing{PrescriptionID: prescriptionID, Lines: []types.DispensedLine{}}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dispensing, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var status string
+	var expired bool
+	query := `SELECT status, valid_until < CURRENT_DATE, refills_used + 1 FROM prescriptions WHERE id = ? FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, prescriptionID).Scan(&status, &expired, &dispensing.Fill)
+	if err == sql.ErrNoRows {
+		return dispensing, fmt.Errorf("prescription does not exist")
+	} else if err != nil {
+		return dispensing, fmt.Errorf("failed to retrieve prescription: %w", err)
+	}
+	if status != "active" && status != "partially_dispensed" {
+		return dispensing, fmt.Errorf("prescription cannot be dispensed")
+	}
+	if expired {
+		return dispensing, fmt.Errorf("prescription has expired")
+	}
+
+	// What is left of each item in the current fill, keyed by position
+	query = `
+		SELECT i.position, i.id, COALESCE(i.drug_id, 0), i.drug, COALESCE(i.quantity, 0) - COALESCE((
+			SELECT SUM(di.quantity) FROM dispensing_items di JOIN dispensings d ON d.id = di.dispensing_id
+			WHERE di.prescription_item_id = i.id AND d.fill = ?), 0)
+		FROM prescription_items i
+		WHERE i.prescription_id = ?
+	`
+	rows, err := tx.QueryContext(ctx, query, dispensing.Fill, prescriptionID)
+	if err != nil {
+		return dispensing, fmt.Errorf("failed to retrieve prescription items: %w", err)
+	}
+	prescriptionItems := map[int]*dispensingItem{}
+	for rows.Next() {
+		var position int
+		var item dispensingItem
+		if err := rows.Scan(&position, &item.id, &item.drugID, &item.drug, &item.remaining); err != nil {
+			rows.Close()
+			return dispensing, err
+		}
+		prescriptionItems[position] = &item
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return dispensing, err
+	}
+
+	query = `INSERT INTO dispensings (prescription_id, fill, notes, dispensed_by) VALUES (?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, prescriptionID, dispensing.Fill, db.NullIfEmpty(notes), dispensedBy)
+	if err != nil {
+		return dispensing, fmt.Errorf("failed to save dispensing: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return dispensing, fmt.Errorf("failed to retrieve dispensing id: %w", err)
+	}
+	dispensing.ID = int(id)
+
+	for _, requested := range items {
+		item, found := prescriptionItems[requested.Item]
+		if !found {
+			return dispensing, fmt.Errorf("prescription item does not exist")
+		}
+		if requested.Quantity > item.remaining {
+			return dispensing, fmt.Errorf("quantity is more than is left to dispense")
+		}
+		item.remaining -= requested.Quantity
+
+		// Free text drugs are not kept in stock
+		if item.drugID == 0 {
+			query := `INSERT INTO dispensing_items (dispensing_id, prescription_item_id, quantity) VALUES (?, ?, ?)`
+			if _, err := tx.ExecContext(ctx, query, dispensing.ID, item.id, requested.Quantity); err != nil {
+				return dispensing, fmt.Errorf("failed to save dispensed item: %w", err)
+			}
+			dispensing.Lines = append(dispensing.Lines, types.DispensedLine{Item: requested.Item, Drug: item.drug, Quantity: requested.Quantity})
+			continue
+		}
+
+		batches, err := allocateBatches(ctx, tx, item.drugID, requested.BatchNumber, requested.Quantity)
+		if err != nil {
+			return dispensing, err
+		}
+		for _, batch := range batches {
+			query := `INSERT INTO dispensing_items (dispensing_id, prescription_item_id, batch_id, quantity) VALUES (?, ?, ?, ?)`
+			if _, err := tx.ExecContext(ctx, query, dispensing.ID, item.id, batch.ID, batch.Balance); err != nil {
+				return dispensing, fmt.Errorf("failed to save dispensed item: %w", err)
+			}
+			if err := moveStock(ctx, tx, int64(batch.ID), -batch.Balance); err != nil {
+				return dispensing, err
+			}
+			query = `INSERT INTO stock_movements (drug_id, batch_id, kind, quantity, dispensing_id, recorded_by)
+				VALUES (?, ?, 'issue', ?, ?, ?)`
+			if _, err := tx.ExecContext(ctx, query, item.drugID, batch.ID, -batch.Balance, dispensing.ID, dispensedBy); err != nil {
+				return dispensing, fmt.Errorf("failed to save stock issue: %w", err)
+			}
+			expiryDate := batch.ExpiryDate
+			dispensing.Lines = append(dispensing.Lines, types.DispensedLine{
+				Item: requested.Item, Drug: item.drug, BatchNumber: batch.BatchNumber, ExpiryDate: &expiryDate, Quantity: batch.Balance,
+			})
+		}
+	}
+
+	dispensing.Status = "dispensed"
+	for _, item := range prescriptionItems {
+		if item.remaining > 0 {
+			dispensing.Status = "partially_dispensed"
+		}
+	}
+	query = `UPDATE prescriptions SET status = ?, dispensed_at = IF(? = 'dispensed', CURRENT_TIMESTAMP, dispensed_at) WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, query, dispensing.Status, dispensing.Status, prescriptionID); err != nil {
+		return dispensing, fmt.Errorf("failed to update prescription status: %w", err)
+	}
+	query = `INSERT INTO prescription_events (prescription_id, event, reason, recorded_by) VALUES (?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, query, prescriptionID, dispensing.Status, db.NullIfEmpty(notes), dispensedBy); err != nil {
+		return dispensing, fmt.Errorf("failed to save prescription history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dispensing, fmt.Errorf("failed to commit dispensing: %w", err)
+	}
+	return dispensing, nil
+}
+
+// allocateBatches locks the stock a quantity of a drug is issued from: the given batch,
+// or the batches that have not expired, the soonest to expire first.
+// The Balance of each batch returned is the quantity to issue from it.
+func allocateBatches(ctx context.Context, tx *sql.Tx, drugID int, batchNumber string, quantity int) ([]types.StockBatch, error) {
+	query := `SELECT b.id, b.batch_number, b.expiry_date, b.expiry_date < CURRENT_DATE, b.balance
+		FROM stock_batches b WHERE b.drug_id = ?`
+	args := []interface{}{drugID}
+	if batchNumber != "" {
+		query += ` AND b.batch_number = ?`
+		args = append(args, batchNumber)
+	} else {
+		query += ` AND b.expiry_date >= CURRENT_DATE`
+	}
+	query += ` ORDER BY b.expiry_date, b.id FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve stock batches: %w", err)
+	}
+	defer rows.Close()
+
+	var allocated []types.StockBatch
+	found := false
+	for rows.Next() && quantity > 0 {
+		var batch types.StockBatch
+		var expired bool
+		if err := rows.Scan(&batch.ID, &batch.BatchNumber, &batch.ExpiryDate, &expired, &batch.Balance); err != nil {
+			return nil, err
+		}
+		found = true
+		if expired {
+			return nil, fmt.Errorf("batch has expired")
+		}
+		if batch.Balance <= 0 {
+			continue
+		}
+		batch.DrugID = drugID
+		batch.Balance = min(batch.Balance, quantity)
+		quantity -= batch.Balance
+		allocated = append(allocated, batch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if batchNumber != "" && !found {
+		return nil, fmt.Errorf("batch does not exist")
+	}
+	if quantity > 0 {
+		return nil, fmt.Errorf("insufficient stock")
+	}
+	return allocated, nil
+}
+
+// ReceiveStock records stock received into a batch of a drug, the batch is created on its first receipt.
+// It returns the id of the stock movement.
+func (s *Store) ReceiveStock(movement types.StockMovement, recordedBy int) (int, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM formulary WHERE id = ?`, movement.DrugID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("drug does not exist")
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to retrieve drug: %w", err)
+	}
+
+	var batchID int64
+	var expiryDate time.Time
+	query := `SELECT id, expiry_date FROM stock_batches WHERE drug_id = ? AND batch_number = ? FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, movement.DrugID, movement.BatchNumber).Scan(&batchID, &expiryDate)
+	if err == sql.ErrNoRows {
+		query := `INSERT INTO stock_batches (drug_id, batch_number, expiry_date) VALUES (?, ?, ?)`
+		result, err := tx.ExecContext(ctx, query, movement.DrugID, movement.BatchNumber, movement.ExpiryDate)
+		if err != nil {
+			return 0, fmt.Errorf("failed to save stock batch: %w", err)
+		}
+		if batchID, err = result.LastInsertId(); err != nil {
+			return 0, fmt.Errorf("failed to retrieve stock batch id: %w", err)
+		}
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to retrieve stock batch: %w", err)
+	} else if expiryDate.Format(dateLayout) != movement.ExpiryDate.Format(dateLayout) {
+		return 0, fmt.Errorf("batch has a different expiry date")
+	}
+
+	id, err := insertMovement(ctx, tx, movement.DrugID, batchID, "receipt", movement.Quantity, movement.Reason, recordedBy)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit stock receipt: %w", err)
+	}
+	return id, nil
+}
+
+// AdjustStock corrects the balance of a batch, e.g. after a stock count or to write off expired or damaged stock.
+// A batch cannot be adjusted below zero. It returns the id of the stock movement.
+func (s *Store) AdjustStock(movement types.StockMovement, recordedBy int) (int, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var batchID int64
+	var balance int
+	query := `SELECT id, balance FROM stock_batches WHERE drug_id = ? AND batch_number = ? FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, movement.DrugID, movement.BatchNumber).Scan(&batchID, &balance)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("batch does not exist")
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to retrieve stock batch: %w", err)
+	}
+	if balance+movement.Quantity < 0 {
+		return 0, fmt.Errorf("insufficient stock")
+	}
+
+	id, err := insertMovement(ctx, tx, movement.DrugID, batchID, "adjustment", movement.Quantity, movement.Reason, recordedBy)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit stock adjustment: %w", err)
+	}
+	return id, nil
+}
+
+// moveStock adds a quantity to the balance of a batch, or takes it off when negative.
+// The balance is checked and changed in one statement so that it cannot go below zero
+// however many issues and adjustments run at once.
+func moveStock(ctx context.Context, tx *sql.Tx, batchID int64, quantity int) error {
+	result, err := tx.ExecContext(ctx, `UPDATE stock_batches SET balance = balance + ? WHERE id = ? AND balance + ? >= 0`, quantity, batchID, quantity)
+	if err != nil {
+		return fmt.Errorf("failed to update stock balance: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to update stock balance: %w", err)
+	} else if affected == 0 {
+		return fmt.Errorf("insufficient stock")
+	}
+	return nil
+}
+
+// insertMovement adds an entry to the stock ledger, updating the balance of the batch, and returns its id
+func insertMovement(ctx context.Context, tx *sql.Tx, drugID int, batchID int64, kind string, quantity int, reason string, recordedBy int) (int, error) {
+	if err := moveStock(ctx, tx, batchID, quantity); err != nil {
+		return 0, err
+	}
+	query := `INSERT INTO stock_movements (drug_id, batch_id, kind, quantity, reason, recorded_by)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, drugID, batchID, kind, quantity, db.NullIfEmpty(reason), recordedBy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save stock movement: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve stock movement id: %w", err)
+	}
+	return int(id), nil
+}
+
+// balanceColumns are the columns scanned by scanBalance, of a formulary drug f grouped with its stock movements m and batches b
+const balanceColumns = `f.id, f.generic_name, f.form, f.strength, f.reorder_level,
+	COALESCE(SUM(m.quantity), 0), COALESCE(SUM(CASE WHEN b.expiry_date >= CURRENT_DATE THEN m.quantity END), 0)`
+
+// balanceJoins joins the stock movements and batches of a formulary drug f
+const balanceJoins = `FROM formulary f
+	LEFT JOIN stock_movements m ON m.drug_id = f.id
+	LEFT JOIN stock_batches b ON b.id = m.batch_id`
+
+// queryBalances runs a query selecting balanceColumns
+func (s *Store) queryBalances(ctx context.Context, query string, args ...interface{}) ([]types.StockBalance, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve stock balances: %w", err)
+	}
+	defer rows.Close()
+
+	balances := []types.StockBalance{}
+	for rows.Next() {
+		var balance types.StockBalance
+		err := rows.Scan(&balance.DrugID, &balance.Drug, &balance.Form, &balance.Strength, &balance.ReorderLevel, &balance.OnHand, &balance.Usable)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan stock balance: %w", err)
+		}
+		balances = append(balances, balance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve stock balances: %w", err)
+	}
+	return balances, nil
+}
+
+// GetStockBalances retrieves the stock of a drug with its batches, or of every active drug and any other drug still in stock when drugID is 0
+func (s *Store) GetStockBalances(drugID int) ([]types.StockBalance, error) {
+	ctx := context.Background()
+
+	query := `SELECT ` + balanceColumns + ` ` + balanceJoins
+	var args []interface{}
+	if drugID != 0 {
+		query += ` WHERE f.id = ? GROUP BY f.id`
+		args = append(args, drugID)
+	} else {
+		query += ` GROUP BY f.id HAVING f.active OR COALESCE(SUM(m.quantity), 0) <> 0`
+	}
+	query += ` ORDER BY f.generic_name, f.form, f.strength`
+
+	balances, err := s.queryBalances(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if drugID != 0 && len(balances) == 0 {
+		return nil, fmt.Errorf("drug does not exist")
+	}
+
+	ids := make([]int, len(balances))
+	for i, balance := range balances {
+		ids[i] = balance.DrugID
+	}
+	batches, err := s.batchesFor(ctx, true, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range balances {
+		balances[i].Batches = batches[balances[i].DrugID]
+	}
+	return balances, nil
+}
+
+// GetStockMovements retrieves the stock ledger of a drug from oldest to newest, from and to are optional and to is excluded
+func (s *Store) GetStockMovements(drugID int, from, to time.Time) ([]types.StockMovement, error) {
+	ctx := context.Background()
+
+	var exists int
+	err := s.db.QueryRowContext(ctx, `SELECT 1 FROM formulary WHERE id = ?`, drugID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("drug does not exist")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve drug: %w", err)
+	}
+
+	query := `
+		SELECT m.id, m.drug_id, b.batch_number, b.expiry_date, m.kind, m.quantity, COALESCE(m.reason, ''),
+			COALESCE(m.dispensing_id, 0), m.recorded_by, m.recorded_at
+		FROM stock_movements m
+		JOIN stock_batches b ON b.id = m.batch_id
+		WHERE m.drug_id = ?
+	`
+	args := []interface{}{drugID}
+	if !from.IsZero() {
+		query += ` AND m.recorded_at >= ?`
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		query += ` AND m.recorded_at < ?`
+		args = append(args, to)
+	}
+	query += ` ORDER BY m.recorded_at, m.id`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve stock movements: %w", err)
+	}
+	defer rows.Close()
+
+	movements := []types.StockMovement{}
+	for rows.Next() {
+		var movement types.StockMovement
+		var recordedBy sql.NullInt64
+		err := rows.Scan(&movement.ID, &movement.DrugID, &movement.BatchNumber, &movement.ExpiryDate, &movement.Kind, &movement.Quantity,
+			&movement.Reason, &movement.DispensingID, &recordedBy, &movement.RecordedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan stock movement: %w", err)
+		}
+		movement.RecordedBy = int(recordedBy.Int64)
+		movements = append(movements, movement)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve stock movements: %w", err)
+	}
+	return movements, nil
+}
+
+// SetReorderLevel sets the stock level at or below which a drug is reported as low on stock, 0 turns the report off for it
+func (s *Store) SetReorderLevel(drugID int, level int) error {
+	ctx := context.Background()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE formulary SET reorder_level = ? WHERE id = ?`, level, drugID)
+	if err != nil {
+		return fmt.Errorf("failed to update reorder level: %w", err)
+	}
+	// MySQL reports no affected rows when the level did not change, so check the drug exists
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		var exists int
+		err := s.db.QueryRowContext(ctx, `SELECT 1 FROM formulary WHERE id = ?`, drugID).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("drug does not exist")
+		} else if err != nil {
+			return fmt.Errorf("failed to retrieve drug: %w", err)
+		}
+	}
+	return nil
+}
+
+// LowStockReport retrieves the active drugs whose usable stock is at or below their reorder level, the lowest first
+func (s *Store) LowStockReport() ([]types.StockBalance, error) {
+	query := `SELECT ` + balanceColumns + ` ` + balanceJoins + `
+		WHERE f.active AND f.reorder_level > 0
+		GROUP BY f.id
+		HAVING COALESCE(SUM(CASE WHEN b.expiry_date >= CURRENT_DATE THEN m.quantity END), 0) <= f.reorder_level
+		ORDER BY COALESCE(SUM(CASE WHEN b.expiry_date >= CURRENT_DATE THEN m.quantity END), 0) - f.reorder_level, f.generic_name`
+	return s.queryBalances(context.Background(), query)
+}
+
+// NearExpiryReport retrieves the batches in stock that expire before the given date, expired ones included, the soonest to expire first
+func (s *Store) NearExpiryReport(before time.Time) ([]types.StockBatch, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT b.id, b.drug_id, CONCAT(f.generic_name, ' ', f.strength, ' ', f.form), b.batch_number, b.expiry_date, b.balance
+		FROM stock_batches b
+		JOIN formulary f ON f.id = b.drug_id
+		WHERE b.expiry_date < ? AND b.balance > 0
+		ORDER BY b.expiry_date, b.id
+	`
+	rows, err := s.db.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve expiring batches: %w", err)
+	}
+	defer rows.Close()
+
+	batches := []types.StockBatch{}
+	for rows.Next() {
+		var batch types.StockBatch
+		if err := rows.Scan(&batch.ID, &batch.DrugID, &batch.Drug, &batch.BatchNumber, &batch.ExpiryDate, &batch.Balance); err != nil {
+			return nil, fmt.Errorf("failed to scan stock batch: %w", err)
+		}
+		batches = append(batches, batch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve expiring batches: %w", err)
+	}
+	return batches, nil
+}
@@ -0,0 +1,66 @@
+package pharmacy
+
+import (
+	"cema_backend/db/dbtest"
+	"cema_backend/types"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentStockIssues(t *testing.T) {
+	db := dbtest.Open(t)
+	store := NewStore(db)
+
+	name := fmt.Sprintf("Stock test %d", time.Now().UnixNano())
+	pharmacistID := dbtest.Doctor(t, db, fmt.Sprintf("pharmacist-%d@example.com", time.Now().UnixNano()))
+	result, err := db.Exec(`INSERT INTO formulary (generic_name, form, strength) VALUES (?, 'tablet', '500 mg')`, name)
+	require.NoError(t, err)
+	drugID, err := result.LastInsertId()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM stock_movements WHERE drug_id = ?`, drugID)
+		db.Exec(`DELETE FROM stock_batches WHERE drug_id = ?`, drugID)
+		db.Exec(`DELETE FROM formulary WHERE id = ?`, drugID)
+	})
+
+	_, err = store.ReceiveStock(types.StockMovement{
+		DrugID: int(drugID), BatchNumber: "B1", ExpiryDate: time.Now().AddDate(1, 0, 0), Quantity: 10,
+	}, pharmacistID)
+	require.NoError(t, err)
+
+	// Test case: Of ten write-offs of 3 at once from a balance of 10, only three go through
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.AdjustStock(types.StockMovement{DrugID: int(drugID), BatchNumber: "B1", Quantity: -3, Reason: "Damaged"}, pharmacistID)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			require.EqualError(t, err, "insufficient stock")
+		}
+	}
+	require.Equal(t, 3, succeeded)
+
+	// The balance and the ledger agree, and neither went below zero
+	var balance, ledger int
+	err = db.QueryRow(`SELECT b.balance, (SELECT SUM(m.quantity) FROM stock_movements m WHERE m.batch_id = b.id)
+		FROM stock_batches b WHERE b.drug_id = ?`, drugID).Scan(&balance, &ledger)
+	require.NoError(t, err)
+	require.Equal(t, 1, balance)
+	require.Equal(t, 1, ledger)
+}
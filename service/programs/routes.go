@@ -2,11 +2,17 @@
 package programs
 
 import (
+	"cema_backend/auth"
+
 	"github.com/gin-gonic/gin"
 )
 
 // RegisterRoutes registers the routes for program-related operations.
 func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
-	router.POST("/register", h.RegisterPrograms)
-	router.GET("/all", h.GetPrograms)
+	protected := router.Group("/")
+	protected.Use(auth.AuthMiddleware())
+	{
+		protected.POST("/register", auth.RequirePermission("programs:write"), h.RegisterPrograms)
+		protected.GET("/all", auth.RequirePermission("programs:read"), h.GetPrograms)
+	}
 }
@@ -11,8 +11,150 @@ import (
 )
 
 type DoctorStore interface {
-	RegisterDoctors(doctor DoctorRegistration) error
-	LoginDoctor(email, password string) error
+	RegisterDoctors(doctor DoctorRegistration) (string, error)
+	LoginDoctor(email, password string) (Actor, error)
+	GetRoles() ([]Role, error)
+	SetDoctorRole(doctorID int, role string) error
+	GetPendingDoctors() ([]StaffAccount, error)
+	ApproveDoctor(doctorID int, role string, approvedBy int) error
+	RejectDoctor(doctorID int, reason string, rejectedBy int) error
+	CreateInvitation(invitation Invitation, tokenHash string, invitedBy int) (Invitation, error)
+	CreateSession(session Session, refreshTokenHash string) (Session, error)
+	RefreshSession(refreshTokenHash string, newRefreshTokenHash string, validDays int) (Actor, error)
+	RevokeSession(sessionID string) error
+	RevokeDoctorSessions(doctorID int, revokedBy int) (int, error)
+	GetMFAStatus(doctorID int) (MFAStatus, error)
+	StartMFAEnrollment(doctorID int, secret string) error
+	ConfirmMFAEnrollment(doctorID int, code string, recoveryCodeHashes []string) error
+	VerifyMFACode(doctorID int, code string) error
+	UseRecoveryCode(doctorID int, codeHash string) error
+	ReplaceRecoveryCodes(doctorID int, recoveryCodeHashes []string) error
+	CreateMFAChallenge(doctorID int, tokenHash string, validMinutes int) error
+	GetMFAChallenge(tokenHash string) (Actor, error)
+	CountMFAAttempt(tokenHash string) error
+	CompleteMFAChallenge(tokenHash string) (Actor, error)
+	SetRoleMFA(role string, required bool) error
+	LoginBlocked(email, ipAddress string) (int, error)
+	RecordLoginFailure(email, ipAddress string, policy LoginPolicy) error
+	RecordLoginSuccess(doctorID int, email, ipAddress string) error
+	UnlockDoctor(doctorID int, unlockedBy int) error
+	ResetMFA(doctorID int, resetBy int) (int, error)
+	GetSecurityEvents(kind string, limit int) ([]SecurityEvent, error)
+	ChangePassword(doctorID int, currentPassword, newPassword string, history int, keepSessionID string) error
+	CreatePasswordReset(email, tokenHash string, validMinutes int) (StaffAccount, error)
+	ResetPassword(tokenHash, newPassword string, history int) error
+	CreateAPIKey(apiKey APIKey, keyHash string, createdBy int) (APIKey, error)
+	GetAPIKeys() ([]APIKey, error)
+	RevokeAPIKey(apiKeyID int, revokedBy int) error
+	SessionStore
+	APIKeyStore
+}
+
+// Notifier delivers messages to staff, such as password reset links
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// Notification is a message for a member of staff
+type Notification struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// LoginPolicy is how failed logins are throttled. Each failure blocks the next login of the account,
+// and of the IP address, for twice as long, reaching a threshold locks it out for LockoutMinutes.
+type LoginPolicy struct {
+	AccountThreshold int
+	IPThreshold      int
+	LockoutMinutes   int
+}
+
+// SecurityEvent is a lockout, suspicious login, unlock or MFA reset recorded for admins to review
+type SecurityEvent struct {
+	ID        int    `json:"id"`
+	Kind      string `json:"kind"`
+	DoctorID  int    `json:"doctor_id,omitempty"`
+	Email     string `json:"email,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	// RecordedBy is the admin who unlocked an account
+	RecordedBy int       `json:"recorded_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// MFAStatus tells whether a doctor has set up an authenticator app and whether their role requires one
+type MFAStatus struct {
+	Enabled  bool `json:"enabled"`
+	Required bool `json:"required"`
+}
+
+// SessionStore is used by the auth middleware to turn away tokens of sessions that were revoked
+type SessionStore interface {
+	SessionActive(sessionID string) (bool, error)
+}
+
+// APIKeyStore is used by the auth middleware to check the API keys of integrations
+type APIKeyStore interface {
+	AuthenticateAPIKey(keyHash string) (APIKey, Actor, error)
+	MarkAPIKeyUsed(apiKeyID int, ipAddress string) error
+}
+
+// APIKey is a key an integration, such as the lab analyser bridge, authenticates with instead of a doctor's login.
+// Each key has a service account its changes are recorded against, granted only the permissions of the key.
+type APIKey struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Prefix is the start of the key, telling keys apart without the secret
+	Prefix      string   `json:"prefix"`
+	Permissions []string `json:"permissions"`
+	// AllowedIPs are the IP addresses and CIDR ranges the key can be used from, empty allows any
+	AllowedIPs []string `json:"allowed_ips"`
+	AccountID  int      `json:"account_id"`
+	// ValidDays is how long the key can be used for, ExpiresAt is set from it. Keys without one do not expire.
+	ValidDays  int        `json:"valid_days,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	CreatedBy  int        `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// Key is only returned when the key is created, only its hash is kept
+	Key string `json:"key,omitempty"`
+}
+
+// Session is a login of a doctor, kept going by refreshing its access token until it expires or is revoked
+type Session struct {
+	ID        string `json:"id"`
+	DoctorID  int    `json:"doctor_id"`
+	UserAgent string `json:"user_agent,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+	// ValidDays is how long the session can go without being refreshed, ExpiresAt is set from it
+	ValidDays int       `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Actor is the authenticated doctor making a request, carried in the JWT claims
+type Actor struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	// Permissions are those of the role when the token was issued
+	Permissions []string `json:"permissions"`
+	// SessionID is the session the token was issued for
+	SessionID string `json:"-"`
+	// APIKeyID is the API key an integration authenticated with, the actor is then the service account of the key
+	APIKeyID int `json:"-"`
+}
+
+// Role is a role staff can be assigned with the permissions it grants
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	// MFARequired makes everyone with the role sign in with an authenticator app
+	MFARequired bool `json:"mfa_required"`
 }
 
 type DoctorRegistration struct {
@@ -22,6 +164,36 @@ type DoctorRegistration struct {
 	PhoneNumber string `json:"phonenumber"`
 	Department  string `json:"department"`
 	Password    string `json:"password"`
+	// RegistrationNumber is the doctor's number with the medical board, printed on the documents they issue
+	RegistrationNumber string `json:"registration_number"`
+	// InvitationToken is the signup token of an invitation, registering with it skips the approval
+	InvitationToken string `json:"invitation_token"`
+}
+
+// StaffAccount is a registered member of staff as seen by the admins reviewing registrations
+type StaffAccount struct {
+	ID          int    `json:"id"`
+	FirstName   string `json:"firstname"`
+	LastName    string `json:"lastname"`
+	Email       string `json:"email"`
+	PhoneNumber string `json:"phonenumber"`
+	Department  string `json:"department"`
+	Role        string `json:"role"`
+	// Status is pending until an admin approves or rejects the account, invited staff are active straight away
+	Status       string    `json:"status"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// Invitation lets the member of staff with the email register with the role without waiting for approval
+type Invitation struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	// ValidHours is how long the invitation can be used for, ExpiresAt is set from it
+	ValidHours int       `json:"valid_hours"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Token is only returned when the invitation is created, it is not kept
+	Token string `json:"token,omitempty"`
 }
 
 type DocLogInRequest struct {
@@ -29,40 +201,294 @@ type DocLogInRequest struct {
 	Password string `json:"password"`
 }
 type ClientStore interface {
-	RegisterClients(client Client) error
-	EnrollClient(phoneNumber string, programName string) error
-	SearchClient(phonenumber string) (ClientResponse, error)
-	GetAllClients() ([]Client, error)
-	UpdateClient(client Client) error
-	DeleteClient(phonenumber string) error
-	CreatePrescription(prescription Prescription) error
-	UpdatePrescription(prescription Prescription) error
-	GetPrescriptionsByClient(client_phone string) ([]Prescription, error)
+	RegisterClients(client Client, registeredBy int) (string, error)
+	EnrollClient(clientID string, programName string, enrolledBy int) error
+	GetClient(clientID string) (ClientResponse, error)
+	SearchClient(phonenumber string, includeArchived bool) (ClientResponse, error)
+	FindClients(q string) ([]ClientResponse, bool, error)
+	GetAllClients(query ClientQuery) (ClientPage, error)
+	UpdateClient(client Client, recordedBy int) error
+	DeleteClient(clientID string, archivedBy int) error
+	RestoreClient(clientID string, restoredBy int) error
+	PurgeArchivedClients(before time.Time, purgedBy int) (PurgeReport, error)
+	FindDuplicateCandidates(client Client) ([]ClientResponse, error)
+	MergeClients(survivorID string, duplicateID string, mergedBy int) error
+	AddClientPhone(clientID string, phone ClientPhone, addedBy int) error
+	RemoveClientPhone(clientID string, phonenumber string, removedBy int) error
+	CreatePrescription(prescription Prescription) (int, []PrescriptionWarning, error)
+	UpdatePrescription(prescription Prescription, recordedBy int) ([]PrescriptionWarning, error)
+	GetPrescription(id int) (Prescription, error)
+	GetPrescriptionsByClient(clientID string) ([]Prescription, error)
+	ChangePrescriptionStatus(id int, status string, reason string, changedBy int) error
+	RefillPrescription(id int, refilledBy int) error
+	GetActiveMedications(clientID string) ([]Medication, error)
+	AddClientAllergy(clientID string, allergy Allergy, recordedBy int) (int, error)
+	ResolveClientAllergy(clientID string, allergyID int, resolvedBy int) error
+	ReplaceInteractionRules(rules []InteractionRule) error
 }
+
+// Client is identified by UUID in the API, the numeric ID is only used inside the database
 type Client struct {
-	ID               int     `json:"id"`
-	FirstName        string  `json:"firstname"`
-	LastName         string  `json:"lastname"`
-	PhoneNumber      string  `json:"phonenumber"`
-	Age              int     `json:"age"`
+	ID          int    `json:"-"`
+	UUID        string `json:"id"`
+	FirstName   string `json:"firstname"`
+	LastName    string `json:"lastname"`
+	PhoneNumber string `json:"phonenumber"`
+	// DateOfBirth is formatted as YYYY-MM-DD, it is estimated for clients who only know their approximate age
+	DateOfBirth  string `json:"date_of_birth"`
+	DOBEstimated bool   `json:"dob_estimated"`
+	// Age is computed from the date of birth when a client is read,
+	// on registration it can be sent instead of a date of birth
+	Age                    int    `json:"age"`
+	Sex                    string `json:"sex"`
+	NationalID             string `json:"national_id,omitempty"`
+	BirthCertificateNumber string `json:"birth_certificate_number,omitempty"`
+	County                 string `json:"county,omitempty"`
+	SubCounty              string `json:"sub_county,omitempty"`
+	Ward                   string `json:"ward,omitempty"`
+	// Height and Weight are the latest measurements, setting them records a new observation
 	Height           float32 `json:"height"`
 	Weight           float32 `json:"weight"`
 	EmergencyContact string  `json:"emergency_contact"`
 	EmergencyNumber  string  `json:"emergency_number"`
 }
 
+// ClientUpdate is a partial update of a client, fields left nil are not changed
+type ClientUpdate struct {
+	FirstName              *string  `json:"firstname"`
+	LastName               *string  `json:"lastname"`
+	PhoneNumber            *string  `json:"phonenumber"`
+	DateOfBirth            *string  `json:"date_of_birth"`
+	DOBEstimated           *bool    `json:"dob_estimated"`
+	Age                    *int     `json:"age"`
+	Sex                    *string  `json:"sex"`
+	NationalID             *string  `json:"national_id"`
+	BirthCertificateNumber *string  `json:"birth_certificate_number"`
+	County                 *string  `json:"county"`
+	SubCounty              *string  `json:"sub_county"`
+	Ward                   *string  `json:"ward"`
+	Height                 *float32 `json:"height"`
+	Weight                 *float32 `json:"weight"`
+	EmergencyContact       *string  `json:"emergency_contact"`
+	EmergencyNumber        *string  `json:"emergency_number"`
+}
+
+// ClientQuery holds the pagination, filter and sort options used when listing clients.
+// Zero values mean the filter is not applied.
+type ClientQuery struct {
+	Limit         int
+	After         *ClientCursor
+	MinAge        int
+	MaxAge        int
+	Program       string
+	EnrolledSince time.Time
+	NamePrefix    string
+	Sort          string
+	// Archived clients are only listed when asked for
+	IncludeArchived bool
+}
+
+// ClientCursor marks the last client of a page, it holds the value of the
+// sort column and the id of that client so the next page can continue after it.
+// Sort is the sort option the page was listed with, a cursor only continues that sort.
+type ClientCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// ClientPage is one page of clients returned by a listing
+type ClientPage struct {
+	Items      []Client `json:"items"`
+	NextCursor string   `json:"next_cursor"`
+	Total      int      `json:"total"`
+}
+
+// ClientPhone is one of the phone numbers of a client
+type ClientPhone struct {
+	PhoneNumber string `json:"phonenumber"`
+	Primary     bool   `json:"primary"`
+}
+
 type ClientResponse struct {
-	ID               int            `json:"id"`
-	FirstName        string         `json:"firstname"`
-	LastName         string         `json:"lastname"`
-	PhoneNumber      string         `json:"phonenumber"`
-	Height           float64        `json:"height"`
-	Weight           float64        `json:"weight"`
-	Age              int            `json:"age"`
-	EmergencyContact string         `json:"emergency_contact"`
-	EmergencyNumber  string         `json:"emergency_number"`
-	Programs         []Programs     `json:"programs"`
-	Prescriptions    []Prescription `json:"prescriptions"`
+	ID           int           `json:"-"`
+	UUID         string        `json:"id"`
+	FirstName    string        `json:"firstname"`
+	LastName     string        `json:"lastname"`
+	PhoneNumber  string        `json:"phonenumber"`
+	PhoneNumbers []ClientPhone `json:"phonenumbers"`
+	Height       float64       `json:"height"`
+	Weight       float64       `json:"weight"`
+	DateOfBirth  string        `json:"date_of_birth"`
+	DOBEstimated bool          `json:"dob_estimated"`
+	// Age is computed from the date of birth when the client is read
+	Age                    int     `json:"age"`
+	Sex                    string  `json:"sex"`
+	NationalID             string  `json:"national_id,omitempty"`
+	BirthCertificateNumber string  `json:"birth_certificate_number,omitempty"`
+	County                 string  `json:"county,omitempty"`
+	SubCounty              string  `json:"sub_county,omitempty"`
+	Ward                   string  `json:"ward,omitempty"`
+	EmergencyContact       string  `json:"emergency_contact"`
+	EmergencyNumber        string  `json:"emergency_number"`
+	Vitals                 *Vitals `json:"vitals,omitempty"`
+	// Encounters is the client's timeline of visits, the most recent first
+	Encounters    []Encounter    `json:"encounters"`
+	Diagnoses     []Diagnosis    `json:"diagnoses"`
+	Allergies     []Allergy      `json:"allergies"`
+	MergedInto    string         `json:"merged_into,omitempty"`
+	ArchivedAt    *time.Time     `json:"archived_at,omitempty"`
+	ArchivedBy    string         `json:"archived_by,omitempty"`
+	Programs      []Programs     `json:"programs"`
+	Prescriptions []Prescription `json:"prescriptions"`
+}
+
+// ClientMatch is a client returned by a free text search with its relevance score
+type ClientMatch struct {
+	ClientResponse
+	Score float64 `json:"score"`
+}
+
+// ClientSearchPage is one page of ranked search results
+type ClientSearchPage struct {
+	Items      []ClientMatch `json:"items"`
+	NextOffset int           `json:"next_offset,omitempty"`
+	// Total counts the matches among the ranked candidates. When Truncated is set more clients matched
+	// than could be ranked, Total is then a lower bound and the query should be narrowed down.
+	Total     int  `json:"total"`
+	Truncated bool `json:"truncated"`
+}
+
+// DuplicateCandidate is an existing client that looks like the same person as a new one
+type DuplicateCandidate struct {
+	Client  ClientResponse `json:"client"`
+	Score   float64        `json:"score"`
+	Reasons []string       `json:"reasons"`
+}
+
+// PurgeReport lists what was permanently removed by a retention purge
+type PurgeReport struct {
+	Before        time.Time `json:"before"`
+	Clients       []string  `json:"clients"`
+	Tombstones    int       `json:"tombstones"`
+	Enrollments   int       `json:"enrollments"`
+	Prescriptions int       `json:"prescriptions"`
+	PhoneNumbers  int       `json:"phone_numbers"`
+	Observations  int       `json:"observations"`
+	Encounters    int       `json:"encounters"`
+	Diagnoses     int       `json:"diagnoses"`
+	Allergies     int       `json:"allergies"`
+}
+
+type ObservationStore interface {
+	RecordObservation(observation Observation, recordedBy int) (int, error)
+	GetObservations(clientID string, from, to time.Time) ([]Observation, error)
+}
+
+// Vitals are the measurements taken from a client, those not taken are left nil
+type Vitals struct {
+	Height      *float64 `json:"height,omitempty"`       // cm
+	Weight      *float64 `json:"weight,omitempty"`       // kg
+	SystolicBP  *int     `json:"systolic_bp,omitempty"`  // mmHg
+	DiastolicBP *int     `json:"diastolic_bp,omitempty"` // mmHg
+	Temperature *float64 `json:"temperature,omitempty"`  // degrees Celsius
+	Pulse       *int     `json:"pulse,omitempty"`        // beats per minute
+	SpO2        *int     `json:"spo2,omitempty"`         // %
+	MUAC        *float64 `json:"muac,omitempty"`         // mid-upper arm circumference, cm
+	// BMI is derived from the weight and the latest height known at the time
+	BMI *float64 `json:"bmi,omitempty"`
+}
+
+// Observation is a set of vitals taken from a client at one time by a doctor
+type Observation struct {
+	ID         int       `json:"id"`
+	ClientID   string    `json:"client_id"`
+	DoctorID   int       `json:"doctor_id,omitempty"`
+	ObservedAt time.Time `json:"observed_at"`
+	Vitals
+}
+
+type EncounterStore interface {
+	CreateEncounter(encounter Encounter, recordedBy int) (int, error)
+	GetEncounter(id int) (Encounter, error)
+	GetEncountersByClient(clientID string) ([]Encounter, error)
+	UpdateEncounterNotes(id int, notes SOAPNotes, amendedBy int) error
+}
+
+// SOAPNotes are the notes of an encounter in the subjective, objective, assessment and plan structure
+type SOAPNotes struct {
+	Subjective string `json:"subjective"`
+	Objective  string `json:"objective"`
+	Assessment string `json:"assessment"`
+	Plan       string `json:"plan"`
+}
+
+// Encounter is a visit of a client, seen by a doctor and optionally as part of a program
+type Encounter struct {
+	ID            int       `json:"id"`
+	ClientID      string    `json:"client_id"`
+	DoctorID      int       `json:"doctor_id,omitempty"`
+	Program       string    `json:"program,omitempty"`
+	EncounteredAt time.Time `json:"encountered_at"`
+	Notes         SOAPNotes `json:"notes"`
+	// Diagnoses and prescriptions made during the encounter, only filled when a single encounter is read
+	Diagnoses     []Diagnosis    `json:"diagnoses,omitempty"`
+	Prescriptions []Prescription `json:"prescriptions,omitempty"`
+	// Amendments are the notes replaced by edits, the most recent first, only filled when a single encounter is read
+	Amendments []NotesAmendment `json:"amendments,omitempty"`
+}
+
+// NotesAmendment is a previous version of the SOAP notes of an encounter, kept when the notes were edited
+type NotesAmendment struct {
+	Notes     SOAPNotes `json:"notes"`
+	AmendedBy int       `json:"amended_by,omitempty"`
+	AmendedAt time.Time `json:"amended_at"`
+}
+
+type DiagnosisStore interface {
+	AddDiagnoses(encounterID int, diagnoses []Diagnosis, recordedBy int) error
+	GetDiagnosesByClient(clientID string) ([]Diagnosis, error)
+	UpdateDiagnosis(diagnosis Diagnosis) error
+	ReportDiagnoses(query DiagnosisReportQuery) ([]DiagnosisCount, error)
+}
+
+// ICDCode is an entry of the diagnosis catalog
+type ICDCode struct {
+	System      string `json:"system"` // ICD-10 or ICD-11
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// Diagnosis is a coded diagnosis made during an encounter.
+// The description is copied from the catalog so records survive catalog updates.
+type Diagnosis struct {
+	ID          int    `json:"id"`
+	EncounterID int    `json:"encounter_id"`
+	ClientID    string `json:"client_id"`
+	DoctorID    int    `json:"doctor_id,omitempty"`
+	ICDCode
+	// Primary marks the main diagnosis of the encounter, the others are secondary
+	Primary bool `json:"primary"`
+	// Certainty is provisional or confirmed
+	Certainty   string    `json:"certainty"`
+	DiagnosedAt time.Time `json:"diagnosed_at"`
+}
+
+// DiagnosisReportQuery filters the diagnoses counted in a report, zero values are not applied
+type DiagnosisReportQuery struct {
+	From          time.Time
+	To            time.Time
+	System        string
+	CodePrefix    string
+	ConfirmedOnly bool
+}
+
+// DiagnosisCount is how often a code was diagnosed across the facility
+type DiagnosisCount struct {
+	ICDCode
+	Diagnoses int `json:"diagnoses"`
+	Confirmed int `json:"confirmed"`
+	Clients   int `json:"clients"`
 }
 
 type ProgramsStore interface {
@@ -81,9 +507,281 @@ type ProgramEnrollment struct {
 }
 
 type Prescription struct {
+	ID       int    `json:"id"`
+	ClientID string `json:"client_id"`
+	DoctorID int    `json:"doctor_id"`
+	// EncounterID is the encounter the prescription was written in, if any
+	EncounterID int                `json:"encounter_id,omitempty"`
+	Items       []PrescriptionItem `json:"items"`
+	DateIssued  time.Time          `json:"date_issued"`
+	// OverrideReason is why the prescription was written despite the blocking warnings kept in OverriddenWarnings
+	OverrideReason     string                `json:"override_reason,omitempty"`
+	OverriddenWarnings []PrescriptionWarning `json:"overridden_warnings,omitempty"`
+	// Status is active, partially_dispensed, dispensed, completed or cancelled,
+	// active and partially dispensed prescriptions past ValidUntil are expired
+	Status       string `json:"status"`
+	CancelReason string `json:"cancel_reason,omitempty"`
+	// Refills is the number of times the prescription can be dispensed again after the first time
+	Refills          int       `json:"refills"`
+	RefillsRemaining int       `json:"refills_remaining"`
+	ValidUntil       time.Time `json:"valid_until"`
+	// History is only returned for a single prescription
+	History []PrescriptionEvent `json:"history,omitempty"`
+}
+
+// PrescriptionEvent is an entry in the history of a prescription:
+// created, amended, refilled or the status it was changed to
+type PrescriptionEvent struct {
+	Event      string    `json:"event"`
+	Reason     string    `json:"reason,omitempty"`
+	DoctorID   int       `json:"doctor_id,omitempty"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Medication is an item of a prescription the client is currently on:
+// one waiting to be dispensed, or dispensed with its course still running
+type Medication struct {
+	PrescriptionID   int       `json:"prescription_id"`
+	Status           string    `json:"status"`
+	DateIssued       time.Time `json:"date_issued"`
+	ValidUntil       time.Time `json:"valid_until"`
+	RefillsRemaining int       `json:"refills_remaining"`
+	PrescriptionItem
+}
+
+// PrescriptionItem is one medicine of a prescription.
+// Items migrated from the old comma separated medicines only have the drug set.
+type PrescriptionItem struct {
+	// DrugID is the formulary entry prescribed, the drug name and strength are taken from it
+	DrugID int    `json:"drug_id,omitempty"`
+	Drug   string `json:"drug"`
+	// ATCCode is the ATC code of the formulary drug, it is only read
+	ATCCode string `json:"atc_code,omitempty"`
+	// FreeText marks a drug typed in instead of chosen from the formulary
+	FreeText bool    `json:"free_text,omitempty"`
+	Strength string  `json:"strength,omitempty"` // e.g. 500 mg
+	Dose     float64 `json:"dose"`               // amount per administration, in the unit
+	Unit     string  `json:"unit"`               // e.g. tablet, ml
+	Route    string  `json:"route"`
+	// Frequency is an abbreviation such as OD, BD, TDS, QID, PRN or STAT
+	Frequency    string `json:"frequency"`
+	DurationDays int    `json:"duration_days"`
+	// Quantity is the number of units to dispense
+	Quantity     int    `json:"quantity"`
+	Instructions string `json:"instructions,omitempty"`
+}
+
+// Allergy is an allergy or intolerance of a client to a substance.
+// A drug allergy can name a formulary drug or an ATC code to cover a whole class, e.g. J01C for penicillins.
+type Allergy struct {
+	ID        int    `json:"id"`
+	ClientID  string `json:"client_id"`
+	Substance string `json:"substance"`
+	DrugID    int    `json:"drug_id,omitempty"`
+	ATCCode   string `json:"atc_code,omitempty"`
+	// Kind is allergy or intolerance
+	Kind     string `json:"kind"`
+	Reaction string `json:"reaction,omitempty"`
+	// Severity is mild, moderate or severe
+	Severity   string    `json:"severity"`
+	Active     bool      `json:"active"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// InteractionRule is an interaction between two drugs, each side is an ATC code or a generic name
+type InteractionRule struct {
+	DrugA string `json:"drug_a"`
+	DrugB string `json:"drug_b"`
+	// Severity is minor, moderate, major or contraindicated
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+// PrescribingContext is what new prescription items are checked against
+type PrescribingContext struct {
+	// Drugs are the formulary drugs being prescribed keyed by id
+	Drugs map[int]Drug
+	// ActiveItems are the items of the client's active prescriptions
+	ActiveItems []PrescriptionItem
+	Allergies   []Allergy
+	Rules       []InteractionRule
+}
+
+// PrescriptionWarning is a problem found with a prescription item, blocking warnings need an override reason
+type PrescriptionWarning struct {
+	// Type is interaction, allergy or unchecked for a drug without an ATC code
+	Type     string `json:"type"`
+	Severity string `json:"severity"`
+	Drug     string `json:"drug"`
+	// With is the other drug of an interaction or the allergy substance
+	With     string `json:"with,omitempty"`
+	Message  string `json:"message"`
+	Blocking bool   `json:"blocking"`
+}
+
+type FormularyStore interface {
+	CreateDrug(drug Drug) (int, error)
+	GetDrug(id int) (Drug, error)
+	ListDrugs(includeInactive bool) ([]Drug, error)
+	SearchDrugs(q string, limit int) ([]Drug, error)
+	UpdateDrug(drug Drug) error
+	ImportDrugs(drugs []Drug) (FormularyImportReport, error)
+}
+
+// Drug is an entry of the formulary, a generic drug in one form and strength
+type Drug struct {
+	ID          int      `json:"id"`
+	GenericName string   `json:"generic_name"`
+	BrandNames  []string `json:"brand_names"`
+	// ATCCode is the WHO Anatomical Therapeutic Chemical code, e.g. J01CA04
+	ATCCode  string `json:"atc_code,omitempty"`
+	Form     string `json:"form"`     // e.g. tablet, syrup, injection
+	Strength string `json:"strength"` // e.g. 500 mg, 125 mg/5 ml
+	// Inactive drugs stay on existing prescriptions but cannot be prescribed
+	Active bool `json:"active"`
+	// StockOnHand is the quantity in stock in batches that have not expired, it is only read
+	StockOnHand int `json:"stock_on_hand"`
+}
+
+// FormularyImportReport is the result of importing drugs, existing drugs with the same name, form and strength are updated
+type FormularyImportReport struct {
+	Created int                    `json:"created"`
+	Updated int                    `json:"updated"`
+	Errors  []FormularyImportError `json:"errors"`
+}
+
+// FormularyImportError is a line of an import file that could not be imported
+type FormularyImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type PharmacyStore interface {
+	GetDispensingPrescription(id int) (DispensingPrescription, error)
+	Dispense(prescriptionID int, items []DispenseRequestItem, notes string, dispensedBy int) (Dispensing, error)
+	ReceiveStock(movement StockMovement, recordedBy int) (int, error)
+	AdjustStock(movement StockMovement, recordedBy int) (int, error)
+	GetStockBalances(drugID int) ([]StockBalance, error)
+	GetStockMovements(drugID int, from, to time.Time) ([]StockMovement, error)
+	SetReorderLevel(drugID int, level int) error
+	LowStockReport() ([]StockBalance, error)
+	NearExpiryReport(before time.Time) ([]StockBatch, error)
+}
+
+// StockBatch is a batch (lot) of a formulary drug and the quantity of it in stock
+type StockBatch struct {
+	ID          int       `json:"id"`
+	DrugID      int       `json:"drug_id"`
+	Drug        string    `json:"drug,omitempty"`
+	BatchNumber string    `json:"batch_number"`
+	ExpiryDate  time.Time `json:"expiry_date"`
+	Balance     int       `json:"balance"`
+}
+
+// StockBalance is the stock of a formulary drug.
+// Usable stock leaves out expired batches, which stay on hand until they are adjusted out.
+type StockBalance struct {
+	DrugID       int          `json:"drug_id"`
+	Drug         string       `json:"drug"`
+	Form         string       `json:"form"`
+	Strength     string       `json:"strength"`
+	OnHand       int          `json:"on_hand"`
+	Usable       int          `json:"usable"`
+	ReorderLevel int          `json:"reorder_level"`
+	Batches      []StockBatch `json:"batches,omitempty"`
+}
+
+// StockMovement is an entry of the stock ledger: a receipt, an issue or an adjustment of a batch.
+// Receipts are positive, issues negative and adjustments either.
+type StockMovement struct {
 	ID          int       `json:"id"`
-	ClientPhone string    `json:"client_phone"`
-	DoctorID    int       `json:"doctor_id"`
-	Medicines   string    `json:"medicines"`
-	DateIssued  time.Time `json:"date_issued"`
+	DrugID      int       `json:"drug_id"`
+	BatchNumber string    `json:"batch_number"`
+	ExpiryDate  time.Time `json:"expiry_date"`
+	// Kind is receipt, issue or adjustment
+	Kind     string `json:"kind"`
+	Quantity int    `json:"quantity"`
+	Reason   string `json:"reason,omitempty"`
+	// DispensingID is the dispensing an issue was made for
+	DispensingID int       `json:"dispensing_id,omitempty"`
+	RecordedBy   int       `json:"recorded_by,omitempty"`
+	RecordedAt   time.Time `json:"recorded_at"`
+}
+
+// DispensingPrescription is a prescription as pulled up by the pharmacy:
+// what is left to dispense of each item in the current fill and the batches it can be dispensed from
+type DispensingPrescription struct {
+	ID               int       `json:"id"`
+	ClientID         string    `json:"client_id"`
+	Status           string    `json:"status"`
+	DateIssued       time.Time `json:"date_issued"`
+	ValidUntil       time.Time `json:"valid_until"`
+	RefillsRemaining int       `json:"refills_remaining"`
+	// Fill is 1 for the first dispensing of the prescription, then one more for each refill
+	Fill  int              `json:"fill"`
+	Items []DispensingItem `json:"items"`
+}
+
+// DispensingItem is an item of a prescription being dispensed, Item is its 1-based position
+type DispensingItem struct {
+	Item int `json:"item"`
+	PrescriptionItem
+	Dispensed int          `json:"dispensed"`
+	Remaining int          `json:"remaining"`
+	Batches   []StockBatch `json:"batches,omitempty"`
+}
+
+// DispenseRequestItem is the quantity handed out for an item of a prescription.
+// Without a batch number the quantity is taken from the batches expiring first.
+type DispenseRequestItem struct {
+	Item        int    `json:"item"`
+	Quantity    int    `json:"quantity"`
+	BatchNumber string `json:"batch_number"`
+}
+
+// Dispensing is a record of handing out items of a prescription, Status is the prescription status after it
+type Dispensing struct {
+	ID             int             `json:"id"`
+	PrescriptionID int             `json:"prescription_id"`
+	Fill           int             `json:"fill"`
+	Status         string          `json:"status"`
+	Lines          []DispensedLine `json:"lines"`
+}
+
+// DispensedLine is a quantity handed out for an item of a prescription from one batch, free text items have no batch
+type DispensedLine struct {
+	Item        int        `json:"item"`
+	Drug        string     `json:"drug"`
+	BatchNumber string     `json:"batch_number,omitempty"`
+	ExpiryDate  *time.Time `json:"expiry_date,omitempty"`
+	Quantity    int        `json:"quantity"`
+}
+
+type DocumentStore interface {
+	GetDoctor(id int) (Doctor, error)
+	RecordDocument(document IssuedDocument) (string, error)
+	GetIssuedDocument(id string) (IssuedDocument, error)
+}
+
+// Doctor is what is printed about the doctor issuing a document
+type Doctor struct {
+	ID                 int    `json:"id"`
+	FirstName          string `json:"firstname"`
+	LastName           string `json:"lastname"`
+	Department         string `json:"department,omitempty"`
+	RegistrationNumber string `json:"registration_number,omitempty"`
+}
+
+// IssuedDocument is a printed prescription or client summary, its ID is encoded in the document's QR code
+type IssuedDocument struct {
+	ID string `json:"id"`
+	// Kind is prescription or client_summary
+	Kind           string `json:"kind"`
+	ClientID       string `json:"-"`
+	PrescriptionID int    `json:"prescription_id,omitempty"`
+	DoctorID       int    `json:"-"`
+	// Doctor and RegistrationNumber are of the issuing doctor, they are only read
+	Doctor             string    `json:"doctor"`
+	RegistrationNumber string    `json:"registration_number,omitempty"`
+	IssuedAt           time.Time `json:"issued_at"`
 }